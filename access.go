@@ -21,8 +21,12 @@ const (
 	AccFinal = 0x0010
 	// AccSuper is archaic and (AFAICT) unused in modern JVM's.
 	AccSuper = 0x0020
+	// AccSynchronized indicates a method whose invocation is wrapped in a monitor.
+	AccSynchronized = 0x0020
 	// AccVolatile indicates volatile variables.
 	AccVolatile = 0x0040
+	// AccBridge indicates a bridge method generated by the compiler.
+	AccBridge = 0x0040
 
 	// AccTransient indicates a transient field (i.e. one that is not serialized).
 	AccTransient = 0x0080
@@ -43,6 +47,18 @@ const (
 	AccAnnotation = 0x2000
 	// AccEnum indicates the class is an enum.
 	AccEnum = 0x4000
+	// AccModule indicates the classfile is a module descriptor (module-info.class).
+	AccModule = 0x8000
+
+	// AccOpen indicates an open module, whose packages are all open for reflection.
+	AccOpen = 0x0020
+	// AccTransitive indicates a module dependency that is implied for readers of the
+	// requiring module.
+	AccTransitive = 0x0020
+	// AccStaticPhase indicates a module dependency that is only required at compile time.
+	AccStaticPhase = 0x0040
+	// AccMandated indicates a module construct that was implicitly declared.
+	AccMandated = 0x8000
 )
 
 // MethodFlagsRepr returns the string representation of flags for a method, in the order
@@ -124,3 +140,59 @@ func FieldFlagsRepr(acc Access) string {
 
 	return strings.Join(text, " ")
 }
+
+// RequiresFlagsRepr returns the modifiers of a module dependency as they appear in a
+// module declaration.
+func RequiresFlagsRepr(acc Access) string {
+	var text []string
+
+	if (acc & AccTransitive) > 0 {
+		text = append(text, "transitive")
+	}
+
+	if (acc & AccStaticPhase) > 0 {
+		text = append(text, "static")
+	}
+
+	if (acc & AccSynthetic) > 0 {
+		text = append(text, "synthetic")
+	}
+
+	if (acc & AccMandated) > 0 {
+		text = append(text, "mandated")
+	}
+
+	return strings.Join(text, " ")
+}
+
+// ClassFlagsRepr returns the modifiers of a class or nested class, in the order one would
+// expect them to appear in a Java source file.
+func ClassFlagsRepr(acc Access) string {
+	var text []string
+
+	if (acc & AccPublic) > 0 {
+		text = append(text, "public")
+	}
+
+	if (acc & AccPrivate) > 0 {
+		text = append(text, "private")
+	}
+
+	if (acc & AccProtected) > 0 {
+		text = append(text, "protected")
+	}
+
+	if (acc&AccAbstract) > 0 && (acc&AccInterface) == 0 {
+		text = append(text, "abstract")
+	}
+
+	if (acc & AccStatic) > 0 {
+		text = append(text, "static")
+	}
+
+	if (acc & AccFinal) > 0 {
+		text = append(text, "final")
+	}
+
+	return strings.Join(text, " ")
+}
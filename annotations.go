@@ -0,0 +1,489 @@
+package classy
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Annotation is a single annotation applied to a class, field, method, parameter or
+// type. TypeIndex refers to the field descriptor of the annotation interface.
+type Annotation struct {
+	TypeIndex            uint16
+	NumElementValuePairs uint16
+	ElementValuePairs    []ElementValuePair
+}
+
+// ElementValuePair is a single element = value pair of an annotation.
+type ElementValuePair struct {
+	ElementNameIndex uint16
+	Value            ElementValue
+}
+
+// ElementValue is the value of an annotation element. Which fields are set depends on
+// Tag, as in the spec's element_value union:
+//
+//	B C D F I J S Z s   ConstValueIndex
+//	e                   TypeNameIndex and ConstNameIndex
+//	c                   ClassInfoIndex
+//	@                   AnnotationValue
+//	[                   NumValues and Values
+type ElementValue struct {
+	Tag             byte
+	ConstValueIndex uint16
+	TypeNameIndex   uint16
+	ConstNameIndex  uint16
+	ClassInfoIndex  uint16
+	AnnotationValue *Annotation
+	NumValues       uint16
+	Values          []ElementValue
+}
+
+// RuntimeVisibleAnnotationsAttribute holds the annotations that are retained at run time
+// and visible to reflection.
+type RuntimeVisibleAnnotationsAttribute struct {
+	NumAnnotations uint16
+	Annotations    []Annotation
+}
+
+// RuntimeInvisibleAnnotationsAttribute holds the annotations that are recorded in the
+// classfile but not visible to reflection.
+type RuntimeInvisibleAnnotationsAttribute struct {
+	NumAnnotations uint16
+	Annotations    []Annotation
+}
+
+// ParameterAnnotations are the annotations on a single formal parameter.
+type ParameterAnnotations struct {
+	NumAnnotations uint16
+	Annotations    []Annotation
+}
+
+// RuntimeVisibleParameterAnnotationsAttribute holds the run time visible annotations on
+// each formal parameter of a method. The parameters may not line up with the method
+// descriptor, as compilers are allowed to omit synthetic and implicit parameters.
+type RuntimeVisibleParameterAnnotationsAttribute struct {
+	NumParameters        byte
+	ParameterAnnotations []ParameterAnnotations
+}
+
+// RuntimeInvisibleParameterAnnotationsAttribute holds the invisible annotations on each
+// formal parameter of a method.
+type RuntimeInvisibleParameterAnnotationsAttribute struct {
+	NumParameters        byte
+	ParameterAnnotations []ParameterAnnotations
+}
+
+// TypeAnnotation is an annotation on a use of a type. TargetType says which kind of type
+// is annotated and so which fields of TargetInfo are set, and TargetPath locates the
+// annotated part of a compound type such as an array or parameterized type.
+type TypeAnnotation struct {
+	TargetType byte
+	TargetInfo TargetInfo
+	TargetPath TypePath
+	Annotation
+}
+
+// Values of TypeAnnotation.TargetType.
+const (
+	TargetClassTypeParameter                byte = 0x00
+	TargetMethodTypeParameter               byte = 0x01
+	TargetClassExtends                      byte = 0x10
+	TargetClassTypeParameterBound           byte = 0x11
+	TargetMethodTypeParameterBound          byte = 0x12
+	TargetField                             byte = 0x13
+	TargetMethodReturn                      byte = 0x14
+	TargetMethodReceiver                    byte = 0x15
+	TargetMethodFormalParameter             byte = 0x16
+	TargetThrows                            byte = 0x17
+	TargetLocalVariable                     byte = 0x40
+	TargetResourceVariable                  byte = 0x41
+	TargetExceptionParameter                byte = 0x42
+	TargetInstanceof                        byte = 0x43
+	TargetNew                               byte = 0x44
+	TargetConstructorReference              byte = 0x45
+	TargetMethodReference                   byte = 0x46
+	TargetCast                              byte = 0x47
+	TargetConstructorInvocationTypeArgument byte = 0x48
+	TargetMethodInvocationTypeArgument      byte = 0x49
+	TargetConstructorReferenceTypeArgument  byte = 0x4A
+	TargetMethodReferenceTypeArgument       byte = 0x4B
+)
+
+// TargetInfo is the spec's target_info union. Only the fields used by the annotation's
+// target type are set:
+//
+//	type_parameter_target          TypeParameterIndex
+//	supertype_target               SupertypeIndex
+//	type_parameter_bound_target    TypeParameterIndex and BoundIndex
+//	empty_target                   nothing
+//	formal_parameter_target        FormalParameterIndex
+//	throws_target                  ThrowsTypeIndex
+//	localvar_target                TableLength and Table
+//	catch_target                   ExceptionTableIndex
+//	offset_target                  Offset
+//	type_argument_target           Offset and TypeArgumentIndex
+type TargetInfo struct {
+	TypeParameterIndex   byte
+	SupertypeIndex       uint16
+	BoundIndex           byte
+	FormalParameterIndex byte
+	ThrowsTypeIndex      uint16
+	TableLength          uint16
+	Table                []LocalVarTarget
+	ExceptionTableIndex  uint16
+	Offset               uint16
+	TypeArgumentIndex    byte
+}
+
+// LocalVarTarget is the range of bytecode over which an annotated local variable lives
+// in slot Index.
+type LocalVarTarget struct {
+	StartPC uint16
+	Length  uint16
+	Index   uint16
+}
+
+// TypePath locates the annotated part of a type.
+type TypePath struct {
+	PathLength byte
+	Path       []TypePathEntry
+}
+
+// TypePathEntry is one step of a TypePath.
+type TypePathEntry struct {
+	TypePathKind      byte
+	TypeArgumentIndex byte
+}
+
+// Values of TypePathEntry.TypePathKind.
+const (
+	// TypePathArray steps into the element type of an array.
+	TypePathArray byte = 0
+	// TypePathNested steps into a nested type.
+	TypePathNested byte = 1
+	// TypePathWildcard steps into the bound of a wildcard type argument.
+	TypePathWildcard byte = 2
+	// TypePathTypeArgument steps into the type argument TypeArgumentIndex.
+	TypePathTypeArgument byte = 3
+)
+
+// RuntimeVisibleTypeAnnotationsAttribute holds the run time visible annotations on
+// uses of types.
+type RuntimeVisibleTypeAnnotationsAttribute struct {
+	NumAnnotations uint16
+	Annotations    []TypeAnnotation
+}
+
+// RuntimeInvisibleTypeAnnotationsAttribute holds the invisible annotations on uses of
+// types.
+type RuntimeInvisibleTypeAnnotationsAttribute struct {
+	NumAnnotations uint16
+	Annotations    []TypeAnnotation
+}
+
+// AnnotationDefaultAttribute holds the default value of an annotation interface element.
+type AnnotationDefaultAttribute struct {
+	DefaultValue ElementValue
+}
+
+func readAnnotations(d *decoder) (uint16, []Annotation) {
+	count := d.u2()
+	var annotations []Annotation
+	for i := uint16(0); d.err == nil && i < count; i++ {
+		annotations = append(annotations, readAnnotation(d))
+	}
+	return count, annotations
+}
+
+func readAnnotation(d *decoder) Annotation {
+	var ann Annotation
+	ann.TypeIndex = d.u2()
+	ann.NumElementValuePairs = d.u2()
+	for i := uint16(0); d.err == nil && i < ann.NumElementValuePairs; i++ {
+		var pair ElementValuePair
+		pair.ElementNameIndex = d.u2()
+		pair.Value = readElementValue(d)
+		ann.ElementValuePairs = append(ann.ElementValuePairs, pair)
+	}
+	return ann
+}
+
+func readElementValue(d *decoder) ElementValue {
+	var value ElementValue
+	pos := d.pos
+	value.Tag = d.u1()
+	switch value.Tag {
+	case 'B', 'C', 'D', 'F', 'I', 'J', 'S', 'Z', 's':
+		value.ConstValueIndex = d.u2()
+	case 'e':
+		value.TypeNameIndex = d.u2()
+		value.ConstNameIndex = d.u2()
+	case 'c':
+		value.ClassInfoIndex = d.u2()
+	case '@':
+		ann := readAnnotation(d)
+		value.AnnotationValue = &ann
+	case '[':
+		value.NumValues = d.u2()
+		for i := uint16(0); d.err == nil && i < value.NumValues; i++ {
+			value.Values = append(value.Values, readElementValue(d))
+		}
+	default:
+		if d.err == nil {
+			d.failAt(pos, ErrMalformed, "unknown element_value tag %q", value.Tag)
+		}
+	}
+	return value
+}
+
+func readParameterAnnotations(d *decoder) (byte, []ParameterAnnotations) {
+	count := d.u1()
+	var params []ParameterAnnotations
+	for i := byte(0); d.err == nil && i < count; i++ {
+		var param ParameterAnnotations
+		param.NumAnnotations, param.Annotations = readAnnotations(d)
+		params = append(params, param)
+	}
+	return count, params
+}
+
+func readTypeAnnotations(d *decoder) (uint16, []TypeAnnotation) {
+	count := d.u2()
+	var annotations []TypeAnnotation
+	for i := uint16(0); d.err == nil && i < count; i++ {
+		var ann TypeAnnotation
+		pos := d.pos
+		ann.TargetType = d.u1()
+		info := &ann.TargetInfo
+		switch ann.TargetType {
+		case TargetClassTypeParameter, TargetMethodTypeParameter:
+			info.TypeParameterIndex = d.u1()
+		case TargetClassExtends:
+			info.SupertypeIndex = d.u2()
+		case TargetClassTypeParameterBound, TargetMethodTypeParameterBound:
+			info.TypeParameterIndex = d.u1()
+			info.BoundIndex = d.u1()
+		case TargetField, TargetMethodReturn, TargetMethodReceiver:
+		case TargetMethodFormalParameter:
+			info.FormalParameterIndex = d.u1()
+		case TargetThrows:
+			info.ThrowsTypeIndex = d.u2()
+		case TargetLocalVariable, TargetResourceVariable:
+			info.TableLength = d.u2()
+			for j := uint16(0); d.err == nil && j < info.TableLength; j++ {
+				var local LocalVarTarget
+				local.StartPC = d.u2()
+				local.Length = d.u2()
+				local.Index = d.u2()
+				info.Table = append(info.Table, local)
+			}
+		case TargetExceptionParameter:
+			info.ExceptionTableIndex = d.u2()
+		case TargetInstanceof, TargetNew, TargetConstructorReference, TargetMethodReference:
+			info.Offset = d.u2()
+		case TargetCast, TargetConstructorInvocationTypeArgument, TargetMethodInvocationTypeArgument,
+			TargetConstructorReferenceTypeArgument, TargetMethodReferenceTypeArgument:
+			info.Offset = d.u2()
+			info.TypeArgumentIndex = d.u1()
+		default:
+			if d.err == nil {
+				d.failAt(pos, ErrMalformed, "unknown type annotation target_type 0x%02x", ann.TargetType)
+			}
+		}
+
+		ann.TargetPath.PathLength = d.u1()
+		for j := byte(0); d.err == nil && j < ann.TargetPath.PathLength; j++ {
+			var entry TypePathEntry
+			entry.TypePathKind = d.u1()
+			entry.TypeArgumentIndex = d.u1()
+			ann.TargetPath.Path = append(ann.TargetPath.Path, entry)
+		}
+		ann.Annotation = readAnnotation(d)
+		annotations = append(annotations, ann)
+	}
+	return count, annotations
+}
+
+// Type gets the annotation interface as a field descriptor, e.g. "Ljava/lang/Deprecated;".
+func (a *Annotation) Type(cp []CpEntry) string {
+	return cpName(cp, a.TypeIndex)
+}
+
+// Repr renders the annotation as it would be written in Java source, e.g.
+// `@com.foo.Bar(name = "x", values = {1, 2})`.
+func (a *Annotation) Repr(cp []CpEntry) string {
+	var b strings.Builder
+	b.WriteString("@")
+	b.WriteString(descriptorTypeName(a.Type(cp)))
+	if len(a.ElementValuePairs) > 0 {
+		var pairs []string
+		for _, pair := range a.ElementValuePairs {
+			pairs = append(pairs, pair.Name(cp)+" = "+pair.Value.Repr(cp))
+		}
+		b.WriteString("(" + strings.Join(pairs, ", ") + ")")
+	}
+	return b.String()
+}
+
+// Name gets the name of the annotation element.
+func (p *ElementValuePair) Name(cp []CpEntry) string {
+	return cpName(cp, p.ElementNameIndex)
+}
+
+// Repr renders the element value as it would be written in Java source.
+func (v *ElementValue) Repr(cp []CpEntry) string {
+	switch v.Tag {
+	case 's':
+		return strconv.Quote(cpName(cp, v.ConstValueIndex))
+	case 'Z', 'C', 'B', 'S', 'I':
+		i, ok := LookupEntry(cp, v.ConstValueIndex).(*CONSTANT_Integer_info)
+		if !ok {
+			return fmt.Sprintf("#%v", v.ConstValueIndex)
+		}
+		switch v.Tag {
+		case 'Z':
+			if i.Value() != 0 {
+				return "true"
+			}
+			return "false"
+		case 'C':
+			return strconv.QuoteRune(rune(i.Value()))
+		}
+		return i.Repr(cp)
+	case 'J':
+		l, ok := LookupEntry(cp, v.ConstValueIndex).(*CONSTANT_Long_info)
+		if !ok {
+			return fmt.Sprintf("#%v", v.ConstValueIndex)
+		}
+		return l.Repr(cp) + "L"
+	case 'F':
+		f, ok := LookupEntry(cp, v.ConstValueIndex).(*CONSTANT_Float_info)
+		if !ok {
+			return fmt.Sprintf("#%v", v.ConstValueIndex)
+		}
+		return javaFloatLiteral(float64(f.Value()), "Float", "f")
+	case 'D':
+		d, ok := LookupEntry(cp, v.ConstValueIndex).(*CONSTANT_Double_info)
+		if !ok {
+			return fmt.Sprintf("#%v", v.ConstValueIndex)
+		}
+		return javaFloatLiteral(d.Value(), "Double", "")
+	case 'e':
+		return descriptorTypeName(cpName(cp, v.TypeNameIndex)) + "." + cpName(cp, v.ConstNameIndex)
+	case 'c':
+		return descriptorTypeName(cpName(cp, v.ClassInfoIndex)) + ".class"
+	case '@':
+		return v.AnnotationValue.Repr(cp)
+	case '[':
+		var values []string
+		for _, value := range v.Values {
+			values = append(values, value.Repr(cp))
+		}
+		return "{" + strings.Join(values, ", ") + "}"
+	default:
+		return "<unknown element_value tag " + strconv.QuoteRune(rune(v.Tag)) + ">"
+	}
+}
+
+// javaFloatLiteral renders a float or double as a Java source expression, using the
+// constants of class for the values that have no literal form.
+func javaFloatLiteral(f float64, class, suffix string) string {
+	switch {
+	case math.IsNaN(f):
+		return class + ".NaN"
+	case math.IsInf(f, 1):
+		return class + ".POSITIVE_INFINITY"
+	case math.IsInf(f, -1):
+		return class + ".NEGATIVE_INFINITY"
+	}
+	bitSize := 64
+	if class == "Float" {
+		bitSize = 32
+	}
+	return javaFloatString(f, bitSize) + suffix
+}
+
+// descriptorTypeName renders a field or return descriptor as a Java type name, falling
+// back to the raw descriptor if it does not parse.
+func descriptorTypeName(descriptor string) string {
+	if descriptor == "V" {
+		return Void.String()
+	}
+	t, err := ParseFieldDescriptor(descriptor)
+	if err != nil {
+		return descriptor
+	}
+	return t.String()
+}
+
+// Annotations decodes the RuntimeVisibleAnnotations and RuntimeInvisibleAnnotations
+// attributes of the class, returning the visible annotations first.
+func (cf *ClassFile) Annotations() ([]Annotation, error) {
+	return annotationsOf(cf.Attrs, cf.ConstantPool)
+}
+
+// Annotations decodes the RuntimeVisibleAnnotations and RuntimeInvisibleAnnotations
+// attributes of the method, returning the visible annotations first.
+func (i *MethodInfo) Annotations(cp []CpEntry) ([]Annotation, error) {
+	return annotationsOf(i.Attrs, cp)
+}
+
+// Annotations decodes the RuntimeVisibleAnnotations and RuntimeInvisibleAnnotations
+// attributes of the field, returning the visible annotations first.
+func (i *FieldInfo) Annotations(cp []CpEntry) ([]Annotation, error) {
+	return annotationsOf(i.Attrs, cp)
+}
+
+// ParameterAnnotations decodes the RuntimeVisibleParameterAnnotations and
+// RuntimeInvisibleParameterAnnotations attributes of the method, merging them into one
+// list of annotations per annotated parameter.
+func (i *MethodInfo) ParameterAnnotations(cp []CpEntry) ([][]Annotation, error) {
+	var params [][]Annotation
+	for _, name := range []string{"RuntimeVisibleParameterAnnotations", "RuntimeInvisibleParameterAnnotations"} {
+		attr := findAttr(i.Attrs, cp, name)
+		if attr == nil {
+			continue
+		}
+		decoded, err := attr.Decode(cp)
+		if err != nil {
+			return nil, err
+		}
+		var annotated []ParameterAnnotations
+		switch a := decoded.(type) {
+		case *RuntimeVisibleParameterAnnotationsAttribute:
+			annotated = a.ParameterAnnotations
+		case *RuntimeInvisibleParameterAnnotationsAttribute:
+			annotated = a.ParameterAnnotations
+		}
+		for len(params) < len(annotated) {
+			params = append(params, nil)
+		}
+		for j, param := range annotated {
+			params[j] = append(params[j], param.Annotations...)
+		}
+	}
+	return params, nil
+}
+
+func annotationsOf(attrs []AttrInfo, cp []CpEntry) ([]Annotation, error) {
+	var annotations []Annotation
+	for _, name := range []string{"RuntimeVisibleAnnotations", "RuntimeInvisibleAnnotations"} {
+		attr := findAttr(attrs, cp, name)
+		if attr == nil {
+			continue
+		}
+		decoded, err := attr.Decode(cp)
+		if err != nil {
+			return nil, err
+		}
+		switch a := decoded.(type) {
+		case *RuntimeVisibleAnnotationsAttribute:
+			annotations = append(annotations, a.Annotations...)
+		case *RuntimeInvisibleAnnotationsAttribute:
+			annotations = append(annotations, a.Annotations...)
+		}
+	}
+	return annotations, nil
+}
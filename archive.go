@@ -0,0 +1,227 @@
+package classy
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ArchiveSeparator separates the path of an archive nested inside another archive from
+// the path of an entry within it, as in "BOOT-INF/lib/dep.jar!/com/foo/Bar.class".
+const ArchiveSeparator = "!/"
+
+// Archive is a JAR, WAR or ZIP file from which classfiles can be read. Archives stored
+// inside the archive (such as the BOOT-INF/lib/*.jar dependencies of a Spring Boot jar
+// or the WEB-INF/lib/*.jar of a war) are opened as well, and their classes are included.
+type Archive struct {
+	closer  io.Closer
+	classes []*ArchiveClass
+	byPath  map[string]*ArchiveClass
+	byName  map[string]*ArchiveClass
+}
+
+// ArchiveClass is a classfile stored in an Archive.
+type ArchiveClass struct {
+	// Path is the location of the classfile in the archive. Classes in nested archives
+	// are prefixed with the nested archive's path and ArchiveSeparator.
+	Path string
+	// Name is the binary name of the class, e.g. "com.foo.Bar", derived from the path.
+	Name string
+	file *zip.File
+}
+
+// Directories whose contents are laid out as a classpath root rather than at the root
+// of the archive.
+var classRootPattern = regexp.MustCompile(`^(BOOT-INF/classes/|WEB-INF/classes/|META-INF/versions/[0-9]+/)`)
+
+// OpenArchive opens the archive at the given path. The returned Archive must be closed
+// when no longer needed.
+func OpenArchive(path string) (*Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	archive, err := NewArchive(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	archive.closer = f
+	return archive, nil
+}
+
+// NewArchive reads an archive of the given size from r.
+func NewArchive(r io.ReaderAt, size int64) (*Archive, error) {
+	archive := &Archive{
+		byPath: make(map[string]*ArchiveClass),
+		byName: make(map[string]*ArchiveClass),
+	}
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if err := archive.add(zr, ""); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// add indexes the classes in zr, descending into nested archives. prefix is the path of
+// zr within the outermost archive, including the trailing separator.
+func (a *Archive) add(zr *zip.Reader, prefix string) error {
+	var nested []*zip.File
+	for _, f := range zr.File {
+		switch {
+		case strings.HasSuffix(f.Name, ".class"):
+			class := &ArchiveClass{
+				Path: prefix + f.Name,
+				Name: classNameFromPath(f.Name),
+				file: f,
+			}
+			a.classes = append(a.classes, class)
+			a.byPath[class.Path] = class
+			// The first class with a given name wins, except that classes from a
+			// versioned directory never shadow the unversioned one.
+			if existing, ok := a.byName[class.Name]; !ok || isVersioned(existing.Path) && !isVersioned(class.Path) {
+				a.byName[class.Name] = class
+			}
+		case IsArchive(f.Name):
+			nested = append(nested, f)
+		}
+	}
+
+	// Nested archives are indexed after the outer archive's own classes, so the outer
+	// classes take precedence in lookups
+	for _, f := range nested {
+		data, err := readZipFile(f)
+		if err != nil {
+			return fmt.Errorf("%v%v: %v", prefix, f.Name, err)
+		}
+		nestedReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return fmt.Errorf("%v%v: %v", prefix, f.Name, err)
+		}
+		if err := a.add(nestedReader, prefix+f.Name+ArchiveSeparator); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the underlying file, if the archive was opened with OpenArchive.
+func (a *Archive) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// Classes returns every classfile in the archive and its nested archives, in the order
+// they are stored.
+func (a *Archive) Classes() []*ArchiveClass {
+	return a.classes
+}
+
+// Lookup reads the class with the given binary name, e.g. "com.foo.Bar". Internal names
+// using slashes ("com/foo/Bar") are accepted as well.
+func (a *Archive) Lookup(binaryName string) (*ClassFile, error) {
+	class, err := a.FindName(binaryName)
+	if err != nil {
+		return nil, err
+	}
+	return class.Read()
+}
+
+// ReadPath reads the classfile stored at the given path in the archive. Paths inside
+// nested archives are joined with ArchiveSeparator.
+func (a *Archive) ReadPath(path string) (*ClassFile, error) {
+	class, err := a.FindPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return class.Read()
+}
+
+// FindName finds the class with the given binary name without reading it; see Lookup.
+func (a *Archive) FindName(binaryName string) (*ArchiveClass, error) {
+	class, ok := a.byName[strings.Replace(binaryName, "/", ".", -1)]
+	if !ok {
+		return nil, fmt.Errorf("class %v not found in archive: %w", binaryName, os.ErrNotExist)
+	}
+	return class, nil
+}
+
+// FindPath finds the classfile stored at the given path without reading it; see
+// ReadPath.
+func (a *Archive) FindPath(path string) (*ArchiveClass, error) {
+	class, ok := a.byPath[strings.TrimPrefix(path, "/")]
+	if !ok {
+		return nil, fmt.Errorf("%v not found in archive: %w", path, os.ErrNotExist)
+	}
+	return class, nil
+}
+
+// Read reads and parses the classfile.
+func (c *ArchiveClass) Read() (*ClassFile, error) {
+	data, err := c.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return ReadClassFile(data)
+}
+
+// Bytes reads the raw contents of the classfile.
+func (c *ArchiveClass) Bytes() ([]byte, error) {
+	data, err := readZipFile(c.file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", c.Path, err)
+	}
+	return data, nil
+}
+
+// Modified returns the modification time recorded for the classfile in the archive.
+func (c *ArchiveClass) Modified() time.Time {
+	return c.file.Modified
+}
+
+// IsArchive reports whether the file name has the extension of an archive that
+// OpenArchive can read.
+func IsArchive(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".jar") || strings.HasSuffix(lower, ".zip") || strings.HasSuffix(lower, ".war")
+}
+
+func isVersioned(path string) bool {
+	if i := strings.LastIndex(path, ArchiveSeparator); i >= 0 {
+		path = path[i+len(ArchiveSeparator):]
+	}
+	return strings.HasPrefix(path, "META-INF/versions/")
+}
+
+// classNameFromPath converts the path of a classfile within an archive to the binary
+// name of the class it holds.
+func classNameFromPath(path string) string {
+	path = classRootPattern.ReplaceAllString(path, "")
+	path = strings.TrimSuffix(path, ".class")
+	return strings.Replace(path, "/", ".", -1)
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
@@ -0,0 +1,527 @@
+package classy
+
+import "fmt"
+
+// Attribute is the decoded contents of an attribute_info structure. DecodeAttr returns
+// one of the *XxxAttribute types in this package for the attributes defined by the JVM
+// specification, or a *RawAttribute for any other.
+type Attribute interface {
+	// AttrName returns the name the attribute is stored under, e.g. "SourceFile".
+	AttrName() string
+}
+
+// RawAttribute is an attribute without a decoder, kept as the raw bytes of its
+// contents.
+type RawAttribute struct {
+	Name string
+	Data []byte
+}
+
+// ConstantValueAttribute holds the initial value of a static field. The index refers
+// to a CONSTANT_Integer, Float, Long, Double or String entry.
+type ConstantValueAttribute struct {
+	ConstantValueIndex uint16
+}
+
+// ExceptionsAttribute lists the checked exceptions a method declares it throws.
+type ExceptionsAttribute struct {
+	NumberOfExceptions  uint16
+	ExceptionIndexTable []uint16
+}
+
+// InnerClassesAttribute records the nested classes a class refers to or declares.
+type InnerClassesAttribute struct {
+	NumberOfClasses uint16
+	Classes         []InnerClass
+}
+
+// InnerClass is a single entry of the InnerClasses attribute. OuterClassInfoIndex is 0
+// for local and anonymous classes, and InnerNameIndex is 0 for anonymous classes.
+type InnerClass struct {
+	InnerClassInfoIndex   uint16
+	OuterClassInfoIndex   uint16
+	InnerNameIndex        uint16
+	InnerClassAccessFlags Access
+}
+
+// EnclosingMethodAttribute identifies the method enclosing a local or anonymous class.
+// MethodIndex is 0 if the class is not enclosed by a method, such as when it appears in
+// an initializer.
+type EnclosingMethodAttribute struct {
+	ClassIndex  uint16
+	MethodIndex uint16
+}
+
+// SyntheticAttribute marks a class, field or method as not appearing in source code.
+type SyntheticAttribute struct{}
+
+// SignatureAttribute holds the generic signature of a class, field, method or record
+// component; see ParseClassSignature, ParseMethodSignature and ParseFieldSignature.
+type SignatureAttribute struct {
+	SignatureIndex uint16
+}
+
+// SourceFileAttribute names the source file a class was compiled from.
+type SourceFileAttribute struct {
+	SourceFileIndex uint16
+}
+
+// SourceDebugExtensionAttribute holds extended debugging information, such as the SMAP
+// emitted by JSP compilers.
+type SourceDebugExtensionAttribute struct {
+	DebugExtension []byte
+}
+
+// LineNumberTableAttribute maps bytecode offsets of a Code attribute to source lines.
+type LineNumberTableAttribute struct {
+	LineNumberTableLength uint16
+	LineNumberTable       []LineNumber
+}
+
+// LineNumber marks the bytecode starting at StartPC as coming from LineNumber.
+type LineNumber struct {
+	StartPC    uint16
+	LineNumber uint16
+}
+
+// LocalVariableTableAttribute describes the local variables of a Code attribute.
+type LocalVariableTableAttribute struct {
+	LocalVariableTableLength uint16
+	LocalVariableTable       []LocalVariable
+}
+
+// LocalVariable is a local variable that is live in slot Index from StartPC for Length
+// bytes of bytecode.
+type LocalVariable struct {
+	StartPC         uint16
+	Length          uint16
+	NameIndex       uint16
+	DescriptorIndex uint16
+	Index           uint16
+}
+
+// LocalVariableTypeTableAttribute describes the generic types of the local variables of
+// a Code attribute.
+type LocalVariableTypeTableAttribute struct {
+	LocalVariableTypeTableLength uint16
+	LocalVariableTypeTable       []LocalVariableType
+}
+
+// LocalVariableType is the generic signature of a local variable.
+type LocalVariableType struct {
+	StartPC        uint16
+	Length         uint16
+	NameIndex      uint16
+	SignatureIndex uint16
+	Index          uint16
+}
+
+// DeprecatedAttribute marks a class, field or method as deprecated.
+type DeprecatedAttribute struct{}
+
+// MethodParametersAttribute records the names and modifiers of a method's parameters.
+type MethodParametersAttribute struct {
+	ParametersCount byte
+	Parameters      []MethodParameter
+}
+
+// MethodParameter is a single formal parameter. NameIndex is 0 for a parameter with no
+// name.
+type MethodParameter struct {
+	NameIndex   uint16
+	AccessFlags Access
+}
+
+// NestHostAttribute names the host of the nest a class belongs to.
+type NestHostAttribute struct {
+	HostClassIndex uint16
+}
+
+// NestMembersAttribute lists the classes belonging to the nest hosted by a class.
+type NestMembersAttribute struct {
+	NumberOfClasses uint16
+	Classes         []uint16
+}
+
+// RecordAttribute describes the components of a record class.
+type RecordAttribute struct {
+	ComponentsCount uint16
+	Components      []RecordComponent
+}
+
+// RecordComponent is a single component of a record, with its own attributes (such as
+// Signature and annotations).
+type RecordComponent struct {
+	NameIndex       uint16
+	DescriptorIndex uint16
+	AttrsCount      uint16
+	Attrs           []AttrInfo
+}
+
+// PermittedSubclassesAttribute lists the classes allowed to extend a sealed class.
+type PermittedSubclassesAttribute struct {
+	NumberOfClasses uint16
+	Classes         []uint16
+}
+
+// attrDecoders maps attribute names to functions decoding their contents.
+var attrDecoders = map[string]func(d *decoder) Attribute{
+	"Code":             func(d *decoder) Attribute { return readCode(d) },
+	"BootstrapMethods": func(d *decoder) Attribute { return readBootstrapMethods(d) },
+	"Module":           func(d *decoder) Attribute { return readModule(d) },
+	"ModulePackages":   func(d *decoder) Attribute { return readModulePackages(d) },
+	"ModuleMainClass":  func(d *decoder) Attribute { return readModuleMainClass(d) },
+	"ConstantValue": func(d *decoder) Attribute {
+		return &ConstantValueAttribute{ConstantValueIndex: d.u2()}
+	},
+	"Exceptions": func(d *decoder) Attribute {
+		attr := new(ExceptionsAttribute)
+		attr.NumberOfExceptions = d.u2()
+		attr.ExceptionIndexTable = d.u2s(int(attr.NumberOfExceptions))
+		return attr
+	},
+	"InnerClasses": func(d *decoder) Attribute {
+		attr := new(InnerClassesAttribute)
+		attr.NumberOfClasses = d.u2()
+		for i := uint16(0); d.err == nil && i < attr.NumberOfClasses; i++ {
+			var class InnerClass
+			class.InnerClassInfoIndex = d.u2()
+			class.OuterClassInfoIndex = d.u2()
+			class.InnerNameIndex = d.u2()
+			class.InnerClassAccessFlags = Access(d.u2())
+			attr.Classes = append(attr.Classes, class)
+		}
+		return attr
+	},
+	"EnclosingMethod": func(d *decoder) Attribute {
+		attr := new(EnclosingMethodAttribute)
+		attr.ClassIndex = d.u2()
+		attr.MethodIndex = d.u2()
+		return attr
+	},
+	"Synthetic": func(d *decoder) Attribute {
+		return &SyntheticAttribute{}
+	},
+	"Signature": func(d *decoder) Attribute {
+		return &SignatureAttribute{SignatureIndex: d.u2()}
+	},
+	"SourceFile": func(d *decoder) Attribute {
+		return &SourceFileAttribute{SourceFileIndex: d.u2()}
+	},
+	"SourceDebugExtension": func(d *decoder) Attribute {
+		return &SourceDebugExtensionAttribute{DebugExtension: d.bytes(d.remaining())}
+	},
+	"LineNumberTable": func(d *decoder) Attribute {
+		attr := new(LineNumberTableAttribute)
+		attr.LineNumberTableLength = d.u2()
+		for i := uint16(0); d.err == nil && i < attr.LineNumberTableLength; i++ {
+			var line LineNumber
+			line.StartPC = d.u2()
+			line.LineNumber = d.u2()
+			attr.LineNumberTable = append(attr.LineNumberTable, line)
+		}
+		return attr
+	},
+	"LocalVariableTable": func(d *decoder) Attribute {
+		attr := new(LocalVariableTableAttribute)
+		attr.LocalVariableTableLength = d.u2()
+		for i := uint16(0); d.err == nil && i < attr.LocalVariableTableLength; i++ {
+			var local LocalVariable
+			local.StartPC = d.u2()
+			local.Length = d.u2()
+			local.NameIndex = d.u2()
+			local.DescriptorIndex = d.u2()
+			local.Index = d.u2()
+			attr.LocalVariableTable = append(attr.LocalVariableTable, local)
+		}
+		return attr
+	},
+	"LocalVariableTypeTable": func(d *decoder) Attribute {
+		attr := new(LocalVariableTypeTableAttribute)
+		attr.LocalVariableTypeTableLength = d.u2()
+		for i := uint16(0); d.err == nil && i < attr.LocalVariableTypeTableLength; i++ {
+			var local LocalVariableType
+			local.StartPC = d.u2()
+			local.Length = d.u2()
+			local.NameIndex = d.u2()
+			local.SignatureIndex = d.u2()
+			local.Index = d.u2()
+			attr.LocalVariableTypeTable = append(attr.LocalVariableTypeTable, local)
+		}
+		return attr
+	},
+	"Deprecated": func(d *decoder) Attribute {
+		return &DeprecatedAttribute{}
+	},
+	"MethodParameters": func(d *decoder) Attribute {
+		attr := new(MethodParametersAttribute)
+		attr.ParametersCount = d.u1()
+		for i := byte(0); d.err == nil && i < attr.ParametersCount; i++ {
+			var param MethodParameter
+			param.NameIndex = d.u2()
+			param.AccessFlags = Access(d.u2())
+			attr.Parameters = append(attr.Parameters, param)
+		}
+		return attr
+	},
+	"NestHost": func(d *decoder) Attribute {
+		return &NestHostAttribute{HostClassIndex: d.u2()}
+	},
+	"NestMembers": func(d *decoder) Attribute {
+		attr := new(NestMembersAttribute)
+		attr.NumberOfClasses = d.u2()
+		attr.Classes = d.u2s(int(attr.NumberOfClasses))
+		return attr
+	},
+	"Record": func(d *decoder) Attribute {
+		attr := new(RecordAttribute)
+		attr.ComponentsCount = d.u2()
+		for i := uint16(0); d.err == nil && i < attr.ComponentsCount; i++ {
+			var component RecordComponent
+			component.NameIndex = d.u2()
+			component.DescriptorIndex = d.u2()
+			component.AttrsCount = d.u2()
+			component.Attrs = readAttrs(d, component.AttrsCount)
+			attr.Components = append(attr.Components, component)
+		}
+		return attr
+	},
+	"StackMapTable": func(d *decoder) Attribute { return readStackMapTable(d) },
+	"PermittedSubclasses": func(d *decoder) Attribute {
+		attr := new(PermittedSubclassesAttribute)
+		attr.NumberOfClasses = d.u2()
+		attr.Classes = d.u2s(int(attr.NumberOfClasses))
+		return attr
+	},
+	"RuntimeVisibleAnnotations": func(d *decoder) Attribute {
+		attr := new(RuntimeVisibleAnnotationsAttribute)
+		attr.NumAnnotations, attr.Annotations = readAnnotations(d)
+		return attr
+	},
+	"RuntimeInvisibleAnnotations": func(d *decoder) Attribute {
+		attr := new(RuntimeInvisibleAnnotationsAttribute)
+		attr.NumAnnotations, attr.Annotations = readAnnotations(d)
+		return attr
+	},
+	"RuntimeVisibleParameterAnnotations": func(d *decoder) Attribute {
+		attr := new(RuntimeVisibleParameterAnnotationsAttribute)
+		attr.NumParameters, attr.ParameterAnnotations = readParameterAnnotations(d)
+		return attr
+	},
+	"RuntimeInvisibleParameterAnnotations": func(d *decoder) Attribute {
+		attr := new(RuntimeInvisibleParameterAnnotationsAttribute)
+		attr.NumParameters, attr.ParameterAnnotations = readParameterAnnotations(d)
+		return attr
+	},
+	"RuntimeVisibleTypeAnnotations": func(d *decoder) Attribute {
+		attr := new(RuntimeVisibleTypeAnnotationsAttribute)
+		attr.NumAnnotations, attr.Annotations = readTypeAnnotations(d)
+		return attr
+	},
+	"RuntimeInvisibleTypeAnnotations": func(d *decoder) Attribute {
+		attr := new(RuntimeInvisibleTypeAnnotationsAttribute)
+		attr.NumAnnotations, attr.Annotations = readTypeAnnotations(d)
+		return attr
+	},
+	"AnnotationDefault": func(d *decoder) Attribute {
+		return &AnnotationDefaultAttribute{DefaultValue: readElementValue(d)}
+	},
+}
+
+// DecodeAttr decodes the contents of an attribute according to its name. Attributes
+// this package does not know how to decode are returned as a *RawAttribute. Failures
+// are reported as a *ParseError with offsets relative to the attribute's data.
+func DecodeAttr(attr AttrInfo, cp []CpEntry) (Attribute, error) {
+	name := attr.Name(cp)
+	decode, ok := attrDecoders[name]
+	if !ok {
+		return &RawAttribute{Name: name, Data: attr.AttrData}, nil
+	}
+	d := &decoder{data: attr.AttrData, path: []string{name}, cp: cp}
+	decoded := decode(d)
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// Decode decodes the contents of the attribute; see DecodeAttr.
+func (i *AttrInfo) Decode(cp []CpEntry) (Attribute, error) {
+	return DecodeAttr(*i, cp)
+}
+
+func (a *RawAttribute) AttrName() string                    { return a.Name }
+func (a *CodeAttribute) AttrName() string                   { return "Code" }
+func (a *BootstrapMethodsAttribute) AttrName() string       { return "BootstrapMethods" }
+func (a *ModuleAttribute) AttrName() string                 { return "Module" }
+func (a *ModulePackagesAttribute) AttrName() string         { return "ModulePackages" }
+func (a *ModuleMainClassAttribute) AttrName() string        { return "ModuleMainClass" }
+func (a *ConstantValueAttribute) AttrName() string          { return "ConstantValue" }
+func (a *ExceptionsAttribute) AttrName() string             { return "Exceptions" }
+func (a *InnerClassesAttribute) AttrName() string           { return "InnerClasses" }
+func (a *EnclosingMethodAttribute) AttrName() string        { return "EnclosingMethod" }
+func (a *SyntheticAttribute) AttrName() string              { return "Synthetic" }
+func (a *SignatureAttribute) AttrName() string              { return "Signature" }
+func (a *SourceFileAttribute) AttrName() string             { return "SourceFile" }
+func (a *SourceDebugExtensionAttribute) AttrName() string   { return "SourceDebugExtension" }
+func (a *LineNumberTableAttribute) AttrName() string        { return "LineNumberTable" }
+func (a *LocalVariableTableAttribute) AttrName() string     { return "LocalVariableTable" }
+func (a *LocalVariableTypeTableAttribute) AttrName() string { return "LocalVariableTypeTable" }
+func (a *DeprecatedAttribute) AttrName() string             { return "Deprecated" }
+func (a *MethodParametersAttribute) AttrName() string       { return "MethodParameters" }
+func (a *NestHostAttribute) AttrName() string               { return "NestHost" }
+func (a *NestMembersAttribute) AttrName() string            { return "NestMembers" }
+func (a *RecordAttribute) AttrName() string                 { return "Record" }
+func (a *StackMapTableAttribute) AttrName() string          { return "StackMapTable" }
+func (a *PermittedSubclassesAttribute) AttrName() string    { return "PermittedSubclasses" }
+func (a *AnnotationDefaultAttribute) AttrName() string      { return "AnnotationDefault" }
+
+func (a *RuntimeVisibleAnnotationsAttribute) AttrName() string { return "RuntimeVisibleAnnotations" }
+func (a *RuntimeInvisibleAnnotationsAttribute) AttrName() string {
+	return "RuntimeInvisibleAnnotations"
+}
+func (a *RuntimeVisibleParameterAnnotationsAttribute) AttrName() string {
+	return "RuntimeVisibleParameterAnnotations"
+}
+func (a *RuntimeInvisibleParameterAnnotationsAttribute) AttrName() string {
+	return "RuntimeInvisibleParameterAnnotations"
+}
+func (a *RuntimeVisibleTypeAnnotationsAttribute) AttrName() string {
+	return "RuntimeVisibleTypeAnnotations"
+}
+func (a *RuntimeInvisibleTypeAnnotationsAttribute) AttrName() string {
+	return "RuntimeInvisibleTypeAnnotations"
+}
+
+// Value gets the constant pool entry holding the field's initial value, or nil if
+// ConstantValueIndex doesn't refer to an Integer, Float, Long, Double or String.
+func (a *ConstantValueAttribute) Value(cp []CpEntry) CpEntry {
+	switch ent := LookupEntry(cp, a.ConstantValueIndex).(type) {
+	case *CONSTANT_Integer_info, *CONSTANT_Float_info, *CONSTANT_Long_info, *CONSTANT_Double_info, *CONSTANT_String_info:
+		return ent
+	}
+	return nil
+}
+
+// ConstantValue finds the field's ConstantValue attribute and returns the constant pool
+// entry holding its value, or nil with no error if the field has none.
+func (i *FieldInfo) ConstantValue(cp []CpEntry) (CpEntry, error) {
+	attr := findAttr(i.Attrs, cp, "ConstantValue")
+	if attr == nil {
+		return nil, nil
+	}
+	decoded, err := attr.Decode(cp)
+	if err != nil {
+		return nil, err
+	}
+	constant := decoded.(*ConstantValueAttribute)
+	value := constant.Value(cp)
+	if value == nil {
+		return nil, fmt.Errorf("ConstantValue #%v is not a constant", constant.ConstantValueIndex)
+	}
+	return value, nil
+}
+
+// Names gets the internal names of the declared exception classes.
+func (a *ExceptionsAttribute) Names(cp []CpEntry) []string {
+	return cpNames(cp, a.ExceptionIndexTable)
+}
+
+// Signature gets the signature string.
+func (a *SignatureAttribute) Signature(cp []CpEntry) string {
+	return cpName(cp, a.SignatureIndex)
+}
+
+// SourceFile gets the name of the source file.
+func (a *SourceFileAttribute) SourceFile(cp []CpEntry) string {
+	return cpName(cp, a.SourceFileIndex)
+}
+
+// InnerName gets the simple name of the nested class, or "" if it is anonymous.
+func (c *InnerClass) InnerName(cp []CpEntry) string {
+	return cpName(cp, c.InnerNameIndex)
+}
+
+// InnerClassName gets the internal name of the nested class.
+func (c *InnerClass) InnerClassName(cp []CpEntry) string {
+	return cpName(cp, c.InnerClassInfoIndex)
+}
+
+// OuterClassName gets the internal name of the enclosing class, or "" if the class is
+// local or anonymous.
+func (c *InnerClass) OuterClassName(cp []CpEntry) string {
+	return cpName(cp, c.OuterClassInfoIndex)
+}
+
+// ClassName gets the internal name of the enclosing class.
+func (a *EnclosingMethodAttribute) ClassName(cp []CpEntry) string {
+	return cpName(cp, a.ClassIndex)
+}
+
+// Method gets the name and descriptor of the enclosing method, or "" if the class is not
+// enclosed by a method. A method_index that doesn't refer to a CONSTANT_NameAndType is
+// rendered as #n.
+func (a *EnclosingMethodAttribute) Method(cp []CpEntry) string {
+	if a.MethodIndex == 0 {
+		return ""
+	}
+	return nameAndTypeRepr(cp, a.MethodIndex)
+}
+
+// Name gets the name of the local variable.
+func (v *LocalVariable) Name(cp []CpEntry) string {
+	return cpName(cp, v.NameIndex)
+}
+
+// Descriptor gets the field descriptor of the local variable's type.
+func (v *LocalVariable) Descriptor(cp []CpEntry) string {
+	return cpName(cp, v.DescriptorIndex)
+}
+
+// Name gets the name of the local variable.
+func (v *LocalVariableType) Name(cp []CpEntry) string {
+	return cpName(cp, v.NameIndex)
+}
+
+// Signature gets the generic signature of the local variable's type.
+func (v *LocalVariableType) Signature(cp []CpEntry) string {
+	return cpName(cp, v.SignatureIndex)
+}
+
+// Name gets the name of the parameter, or "" if it has none.
+func (p *MethodParameter) Name(cp []CpEntry) string {
+	return cpName(cp, p.NameIndex)
+}
+
+// HostClassName gets the internal name of the nest host.
+func (a *NestHostAttribute) HostClassName(cp []CpEntry) string {
+	return cpName(cp, a.HostClassIndex)
+}
+
+// Names gets the internal names of the nest members.
+func (a *NestMembersAttribute) Names(cp []CpEntry) []string {
+	return cpNames(cp, a.Classes)
+}
+
+// Name gets the name of the record component.
+func (c *RecordComponent) Name(cp []CpEntry) string {
+	return cpName(cp, c.NameIndex)
+}
+
+// Descriptor gets the field descriptor of the record component's type.
+func (c *RecordComponent) Descriptor(cp []CpEntry) string {
+	return cpName(cp, c.DescriptorIndex)
+}
+
+// Names gets the internal names of the permitted subclasses.
+func (a *PermittedSubclassesAttribute) Names(cp []CpEntry) []string {
+	return cpNames(cp, a.Classes)
+}
+
+func cpNames(cp []CpEntry, indices []uint16) []string {
+	var names []string
+	for _, index := range indices {
+		names = append(names, cpName(cp, index))
+	}
+	return names
+}
@@ -0,0 +1,82 @@
+package classy
+
+import (
+	"fmt"
+)
+
+// BootstrapMethodsAttribute is the decoded form of a class's BootstrapMethods attribute,
+// which holds the bootstrap methods referenced by CONSTANT_Dynamic and
+// CONSTANT_InvokeDynamic entries.
+type BootstrapMethodsAttribute struct {
+	NumBootstrapMethods uint16
+	BootstrapMethods    []BootstrapMethod
+}
+
+// BootstrapMethod is a single bootstrap method specifier. BootstrapMethodRef is the
+// index of a CONSTANT_MethodHandle entry and each of BootstrapArguments is the index of
+// a loadable constant passed as a static argument.
+type BootstrapMethod struct {
+	BootstrapMethodRef    uint16
+	NumBootstrapArguments uint16
+	BootstrapArguments    []uint16
+}
+
+// ReadBootstrapMethodsAttribute decodes the contents of a BootstrapMethods attribute
+// from its raw attribute data.
+func ReadBootstrapMethodsAttribute(data []byte) (*BootstrapMethodsAttribute, error) {
+	d := &decoder{data: data, path: []string{"BootstrapMethods"}}
+	attr := readBootstrapMethods(d)
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return attr, nil
+}
+
+func readBootstrapMethods(d *decoder) *BootstrapMethodsAttribute {
+	attr := new(BootstrapMethodsAttribute)
+	attr.NumBootstrapMethods = d.u2()
+	for i := uint16(0); d.err == nil && i < attr.NumBootstrapMethods; i++ {
+		var method BootstrapMethod
+		method.BootstrapMethodRef = d.u2()
+		method.NumBootstrapArguments = d.u2()
+		method.BootstrapArguments = d.u2s(int(method.NumBootstrapArguments))
+		attr.BootstrapMethods = append(attr.BootstrapMethods, method)
+	}
+	return attr
+}
+
+// BootstrapMethods finds and decodes the class's BootstrapMethods attribute, returning
+// nil with no error if the class has none.
+func (cf *ClassFile) BootstrapMethods() (*BootstrapMethodsAttribute, error) {
+	attr := findAttr(cf.Attrs, cf.ConstantPool, "BootstrapMethods")
+	if attr == nil {
+		return nil, nil
+	}
+	return ReadBootstrapMethodsAttribute(attr.AttrData)
+}
+
+// BootstrapMethodFor resolves the bootstrap method of a CONSTANT_Dynamic or
+// CONSTANT_InvokeDynamic entry through the class's BootstrapMethods attribute.
+func (cf *ClassFile) BootstrapMethodFor(ent CpEntry) (*BootstrapMethod, error) {
+	var index uint16
+	switch info := ent.(type) {
+	case *CONSTANT_Dynamic_info:
+		index = info.BootstrapMethodAttrIndex
+	case *CONSTANT_InvokeDynamic_info:
+		index = info.BootstrapMethodAttrIndex
+	default:
+		return nil, fmt.Errorf("%v entries have no bootstrap method", ent.StringTag())
+	}
+
+	attr, err := cf.BootstrapMethods()
+	if err != nil {
+		return nil, err
+	}
+	if attr == nil {
+		return nil, fmt.Errorf("%v refers to bootstrap method %v, but the class has no BootstrapMethods attribute", ent.StringTag(), index)
+	}
+	if int(index) >= len(attr.BootstrapMethods) {
+		return nil, fmt.Errorf("%v refers to bootstrap method %v, but only %v are defined", ent.StringTag(), index, len(attr.BootstrapMethods))
+	}
+	return &attr.BootstrapMethods[index], nil
+}
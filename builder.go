@@ -0,0 +1,689 @@
+package classy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// ConstantPoolBuilder builds a constant pool, adding each distinct constant only once.
+// Every method returns the index of the constant, which is 0 once the pool is full;
+// Err reports that.
+type ConstantPoolBuilder struct {
+	entries []CpEntry
+	indexes map[string]uint16
+	err     error
+}
+
+// NewConstantPoolBuilder starts a constant pool builder from the entries of an existing
+// pool, which keep their indexes, so that constants can be added to a parsed class.
+// Pass nil to start an empty pool.
+func NewConstantPoolBuilder(existing []CpEntry) *ConstantPoolBuilder {
+	b := &ConstantPoolBuilder{indexes: map[string]uint16{}}
+	for _, entry := range existing {
+		if entry != nil {
+			if _, ok := b.indexes[b.key(entry)]; !ok {
+				b.indexes[b.key(entry)] = uint16(len(b.entries) + 1)
+			}
+		}
+		b.entries = append(b.entries, entry)
+	}
+	return b
+}
+
+// Entries gets the constant pool built so far, in the form of ClassFile.ConstantPool.
+func (b *ConstantPoolBuilder) Entries() []CpEntry {
+	return b.entries
+}
+
+// Err reports whether the pool ran out of indexes.
+func (b *ConstantPoolBuilder) Err() error {
+	return b.err
+}
+
+// key identifies a constant by its encoding, which holds its tag and contents.
+func (b *ConstantPoolBuilder) key(entry CpEntry) string {
+	var buf bytes.Buffer
+	if err := writeCpEntry(&buf, entry); err != nil {
+		return fmt.Sprintf("%p", entry)
+	}
+	return buf.String()
+}
+
+// add finds or adds a constant.
+func (b *ConstantPoolBuilder) add(entry CpEntry) uint16 {
+	key := b.key(entry)
+	if index, ok := b.indexes[key]; ok {
+		return index
+	}
+	width := 1
+	switch entry.(type) {
+	case *CONSTANT_Long_info, *CONSTANT_Double_info:
+		width = 2
+	}
+	if len(b.entries)+width >= math.MaxUint16 {
+		if b.err == nil {
+			b.err = fmt.Errorf("Too many constant pool entries")
+		}
+		return 0
+	}
+	b.entries = append(b.entries, entry)
+	if width == 2 {
+		b.entries = append(b.entries, nil)
+	}
+	index := uint16(len(b.entries) - width + 1)
+	b.indexes[key] = index
+	return index
+}
+
+// Utf8 adds a CONSTANT_Utf8, encoded as modified UTF-8.
+func (b *ConstantPoolBuilder) Utf8(s string) uint16 {
+	bytes := EncodeModifiedUTF8(s)
+	if len(bytes) > math.MaxUint16 {
+		if b.err == nil {
+			b.err = fmt.Errorf("Utf8 constant of %v bytes is too long", len(bytes))
+		}
+		return 0
+	}
+	return b.add(&CONSTANT_Utf8_info{Tag: CONSTANT_Utf8, Length: uint16(len(bytes)), Bytes: bytes})
+}
+
+// Integer adds a CONSTANT_Integer.
+func (b *ConstantPoolBuilder) Integer(v int32) uint16 {
+	return b.add(&CONSTANT_Integer_info{Tag: CONSTANT_Integer, Bytes: uint32(v)})
+}
+
+// Float adds a CONSTANT_Float.
+func (b *ConstantPoolBuilder) Float(v float32) uint16 {
+	return b.add(&CONSTANT_Float_info{Tag: CONSTANT_Float, Bytes: math.Float32bits(v)})
+}
+
+// Long adds a CONSTANT_Long.
+func (b *ConstantPoolBuilder) Long(v int64) uint16 {
+	return b.add(&CONSTANT_Long_info{Tag: CONSTANT_Long, HighBytes: uint32(uint64(v) >> 32), LowBytes: uint32(v)})
+}
+
+// Double adds a CONSTANT_Double.
+func (b *ConstantPoolBuilder) Double(v float64) uint16 {
+	bits := math.Float64bits(v)
+	return b.add(&CONSTANT_Double_info{Tag: CONSTANT_Double, HighBytes: uint32(bits >> 32), LowBytes: uint32(bits)})
+}
+
+// Class adds a CONSTANT_Class for a class in internal form, e.g. "java/lang/String", or
+// an array descriptor.
+func (b *ConstantPoolBuilder) Class(name string) uint16 {
+	return b.add(&CONSTANT_Class_info{Tag: CONSTANT_Class, NameIndex: b.Utf8(name)})
+}
+
+// String adds a CONSTANT_String.
+func (b *ConstantPoolBuilder) String(s string) uint16 {
+	return b.add(&CONSTANT_String_info{Tag: CONSTANT_String, StringIndex: b.Utf8(s)})
+}
+
+// NameAndType adds a CONSTANT_NameAndType.
+func (b *ConstantPoolBuilder) NameAndType(name, descriptor string) uint16 {
+	return b.add(&CONSTANT_NameAndType_info{Tag: CONSTANT_NameAndType, NameIndex: b.Utf8(name), DescriptorIndex: b.Utf8(descriptor)})
+}
+
+// Fieldref adds a CONSTANT_Fieldref for a field of the class owner.
+func (b *ConstantPoolBuilder) Fieldref(owner, name, descriptor string) uint16 {
+	return b.add(&CONSTANT_Fieldref_info{Tag: CONSTANT_Fieldref, ClassIndex: b.Class(owner), NameAndTypeIndex: b.NameAndType(name, descriptor)})
+}
+
+// Methodref adds a CONSTANT_Methodref for a method of the class owner.
+func (b *ConstantPoolBuilder) Methodref(owner, name, descriptor string) uint16 {
+	return b.add(&CONSTANT_Methodref_info{Tag: CONSTANT_Methodref, ClassIndex: b.Class(owner), NameAndTypeIndex: b.NameAndType(name, descriptor)})
+}
+
+// InterfaceMethodref adds a CONSTANT_InterfaceMethodref for a method of the interface
+// owner.
+func (b *ConstantPoolBuilder) InterfaceMethodref(owner, name, descriptor string) uint16 {
+	return b.add(&CONSTANT_InterfaceMethodref_info{Tag: CONSTANT_InterfaceMethodref, ClassIndex: b.Class(owner), NameAndTypeIndex: b.NameAndType(name, descriptor)})
+}
+
+// MethodHandle adds a CONSTANT_MethodHandle of one of the Ref kinds, referring to the
+// field or method reference at index ref.
+func (b *ConstantPoolBuilder) MethodHandle(kind byte, ref uint16) uint16 {
+	return b.add(&CONSTANT_MethodHandle_info{Tag: CONSTANT_MethodHandle, ReferenceKind: kind, ReferenceIndex: ref})
+}
+
+// MethodType adds a CONSTANT_MethodType.
+func (b *ConstantPoolBuilder) MethodType(descriptor string) uint16 {
+	return b.add(&CONSTANT_MethodType_info{Tag: CONSTANT_MethodType, DescriptorIndex: b.Utf8(descriptor)})
+}
+
+// Dynamic adds a CONSTANT_Dynamic computed by the bootstrap method at the given index
+// of the BootstrapMethods attribute.
+func (b *ConstantPoolBuilder) Dynamic(bootstrap uint16, name, descriptor string) uint16 {
+	return b.add(&CONSTANT_Dynamic_info{Tag: CONSTANT_Dynamic, BootstrapMethodAttrIndex: bootstrap, NameAndTypeIndex: b.NameAndType(name, descriptor)})
+}
+
+// InvokeDynamic adds a CONSTANT_InvokeDynamic whose call site is linked by the bootstrap
+// method at the given index of the BootstrapMethods attribute.
+func (b *ConstantPoolBuilder) InvokeDynamic(bootstrap uint16, name, descriptor string) uint16 {
+	return b.add(&CONSTANT_InvokeDynamic_info{Tag: CONSTANT_InvokeDynamic, BootstrapMethodAttrIndex: bootstrap, NameAndTypeIndex: b.NameAndType(name, descriptor)})
+}
+
+// Module adds a CONSTANT_Module.
+func (b *ConstantPoolBuilder) Module(name string) uint16 {
+	return b.add(&CONSTANT_Module_info{Tag: CONSTANT_Module, NameIndex: b.Utf8(name)})
+}
+
+// Package adds a CONSTANT_Package for a package in internal form, e.g. "java/lang".
+func (b *ConstantPoolBuilder) Package(name string) uint16 {
+	return b.add(&CONSTANT_Package_info{Tag: CONSTANT_Package, NameIndex: b.Utf8(name)})
+}
+
+// ClassBuilder builds a ClassFile from scratch. Constants are added to the pool as they
+// are needed, and methods are given their Code attribute, with max_stack and max_locals
+// worked out, when the class is built. Mistakes are kept until Build, which reports the
+// first.
+type ClassBuilder struct {
+	cp               *ConstantPoolBuilder
+	cf               ClassFile
+	fields           []*FieldBuilder
+	methods          []*MethodBuilder
+	bootstrapMethods []BootstrapMethod
+	err              error
+}
+
+// NewClassBuilder starts building a class with the given access flags and internal
+// name. superName is "" only for java/lang/Object and modules. The class file version
+// is 49.0, which doesn't need StackMapTable attributes; see SetVersion.
+func NewClassBuilder(access Access, name, superName string, interfaces ...string) *ClassBuilder {
+	b := &ClassBuilder{cp: NewConstantPoolBuilder(nil)}
+	b.cf.Magic = 0xCAFEBABE
+	b.cf.MajorVersion = 49
+	b.cf.AccessFlags = access
+	b.cf.ThisClass = b.cp.Class(name)
+	if superName != "" {
+		b.cf.SuperClass = b.cp.Class(superName)
+	}
+	for _, iface := range interfaces {
+		b.cf.Interfaces = append(b.cf.Interfaces, b.cp.Class(iface))
+	}
+	return b
+}
+
+// ConstantPool gets the builder of the class's constant pool, for constants such as
+// those loaded by MethodBuilder.Ldc.
+func (b *ClassBuilder) ConstantPool() *ConstantPoolBuilder {
+	return b.cp
+}
+
+// SetVersion sets the class file version. Code in classes of version 50 and above
+// must be given a StackMapTable for the JVM to verify it, which ClassFile.ComputeFrames
+// can add to the built class.
+func (b *ClassBuilder) SetVersion(major, minor uint16) {
+	b.cf.MajorVersion, b.cf.MinorVersion = major, minor
+}
+
+// SetSourceFile adds a SourceFile attribute.
+func (b *ClassBuilder) SetSourceFile(name string) {
+	b.AddAttribute("SourceFile", u2Bytes(b.cp.Utf8(name)))
+}
+
+// AddAttribute adds an attribute to the class, given its contents.
+func (b *ClassBuilder) AddAttribute(name string, data []byte) {
+	b.cf.Attrs = append(b.cf.Attrs, AttrInfo{NameIndex: b.cp.Utf8(name), AttrData: data})
+}
+
+// AddBootstrapMethod adds an entry to the class's BootstrapMethods attribute and returns
+// its index, for ConstantPoolBuilder.InvokeDynamic and Dynamic. handle is the index of
+// a CONSTANT_MethodHandle and args are the indexes of its static arguments.
+func (b *ClassBuilder) AddBootstrapMethod(handle uint16, args ...uint16) uint16 {
+	b.bootstrapMethods = append(b.bootstrapMethods, BootstrapMethod{
+		BootstrapMethodRef:    handle,
+		NumBootstrapArguments: uint16(len(args)),
+		BootstrapArguments:    args,
+	})
+	return uint16(len(b.bootstrapMethods) - 1)
+}
+
+// FieldBuilder builds a field of a class.
+type FieldBuilder struct {
+	class *ClassBuilder
+	info  FieldInfo
+}
+
+// AddField adds a field to the class.
+func (b *ClassBuilder) AddField(access Access, name, descriptor string) *FieldBuilder {
+	f := &FieldBuilder{class: b}
+	f.info.AccessFlags = access
+	f.info.NameIndex = b.cp.Utf8(name)
+	f.info.DescriptorIndex = b.cp.Utf8(descriptor)
+	b.fields = append(b.fields, f)
+	return f
+}
+
+// SetConstantValue adds a ConstantValue attribute, given the index of the constant.
+func (f *FieldBuilder) SetConstantValue(index uint16) {
+	f.AddAttribute("ConstantValue", u2Bytes(index))
+}
+
+// AddAttribute adds an attribute to the field, given its contents.
+func (f *FieldBuilder) AddAttribute(name string, data []byte) {
+	f.info.Attrs = append(f.info.Attrs, AttrInfo{NameIndex: f.class.cp.Utf8(name), AttrData: data})
+}
+
+// Build finishes the class. The builder is left as it was, so Build can be called
+// again, building the class with anything added since.
+func (b *ClassBuilder) Build() (*ClassFile, error) {
+	cf := b.cf
+	cf.Attrs = append([]AttrInfo(nil), b.cf.Attrs...)
+	for _, f := range b.fields {
+		cf.Fields = append(cf.Fields, f.info)
+	}
+	for _, m := range b.methods {
+		method, err := m.build()
+		if err != nil {
+			return nil, err
+		}
+		cf.Methods = append(cf.Methods, method)
+	}
+	if len(b.bootstrapMethods) > 0 {
+		values := []uint16{uint16(len(b.bootstrapMethods))}
+		for _, method := range b.bootstrapMethods {
+			values = append(values, method.BootstrapMethodRef, method.NumBootstrapArguments)
+			values = append(values, method.BootstrapArguments...)
+		}
+		cf.Attrs = append(cf.Attrs, AttrInfo{NameIndex: b.cp.Utf8("BootstrapMethods"), AttrData: u2Bytes(values...)})
+	}
+
+	if b.err != nil {
+		return nil, b.err
+	}
+	if err := b.cp.Err(); err != nil {
+		return nil, err
+	}
+	cf.ConstantPool = b.cp.Entries()
+	if err := cf.UpdateCounts(); err != nil {
+		return nil, err
+	}
+	return &cf, nil
+}
+
+func u2Bytes(values ...uint16) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, values)
+	return buf.Bytes()
+}
+
+// Label marks a position in a method's code, for branches, exception handlers and line
+// numbers. Labels are created by MethodBuilder.NewLabel and placed with Mark.
+type Label struct {
+	// insn is the index of the instruction the label is placed at
+	insn int
+}
+
+// labelRef is an operand that is given by a label: the Target of an instruction, the
+// Default of a switch (slot -1) or one of its Targets.
+type labelRef struct {
+	insn  int
+	slot  int
+	label *Label
+}
+
+const (
+	labelTarget  = -2
+	labelDefault = -1
+)
+
+// MethodBuilder builds a method of a class. The Emit methods append instructions to its
+// code; a method that isn't abstract or native must have some.
+type MethodBuilder struct {
+	class      *ClassBuilder
+	access     Access
+	name       string
+	descriptor string
+	info       MethodInfo
+
+	insns      []Instruction
+	size       int
+	labels     []*Label
+	refs       []labelRef
+	handlers   []handlerLabels
+	lines      []lineLabel
+	exceptions []uint16
+	codeAttrs  []AttrInfo
+}
+
+type handlerLabels struct {
+	start, end, handler *Label
+	catchType           uint16
+}
+
+type lineLabel struct {
+	label *Label
+	line  uint16
+}
+
+// AddMethod adds a method to the class.
+func (b *ClassBuilder) AddMethod(access Access, name, descriptor string) *MethodBuilder {
+	m := &MethodBuilder{class: b, access: access, name: name, descriptor: descriptor}
+	m.info.AccessFlags = access
+	m.info.NameIndex = b.cp.Utf8(name)
+	m.info.DescriptorIndex = b.cp.Utf8(descriptor)
+	b.methods = append(b.methods, m)
+	return m
+}
+
+func (m *MethodBuilder) fail(format string, args ...interface{}) {
+	if m.class.err == nil {
+		m.class.err = fmt.Errorf("method %v%v: %v", m.name, m.descriptor, fmt.Sprintf(format, args...))
+	}
+}
+
+// AddException adds a class to the method's Exceptions attribute.
+func (m *MethodBuilder) AddException(class string) {
+	m.exceptions = append(m.exceptions, m.class.cp.Class(class))
+}
+
+// AddAttribute adds an attribute to the method, given its contents.
+func (m *MethodBuilder) AddAttribute(name string, data []byte) {
+	m.info.Attrs = append(m.info.Attrs, AttrInfo{NameIndex: m.class.cp.Utf8(name), AttrData: data})
+}
+
+// AddCodeAttribute adds an attribute to the method's Code attribute, given its
+// contents.
+func (m *MethodBuilder) AddCodeAttribute(name string, data []byte) {
+	m.codeAttrs = append(m.codeAttrs, AttrInfo{NameIndex: m.class.cp.Utf8(name), AttrData: data})
+}
+
+// NewLabel creates a label, to be placed with Mark.
+func (m *MethodBuilder) NewLabel() *Label {
+	label := &Label{insn: -1}
+	m.labels = append(m.labels, label)
+	return label
+}
+
+// Mark places a label at the next instruction to be emitted.
+func (m *MethodBuilder) Mark(label *Label) {
+	if label.insn >= 0 {
+		m.fail("label marked twice")
+		return
+	}
+	label.insn = len(m.insns)
+}
+
+// TryCatch adds an exception handler for the code from start up to end, catching the
+// class catchType, or everything if it is "". Handlers are tried in the order they are
+// added.
+func (m *MethodBuilder) TryCatch(start, end, handler *Label, catchType string) {
+	var index uint16
+	if catchType != "" {
+		index = m.class.cp.Class(catchType)
+	}
+	m.handlers = append(m.handlers, handlerLabels{start, end, handler, index})
+}
+
+// LineNumber records that the next instruction to be emitted comes from the given
+// source line.
+func (m *MethodBuilder) LineNumber(line uint16) {
+	label := m.NewLabel()
+	m.Mark(label)
+	m.lines = append(m.lines, lineLabel{label, line})
+}
+
+func (m *MethodBuilder) emit(ins Instruction, kinds ...OperandKind) int {
+	ok := false
+	for _, kind := range kinds {
+		ok = ok || ins.Opcode.Operands() == kind
+	}
+	if !ok {
+		m.fail("%v can't be emitted with these operands", ins.Opcode)
+		return -1
+	}
+	ins.Offset = m.size
+	m.size += ins.Size()
+	m.insns = append(m.insns, ins)
+	return len(m.insns) - 1
+}
+
+func (m *MethodBuilder) labelRef(insn, slot int, label *Label) {
+	if insn >= 0 {
+		m.refs = append(m.refs, labelRef{insn, slot, label})
+	}
+}
+
+// Insn emits an instruction without operands, such as iadd or areturn.
+func (m *MethodBuilder) Insn(op Opcode) {
+	m.emit(Instruction{Opcode: op}, OperandNone)
+}
+
+// VarInsn emits an instruction that loads, stores or returns to a local variable. Loads
+// and stores of slots 0 to 3 use the short forms, such as iload_0, and others are
+// widened if the index needs it.
+func (m *MethodBuilder) VarInsn(op Opcode, index uint16) {
+	if index <= 3 {
+		switch {
+		case op >= OpIload && op <= OpAload:
+			m.Insn(OpIload0 + (op-OpIload)*4 + Opcode(index))
+			return
+		case op >= OpIstore && op <= OpAstore:
+			m.Insn(OpIstore0 + (op-OpIstore)*4 + Opcode(index))
+			return
+		}
+	}
+	m.emit(Instruction{Opcode: op, Index: index, Wide: index > math.MaxUint8}, OperandLocal)
+}
+
+// IincInsn emits iinc, widening it if the index or increment needs it.
+func (m *MethodBuilder) IincInsn(index uint16, increment int16) {
+	wide := index > math.MaxUint8 || increment < math.MinInt8 || increment > math.MaxInt8
+	m.emit(Instruction{Opcode: OpIinc, Index: index, Value: int32(increment), Wide: wide}, OperandIinc)
+}
+
+// IntInsn emits bipush, sipush, or newarray with one of the T array types.
+func (m *MethodBuilder) IntInsn(op Opcode, value int32) {
+	m.emit(Instruction{Opcode: op, Value: value}, OperandByte, OperandShort, OperandAtype)
+}
+
+// TypeInsn emits new, anewarray, checkcast or instanceof for a class in internal form or
+// an array descriptor.
+func (m *MethodBuilder) TypeInsn(op Opcode, class string) {
+	switch op {
+	case OpNew, OpAnewarray, OpCheckcast, OpInstanceof:
+		m.emit(Instruction{Opcode: op, Index: m.class.cp.Class(class)}, OperandCp)
+	default:
+		m.fail("%v doesn't take a class", op)
+	}
+}
+
+// FieldInsn emits getstatic, putstatic, getfield or putfield.
+func (m *MethodBuilder) FieldInsn(op Opcode, owner, name, descriptor string) {
+	switch op {
+	case OpGetstatic, OpPutstatic, OpGetfield, OpPutfield:
+		m.emit(Instruction{Opcode: op, Index: m.class.cp.Fieldref(owner, name, descriptor)}, OperandCp)
+	default:
+		m.fail("%v doesn't take a field", op)
+	}
+}
+
+// MethodInsn emits invokevirtual, invokespecial, invokestatic or invokeinterface. isInterface
+// says whether owner is an interface, which is always so for invokeinterface.
+func (m *MethodBuilder) MethodInsn(op Opcode, owner, name, descriptor string, isInterface bool) {
+	cp := m.class.cp
+	switch op {
+	case OpInvokevirtual, OpInvokespecial, OpInvokestatic:
+		index := cp.Methodref(owner, name, descriptor)
+		if isInterface {
+			index = cp.InterfaceMethodref(owner, name, descriptor)
+		}
+		m.emit(Instruction{Opcode: op, Index: index}, OperandCp)
+	case OpInvokeinterface:
+		desc, err := ParseMethodDescriptor(descriptor)
+		if err != nil {
+			m.fail("%v", err)
+			return
+		}
+		index := cp.InterfaceMethodref(owner, name, descriptor)
+		m.emit(Instruction{Opcode: op, Index: index, Value: int32(desc.ParamSlots() + 1)}, OperandInterface)
+	default:
+		m.fail("%v doesn't take a method", op)
+	}
+}
+
+// InvokeDynamicInsn emits invokedynamic for a call site linked by the bootstrap method at
+// the given index, as returned by ClassBuilder.AddBootstrapMethod.
+func (m *MethodBuilder) InvokeDynamicInsn(name, descriptor string, bootstrap uint16) {
+	index := m.class.cp.InvokeDynamic(bootstrap, name, descriptor)
+	m.emit(Instruction{Opcode: OpInvokedynamic, Index: index}, OperandDynamic)
+}
+
+// LdcInsn emits ldc, ldc_w or ldc2_w, whichever suits the constant at index.
+func (m *MethodBuilder) LdcInsn(index uint16) {
+	op := OpLdc
+	if slots, err := constantSlots(m.class.cp.Entries(), index, m.size); err != nil {
+		m.fail("%v", err)
+		return
+	} else if slots == 2 {
+		op = OpLdc2W
+	} else if index > math.MaxUint8 {
+		op = OpLdcW
+	}
+	m.emit(Instruction{Opcode: op, Index: index}, OperandCpByte, OperandCp)
+}
+
+// JumpInsn emits a branch to label, which is placed with Mark before or after. A goto or
+// jsr whose label is out of reach of a 16-bit offset is widened to goto_w or jsr_w, and
+// such an if becomes the opposite if, branching over a goto_w to the label.
+func (m *MethodBuilder) JumpInsn(op Opcode, label *Label) {
+	m.labelRef(m.emit(Instruction{Opcode: op}, OperandBranch, OperandBranchWide), labelTarget, label)
+}
+
+// TableSwitchInsn emits tableswitch, jumping to targets[i] for the key low+i and to dflt
+// for any other.
+func (m *MethodBuilder) TableSwitchInsn(low int32, dflt *Label, targets ...*Label) {
+	if len(targets) == 0 || int64(low)+int64(len(targets))-1 > math.MaxInt32 {
+		m.fail("tableswitch needs from 1 to %v targets", int64(math.MaxInt32)-int64(low)+1)
+		return
+	}
+	sw := &Switch{Low: low, High: low + int32(len(targets)-1), Targets: make([]int, len(targets))}
+	m.switchInsn(OpTableswitch, sw, dflt, targets)
+}
+
+// LookupSwitchInsn emits lookupswitch, jumping to targets[i] for keys[i] and to dflt for
+// any other key. The keys may be in any order.
+func (m *MethodBuilder) LookupSwitchInsn(dflt *Label, keys []int32, targets []*Label) {
+	if len(keys) != len(targets) {
+		m.fail("lookupswitch has %v keys and %v targets", len(keys), len(targets))
+		return
+	}
+	// The JVM needs the keys sorted
+	keys = append([]int32(nil), keys...)
+	targets = append([]*Label(nil), targets...)
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j] < keys[j-1]; j-- {
+			keys[j], keys[j-1] = keys[j-1], keys[j]
+			targets[j], targets[j-1] = targets[j-1], targets[j]
+		}
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i] == keys[i-1] {
+			m.fail("lookupswitch has the key %v twice", keys[i])
+			return
+		}
+	}
+	sw := &Switch{Keys: keys, Targets: make([]int, len(targets))}
+	m.switchInsn(OpLookupswitch, sw, dflt, targets)
+}
+
+func (m *MethodBuilder) switchInsn(op Opcode, sw *Switch, dflt *Label, targets []*Label) {
+	insn := m.emit(Instruction{Opcode: op, Switch: sw}, OperandTableswitch, OperandLookupswitch)
+	m.labelRef(insn, labelDefault, dflt)
+	for i, target := range targets {
+		m.labelRef(insn, i, target)
+	}
+}
+
+// MultiANewArrayInsn emits multianewarray for an array descriptor such as "[[I".
+func (m *MethodBuilder) MultiANewArrayInsn(descriptor string, dimensions byte) {
+	m.emit(Instruction{Opcode: OpMultianewarray, Index: m.class.cp.Class(descriptor), Value: int32(dimensions)}, OperandMultiArray)
+}
+
+// index resolves a label to the index of its instruction once all the code has been
+// emitted.
+func (m *MethodBuilder) index(label *Label) int {
+	if label.insn < 0 {
+		m.fail("label used but never marked")
+		return 0
+	}
+	return label.insn
+}
+
+// build finishes the method, adding its Code and Exceptions attributes.
+func (m *MethodBuilder) build() (MethodInfo, error) {
+	cp := m.class.cp
+	info := m.info
+	info.Attrs = append([]AttrInfo(nil), m.info.Attrs...)
+	if len(m.exceptions) > 0 {
+		values := append([]uint16{uint16(len(m.exceptions))}, m.exceptions...)
+		info.Attrs = append(info.Attrs, AttrInfo{NameIndex: cp.Utf8("Exceptions"), AttrData: u2Bytes(values...)})
+	}
+	if m.access&(AccAbstract|AccNative) != 0 {
+		if len(m.insns) > 0 {
+			m.fail("abstract and native methods can't have code")
+		}
+		return info, m.class.err
+	}
+	if len(m.insns) == 0 {
+		m.fail("no code")
+		return info, m.class.err
+	}
+
+	for _, ref := range m.refs {
+		ins := &m.insns[ref.insn]
+		switch ref.slot {
+		case labelTarget:
+			ins.Target = m.index(ref.label)
+		case labelDefault:
+			ins.Switch.Default = m.index(ref.label)
+		default:
+			ins.Switch.Targets[ref.slot] = m.index(ref.label)
+		}
+	}
+	for _, h := range m.handlers {
+		m.index(h.start)
+		m.index(h.end)
+		m.index(h.handler)
+	}
+	for _, line := range m.lines {
+		m.index(line.label)
+	}
+	if m.class.err != nil {
+		return info, m.class.err
+	}
+
+	code := &CodeAttribute{Attrs: append([]AttrInfo(nil), m.codeAttrs...)}
+	var offsets []int
+	var err error
+	if code.Code, offsets, err = layoutInstructions(m.insns); err != nil {
+		return info, fmt.Errorf("method %v%v: %v", m.name, m.descriptor, err)
+	}
+	for _, h := range m.handlers {
+		code.ExceptionTable = append(code.ExceptionTable, ExceptionTableEntry{
+			StartPC:   uint16(offsets[h.start.insn]),
+			EndPC:     uint16(offsets[h.end.insn]),
+			HandlerPC: uint16(offsets[h.handler.insn]),
+			CatchType: h.catchType,
+		})
+	}
+	if len(m.lines) > 0 {
+		values := []uint16{uint16(len(m.lines))}
+		for _, line := range m.lines {
+			values = append(values, uint16(offsets[line.label.insn]), line.line)
+		}
+		code.Attrs = append(code.Attrs, AttrInfo{NameIndex: cp.Utf8("LineNumberTable"), AttrData: u2Bytes(values...)})
+	}
+	if err := code.ComputeMaxs(cp.Entries(), m.access, m.descriptor); err != nil {
+		return info, fmt.Errorf("method %v%v: %v", m.name, m.descriptor, err)
+	}
+	data, err := WriteCodeAttribute(code)
+	if err != nil {
+		return info, fmt.Errorf("method %v%v: %v", m.name, m.descriptor, err)
+	}
+	info.Attrs = append([]AttrInfo{{NameIndex: cp.Utf8("Code"), AttrData: data}}, info.Attrs...)
+	return info, nil
+}
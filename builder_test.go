@@ -0,0 +1,244 @@
+package classy
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// methodCode finds a method of cf by name and decodes its code.
+func methodCode(t *testing.T, cf *ClassFile, name string) (*CodeAttribute, []Instruction) {
+	t.Helper()
+	for i := range cf.Methods {
+		if cf.Methods[i].Name(cf.ConstantPool) != name {
+			continue
+		}
+		code, err := cf.Methods[i].Code(cf.ConstantPool)
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if code == nil {
+			t.Fatalf("%v has no code", name)
+		}
+		insns, err := code.Instructions()
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		return code, insns
+	}
+	t.Fatalf("no method %v", name)
+	return nil, nil
+}
+
+// reprs renders instructions one per line as offset: instruction, leaving out nops.
+func reprs(cp []CpEntry, insns []Instruction) string {
+	var lines []string
+	for _, ins := range insns {
+		if ins.Opcode != OpNop {
+			lines = append(lines, strconv.Itoa(ins.Offset)+": "+ins.Repr(cp))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestConstantPoolBuilder(t *testing.T) {
+	b := NewConstantPoolBuilder(nil)
+	utf8 := b.Utf8("a")
+	if again := b.Utf8("a"); again != utf8 {
+		t.Errorf("Utf8 added twice, at #%v and #%v", utf8, again)
+	}
+	long := b.Long(1)
+	if next := b.Integer(1); next != long+2 {
+		t.Errorf("Integer after a Long at #%v is at #%v, want #%v", long, next, long+2)
+	}
+	method := b.Methodref("a/B", "c", "()V")
+	if b.Class("a/B") > method || b.NameAndType("c", "()V") > method {
+		t.Error("Methodref added its class and NameAndType again")
+	}
+	if b.Methodref("a/B", "c", "()V") != method {
+		t.Error("Methodref added twice")
+	}
+	if b.InterfaceMethodref("a/B", "c", "()V") == method {
+		t.Error("InterfaceMethodref shares the Methodref's index")
+	}
+	cp := b.Entries()
+	if got := cp[method-1].Repr(cp); got != "a/B.c:()V" {
+		t.Errorf("Methodref is %v", got)
+	}
+	if err := b.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMethodBuilderVarInsn(t *testing.T) {
+	// Stores are given something to store
+	tests := []struct {
+		push  Opcode
+		op    Opcode
+		index uint16
+		want  string
+	}{
+		{OpNop, OpIload, 0, "iload_0"},
+		{OpNop, OpLload, 1, "lload_1"},
+		{OpNop, OpAload, 3, "aload_3"},
+		{OpAconstNull, OpAstore, 2, "astore_2"},
+		{OpDconst0, OpDstore, 3, "dstore_3"},
+		{OpNop, OpIload, 4, "iload 4"},
+		{OpFconst0, OpFstore, 255, "fstore 255"},
+		{OpNop, OpAload, 256, "wide aload 256"},
+		{OpNop, OpRet, 0, "ret 0"},
+	}
+	for _, test := range tests {
+		b := NewClassBuilder(AccPublic, "T", "java/lang/Object")
+		m := b.AddMethod(AccPublic|AccStatic, "f", "()V")
+		m.Insn(test.push)
+		m.VarInsn(test.op, test.index)
+		m.Insn(OpReturn)
+		cf, err := b.Build()
+		if err != nil {
+			t.Errorf("%v %v: %v", test.op, test.index, err)
+			continue
+		}
+		_, insns := methodCode(t, cf, "f")
+		if got := insns[1].Repr(cf.ConstantPool); got != test.want {
+			t.Errorf("VarInsn(%v, %v) emitted %v, want %v", test.op, test.index, got, test.want)
+		}
+	}
+}
+
+func TestMethodBuilderJumpInsn(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(m *MethodBuilder)
+		want  string
+	}{
+		{"near", func(m *MethodBuilder) {
+			end := m.NewLabel()
+			m.VarInsn(OpIload, 0)
+			m.JumpInsn(OpIfeq, end)
+			m.Insn(OpNop)
+			m.Mark(end)
+			m.Insn(OpReturn)
+		}, "0: iload_0\n1: ifeq 5\n5: return"},
+		{"far if", func(m *MethodBuilder) {
+			end := m.NewLabel()
+			m.VarInsn(OpIload, 0)
+			m.JumpInsn(OpIfeq, end)
+			nops(m, 40000)
+			m.Mark(end)
+			m.Insn(OpReturn)
+		}, "0: iload_0\n1: ifne 9\n4: goto_w 40009\n40009: return"},
+		{"far goto back", func(m *MethodBuilder) {
+			top := m.NewLabel()
+			m.Mark(top)
+			nops(m, 40000)
+			m.VarInsn(OpIload, 0)
+			m.JumpInsn(OpIfnull, top)
+			m.Insn(OpReturn)
+		}, "40000: iload_0\n40001: ifnonnull 40009\n40004: goto_w 0\n40009: return"},
+		{"far switch", func(m *MethodBuilder) {
+			end := m.NewLabel()
+			m.VarInsn(OpIload, 0)
+			m.TableSwitchInsn(0, end, end)
+			nops(m, 40000)
+			m.Mark(end)
+			m.Insn(OpReturn)
+		}, "0: iload_0\n1: tableswitch { 0: 40020, default: 40020 }\n40020: return"},
+	}
+	for _, test := range tests {
+		b := NewClassBuilder(AccPublic, "T", "java/lang/Object")
+		test.build(b.AddMethod(AccPublic|AccStatic, "f", "(I)V"))
+		cf, err := b.Build()
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		_, insns := methodCode(t, cf, "f")
+		if got := reprs(cf.ConstantPool, insns); got != test.want {
+			t.Errorf("%v: got\n%v\nwant\n%v", test.name, got, test.want)
+		}
+	}
+}
+
+func nops(m *MethodBuilder, n int) {
+	for i := 0; i < n; i++ {
+		m.Insn(OpNop)
+	}
+}
+
+func TestClassBuilderBuildTwice(t *testing.T) {
+	b := NewClassBuilder(AccPublic|AccSuper, "T", "java/lang/Object")
+	cp := b.ConstantPool()
+	b.AddBootstrapMethod(cp.MethodHandle(RefInvokeStatic, cp.Methodref("T", "bsm", "()V")))
+	b.SetSourceFile("T.java")
+	b.AddField(AccPrivate, "x", "I")
+	m := b.AddMethod(AccPublic|AccStatic, "f", "()V")
+	m.AddException("java/io/IOException")
+	m.LineNumber(1)
+	m.Insn(OpReturn)
+
+	first, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstBytes, err := WriteClassFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondBytes, err := WriteClassFile(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(firstBytes, secondBytes) {
+		t.Error("building twice gives different classes")
+	}
+	var names []string
+	for i := range second.Attrs {
+		names = append(names, second.Attrs[i].Name(second.ConstantPool))
+	}
+	if got := strings.Join(names, " "); got != "SourceFile BootstrapMethods" {
+		t.Errorf("class attributes are %v", got)
+	}
+	if len(second.Methods[0].Attrs) != 2 {
+		t.Errorf("method has %v attributes, want Code and Exceptions", len(second.Methods[0].Attrs))
+	}
+}
+
+func TestClassBuilderErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(m *MethodBuilder)
+		want  string
+	}{
+		{"unmarked label", func(m *MethodBuilder) {
+			m.JumpInsn(OpGoto, m.NewLabel())
+		}, "label used but never marked"},
+		{"label marked twice", func(m *MethodBuilder) {
+			label := m.NewLabel()
+			m.Mark(label)
+			m.Insn(OpNop)
+			m.Mark(label)
+			m.Insn(OpReturn)
+		}, "label marked twice"},
+		{"no code", func(m *MethodBuilder) {}, "no code"},
+		{"wrong operands", func(m *MethodBuilder) {
+			m.Insn(OpIload)
+		}, "iload can't be emitted with these operands"},
+		{"type of iadd", func(m *MethodBuilder) {
+			m.TypeInsn(OpIadd, "T")
+		}, "iadd doesn't take a class"},
+	}
+	for _, test := range tests {
+		b := NewClassBuilder(AccPublic, "T", "java/lang/Object")
+		test.build(b.AddMethod(AccPublic|AccStatic, "f", "()V"))
+		_, err := b.Build()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,486 @@
+package classy
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CFG is the control-flow graph of a method's code: its basic blocks and the edges
+// between them, with the dominator and post-dominator trees and the loops they give.
+type CFG struct {
+	// Blocks lists the basic blocks in order of offset. The first is the entry block.
+	Blocks []*BasicBlock
+	// RPO lists the blocks that can be reached from the entry block in reverse
+	// postorder, following every kind of edge.
+	RPO []*BasicBlock
+	// Exits lists the blocks that leave the method: those that end in a return or an
+	// athrow, or that have no successors.
+	Exits []*BasicBlock
+	// Loops lists the natural loops, outer loops before those nested in them.
+	Loops []*Loop
+}
+
+// BasicBlock is a run of instructions that control enters only at the first and leaves
+// only after the last, apart from exceptions.
+type BasicBlock struct {
+	// Index is the position of the block in CFG.Blocks.
+	Index int
+	// Start is the offset of the first instruction and End the offset after the last.
+	Start, End   int
+	Instructions []Instruction
+	Succs, Preds []*Edge
+	// Dominator is the immediate dominator of the block, nil for the entry block and
+	// for blocks that can't be reached. Dominated lists the blocks it is the immediate
+	// dominator of.
+	Dominator *BasicBlock
+	Dominated []*BasicBlock
+	// PostDominator is the immediate post-dominator of the block, nil where that is
+	// the method's exit, and for blocks that never reach it. PostDominated lists the
+	// blocks it is the immediate post-dominator of.
+	PostDominator *BasicBlock
+	PostDominated []*BasicBlock
+	// Loop is the innermost loop the block belongs to, nil if it's in none.
+	Loop *Loop
+	rpo  int
+}
+
+// EdgeKind is how control passes along an Edge.
+type EdgeKind byte
+
+const (
+	EdgeFallThrough   EdgeKind = iota // to the next instruction
+	EdgeBranch                        // to the target of a goto or conditional branch
+	EdgeSwitch                        // to the target of a switch case
+	EdgeSwitchDefault                 // to the default target of a switch
+	EdgeJsr                           // to the subroutine a jsr calls
+	EdgeRet                           // from a ret to the instruction after a jsr
+	EdgeException                     // to an exception handler
+)
+
+var edgeKindNames = [...]string{"fall through", "branch", "switch", "switch default", "jsr", "ret", "exception"}
+
+func (k EdgeKind) String() string {
+	if int(k) < len(edgeKindNames) {
+		return edgeKindNames[k]
+	}
+	return fmt.Sprintf("EdgeKind(%v)", byte(k))
+}
+
+// Edge is a way control can pass from one block to another.
+type Edge struct {
+	From, To *BasicBlock
+	Kind     EdgeKind
+	// Key is the case of an EdgeSwitch.
+	Key int32
+	// Handler is the index in the exception table of the handler of an EdgeException.
+	Handler int
+}
+
+// Loop is a natural loop: the blocks that can reach the sources of the back edges to
+// its header, a block that dominates them all, without passing through the header.
+// Loops entered at more than one block aren't found.
+type Loop struct {
+	Header *BasicBlock
+	// Blocks lists the blocks of the loop by offset, including the header and those of
+	// nested loops.
+	Blocks    []*BasicBlock
+	BackEdges []*Edge
+	// Parent is the loop this one is nested in, and Depth the number of loops it's in
+	// counting itself.
+	Parent   *Loop
+	Children []*Loop
+	Depth    int
+}
+
+// NewCFG builds the control-flow graph of code. A block ends at each branch, switch,
+// return, athrow, jsr and ret, and before each branch target and the start, end and
+// handler of each exception table entry, so that a handler covers whole blocks; each
+// block has an EdgeException to every handler that covers it. A ret has an EdgeRet to
+// the instruction after each jsr that calls a subroutine it returns from.
+func NewCFG(code *CodeAttribute) (*CFG, error) {
+	insns, err := DecodeInstructions(code.Code)
+	if err != nil {
+		return nil, err
+	}
+	if len(insns) == 0 {
+		return nil, fmt.Errorf("no code")
+	}
+	at := map[int]int{}
+	for i := range insns {
+		at[insns[i].Offset] = i
+	}
+	end := len(code.Code)
+
+	leaders := make([]bool, len(insns)+1)
+	leaders[0] = true
+	leaders[len(insns)] = true
+	mark := func(from, offset int, what string) error {
+		i, ok := at[offset]
+		if !ok {
+			return fmt.Errorf("offset %v: %v %v is not the start of an instruction", from, what, offset)
+		}
+		leaders[i] = true
+		return nil
+	}
+	for _, handler := range code.ExceptionTable {
+		start, stop := int(handler.StartPC), int(handler.EndPC)
+		if start >= stop {
+			return nil, fmt.Errorf("offset %v: exception handler range %v-%v is empty", start, start, stop)
+		}
+		if err := mark(start, start, "exception handler start"); err != nil {
+			return nil, err
+		}
+		if stop != end {
+			if err := mark(start, stop, "exception handler end"); err != nil {
+				return nil, err
+			}
+		}
+		if err := mark(start, int(handler.HandlerPC), "exception handler"); err != nil {
+			return nil, err
+		}
+	}
+	var subs *subroutines
+	for i := range insns {
+		if op := insns[i].Opcode; op == OpJsr || op == OpJsrW || op == OpRet {
+			a := &analyzer{code: code, insns: insns, at: at}
+			if err := a.findSubroutines(); err != nil {
+				if verifyErr, ok := err.(*VerifyError); ok {
+					return nil, fmt.Errorf("offset %v: %v", verifyErr.Offset, verifyErr.Detail)
+				}
+				return nil, err
+			}
+			subs = a.subs
+			break
+		}
+	}
+	for i := range insns {
+		ins := &insns[i]
+		targets, fallsThrough := successors(ins)
+		if ins.Opcode == OpJsr || ins.Opcode == OpJsrW {
+			fallsThrough = false
+		}
+		for _, target := range targets {
+			if err := mark(ins.Offset, target, "branch to"); err != nil {
+				return nil, err
+			}
+		}
+		if fallsThrough && i+1 == len(insns) {
+			return nil, fmt.Errorf("offset %v: execution falls off the end of the code", ins.Offset)
+		}
+		if len(targets) > 0 || !fallsThrough {
+			leaders[i+1] = true
+		}
+	}
+
+	g := &CFG{}
+	blockOf := make([]*BasicBlock, len(insns))
+	for i := 0; i < len(insns); {
+		b := &BasicBlock{Index: len(g.Blocks), Start: insns[i].Offset, rpo: -1}
+		first := i
+		for i++; !leaders[i]; i++ {
+		}
+		b.Instructions = insns[first:i]
+		b.End = end
+		if i < len(insns) {
+			b.End = insns[i].Offset
+		}
+		for j := first; j < i; j++ {
+			blockOf[j] = b
+		}
+		g.Blocks = append(g.Blocks, b)
+	}
+
+	addEdge := func(from *BasicBlock, to int, kind EdgeKind) *Edge {
+		e := &Edge{From: from, To: blockOf[at[to]], Kind: kind}
+		from.Succs = append(from.Succs, e)
+		e.To.Preds = append(e.To.Preds, e)
+		return e
+	}
+	for _, b := range g.Blocks {
+		last := &b.Instructions[len(b.Instructions)-1]
+		switch last.Opcode {
+		case OpTableswitch, OpLookupswitch:
+			for j, target := range last.Switch.Targets {
+				e := addEdge(b, target, EdgeSwitch)
+				if last.Opcode == OpTableswitch {
+					e.Key = last.Switch.Low + int32(j)
+				} else {
+					e.Key = last.Switch.Keys[j]
+				}
+			}
+			addEdge(b, last.Switch.Default, EdgeSwitchDefault)
+		case OpJsr, OpJsrW:
+			addEdge(b, last.Target, EdgeJsr)
+		case OpRet:
+			i := at[last.Offset]
+			returnsTo := map[int]bool{}
+			for _, sub := range subs.entries {
+				for _, ret := range subs.rets[sub] {
+					if ret != i {
+						continue
+					}
+					for _, call := range subs.callers[sub] {
+						if call+1 == len(insns) {
+							return nil, fmt.Errorf("offset %v: execution falls off the end of the code after the subroutine returns", insns[call].Offset)
+						}
+						returnsTo[call+1] = true
+					}
+				}
+			}
+			var next []int
+			for j := range returnsTo {
+				next = append(next, j)
+			}
+			sort.Ints(next)
+			for _, j := range next {
+				addEdge(b, insns[j].Offset, EdgeRet)
+			}
+		default:
+			targets, fallsThrough := successors(last)
+			for _, target := range targets {
+				addEdge(b, target, EdgeBranch)
+			}
+			if fallsThrough {
+				addEdge(b, b.End, EdgeFallThrough)
+			}
+		}
+		for h, handler := range code.ExceptionTable {
+			if b.Start >= int(handler.StartPC) && b.Start < int(handler.EndPC) {
+				addEdge(b, int(handler.HandlerPC), EdgeException).Handler = h
+			}
+		}
+		switch last.Opcode {
+		case OpIreturn, OpLreturn, OpFreturn, OpDreturn, OpAreturn, OpReturn, OpAthrow:
+			g.Exits = append(g.Exits, b)
+		default:
+			if len(b.Succs) == 0 {
+				g.Exits = append(g.Exits, b)
+			}
+		}
+	}
+
+	g.dominators()
+	g.postDominators()
+	g.findLoops()
+	return g, nil
+}
+
+// BlockAt gets the block holding the instruction at offset, or nil if there is none.
+func (g *CFG) BlockAt(offset int) *BasicBlock {
+	i := sort.Search(len(g.Blocks), func(i int) bool { return g.Blocks[i].End > offset })
+	if i == len(g.Blocks) || offset < g.Blocks[i].Start {
+		return nil
+	}
+	return g.Blocks[i]
+}
+
+// Dominates reports whether every path from the entry block to b passes through a. A
+// block dominates itself.
+func (g *CFG) Dominates(a, b *BasicBlock) bool {
+	for ; b != nil; b = b.Dominator {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
+
+// PostDominates reports whether every path from b out of the method passes through a.
+// A block post-dominates itself.
+func (g *CFG) PostDominates(a, b *BasicBlock) bool {
+	for ; b != nil; b = b.PostDominator {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *CFG) dominators() {
+	succs := make([][]int, len(g.Blocks))
+	preds := make([][]int, len(g.Blocks))
+	for _, b := range g.Blocks {
+		for _, e := range b.Succs {
+			succs[b.Index] = append(succs[b.Index], e.To.Index)
+			preds[e.To.Index] = append(preds[e.To.Index], b.Index)
+		}
+	}
+	order := reversePostorder(0, succs)
+	for i, n := range order {
+		g.RPO = append(g.RPO, g.Blocks[n])
+		g.Blocks[n].rpo = i
+	}
+	for n, d := range dominators(order, preds) {
+		if d >= 0 {
+			b := g.Blocks[n]
+			b.Dominator = g.Blocks[d]
+			b.Dominator.Dominated = append(b.Dominator.Dominated, b)
+		}
+	}
+}
+
+// postDominators finds the dominators of the reversed graph, in which a node standing
+// for the method's exit leads to each exit block.
+func (g *CFG) postDominators() {
+	exit := len(g.Blocks)
+	succs := make([][]int, exit+1)
+	preds := make([][]int, exit+1)
+	for _, b := range g.Blocks {
+		for _, e := range b.Succs {
+			succs[e.To.Index] = append(succs[e.To.Index], b.Index)
+			preds[b.Index] = append(preds[b.Index], e.To.Index)
+		}
+	}
+	for _, b := range g.Exits {
+		succs[exit] = append(succs[exit], b.Index)
+		preds[b.Index] = append(preds[b.Index], exit)
+	}
+	for n, d := range dominators(reversePostorder(exit, succs), preds) {
+		if d >= 0 && d != exit {
+			b := g.Blocks[n]
+			b.PostDominator = g.Blocks[d]
+			b.PostDominator.PostDominated = append(b.PostDominator.PostDominated, b)
+		}
+	}
+}
+
+// findLoops finds a natural loop for each block that is the target of back edges,
+// edges to a block that dominates their source.
+func (g *CFG) findLoops() {
+	for _, header := range g.RPO {
+		var back []*Edge
+		for _, e := range header.Preds {
+			if e.From.rpo >= 0 && g.Dominates(header, e.From) {
+				back = append(back, e)
+			}
+		}
+		if len(back) == 0 {
+			continue
+		}
+		loop := &Loop{Header: header, BackEdges: back, Depth: 1}
+		in := map[*BasicBlock]bool{header: true}
+		var work []*BasicBlock
+		for _, e := range back {
+			work = append(work, e.From)
+		}
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			if in[b] {
+				continue
+			}
+			in[b] = true
+			for _, e := range b.Preds {
+				if e.From.rpo >= 0 {
+					work = append(work, e.From)
+				}
+			}
+		}
+		for _, b := range g.Blocks {
+			if in[b] {
+				loop.Blocks = append(loop.Blocks, b)
+			}
+		}
+
+		// Loops with different headers are either nested or apart, and the header of
+		// an outer loop comes first in reverse postorder, so the deepest loop found
+		// so far that holds this one's header is its parent
+		for _, outer := range g.Loops {
+			if (loop.Parent == nil || outer.Depth > loop.Parent.Depth) && outer.contains(header) {
+				loop.Parent = outer
+			}
+		}
+		if loop.Parent != nil {
+			loop.Depth = loop.Parent.Depth + 1
+			loop.Parent.Children = append(loop.Parent.Children, loop)
+		}
+		for _, b := range loop.Blocks {
+			b.Loop = loop
+		}
+		g.Loops = append(g.Loops, loop)
+	}
+}
+
+func (l *Loop) contains(b *BasicBlock) bool {
+	i := sort.Search(len(l.Blocks), func(i int) bool { return l.Blocks[i].Index >= b.Index })
+	return i < len(l.Blocks) && l.Blocks[i] == b
+}
+
+// reversePostorder orders the nodes that can be reached from root, where succs lists
+// the successors of each node, so that each comes before its successors other than
+// along back edges.
+func reversePostorder(root int, succs [][]int) []int {
+	visited := make([]bool, len(succs))
+	var post []int
+	type entry struct{ node, next int }
+	stack := []entry{{root, 0}}
+	visited[root] = true
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.next < len(succs[top.node]) {
+			n := succs[top.node][top.next]
+			top.next++
+			if !visited[n] {
+				visited[n] = true
+				stack = append(stack, entry{n, 0})
+			}
+			continue
+		}
+		post = append(post, top.node)
+		stack = stack[:len(stack)-1]
+	}
+	for i, j := 0, len(post)-1; i < j; i, j = i+1, j-1 {
+		post[i], post[j] = post[j], post[i]
+	}
+	return post
+}
+
+// dominators finds the immediate dominator of each node by the iterative algorithm of
+// Cooper, Harvey and Kennedy, given the reachable nodes in reverse postorder from the
+// root and the predecessors of each node. The root and nodes that can't be reached
+// get -1.
+func dominators(order []int, preds [][]int) []int {
+	position := make([]int, len(preds))
+	idom := make([]int, len(preds))
+	for n := range idom {
+		position[n], idom[n] = -1, -1
+	}
+	for i, n := range order {
+		position[n] = i
+	}
+	root := order[0]
+	idom[root] = root
+	intersect := func(a, b int) int {
+		for a != b {
+			for position[a] > position[b] {
+				a = idom[a]
+			}
+			for position[b] > position[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, n := range order[1:] {
+			d := -1
+			for _, p := range preds[n] {
+				if idom[p] < 0 {
+					continue
+				}
+				if d < 0 {
+					d = p
+				} else {
+					d = intersect(p, d)
+				}
+			}
+			if d != idom[n] {
+				idom[n] = d
+				changed = true
+			}
+		}
+	}
+	idom[root] = -1
+	return idom
+}
@@ -0,0 +1,115 @@
+package classy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildNestedLoops builds a method with the code javac compiles from
+//
+//	static int f(int n) {
+//		int s = 0;
+//		for (int i = 0; i < n; i++)
+//			for (int j = 0; j < i; j++)
+//				s += j;
+//		return s;
+//	}
+func buildNestedLoops(t *testing.T) *CodeAttribute {
+	t.Helper()
+	b := NewClassBuilder(AccPublic|AccSuper, "T", "java/lang/Object")
+	m := b.AddMethod(AccStatic, "f", "(I)I")
+	outer, inner, next, end := m.NewLabel(), m.NewLabel(), m.NewLabel(), m.NewLabel()
+	m.Insn(OpIconst0)
+	m.VarInsn(OpIstore, 1)
+	m.Insn(OpIconst0)
+	m.VarInsn(OpIstore, 2)
+	m.Mark(outer)
+	m.VarInsn(OpIload, 2)
+	m.VarInsn(OpIload, 0)
+	m.JumpInsn(OpIfIcmpge, end)
+	m.Insn(OpIconst0)
+	m.VarInsn(OpIstore, 3)
+	m.Mark(inner)
+	m.VarInsn(OpIload, 3)
+	m.VarInsn(OpIload, 2)
+	m.JumpInsn(OpIfIcmpge, next)
+	m.VarInsn(OpIload, 1)
+	m.VarInsn(OpIload, 3)
+	m.Insn(OpIadd)
+	m.VarInsn(OpIstore, 1)
+	m.IincInsn(3, 1)
+	m.JumpInsn(OpGoto, inner)
+	m.Mark(next)
+	m.IincInsn(2, 1)
+	m.JumpInsn(OpGoto, outer)
+	m.Mark(end)
+	m.VarInsn(OpIload, 1)
+	m.Insn(OpIreturn)
+	cf, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := methodCode(t, cf, "f")
+	return code
+}
+
+func TestCFGNestedLoops(t *testing.T) {
+	code := buildNestedLoops(t)
+	g, err := NewCFG(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := func(b *BasicBlock) string {
+		if b == nil {
+			return "-"
+		}
+		return fmt.Sprint(b.Start)
+	}
+	var lines []string
+	for _, b := range g.Blocks {
+		depth := 0
+		if b.Loop != nil {
+			depth = b.Loop.Depth
+		}
+		lines = append(lines, fmt.Sprintf("%v-%v dom %v pdom %v depth %v", b.Start, b.End, start(b.Dominator), start(b.PostDominator), depth))
+	}
+	want := `0-4 dom - pdom 4 depth 0
+4-9 dom 0 pdom 32 depth 1
+9-11 dom 4 pdom 11 depth 1
+11-16 dom 9 pdom 26 depth 2
+16-26 dom 11 pdom 11 depth 2
+26-32 dom 11 pdom 4 depth 1
+32-34 dom 4 pdom - depth 0`
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Errorf("blocks:\n%v\nwant:\n%v", got, want)
+	}
+
+	entry, outer, inner, body, exit := g.BlockAt(0), g.BlockAt(4), g.BlockAt(11), g.BlockAt(16), g.BlockAt(32)
+	if g.Blocks[0] != entry || len(g.Exits) != 1 || g.Exits[0] != exit {
+		t.Errorf("entry %v and exits %v, want blocks at 0 and 32", start(g.Blocks[0]), len(g.Exits))
+	}
+	if !g.Dominates(outer, body) || g.Dominates(body, outer) || !g.Dominates(inner, inner) {
+		t.Error("the loop headers should dominate the inner loop's body")
+	}
+	if !g.PostDominates(exit, entry) || !g.PostDominates(outer, body) || g.PostDominates(body, inner) {
+		t.Error("the exit and outer header should post-dominate the inner loop's body")
+	}
+
+	if len(g.Loops) != 2 {
+		t.Fatalf("%v loops, want 2", len(g.Loops))
+	}
+	lo, li := g.Loops[0], g.Loops[1]
+	if lo.Header != outer || lo.Parent != nil || lo.Depth != 1 || len(lo.Blocks) != 5 {
+		t.Errorf("outer loop at %v, depth %v with %v blocks", start(lo.Header), lo.Depth, len(lo.Blocks))
+	}
+	if li.Header != inner || li.Parent != lo || li.Depth != 2 || len(li.Blocks) != 2 {
+		t.Errorf("inner loop at %v, depth %v with %v blocks", start(li.Header), li.Depth, len(li.Blocks))
+	}
+	if len(lo.Children) != 1 || lo.Children[0] != li {
+		t.Error("the inner loop should be the outer loop's only child")
+	}
+	if len(li.BackEdges) != 1 || li.BackEdges[0].From != body || len(lo.BackEdges) != 1 || lo.BackEdges[0].From != g.BlockAt(26) {
+		t.Error("each loop should have one back edge, from its last block")
+	}
+}
@@ -28,6 +28,10 @@ type ClassFile struct {
 	Methods           []MethodInfo
 	AttrsCount        uint16
 	Attrs             []AttrInfo
+
+	// trailing counts the bytes that ReadClassFile found after the last attribute, for
+	// Validate to report
+	trailing int
 }
 
 // CpEntry is an entry that exists in the classfile's constant pool
@@ -69,42 +73,33 @@ type MethodInfo struct {
 // Get the binary name of the class stored in the ClassFile.
 // Converts the forward-slashes to dots.
 func (cf *ClassFile) GetBinaryName() string {
-	binaryNameRaw := cf.ConstantPool[cf.ThisClass-1].Repr(cf.ConstantPool)
+	binaryNameRaw, _ := lookupClassName(cf.ConstantPool, cf.ThisClass)
 	return strings.Replace(binaryNameRaw, "/", ".", -1)
 }
 
 // Name gets the String name of the field, performing a lookup in the provided constant
-// pool.  It requires looking up a CONSTANT_Utf8 entry in the constant pool.
+// pool.  It requires looking up a CONSTANT_Utf8 entry in the constant pool, and is ""
+// if name_index doesn't refer to one, as are the other names and descriptors below.
 func (i *FieldInfo) Name(cp []CpEntry) string {
-	idx := i.NameIndex - 1
-	ent := cp[idx].(*CONSTANT_Utf8_info)
-	return string(ent.Bytes[:ent.Length])
+	return utf8Value(cp, i.NameIndex)
 }
 
 // Descriptor gets the string representation of field's type descriptor.
 func (i *FieldInfo) Descriptor(cp []CpEntry) string {
-	idx := i.DescriptorIndex - 1
-	ent := cp[idx].(*CONSTANT_Utf8_info)
-	return string(ent.Bytes[:ent.Length])
+	return utf8Value(cp, i.DescriptorIndex)
 }
 
 // Name gets the name of the attribute.
 func (i *AttrInfo) Name(cp []CpEntry) string {
-	idx := i.NameIndex - 1
-	ent := cp[idx].(*CONSTANT_Utf8_info)
-	return string(ent.Bytes[:ent.Length])
+	return utf8Value(cp, i.NameIndex)
 }
 
 // Name gets the name of the method.
 func (i *MethodInfo) Name(cp []CpEntry) string {
-	idx := i.NameIndex - 1
-	ent := cp[idx].(*CONSTANT_Utf8_info)
-	return string(ent.Bytes[:ent.Length])
+	return utf8Value(cp, i.NameIndex)
 }
 
 // Descriptor gets the string representation of method's type descriptor.
 func (i *MethodInfo) Descriptor(cp []CpEntry) string {
-	idx := i.DescriptorIndex - 1
-	ent := cp[idx].(*CONSTANT_Utf8_info)
-	return string(ent.Bytes[:ent.Length])
+	return utf8Value(cp, i.DescriptorIndex)
 }
@@ -0,0 +1,1313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/a10y/classy"
+)
+
+// asm assembles a .j file, in the syntax printed by disasm, into a classfile.
+func asm(args []string) {
+	flags := flag.NewFlagSet("asm", flag.ExitOnError)
+	flags.Usage = usage
+	output := flags.String("o", "", "where to write the classfile, - for stdout")
+	input := inputArg(flags, args)
+	classFile, err := assembleText(string(readInput(input)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error assembling %v: %v\n", input, err)
+		os.Exit(1)
+	}
+	writeAssembled(classFile, input, *output)
+}
+
+// assembleText assembles the source of a .j file. Constants pinned with .const keep
+// their index, and every other constant is looked up by value among them before being
+// added to the first free index.
+func assembleText(src string) (*classy.ClassFile, error) {
+	lines, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &asmParser{pool: &asmPool{keys: map[string]uint16{}}}
+	for _, line := range lines {
+		if line.toks[0].kind == tokWord && line.toks[0].text == ".const" {
+			p.pinned = append(p.pinned, line)
+		} else {
+			p.lines = append(p.lines, line)
+		}
+	}
+	p.pinConstants()
+	cf := p.class()
+	if p.err == nil && p.pos < len(p.lines) {
+		p.fail(p.lines[p.pos].num, "unexpected %v after .end class", p.lines[p.pos].toks[0].text)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	cf.ConstantPool = p.pool.cp
+	for i, entry := range p.pool.cp {
+		if entry == nil && !p.pool.taken[i] {
+			return nil, fmt.Errorf("constant pool index %v is unused, add a .const for it", i+1)
+		}
+	}
+	if err := cf.UpdateCounts(); err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+// asmParser assembles lines of a .j file. Like the classfile decoder it keeps the first
+// error it runs into and returns zero values after that.
+type asmParser struct {
+	lines  []asmLine
+	pos    int
+	pinned []asmLine
+	pool   *asmPool
+	err    error
+}
+
+func (p *asmParser) fail(num int, format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf("line %v: %v", num, fmt.Sprintf(format, args...))
+	}
+}
+
+// next returns a reader for the next line, or nil at the end of the file.
+func (p *asmParser) next() *lineReader {
+	if p.err != nil || p.pos >= len(p.lines) {
+		return nil
+	}
+	p.pos++
+	return &lineReader{p: p, line: p.lines[p.pos-1]}
+}
+
+// block calls each for the lines up to .end name.
+func (p *asmParser) block(start int, name string, each func(r *lineReader)) {
+	for {
+		r := p.next()
+		if r == nil {
+			p.fail(start, "missing .end %v", name)
+			return
+		}
+		if r.peek(".end") {
+			r.expect(".end")
+			r.expect(name)
+			r.end()
+			return
+		}
+		each(r)
+	}
+}
+
+// lineReader reads the tokens of a line.
+type lineReader struct {
+	p    *asmParser
+	line asmLine
+	i    int
+}
+
+func (r *lineReader) fail(format string, args ...interface{}) {
+	r.p.fail(r.line.num, format, args...)
+}
+
+func (r *lineReader) more() bool {
+	return r.p.err == nil && r.i < len(r.line.toks)
+}
+
+func (r *lineReader) next(what string) token {
+	if r.p.err != nil {
+		return token{}
+	}
+	if r.i >= len(r.line.toks) {
+		r.fail("expected %v", what)
+		return token{}
+	}
+	r.i++
+	return r.line.toks[r.i-1]
+}
+
+// peek reports whether the next token is the given word.
+func (r *lineReader) peek(word string) bool {
+	return r.more() && r.line.toks[r.i].kind == tokWord && r.line.toks[r.i].text == word
+}
+
+func (r *lineReader) expect(word string) {
+	if t := r.next(word); r.p.err == nil && (t.kind != tokWord || t.text != word) {
+		r.fail("expected %v, found %v", word, t.text)
+	}
+}
+
+func (r *lineReader) end() {
+	if r.more() {
+		r.fail("unexpected %v", r.line.toks[r.i].text)
+	}
+}
+
+func (r *lineReader) word(what string) string {
+	t := r.next(what)
+	if r.p.err == nil && t.kind != tokWord {
+		r.fail("expected %v, found %q", what, t.text)
+	}
+	return t.text
+}
+
+func (r *lineReader) int(what string, min, max int64) int64 {
+	s := r.word(what)
+	if r.p.err != nil {
+		return 0
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < min || v > max {
+		r.fail("expected %v from %v to %v, found %v", what, min, max, s)
+	}
+	return v
+}
+
+func (r *lineReader) u2(what string) uint16 {
+	return uint16(r.int(what, 0, math.MaxUint16))
+}
+
+// flags reads access flags up to the end of the line.
+func (r *lineReader) flags(names []flagName) classy.Access {
+	var acc classy.Access
+	for r.more() {
+		word := r.word("flag")
+		flag, ok := parseFlag(word, names)
+		if !ok {
+			r.fail("unknown flag %v", word)
+		}
+		acc |= flag
+	}
+	return acc
+}
+
+// constExpr is a constant as written in a .j file, either by value or as a reference to
+// an index.
+type constExpr struct {
+	// tag is the kind of constant, e.g. Method, or "" for a reference by index.
+	tag   string
+	index uint16
+	// bytes holds the contents of a Utf8, and bits the value of a number.
+	bytes []byte
+	bits  uint64
+	// num is the reference kind of a MethodHandle or the bootstrap method index of a
+	// Dynamic or InvokeDynamic.
+	num  uint16
+	args []*constExpr
+}
+
+// key is the constantKey the constant will have once it is in the pool.
+func (e *constExpr) key(cp []classy.CpEntry, depth int) string {
+	if e.tag == "" {
+		return constantKey(cp, e.index, depth)
+	}
+	var args []string
+	switch e.tag {
+	case "Utf8":
+		args = append(args, fmt.Sprintf("%q", e.bytes))
+	case "Int", "Float":
+		args = append(args, strconv.FormatUint(e.bits&math.MaxUint32, 10))
+	case "Long", "Double":
+		args = append(args, strconv.FormatUint(e.bits, 10))
+	case "MethodHandle", "Dynamic", "InvokeDynamic":
+		args = append(args, strconv.Itoa(int(e.num)))
+	}
+	for _, arg := range e.args {
+		args = append(args, arg.key(cp, depth+1))
+	}
+	return "(" + e.tag + " " + strings.Join(args, " ") + ")"
+}
+
+func utf8Const(s string) *constExpr {
+	return &constExpr{tag: "Utf8", bytes: classy.EncodeModifiedUTF8(s)}
+}
+
+// text reads a Utf8 constant given as a word or string, or by index.
+func (r *lineReader) text(what string) *constExpr {
+	t := r.next(what)
+	switch {
+	case r.p.err != nil:
+		return nil
+	case t.kind == tokWord && rawRef.MatchString(t.text):
+		return r.ref(t.text)
+	case t.kind == tokBytes:
+		return &constExpr{tag: "Utf8", bytes: []byte(t.text)}
+	default:
+		return utf8Const(t.text)
+	}
+}
+
+func (r *lineReader) ref(word string) *constExpr {
+	v, err := strconv.ParseUint(word[1:len(word)-1], 10, 16)
+	if err != nil {
+		r.fail("constant pool index %v is out of range", word)
+	}
+	return &constExpr{index: uint16(v)}
+}
+
+// class reads a Class constant given by the name of the class, or by index.
+func (r *lineReader) class(what string) *constExpr {
+	t := r.next(what)
+	if r.p.err == nil && t.kind == tokWord && rawRef.MatchString(t.text) {
+		return r.ref(t.text)
+	}
+	r.i--
+	return &constExpr{tag: "Class", args: []*constExpr{r.text(what)}}
+}
+
+// nameAndType reads a NameAndType given as a name and descriptor, or by index.
+func (r *lineReader) nameAndType() *constExpr {
+	if r.more() && r.line.toks[r.i].kind == tokWord && rawRef.MatchString(r.line.toks[r.i].text) {
+		return r.ref(r.word("name and type"))
+	}
+	return &constExpr{tag: "NameAndType", args: []*constExpr{r.text("name"), r.text("descriptor")}}
+}
+
+// constant reads a constant: a reference by index, a tag followed by the constant's
+// contents, a number (42, 42L, 4.2f or 4.2) or a string, which stands for a String.
+func (r *lineReader) constant() *constExpr {
+	t := r.next("constant")
+	if r.p.err != nil {
+		return nil
+	}
+	if t.kind != tokWord {
+		return &constExpr{tag: "String", args: []*constExpr{{tag: "Utf8", bytes: stringBytes(t)}}}
+	}
+	if rawRef.MatchString(t.text) {
+		return r.ref(t.text)
+	}
+
+	e := &constExpr{tag: t.text}
+	switch t.text {
+	case "Utf8":
+		v := r.next("string")
+		if v.kind == tokWord && rawRef.MatchString(v.text) {
+			r.fail("Utf8 needs a string")
+		}
+		e.bytes = stringBytes(v)
+	case "Int":
+		return r.number(r.word("integer"), "Int")
+	case "Float":
+		return r.number(strings.TrimSuffix(r.word("float"), "f")+"f", "Float")
+	case "Long":
+		return r.number(strings.TrimSuffix(r.word("long"), "L")+"L", "Long")
+	case "Double":
+		return r.number(r.word("double"), "Double")
+	case "Class", "String", "MethodType", "Module", "Package":
+		e.args = []*constExpr{r.text("name")}
+	case "Field", "Method", "InterfaceMethod":
+		e.args = []*constExpr{r.class("class"), r.nameAndType()}
+	case "NameAndType":
+		e.args = []*constExpr{r.text("name"), r.text("descriptor")}
+	case "MethodHandle":
+		word := r.word("reference kind")
+		kind, ok := parseRefKind(word)
+		if !ok {
+			r.fail("unknown reference kind %v", word)
+		}
+		e.num = uint16(kind)
+		e.args = []*constExpr{r.constant()}
+	case "Dynamic", "InvokeDynamic":
+		e.num = r.u2("bootstrap method index")
+		e.args = []*constExpr{r.nameAndType()}
+	default:
+		return r.number(t.text, "")
+	}
+	return e
+}
+
+func stringBytes(t token) []byte {
+	if t.kind == tokBytes {
+		return []byte(t.text)
+	}
+	return classy.EncodeModifiedUTF8(t.text)
+}
+
+// number reads a numeric constant, checking it has the expected tag unless that is "".
+func (r *lineReader) number(s, want string) *constExpr {
+	if r.p.err != nil {
+		return nil
+	}
+	e := &constExpr{}
+	ok := true
+	digits := strings.TrimPrefix(s, "-")
+	switch {
+	case strings.HasSuffix(s, "L"):
+		e.tag = "Long"
+		v, err := parseInt(strings.TrimSuffix(s, "L"), 64)
+		e.bits, ok = uint64(v), err == nil
+	case strings.HasPrefix(digits, "0x"):
+		e.tag = "Int"
+		v, err := parseInt(s, 32)
+		e.bits, ok = uint64(uint32(v)), err == nil
+	case strings.HasSuffix(s, "f"):
+		e.tag = "Float"
+		e.bits, ok = parseFloatBits(strings.TrimSuffix(s, "f"), 32)
+	case strings.ContainsAny(s, ".eE") || strings.HasPrefix(digits, "NaN") || digits == "Infinity":
+		e.tag = "Double"
+		e.bits, ok = parseFloatBits(s, 64)
+	default:
+		e.tag = "Int"
+		v, err := parseInt(s, 32)
+		e.bits, ok = uint64(uint32(v)), err == nil
+	}
+	if want == "Double" && e.tag == "Int" && ok {
+		e.tag = "Double"
+		e.bits, ok = parseFloatBits(s, 64)
+	}
+	if !ok || (want != "" && e.tag != want) {
+		if want == "" {
+			want = "constant"
+		}
+		r.fail("expected %v, found %v", want, s)
+	}
+	return e
+}
+
+// parseInt reads a decimal number, or a hex one that may also be written unsigned.
+func parseInt(s string, bits int) (int64, error) {
+	digits := strings.TrimPrefix(s, "-")
+	if !strings.HasPrefix(digits, "0x") {
+		return strconv.ParseInt(s, 10, bits)
+	}
+	if v, err := strconv.ParseInt(s, 0, bits); err == nil {
+		return v, nil
+	}
+	v, err := strconv.ParseUint(s, 0, bits)
+	if bits == 32 {
+		return int64(int32(v)), err
+	}
+	return int64(v), err
+}
+
+// asmPool is the constant pool being assembled. taken marks the indexes that are in
+// use, including the empty slots following 8-byte constants. Nothing can be added to a
+// frozen pool.
+type asmPool struct {
+	cp     []classy.CpEntry
+	taken  []bool
+	keys   map[string]uint16
+	frozen bool
+}
+
+func (pool *asmPool) grow(size int) {
+	for len(pool.cp) < size {
+		pool.cp = append(pool.cp, nil)
+		pool.taken = append(pool.taken, false)
+	}
+}
+
+// add puts an entry in the first free index.
+func (pool *asmPool) add(entry classy.CpEntry, width int) (uint16, error) {
+	i := 0
+	for ; i < len(pool.taken); i++ {
+		if !pool.taken[i] && (width == 1 || i+1 >= len(pool.taken) || !pool.taken[i+1]) {
+			break
+		}
+	}
+	if i+width >= math.MaxUint16 {
+		return 0, fmt.Errorf("too many constants")
+	}
+	pool.grow(i + width)
+	pool.cp[i] = entry
+	for j := i; j < i+width; j++ {
+		pool.taken[j] = true
+	}
+	return uint16(i + 1), nil
+}
+
+// resolve finds the index of a constant, adding it to the pool if it isn't there.
+func (p *asmParser) resolve(num int, e *constExpr) uint16 {
+	if p.err != nil || e == nil {
+		return 0
+	}
+	if e.tag == "" {
+		return e.index
+	}
+	key := e.key(p.pool.cp, 0)
+	if index, ok := p.pool.keys[key]; ok {
+		return index
+	}
+	if p.pool.frozen {
+		p.fail(num, "constant %v is not in the constant pool", key)
+		return 0
+	}
+	entry := p.build(num, e)
+	if p.err != nil {
+		return 0
+	}
+	index, err := p.pool.add(entry, constWidth(e))
+	if err != nil {
+		p.fail(num, "%v", err)
+	}
+	p.pool.keys[key] = index
+	return index
+}
+
+func constWidth(e *constExpr) int {
+	if e.tag == "Long" || e.tag == "Double" {
+		return 2
+	}
+	return 1
+}
+
+// build creates the pool entry for a constant, resolving the constants it refers to.
+func (p *asmParser) build(num int, e *constExpr) classy.CpEntry {
+	var args []uint16
+	for _, arg := range e.args {
+		args = append(args, p.resolve(num, arg))
+	}
+	switch e.tag {
+	case "Utf8":
+		if len(e.bytes) > math.MaxUint16 {
+			p.fail(num, "Utf8 constant of %v bytes is too long", len(e.bytes))
+		}
+		return &classy.CONSTANT_Utf8_info{Tag: classy.CONSTANT_Utf8, Length: uint16(len(e.bytes)), Bytes: e.bytes}
+	case "Int":
+		return &classy.CONSTANT_Integer_info{Tag: classy.CONSTANT_Integer, Bytes: uint32(e.bits)}
+	case "Float":
+		return &classy.CONSTANT_Float_info{Tag: classy.CONSTANT_Float, Bytes: uint32(e.bits)}
+	case "Long":
+		return &classy.CONSTANT_Long_info{Tag: classy.CONSTANT_Long, HighBytes: uint32(e.bits >> 32), LowBytes: uint32(e.bits)}
+	case "Double":
+		return &classy.CONSTANT_Double_info{Tag: classy.CONSTANT_Double, HighBytes: uint32(e.bits >> 32), LowBytes: uint32(e.bits)}
+	case "Class":
+		return &classy.CONSTANT_Class_info{Tag: classy.CONSTANT_Class, NameIndex: args[0]}
+	case "String":
+		return &classy.CONSTANT_String_info{Tag: classy.CONSTANT_String, StringIndex: args[0]}
+	case "Field":
+		return &classy.CONSTANT_Fieldref_info{Tag: classy.CONSTANT_Fieldref, ClassIndex: args[0], NameAndTypeIndex: args[1]}
+	case "Method":
+		return &classy.CONSTANT_Methodref_info{Tag: classy.CONSTANT_Methodref, ClassIndex: args[0], NameAndTypeIndex: args[1]}
+	case "InterfaceMethod":
+		return &classy.CONSTANT_InterfaceMethodref_info{Tag: classy.CONSTANT_InterfaceMethodref, ClassIndex: args[0], NameAndTypeIndex: args[1]}
+	case "NameAndType":
+		return &classy.CONSTANT_NameAndType_info{Tag: classy.CONSTANT_NameAndType, NameIndex: args[0], DescriptorIndex: args[1]}
+	case "MethodHandle":
+		return &classy.CONSTANT_MethodHandle_info{Tag: classy.CONSTANT_MethodHandle, ReferenceKind: byte(e.num), ReferenceIndex: args[0]}
+	case "MethodType":
+		return &classy.CONSTANT_MethodType_info{Tag: classy.CONSTANT_MethodType, DescriptorIndex: args[0]}
+	case "Dynamic":
+		return &classy.CONSTANT_Dynamic_info{Tag: classy.CONSTANT_Dynamic, BootstrapMethodAttrIndex: e.num, NameAndTypeIndex: args[0]}
+	case "InvokeDynamic":
+		return &classy.CONSTANT_InvokeDynamic_info{Tag: classy.CONSTANT_InvokeDynamic, BootstrapMethodAttrIndex: e.num, NameAndTypeIndex: args[0]}
+	case "Module":
+		return &classy.CONSTANT_Module_info{Tag: classy.CONSTANT_Module, NameIndex: args[0]}
+	case "Package":
+		return &classy.CONSTANT_Package_info{Tag: classy.CONSTANT_Package, NameIndex: args[0]}
+	}
+	p.fail(num, "unknown constant %v", e.tag)
+	return nil
+}
+
+// pinConstants places the constants given by .const [index] = constant lines. Their
+// indexes are reserved first so that the constants they refer to by value don't take
+// them.
+func (p *asmParser) pinConstants() {
+	type pin struct {
+		num   int
+		index uint16
+		expr  *constExpr
+	}
+	var pins []pin
+	for _, line := range p.pinned {
+		r := &lineReader{p: p, line: line}
+		r.expect(".const")
+		word := r.word("constant pool index")
+		if p.err == nil && !rawRef.MatchString(word) {
+			r.fail("expected a constant pool index like [1], found %v", word)
+			return
+		}
+		index := r.ref(word).index
+		r.expect("=")
+		expr := r.constant()
+		r.end()
+		if p.err != nil {
+			return
+		}
+		if expr.tag == "" || index == 0 {
+			r.fail("a .const must give the constant at a non-zero index")
+			return
+		}
+		p.pool.grow(int(index) - 1 + constWidth(expr))
+		for i := int(index) - 1; i < int(index)-1+constWidth(expr); i++ {
+			if p.pool.taken[i] {
+				r.fail("constant pool index %v is given twice", i+1)
+				return
+			}
+			p.pool.taken[i] = true
+		}
+		pins = append(pins, pin{line.num, index, expr})
+	}
+
+	for _, pin := range pins {
+		p.pool.cp[pin.index-1] = p.build(pin.num, pin.expr)
+	}
+	// Constants written by value resolve to the first pinned index holding them
+	p.pool.keys = constantKeys(p.pool.cp)
+}
+
+// class assembles everything but the constant pool.
+func (p *asmParser) class() *classy.ClassFile {
+	cf := &classy.ClassFile{Magic: 0xCAFEBABE, MajorVersion: 49}
+	start := 0
+	var superClass *constExpr
+	for {
+		r := p.next()
+		if r == nil {
+			if p.err == nil {
+				p.fail(len(p.lines), "missing .end class")
+			}
+			return cf
+		}
+		directive := r.word("directive")
+		switch directive {
+		case ".version":
+			cf.MajorVersion = r.u2("major version")
+			cf.MinorVersion = r.u2("minor version")
+		case ".class":
+			start = r.line.num
+			toks := r.line.toks[1:]
+			if len(toks) == 0 {
+				r.fail("expected class name")
+				break
+			}
+			for range toks[:len(toks)-1] {
+				flag, ok := parseFlag(r.word("flag"), classFlagNames)
+				if !ok {
+					r.fail("unknown flag %v", r.line.toks[r.i-1].text)
+				}
+				cf.AccessFlags |= flag
+			}
+			cf.ThisClass = p.resolve(r.line.num, r.class("class name"))
+		case ".super":
+			superClass = r.class("superclass")
+			cf.SuperClass = p.resolve(r.line.num, superClass)
+		case ".implements":
+			cf.Interfaces = append(cf.Interfaces, p.resolve(r.line.num, r.class("interface")))
+		case ".field":
+			cf.Fields = append(cf.Fields, p.field(r))
+			continue
+		case ".method":
+			cf.Methods = append(cf.Methods, p.method(r))
+			continue
+		case ".end":
+			r.expect("class")
+			r.end()
+			if start == 0 {
+				p.fail(r.line.num, "missing .class")
+			}
+			if superClass == nil && p.err == nil {
+				cf.SuperClass = p.resolve(r.line.num, &constExpr{tag: "Class", args: []*constExpr{utf8Const("java/lang/Object")}})
+			}
+			return cf
+		default:
+			attr, ok := p.attr(r, directive)
+			if !ok {
+				r.fail("unknown directive %v", directive)
+			}
+			cf.Attrs = append(cf.Attrs, attr)
+			continue
+		}
+		r.end()
+	}
+}
+
+// field assembles `.field flags name descriptor [= constant] [.fieldattributes]`, where
+// the constant is short for a leading .constantvalue.
+func (p *asmParser) field(r *lineReader) classy.FieldInfo {
+	var field classy.FieldInfo
+	toks := r.line.toks[1:]
+	end := len(toks)
+	attributes := false
+	if end > 0 && toks[end-1].kind == tokWord && toks[end-1].text == ".fieldattributes" {
+		attributes = true
+		end--
+	}
+	value := -1
+	for i, t := range toks[:end] {
+		if t.kind == tokWord && t.text == "=" {
+			value = i
+			end = i
+			break
+		}
+	}
+	if end < 2 {
+		r.fail("expected flags, name and descriptor")
+		return field
+	}
+	for range toks[:end-2] {
+		word := r.word("flag")
+		flag, ok := parseFlag(word, fieldFlagNames)
+		if !ok {
+			r.fail("unknown flag %v", word)
+		}
+		field.AccessFlags |= flag
+	}
+	field.NameIndex = p.resolve(r.line.num, r.text("name"))
+	field.DescriptorIndex = p.resolve(r.line.num, r.text("descriptor"))
+	if value >= 0 {
+		r.expect("=")
+		index := p.resolve(r.line.num, r.constant())
+		field.Attrs = append(field.Attrs, p.attrInfo(r.line.num, "ConstantValue", u2s(index)))
+	}
+	if attributes {
+		r.expect(".fieldattributes")
+		r.end()
+		p.block(r.line.num, "fieldattributes", func(r *lineReader) {
+			field.Attrs = append(field.Attrs, p.requireAttr(r))
+		})
+	}
+	r.end()
+	return field
+}
+
+// method assembles a method from `.method flags name : descriptor` to `.end method`.
+func (p *asmParser) method(r *lineReader) classy.MethodInfo {
+	var method classy.MethodInfo
+	colon := -1
+	for i, t := range r.line.toks {
+		if t.kind == tokWord && t.text == ":" {
+			colon = i
+		}
+	}
+	if colon < 2 {
+		r.fail("expected flags, name, : and descriptor")
+		return method
+	}
+	for range r.line.toks[1 : colon-1] {
+		word := r.word("flag")
+		flag, ok := parseFlag(word, methodFlagNames)
+		if !ok {
+			r.fail("unknown flag %v", word)
+		}
+		method.AccessFlags |= flag
+	}
+	method.NameIndex = p.resolve(r.line.num, r.text("name"))
+	r.expect(":")
+	method.DescriptorIndex = p.resolve(r.line.num, r.text("descriptor"))
+	r.end()
+
+	p.block(r.line.num, "method", func(r *lineReader) {
+		if r.peek(".code") {
+			method.Attrs = append(method.Attrs, p.code(r))
+			return
+		}
+		method.Attrs = append(method.Attrs, p.requireAttr(r))
+	})
+	return method
+}
+
+func (p *asmParser) requireAttr(r *lineReader) classy.AttrInfo {
+	directive := r.word("attribute")
+	attr, ok := p.attr(r, directive)
+	if !ok {
+		r.fail("unknown attribute directive %v", directive)
+	}
+	return attr
+}
+
+// attrInfo builds an attribute, resolving its name.
+func (p *asmParser) attrInfo(num int, name string, data []byte) classy.AttrInfo {
+	return classy.AttrInfo{NameIndex: p.resolve(num, utf8Const(name)), AttrData: data}
+}
+
+func u2s(values ...uint16) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, values)
+	return buf.Bytes()
+}
+
+// attr assembles the attribute written with the given directive, reporting false if
+// the directive isn't one.
+func (p *asmParser) attr(r *lineReader, directive string) (classy.AttrInfo, bool) {
+	num := r.line.num
+	resolve := func(e *constExpr) uint16 { return p.resolve(num, e) }
+	var attr classy.AttrInfo
+	switch directive {
+	case ".attribute":
+		name := resolve(r.text("attribute name"))
+		data := r.next("attribute data")
+		if p.err == nil && data.kind != tokBytes {
+			r.fail(`attribute data must be a b"..." string`)
+		}
+		attr = classy.AttrInfo{NameIndex: name, AttrData: []byte(data.text)}
+	case ".sourcefile":
+		attr = p.attrInfo(num, "SourceFile", u2s(resolve(r.text("source file"))))
+	case ".signature":
+		attr = p.attrInfo(num, "Signature", u2s(resolve(r.text("signature"))))
+	case ".deprecated":
+		attr = p.attrInfo(num, "Deprecated", nil)
+	case ".synthetic":
+		attr = p.attrInfo(num, "Synthetic", nil)
+	case ".constantvalue":
+		attr = p.attrInfo(num, "ConstantValue", u2s(resolve(r.constant())))
+	case ".nesthost":
+		attr = p.attrInfo(num, "NestHost", u2s(resolve(r.class("class"))))
+	case ".exceptions", ".nestmembers", ".permittedsubclasses":
+		var classes []uint16
+		for r.more() {
+			classes = append(classes, resolve(r.class("class")))
+		}
+		name := map[string]string{
+			".exceptions":          "Exceptions",
+			".nestmembers":         "NestMembers",
+			".permittedsubclasses": "PermittedSubclasses",
+		}[directive]
+		attr = p.attrInfo(num, name, u2s(append([]uint16{uint16(len(classes))}, classes...)...))
+	case ".enclosing":
+		r.expect("method")
+		class := resolve(r.class("class"))
+		attr = p.attrInfo(num, "EnclosingMethod", u2s(class, resolve(r.nameAndType())))
+	case ".innerclasses":
+		r.end()
+		var entries []uint16
+		p.block(num, "innerclasses", func(r *lineReader) {
+			inner := p.resolve(r.line.num, r.class("inner class"))
+			outer := p.resolve(r.line.num, r.class("outer class"))
+			name := p.resolve(r.line.num, r.text("inner name"))
+			entries = append(entries, inner, outer, name, uint16(r.flags(innerClassFlagNames)))
+		})
+		attr = p.attrInfo(num, "InnerClasses", u2s(append([]uint16{uint16(len(entries) / 4)}, entries...)...))
+	case ".bootstrapmethods":
+		r.end()
+		var data []uint16
+		count := uint16(0)
+		p.block(num, "bootstrapmethods", func(r *lineReader) {
+			handle := p.resolve(r.line.num, r.constant())
+			var args []uint16
+			for r.more() {
+				args = append(args, p.resolve(r.line.num, r.constant()))
+			}
+			data = append(append(data, handle, uint16(len(args))), args...)
+			count++
+		})
+		attr = p.attrInfo(num, "BootstrapMethods", u2s(append([]uint16{count}, data...)...))
+	case ".methodparameters":
+		r.end()
+		var params []uint16
+		p.block(num, "methodparameters", func(r *lineReader) {
+			params = append(params, p.resolve(r.line.num, r.text("parameter name")), uint16(r.flags(parameterFlagNames)))
+		})
+		if len(params)/2 > math.MaxUint8 {
+			p.fail(num, "too many parameters")
+		}
+		attr = p.attrInfo(num, "MethodParameters", append([]byte{byte(len(params) / 2)}, u2s(params...)...))
+	default:
+		return attr, false
+	}
+	r.end()
+	return attr, true
+}
+
+// pcRef is a bytecode offset written as a label, or as a number.
+type pcRef struct {
+	num   int
+	label string
+	pc    int
+}
+
+func (r *lineReader) pc(what string) pcRef {
+	word := r.word(what)
+	if v, err := strconv.Atoi(word); err == nil {
+		return pcRef{num: r.line.num, pc: v}
+	}
+	return pcRef{num: r.line.num, label: word}
+}
+
+// asmCode is a Code attribute being assembled, whose labels are only known once every
+// instruction has been laid out.
+type asmCode struct {
+	insns  []asmInsn
+	labels map[string]int
+	frames []asmFrame
+	// fixups build the parts of the attribute that refer to labels
+	fixups []func()
+}
+
+type asmInsn struct {
+	ins     classy.Instruction
+	target  pcRef
+	targets []pcRef
+}
+
+// asmFrame is a stack map frame, at the instruction that follows its .stack directive.
+// uninit holds the offset of each Uninitialized type among the locals and then the
+// stack items, which is only known once the code is laid out.
+type asmFrame struct {
+	num    int
+	insn   int
+	kind   string
+	frame  classy.StackMapFrame
+	uninit []pcRef
+}
+
+// stackFrameKinds are the kinds of .stack frame, by whether they list locals and stack items.
+var stackFrameKinds = map[string]struct{ locals, stack bool }{
+	"same":                              {},
+	"same_extended":                     {},
+	"same_locals_1_stack_item":          {stack: true},
+	"same_locals_1_stack_item_extended": {stack: true},
+	"chop":                              {},
+	"append":                            {locals: true},
+	"full":                              {locals: true, stack: true},
+}
+
+var verificationTags = map[string]byte{
+	"Top":               classy.ItemTop,
+	"Integer":           classy.ItemInteger,
+	"Float":             classy.ItemFloat,
+	"Double":            classy.ItemDouble,
+	"Long":              classy.ItemLong,
+	"Null":              classy.ItemNull,
+	"UninitializedThis": classy.ItemUninitializedThis,
+	"Object":            classy.ItemObject,
+	"Uninitialized":     classy.ItemUninitialized,
+}
+
+// stackFrame parses a .stack directive, whose frame is laid out with the code.
+func (p *asmParser) stackFrame(r *lineReader, code *asmCode) {
+	f := asmFrame{num: r.line.num, insn: len(code.insns), kind: r.word("frame kind")}
+	kind, ok := stackFrameKinds[f.kind]
+	if !ok {
+		r.fail("unknown frame kind %v", f.kind)
+		return
+	}
+	types := func(what string, stop string) []classy.VerificationTypeInfo {
+		var types []classy.VerificationTypeInfo
+		for r.more() && !r.peek(stop) {
+			name := r.word(what)
+			info := classy.VerificationTypeInfo{Tag: verificationTags[name]}
+			var ref pcRef
+			switch name {
+			case "Object":
+				info.CpoolIndex = p.resolve(r.line.num, r.class("class"))
+			case "Uninitialized":
+				ref = r.pc("new instruction")
+			default:
+				if _, ok := verificationTags[name]; !ok {
+					r.fail("unknown verification type %v", name)
+					return nil
+				}
+			}
+			types = append(types, info)
+			f.uninit = append(f.uninit, ref)
+		}
+		return types
+	}
+	switch {
+	case f.kind == "chop":
+		f.frame.FrameType = classy.SameFrameExtended - byte(r.int("chopped locals", 1, 3))
+	case kind.locals && kind.stack:
+		r.expect("locals")
+		f.frame.Locals = types("local type", "stack")
+		r.expect("stack")
+		f.frame.Stack = types("stack type", "")
+	case kind.locals:
+		f.frame.Locals = types("local type", "")
+		if len(f.frame.Locals) < 1 || len(f.frame.Locals) > 3 {
+			r.fail("append frames add 1 to 3 locals")
+		}
+	case kind.stack:
+		f.frame.Stack = types("stack type", "")
+		if len(f.frame.Stack) != 1 {
+			r.fail("%v frames have 1 stack item", f.kind)
+		}
+	}
+	r.end()
+	code.frames = append(code.frames, f)
+}
+
+// stackMapTable lays out the frames of the .stack directives, given the offset of each
+// instruction. same and same_locals_1_stack_item frames become _extended ones when
+// their offset_delta needs it.
+func (p *asmParser) stackMapTable(code *asmCode, offsets []int) []byte {
+	table := &classy.StackMapTableAttribute{}
+	last := -1
+	for _, f := range code.frames {
+		frame := f.frame
+		offset := offsets[f.insn]
+		delta := offset - last - 1
+		if delta < 0 || delta > math.MaxUint16 {
+			p.fail(f.num, "frame at offset %v is out of order", offset)
+			return nil
+		}
+		last = offset
+		frame.OffsetDelta = uint16(delta)
+		switch f.kind {
+		case "same":
+			frame.FrameType = classy.SameFrameExtended
+			if delta < 64 {
+				frame.FrameType = byte(delta)
+			}
+		case "same_extended":
+			frame.FrameType = classy.SameFrameExtended
+		case "same_locals_1_stack_item":
+			frame.FrameType = classy.SameLocals1StackItemExtended
+			if delta < 64 {
+				frame.FrameType = byte(64 + delta)
+			}
+		case "same_locals_1_stack_item_extended":
+			frame.FrameType = classy.SameLocals1StackItemExtended
+		case "append":
+			frame.FrameType = classy.SameFrameExtended + byte(len(frame.Locals))
+		case "full":
+			frame.FrameType = classy.FullFrame
+		}
+		for i, ref := range f.uninit {
+			if ref.num == 0 {
+				continue
+			}
+			if i < len(frame.Locals) {
+				frame.Locals[i].Offset = uint16(p.offset(code, ref))
+			} else {
+				frame.Stack[i-len(frame.Locals)].Offset = uint16(p.offset(code, ref))
+			}
+		}
+		table.Entries = append(table.Entries, frame)
+	}
+	data, err := classy.WriteStackMapTable(table)
+	if err != nil {
+		p.fail(code.frames[0].num, "%v", err)
+	}
+	return data
+}
+
+// offset resolves a pcRef once labels have their offsets.
+func (p *asmParser) offset(code *asmCode, ref pcRef) int {
+	if ref.label == "" {
+		return ref.pc
+	}
+	pc, ok := code.labels[ref.label]
+	if !ok {
+		p.fail(ref.num, "undefined label %v", ref.label)
+	}
+	return pc
+}
+
+// code assembles a Code attribute, from `.code stack N locals N` to `.end code`.
+func (p *asmParser) code(r *lineReader) classy.AttrInfo {
+	num := r.line.num
+	r.expect(".code")
+	r.expect("stack")
+	maxStack := r.u2("max stack")
+	r.expect("locals")
+	maxLocals := r.u2("max locals")
+	r.end()
+
+	code := &asmCode{labels: map[string]int{}}
+	var exceptions []uint16
+	var attrs []classy.AttrInfo
+	p.block(num, "code", func(r *lineReader) {
+		for r.more() && r.line.toks[r.i].kind == tokWord && strings.HasSuffix(r.line.toks[r.i].text, ":") {
+			label := strings.TrimSuffix(r.word("label"), ":")
+			if _, ok := code.labels[label]; ok {
+				r.fail("label %v is defined twice", label)
+			}
+			code.labels[label] = len(code.insns)
+		}
+		if !r.more() {
+			return
+		}
+		if r.line.toks[r.i].kind == tokWord && strings.HasPrefix(r.line.toks[r.i].text, ".") {
+			p.codeDirective(r, code, &exceptions, &attrs)
+			return
+		}
+		code.insns = append(code.insns, p.instruction(r))
+	})
+	if p.err != nil {
+		return classy.AttrInfo{}
+	}
+
+	// Lay out the instructions, and give labels their offsets
+	var insns []classy.Instruction
+	pos := 0
+	offsets := make([]int, len(code.insns)+1)
+	for i := range code.insns {
+		code.insns[i].ins.Offset = pos
+		offsets[i] = pos
+		pos += code.insns[i].ins.Size()
+	}
+	offsets[len(code.insns)] = pos
+	for label, i := range code.labels {
+		code.labels[label] = offsets[i]
+	}
+	for i := range code.insns {
+		insn := &code.insns[i]
+		if insn.ins.Switch != nil {
+			insn.ins.Switch.Default = p.offset(code, insn.target)
+			for j, target := range insn.targets {
+				insn.ins.Switch.Targets[j] = p.offset(code, target)
+			}
+		} else if insn.target.num != 0 {
+			insn.ins.Target = p.offset(code, insn.target)
+		}
+		insns = append(insns, insn.ins)
+	}
+	for _, fixup := range code.fixups {
+		fixup()
+	}
+	if len(code.frames) > 0 {
+		attrs = append(attrs, p.attrInfo(code.frames[0].num, "StackMapTable", p.stackMapTable(code, offsets)))
+	}
+	if p.err != nil {
+		return classy.AttrInfo{}
+	}
+	bytecode, err := classy.EncodeInstructions(insns)
+	if err != nil {
+		p.fail(num, "%v", err)
+		return classy.AttrInfo{}
+	}
+
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	write(maxStack)
+	write(maxLocals)
+	write(uint32(len(bytecode)))
+	write(bytecode)
+	write(uint16(len(exceptions) / 4))
+	write(exceptions)
+	write(uint16(len(attrs)))
+	for _, attr := range attrs {
+		write(attr.NameIndex)
+		write(uint32(len(attr.AttrData)))
+		write(attr.AttrData)
+	}
+	return p.attrInfo(num, "Code", buf.Bytes())
+}
+
+// codeDirective assembles a .catch or an attribute of a Code attribute.
+func (p *asmParser) codeDirective(r *lineReader, code *asmCode, exceptions *[]uint16, attrs *[]classy.AttrInfo) {
+	num := r.line.num
+	directive := r.word("directive")
+	switch directive {
+	case ".catch":
+		var catchType uint16
+		if r.peek("any") {
+			r.expect("any")
+		} else {
+			catchType = p.resolve(num, r.class("exception class"))
+		}
+		r.expect("from")
+		start := r.pc("start")
+		r.expect("to")
+		end := r.pc("end")
+		r.expect("using")
+		handler := r.pc("handler")
+		r.end()
+		at := len(*exceptions)
+		*exceptions = append(*exceptions, 0, 0, 0, catchType)
+		code.fixups = append(code.fixups, func() {
+			(*exceptions)[at] = uint16(p.offset(code, start))
+			(*exceptions)[at+1] = uint16(p.offset(code, end))
+			(*exceptions)[at+2] = uint16(p.offset(code, handler))
+		})
+	case ".stack":
+		p.stackFrame(r, code)
+	case ".linenumbertable":
+		r.end()
+		var entries []pcRef
+		var lines []uint16
+		p.block(num, "linenumbertable", func(r *lineReader) {
+			entries = append(entries, r.pc("start"))
+			lines = append(lines, r.u2("line number"))
+			r.end()
+		})
+		at := len(*attrs)
+		*attrs = append(*attrs, p.attrInfo(num, "LineNumberTable", nil))
+		code.fixups = append(code.fixups, func() {
+			data := []uint16{uint16(len(entries))}
+			for i, entry := range entries {
+				data = append(data, uint16(p.offset(code, entry)), lines[i])
+			}
+			(*attrs)[at].AttrData = u2s(data...)
+		})
+	case ".localvariabletable", ".localvariabletypetable":
+		r.end()
+		name := strings.TrimPrefix(directive, ".")
+		type local struct {
+			start, end pcRef
+			fields     []uint16
+		}
+		var locals []local
+		p.block(num, name, func(r *lineReader) {
+			index := r.u2("local variable index")
+			r.expect("is")
+			nameIndex := p.resolve(r.line.num, r.text("name"))
+			descriptor := p.resolve(r.line.num, r.text("descriptor"))
+			r.expect("from")
+			start := r.pc("start")
+			r.expect("to")
+			end := r.pc("end")
+			r.end()
+			locals = append(locals, local{start, end, []uint16{nameIndex, descriptor, index}})
+		})
+		attrName := "LocalVariableTable"
+		if directive == ".localvariabletypetable" {
+			attrName = "LocalVariableTypeTable"
+		}
+		at := len(*attrs)
+		*attrs = append(*attrs, p.attrInfo(num, attrName, nil))
+		code.fixups = append(code.fixups, func() {
+			data := []uint16{uint16(len(locals))}
+			for _, local := range locals {
+				start := p.offset(code, local.start)
+				data = append(data, uint16(start), uint16(p.offset(code, local.end)-start))
+				data = append(data, local.fields...)
+			}
+			(*attrs)[at].AttrData = u2s(data...)
+		})
+	default:
+		attr, ok := p.attr(r, directive)
+		if !ok {
+			r.fail("unknown directive %v in code", directive)
+		}
+		*attrs = append(*attrs, attr)
+	}
+}
+
+// classOperands are the instructions whose constant is a class, which is written as
+// just the name of the class.
+var classOperands = map[classy.Opcode]bool{
+	classy.OpNew:        true,
+	classy.OpAnewarray:  true,
+	classy.OpCheckcast:  true,
+	classy.OpInstanceof: true,
+}
+
+// instruction assembles an instruction. Switches continue on the following lines, one
+// target per line, up to their default.
+func (p *asmParser) instruction(r *lineReader) asmInsn {
+	var insn asmInsn
+	ins := &insn.ins
+	mnemonic := r.word("instruction")
+	if mnemonic == "wide" {
+		ins.Wide = true
+		mnemonic = r.word("instruction")
+	}
+	op, ok := classy.LookupOpcode(mnemonic)
+	if !ok || op == classy.OpWide {
+		r.fail("unknown instruction %v", mnemonic)
+		return insn
+	}
+	ins.Opcode = op
+
+	switch op.Operands() {
+	case classy.OperandLocal:
+		ins.Index = r.u2("local variable index")
+		ins.Wide = ins.Wide || ins.Index > math.MaxUint8
+	case classy.OperandIinc:
+		ins.Index = r.u2("local variable index")
+		ins.Value = int32(r.int("increment", math.MinInt16, math.MaxInt16))
+		ins.Wide = ins.Wide || ins.Index > math.MaxUint8 || ins.Value < math.MinInt8 || ins.Value > math.MaxInt8
+	case classy.OperandByte:
+		ins.Value = int32(r.int("value", math.MinInt8, math.MaxInt8))
+	case classy.OperandShort:
+		ins.Value = int32(r.int("value", math.MinInt16, math.MaxInt16))
+	case classy.OperandAtype:
+		word := r.word("array type")
+		ins.Value = -1
+		for atype := classy.TBoolean; atype <= classy.TLong; atype++ {
+			if classy.ArrayTypeName(atype) == word {
+				ins.Value = int32(atype)
+			}
+		}
+		if v, err := strconv.ParseUint(word, 10, 8); err == nil {
+			ins.Value = int32(v)
+		}
+		if ins.Value < 0 && p.err == nil {
+			r.fail("unknown array type %v", word)
+		}
+	case classy.OperandCpByte, classy.OperandCp:
+		if classOperands[op] {
+			ins.Index = p.resolve(r.line.num, r.class("class"))
+		} else {
+			ins.Index = p.resolve(r.line.num, r.constant())
+		}
+		if op == classy.OpLdc && ins.Index > math.MaxUint8 {
+			ins.Opcode = classy.OpLdcW
+		}
+	case classy.OperandInterface:
+		ins.Index = p.resolve(r.line.num, r.constant())
+		if r.more() {
+			ins.Value = int32(r.int("count", 0, math.MaxUint8))
+		} else if p.err == nil {
+			ins.Value = p.interfaceCount(r, ins.Index)
+		}
+	case classy.OperandDynamic:
+		ins.Index = p.resolve(r.line.num, r.constant())
+	case classy.OperandMultiArray:
+		ins.Index = p.resolve(r.line.num, r.class("class"))
+		ins.Value = int32(r.int("dimensions", 0, math.MaxUint8))
+	case classy.OperandBranch, classy.OperandBranchWide:
+		insn.target = r.pc("target")
+	case classy.OperandTableswitch:
+		ins.Switch = &classy.Switch{Low: int32(r.int("low", math.MinInt32, math.MaxInt32))}
+		r.end()
+		insn.target, insn.targets, _ = p.switchTargets(r.line.num, false)
+		ins.Switch.High = ins.Switch.Low + int32(len(insn.targets)) - 1
+		ins.Switch.Targets = make([]int, len(insn.targets))
+	case classy.OperandLookupswitch:
+		ins.Switch = &classy.Switch{}
+		r.end()
+		insn.target, insn.targets, ins.Switch.Keys = p.switchTargets(r.line.num, true)
+		ins.Switch.Targets = make([]int, len(insn.targets))
+	}
+	r.end()
+	return insn
+}
+
+// switchTargets reads the lines of a switch: a target per line, preceded by its key and
+// a colon for lookupswitch, and finally `default : target`.
+func (p *asmParser) switchTargets(start int, keyed bool) (pcRef, []pcRef, []int32) {
+	var targets []pcRef
+	var keys []int32
+	for {
+		r := p.next()
+		if r == nil {
+			p.fail(start, "missing default for switch")
+			return pcRef{}, nil, nil
+		}
+		if r.peek("default") {
+			r.expect("default")
+			r.expect(":")
+			dflt := r.pc("default target")
+			r.end()
+			return dflt, targets, keys
+		}
+		if keyed {
+			keys = append(keys, int32(r.int("key", math.MinInt32, math.MaxInt32)))
+			r.expect(":")
+		}
+		targets = append(targets, r.pc("target"))
+		r.end()
+	}
+}
+
+// interfaceCount computes the count operand of invokeinterface from the method's
+// descriptor.
+func (p *asmParser) interfaceCount(r *lineReader, index uint16) int32 {
+	entry := func(i uint16) classy.CpEntry {
+		return classy.LookupEntry(p.pool.cp, i)
+	}
+	if ref, ok := entry(index).(*classy.CONSTANT_InterfaceMethodref_info); ok {
+		if nat, ok := entry(ref.NameAndTypeIndex).(*classy.CONSTANT_NameAndType_info); ok {
+			if utf8, ok := entry(nat.DescriptorIndex).(*classy.CONSTANT_Utf8_info); ok {
+				if desc, err := classy.ParseMethodDescriptor(string(utf8.Bytes)); err == nil {
+					return int32(desc.ParamSlots() + 1)
+				}
+			}
+		}
+	}
+	r.fail("can't work out the count for invokeinterface, give it after the method")
+	return 0
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/a10y/classy"
+)
+
+// This file holds what the disassembler and assembler share: the lexer for .j files,
+// quoting, flag names and the keys that identify constants by their contents.
+
+type tokenKind int
+
+const (
+	// tokWord is a run of non-blank characters
+	tokWord tokenKind = iota
+	// tokString is a double quoted string, with Go escapes
+	tokString
+	// tokBytes is a double quoted string prefixed with b, holding raw bytes
+	tokBytes
+)
+
+type token struct {
+	kind tokenKind
+	// text is the word, or the unquoted contents of a string
+	text string
+}
+
+// asmLine is a line of a .j file with its comment removed. Blank lines are dropped.
+type asmLine struct {
+	num  int
+	toks []token
+}
+
+// tokenize splits a .j file into lines of tokens. A comment starts with a ; at the start
+// of a token and runs to the end of the line.
+func tokenize(src string) ([]asmLine, error) {
+	var lines []asmLine
+	for i, text := range strings.Split(src, "\n") {
+		line := asmLine{num: i + 1}
+		for pos := 0; pos < len(text); {
+			c := text[pos]
+			switch {
+			case c == ' ' || c == '\t' || c == '\r':
+				pos++
+				continue
+			case c == ';':
+				pos = len(text)
+				continue
+			}
+
+			kind := tokWord
+			if c == '"' || (c == 'b' && pos+1 < len(text) && text[pos+1] == '"') {
+				kind = tokString
+				if c == 'b' {
+					kind = tokBytes
+					pos++
+				}
+				end := pos + 1
+				for end < len(text) && text[end] != '"' {
+					if text[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(text) {
+					return nil, fmt.Errorf("line %v: unterminated string", line.num)
+				}
+				s, err := strconv.Unquote(text[pos : end+1])
+				if err != nil {
+					return nil, fmt.Errorf("line %v: invalid string %v", line.num, text[pos:end+1])
+				}
+				line.toks = append(line.toks, token{kind, s})
+				pos = end + 1
+				continue
+			}
+
+			end := pos
+			for end < len(text) && text[end] != ' ' && text[end] != '\t' && text[end] != '\r' {
+				end++
+			}
+			line.toks = append(line.toks, token{kind, text[pos:end]})
+			pos = end
+		}
+		if len(line.toks) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// rawRef matches a reference to a constant pool entry by its index, e.g. [12].
+var rawRef = regexp.MustCompile(`^\[[0-9]+\]$`)
+
+// quoteWord renders text as a bare word when it would be read back as one, and as a
+// quoted string otherwise.
+func quoteWord(s string) string {
+	if s == "" || s == "any" || rawRef.MatchString(s) || strings.HasSuffix(s, ":") ||
+		s[0] == ';' || s[0] == '.' || !utf8.ValidString(s) {
+		return strconv.Quote(s)
+	}
+	for _, r := range s {
+		if r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+// quoteBytes renders raw bytes as a b"..." string, escaping everything but printable
+// ASCII.
+func quoteBytes(b []byte) string {
+	var sb strings.Builder
+	sb.WriteString(`b"`)
+	for _, c := range b {
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// utf8Text renders the contents of a CONSTANT_Utf8 entry, falling back to raw bytes
+// when they aren't the modified UTF-8 encoding of any string.
+func utf8Text(b []byte, quoted bool) string {
+	s, err := classy.DecodeModifiedUTF8(b)
+	if err != nil || string(classy.EncodeModifiedUTF8(s)) != string(b) {
+		return quoteBytes(b)
+	}
+	if quoted {
+		return strconv.Quote(s)
+	}
+	return quoteWord(s)
+}
+
+// Flag names, which are the names of the ACC_ constants in lower case. Bits without a
+// name are written as hex numbers.
+var (
+	innerClassFlagNames = []flagName{
+		{classy.AccPublic, "ACC_PUBLIC"},
+		{classy.AccPrivate, "ACC_PRIVATE"},
+		{classy.AccProtected, "ACC_PROTECTED"},
+		{classy.AccStatic, "ACC_STATIC"},
+		{classy.AccFinal, "ACC_FINAL"},
+		{classy.AccInterface, "ACC_INTERFACE"},
+		{classy.AccAbstract, "ACC_ABSTRACT"},
+		{classy.AccSynthetic, "ACC_SYNTHETIC"},
+		{classy.AccAnnotation, "ACC_ANNOTATION"},
+		{classy.AccEnum, "ACC_ENUM"},
+	}
+	parameterFlagNames = []flagName{
+		{classy.AccFinal, "ACC_FINAL"},
+		{classy.AccSynthetic, "ACC_SYNTHETIC"},
+		{classy.AccMandated, "ACC_MANDATED"},
+	}
+)
+
+func asmFlags(acc classy.Access, names []flagName) []string {
+	var words []string
+	for _, name := range names {
+		if acc&name.flag != 0 {
+			words = append(words, strings.ToLower(strings.TrimPrefix(name.name, "ACC_")))
+			acc &^= name.flag
+		}
+	}
+	if acc != 0 {
+		words = append(words, fmt.Sprintf("0x%04x", uint16(acc)))
+	}
+	return words
+}
+
+// parseFlag reads a flag written by asmFlags.
+func parseFlag(word string, names []flagName) (classy.Access, bool) {
+	for _, name := range names {
+		if word == strings.ToLower(strings.TrimPrefix(name.name, "ACC_")) {
+			return name.flag, true
+		}
+	}
+	if strings.HasPrefix(word, "0x") {
+		if v, err := strconv.ParseUint(word[2:], 16, 16); err == nil {
+			return classy.Access(v), true
+		}
+	}
+	return 0, false
+}
+
+// refKindNames are the names of method handle reference kinds, without REF_.
+func refKindName(kind byte) string {
+	if name := classy.ReferenceKindName(kind); strings.HasPrefix(name, "REF_") && !strings.HasPrefix(name, "REF_unknown") {
+		return strings.TrimPrefix(name, "REF_")
+	}
+	return strconv.Itoa(int(kind))
+}
+
+func parseRefKind(word string) (byte, bool) {
+	for kind := byte(1); kind <= classy.RefInvokeInterface; kind++ {
+		if word == refKindName(kind) {
+			return kind, true
+		}
+	}
+	v, err := strconv.ParseUint(word, 10, 8)
+	return byte(v), err == nil
+}
+
+// maxKeyDepth bounds how deeply constantKey follows references, which keeps it finite
+// for malformed pools with cycles. No well-formed constant nests deeper than a
+// MethodHandle's Utf8 names.
+const maxKeyDepth = 3
+
+// constantKey identifies the constant at index by its contents rather than its index,
+// so that two entries with the same key are interchangeable. References that can't be
+// followed are keyed by their index.
+func constantKey(cp []classy.CpEntry, index uint16, depth int) string {
+	ent := classy.LookupEntry(cp, index)
+	if ent == nil || depth > maxKeyDepth {
+		return fmt.Sprintf("[%v]", index)
+	}
+	key := func(i uint16) string { return constantKey(cp, i, depth+1) }
+	switch e := ent.(type) {
+	case *classy.CONSTANT_Utf8_info:
+		return fmt.Sprintf("(Utf8 %q)", e.Bytes)
+	case *classy.CONSTANT_Integer_info:
+		return fmt.Sprintf("(Int %v)", e.Bytes)
+	case *classy.CONSTANT_Float_info:
+		return fmt.Sprintf("(Float %v)", e.Bytes)
+	case *classy.CONSTANT_Long_info:
+		return fmt.Sprintf("(Long %v)", uint64(e.HighBytes)<<32|uint64(e.LowBytes))
+	case *classy.CONSTANT_Double_info:
+		return fmt.Sprintf("(Double %v)", uint64(e.HighBytes)<<32|uint64(e.LowBytes))
+	case *classy.CONSTANT_Class_info:
+		return fmt.Sprintf("(Class %v)", key(e.NameIndex))
+	case *classy.CONSTANT_String_info:
+		return fmt.Sprintf("(String %v)", key(e.StringIndex))
+	case *classy.CONSTANT_Fieldref_info:
+		return fmt.Sprintf("(Field %v %v)", key(e.ClassIndex), key(e.NameAndTypeIndex))
+	case *classy.CONSTANT_Methodref_info:
+		return fmt.Sprintf("(Method %v %v)", key(e.ClassIndex), key(e.NameAndTypeIndex))
+	case *classy.CONSTANT_InterfaceMethodref_info:
+		return fmt.Sprintf("(InterfaceMethod %v %v)", key(e.ClassIndex), key(e.NameAndTypeIndex))
+	case *classy.CONSTANT_NameAndType_info:
+		return fmt.Sprintf("(NameAndType %v %v)", key(e.NameIndex), key(e.DescriptorIndex))
+	case *classy.CONSTANT_MethodHandle_info:
+		return fmt.Sprintf("(MethodHandle %v %v)", e.ReferenceKind, key(e.ReferenceIndex))
+	case *classy.CONSTANT_MethodType_info:
+		return fmt.Sprintf("(MethodType %v)", key(e.DescriptorIndex))
+	case *classy.CONSTANT_Dynamic_info:
+		return fmt.Sprintf("(Dynamic %v %v)", e.BootstrapMethodAttrIndex, key(e.NameAndTypeIndex))
+	case *classy.CONSTANT_InvokeDynamic_info:
+		return fmt.Sprintf("(InvokeDynamic %v %v)", e.BootstrapMethodAttrIndex, key(e.NameAndTypeIndex))
+	case *classy.CONSTANT_Module_info:
+		return fmt.Sprintf("(Module %v)", key(e.NameIndex))
+	case *classy.CONSTANT_Package_info:
+		return fmt.Sprintf("(Package %v)", key(e.NameIndex))
+	}
+	return fmt.Sprintf("[%v]", index)
+}
+
+// constantKeys maps the key of every constant to the first index holding it, which is
+// the entry a constant written by value resolves to.
+func constantKeys(cp []classy.CpEntry) map[string]uint16 {
+	keys := map[string]uint16{}
+	for i := range cp {
+		if cp[i] == nil {
+			continue
+		}
+		key := constantKey(cp, uint16(i+1), 0)
+		if _, ok := keys[key]; !ok {
+			keys[key] = uint16(i + 1)
+		}
+	}
+	return keys
+}
+
+// The bit patterns Java uses for NaN, which are written as plain NaN.
+const (
+	canonicalFloatNaN  = 0x7fc00000
+	canonicalDoubleNaN = 0x7ff8000000000000
+)
+
+// floatText writes a float constant the way Java does, with NaNs other than the
+// canonical one giving their bits as NaN<0x7fc00001>.
+func floatText(bits uint32) string {
+	f := math.Float32frombits(bits)
+	if f != f && bits != canonicalFloatNaN {
+		return fmt.Sprintf("NaN<0x%08x>f", bits)
+	}
+	return (&classy.CONSTANT_Float_info{Bytes: bits}).Repr(nil) + "f"
+}
+
+func doubleText(bits uint64) string {
+	f := math.Float64frombits(bits)
+	if f != f && bits != canonicalDoubleNaN {
+		return fmt.Sprintf("NaN<0x%016x>", bits)
+	}
+	return (&classy.CONSTANT_Double_info{HighBytes: uint32(bits >> 32), LowBytes: uint32(bits)}).Repr(nil)
+}
+
+// parseFloatBits reads a float or double written by floatText or doubleText, without
+// its f suffix, returning its bits.
+func parseFloatBits(s string, bitSize int) (uint64, bool) {
+	if strings.HasPrefix(s, "NaN<") && strings.HasSuffix(s, ">") {
+		bits, err := strconv.ParseUint(s[4:len(s)-1], 0, bitSize)
+		return bits, err == nil
+	}
+	f, err := strconv.ParseFloat(s, bitSize)
+	if err != nil && !isRangeError(err) {
+		return 0, false
+	}
+	switch {
+	case bitSize == 32 && f != f:
+		return canonicalFloatNaN, true
+	case bitSize == 32:
+		return uint64(math.Float32bits(float32(f))), true
+	case f != f:
+		return canonicalDoubleNaN, true
+	default:
+		return math.Float64bits(f), true
+	}
+}
+
+func isRangeError(err error) bool {
+	numErr, ok := err.(*strconv.NumError)
+	return ok && numErr.Err == strconv.ErrRange
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/a10y/classy"
+)
+
+// assembleJSON rebuilds a classfile from a document printed by --format=json, so that
+// a class can be patched in a text editor.
+func assembleJSON(args []string) {
+	flags := flag.NewFlagSet("assemble-json", flag.ExitOnError)
+	flags.Usage = usage
+	output := flags.String("o", "", "where to write the classfile, - for stdout")
+	input := inputArg(flags, args)
+	classFile, err := assemble(readInput(input))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error assembling %v: %v\n", input, err)
+		os.Exit(1)
+	}
+	writeAssembled(classFile, input, *output)
+}
+
+// inputArg parses the flags of a command that takes a single input file, which may
+// come before or after the flags.
+func inputArg(flags *flag.FlagSet, args []string) string {
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		usage()
+	}
+	input := flags.Arg(0)
+	flags.Parse(flags.Args()[1:])
+	if flags.NArg() != 0 {
+		usage()
+	}
+	return input
+}
+
+// readInput reads a file to assemble, or stdin if input is -.
+func readInput(input string) []byte {
+	var data []byte
+	var err error
+	if input == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(input)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %v: %v\n", input, err)
+		os.Exit(-1)
+	}
+	return data
+}
+
+// writeAssembled writes an assembled class to output, which defaults to the input with
+// its extension replaced by .class, or to stdout when output is -.
+func writeAssembled(classFile *classy.ClassFile, input, output string) {
+	class, err := classy.WriteClassFile(classFile)
+	if err == nil {
+		// Catch edits that leave a classfile we couldn't read back, such as a constant
+		// tag the class version doesn't allow
+		_, err = classy.ReadClassFile(class)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error assembling %v: %v\n", input, err)
+		os.Exit(1)
+	}
+
+	switch {
+	case output == "-":
+		_, err = os.Stdout.Write(class)
+	case output != "":
+		err = ioutil.WriteFile(output, class, 0644)
+	case input == "-":
+		fmt.Fprintf(os.Stderr, "-o is needed when reading from stdin\n")
+		os.Exit(-1)
+	default:
+		output = strings.TrimSuffix(input, filepath.Ext(input)) + ".class"
+		err = ioutil.WriteFile(output, class, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %v: %v\n", output, err)
+		os.Exit(-1)
+	}
+}
+
+// assemble builds a ClassFile from a JSON document in the schema of dumpClass. Only the
+// authoritative parts of the document are read: indexes, flags and the values of Utf8
+// and numeric constants, and the data of attributes. Names, flag lists, decoded
+// attributes and the values of other constants are ignored, and counts are recomputed.
+func assemble(data []byte) (*classy.ClassFile, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if _, ok := doc.([]interface{}); ok {
+		return nil, fmt.Errorf("document holds several classes; dump a single class, as in app.jar!/com/foo/Bar.class")
+	}
+
+	a := &assembler{}
+	root := a.object(doc, "document")
+	if version := a.uint(root, "schema_version", "document", 16); a.err == nil && version != schemaVersion {
+		return nil, fmt.Errorf("unsupported schema_version %v, expected %v", version, schemaVersion)
+	}
+
+	cf := &classy.ClassFile{}
+	magic := a.string(root, "magic", "document")
+	if a.err == nil {
+		m, err := strconv.ParseUint(magic, 0, 32)
+		if err != nil {
+			a.fail("magic", "invalid magic %q", magic)
+		}
+		cf.Magic = uint32(m)
+	}
+	cf.MinorVersion = uint16(a.uint(root, "minor_version", "document", 16))
+	cf.MajorVersion = uint16(a.uint(root, "major_version", "document", 16))
+
+	for i, v := range a.list(root, "constant_pool", "document") {
+		path := fmt.Sprintf("constant_pool[%v]", i)
+		entry := a.object(v, path)
+		index := int(a.uint(entry, "index", path, 16))
+		ent := a.constant(entry, path)
+		if a.err != nil {
+			break
+		}
+		if index == 0 {
+			a.fail(path, "index 0 is not a valid constant pool index")
+			break
+		}
+		width := 1
+		switch ent.(type) {
+		case *classy.CONSTANT_Long_info, *classy.CONSTANT_Double_info:
+			width = 2
+		}
+		for len(cf.ConstantPool) < index-1+width {
+			cf.ConstantPool = append(cf.ConstantPool, nil)
+		}
+		for slot := index - 1; slot < index-1+width; slot++ {
+			if cf.ConstantPool[slot] != nil {
+				a.fail(path, "constant pool index %v is already taken", slot+1)
+			}
+		}
+		cf.ConstantPool[index-1] = ent
+	}
+
+	cf.AccessFlags = classy.Access(a.uint(root, "access_flags", "document", 16))
+	cf.ThisClass = uint16(a.uint(root, "this_class", "document", 16))
+	cf.SuperClass = uint16(a.uint(root, "super_class", "document", 16))
+	for i, v := range a.list(root, "interfaces", "document") {
+		path := fmt.Sprintf("interfaces[%v]", i)
+		cf.Interfaces = append(cf.Interfaces, uint16(a.uint(a.object(v, path), "index", path, 16)))
+	}
+	for i, v := range a.list(root, "fields", "document") {
+		path := fmt.Sprintf("fields[%v]", i)
+		member := a.object(v, path)
+		cf.Fields = append(cf.Fields, classy.FieldInfo{
+			AccessFlags:     classy.Access(a.uint(member, "access_flags", path, 16)),
+			NameIndex:       uint16(a.uint(member, "name_index", path, 16)),
+			DescriptorIndex: uint16(a.uint(member, "descriptor_index", path, 16)),
+			Attrs:           a.attrs(member, path),
+		})
+	}
+	for i, v := range a.list(root, "methods", "document") {
+		path := fmt.Sprintf("methods[%v]", i)
+		member := a.object(v, path)
+		cf.Methods = append(cf.Methods, classy.MethodInfo{
+			AccessFlags:     classy.Access(a.uint(member, "access_flags", path, 16)),
+			NameIndex:       uint16(a.uint(member, "name_index", path, 16)),
+			DescriptorIndex: uint16(a.uint(member, "descriptor_index", path, 16)),
+			Attrs:           a.attrs(member, path),
+		})
+	}
+	cf.Attrs = a.attrs(root, "document")
+
+	if a.err != nil {
+		return nil, a.err
+	}
+	if err := cf.UpdateCounts(); err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+// assembler reads values out of a decoded JSON document. Like the classfile decoder it
+// keeps the first error it runs into, naming where in the document it was, and returns
+// zero values after that.
+type assembler struct {
+	err error
+}
+
+func (a *assembler) fail(path, format string, args ...interface{}) {
+	if a.err == nil {
+		a.err = fmt.Errorf("%v: %v", path, fmt.Sprintf(format, args...))
+	}
+}
+
+func (a *assembler) object(v interface{}, path string) map[string]interface{} {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		a.fail(path, "expected an object")
+	}
+	return obj
+}
+
+func (a *assembler) get(obj map[string]interface{}, key, path string) interface{} {
+	if a.err != nil {
+		return nil
+	}
+	v, ok := obj[key]
+	if !ok {
+		a.fail(path, "missing %q", key)
+	}
+	return v
+}
+
+func (a *assembler) list(obj map[string]interface{}, key, path string) []interface{} {
+	v := a.get(obj, key, path)
+	items, ok := v.([]interface{})
+	if !ok {
+		a.fail(path, "%q must be a list", key)
+	}
+	return items
+}
+
+func (a *assembler) string(obj map[string]interface{}, key, path string) string {
+	v := a.get(obj, key, path)
+	s, ok := v.(string)
+	if !ok {
+		a.fail(path, "%q must be a string", key)
+	}
+	return s
+}
+
+// uint reads an unsigned integer that must fit in the given number of bits.
+func (a *assembler) uint(obj map[string]interface{}, key, path string, bits int) uint64 {
+	v := a.get(obj, key, path)
+	n, ok := v.(json.Number)
+	if !ok {
+		a.fail(path, "%q must be a number", key)
+		return 0
+	}
+	u, err := strconv.ParseUint(string(n), 10, bits)
+	if err != nil {
+		a.fail(path, "%q must be an integer from 0 to %v, found %v", key, uint64(1)<<uint(bits)-1, n)
+	}
+	return u
+}
+
+// int reads a signed integer that must fit in the given number of bits.
+func (a *assembler) int(obj map[string]interface{}, key, path string, bits int) int64 {
+	v := a.get(obj, key, path)
+	n, ok := v.(json.Number)
+	if !ok {
+		a.fail(path, "%q must be a number", key)
+		return 0
+	}
+	i, err := strconv.ParseInt(string(n), 10, bits)
+	if err != nil {
+		a.fail(path, "%q must be a %v-bit integer, found %v", key, bits, n)
+	}
+	return i
+}
+
+// float reads a float of the given precision, given as a number or as one of the
+// strings "NaN", "Infinity" and "-Infinity".
+func (a *assembler) float(obj map[string]interface{}, key, path string, bits int) float64 {
+	v := a.get(obj, key, path)
+	switch x := v.(type) {
+	case json.Number:
+		f, err := strconv.ParseFloat(string(x), bits)
+		if err != nil {
+			a.fail(path, "%q is not a valid %v-bit float: %v", key, bits, x)
+		}
+		return f
+	case string:
+		switch x {
+		case "NaN":
+			return math.NaN()
+		case "Infinity":
+			return math.Inf(1)
+		case "-Infinity":
+			return math.Inf(-1)
+		}
+	}
+	a.fail(path, "%q must be a number, \"NaN\", \"Infinity\" or \"-Infinity\"", key)
+	return 0
+}
+
+// constant builds a constant pool entry from its tag and its value or index fields.
+func (a *assembler) constant(entry map[string]interface{}, path string) classy.CpEntry {
+	tag := a.string(entry, "tag", path)
+	if a.err != nil {
+		return nil
+	}
+	u2 := func(key string) uint16 {
+		return uint16(a.uint(entry, key, path, 16))
+	}
+	switch tag {
+	case "Utf8":
+		return classy.NewUtf8(a.string(entry, "value", path))
+	case "Integer":
+		return &classy.CONSTANT_Integer_info{Tag: classy.CONSTANT_Integer, Bytes: uint32(a.int(entry, "value", path, 32))}
+	case "Float":
+		f := a.float(entry, "value", path, 32)
+		bits := math.Float32bits(float32(f))
+		if math.IsNaN(f) {
+			// The canonical NaN, which javac and Float.floatToIntBits use
+			bits = 0x7fc00000
+		}
+		return &classy.CONSTANT_Float_info{Tag: classy.CONSTANT_Float, Bytes: bits}
+	case "Long":
+		v := uint64(a.int(entry, "value", path, 64))
+		return &classy.CONSTANT_Long_info{Tag: classy.CONSTANT_Long, HighBytes: uint32(v >> 32), LowBytes: uint32(v)}
+	case "Double":
+		f := a.float(entry, "value", path, 64)
+		v := math.Float64bits(f)
+		if math.IsNaN(f) {
+			v = 0x7ff8000000000000
+		}
+		return &classy.CONSTANT_Double_info{Tag: classy.CONSTANT_Double, HighBytes: uint32(v >> 32), LowBytes: uint32(v)}
+	case "Class":
+		return &classy.CONSTANT_Class_info{Tag: classy.CONSTANT_Class, NameIndex: u2("name_index")}
+	case "String":
+		return &classy.CONSTANT_String_info{Tag: classy.CONSTANT_String, StringIndex: u2("string_index")}
+	case "Fieldref":
+		return &classy.CONSTANT_Fieldref_info{Tag: classy.CONSTANT_Fieldref, ClassIndex: u2("class_index"), NameAndTypeIndex: u2("name_and_type_index")}
+	case "Methodref":
+		return &classy.CONSTANT_Methodref_info{Tag: classy.CONSTANT_Methodref, ClassIndex: u2("class_index"), NameAndTypeIndex: u2("name_and_type_index")}
+	case "InterfaceMethodref":
+		return &classy.CONSTANT_InterfaceMethodref_info{Tag: classy.CONSTANT_InterfaceMethodref, ClassIndex: u2("class_index"), NameAndTypeIndex: u2("name_and_type_index")}
+	case "NameAndType":
+		return &classy.CONSTANT_NameAndType_info{Tag: classy.CONSTANT_NameAndType, NameIndex: u2("name_index"), DescriptorIndex: u2("descriptor_index")}
+	case "MethodHandle":
+		return &classy.CONSTANT_MethodHandle_info{Tag: classy.CONSTANT_MethodHandle, ReferenceKind: byte(a.uint(entry, "reference_kind", path, 8)), ReferenceIndex: u2("reference_index")}
+	case "MethodType":
+		return &classy.CONSTANT_MethodType_info{Tag: classy.CONSTANT_MethodType, DescriptorIndex: u2("descriptor_index")}
+	case "Dynamic":
+		return &classy.CONSTANT_Dynamic_info{Tag: classy.CONSTANT_Dynamic, BootstrapMethodAttrIndex: u2("bootstrap_method_attr_index"), NameAndTypeIndex: u2("name_and_type_index")}
+	case "InvokeDynamic":
+		return &classy.CONSTANT_InvokeDynamic_info{Tag: classy.CONSTANT_InvokeDynamic, BootstrapMethodAttrIndex: u2("bootstrap_method_attr_index"), NameAndTypeIndex: u2("name_and_type_index")}
+	case "Module":
+		return &classy.CONSTANT_Module_info{Tag: classy.CONSTANT_Module, NameIndex: u2("name_index")}
+	case "Package":
+		return &classy.CONSTANT_Package_info{Tag: classy.CONSTANT_Package, NameIndex: u2("name_index")}
+	}
+	a.fail(path, "unknown tag %q", tag)
+	return nil
+}
+
+// attrs builds the attributes of a class, field or method from their name index and
+// raw data.
+func (a *assembler) attrs(obj map[string]interface{}, path string) []classy.AttrInfo {
+	var attrs []classy.AttrInfo
+	for i, v := range a.list(obj, "attributes", path) {
+		attrPath := fmt.Sprintf("%v.attributes[%v]", path, i)
+		if path == "document" {
+			attrPath = fmt.Sprintf("attributes[%v]", i)
+		}
+		attr := a.object(v, attrPath)
+		nameIndex := uint16(a.uint(attr, "name_index", attrPath, 16))
+		data, err := hex.DecodeString(a.string(attr, "data", attrPath))
+		if err != nil {
+			a.fail(attrPath, "\"data\" must be hex: %v", err)
+		}
+		attrs = append(attrs, classy.AttrInfo{NameIndex: nameIndex, AttrData: data})
+	}
+	return attrs
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/a10y/classy"
+)
+
+// describeAttr renders a decoded attribute as a one-line summary followed by any
+// per-entry detail lines.
+func describeAttr(attr classy.Attribute, cp []classy.CpEntry) (string, []string) {
+	switch a := attr.(type) {
+	case *classy.SourceFileAttribute:
+		return "SourceFile: " + a.SourceFile(cp), nil
+	case *classy.SignatureAttribute:
+		return "Signature: " + a.Signature(cp), nil
+	case *classy.ConstantValueAttribute:
+		if value := a.Value(cp); value != nil {
+			return "ConstantValue: " + value.Repr(cp), nil
+		}
+		return fmt.Sprintf("ConstantValue: #%v", a.ConstantValueIndex), nil
+	case *classy.ExceptionsAttribute:
+		return "Exceptions: " + strings.Join(a.Names(cp), ", "), nil
+	case *classy.NestHostAttribute:
+		return "NestHost: " + a.HostClassName(cp), nil
+	case *classy.NestMembersAttribute:
+		return "NestMembers: " + strings.Join(a.Names(cp), ", "), nil
+	case *classy.PermittedSubclassesAttribute:
+		return "PermittedSubclasses: " + strings.Join(a.Names(cp), ", "), nil
+	case *classy.EnclosingMethodAttribute:
+		line := "EnclosingMethod: " + a.ClassName(cp)
+		if method := a.Method(cp); method != "" {
+			line += "." + method
+		}
+		return line, nil
+	case *classy.SourceDebugExtensionAttribute:
+		return fmt.Sprintf("SourceDebugExtension: %v bytes", len(a.DebugExtension)), nil
+	case *classy.InnerClassesAttribute:
+		var lines []string
+		for _, class := range a.Classes {
+			line := class.InnerClassName(cp)
+			if outer := class.OuterClassName(cp); outer != "" {
+				line += " of " + outer
+			}
+			if name := class.InnerName(cp); name != "" {
+				line += " as " + name
+			}
+			if flags := classy.ClassFlagsRepr(class.InnerClassAccessFlags); flags != "" {
+				line = flags + " " + line
+			}
+			lines = append(lines, line)
+		}
+		return "InnerClasses:", lines
+	case *classy.BootstrapMethodsAttribute:
+		var lines []string
+		for i, bsm := range a.BootstrapMethods {
+			var args []string
+			for _, arg := range bsm.BootstrapArguments {
+				args = append(args, constantRepr(cp, arg))
+			}
+			lines = append(lines, fmt.Sprintf("%v: %v (%v)", i, constantRepr(cp, bsm.BootstrapMethodRef), strings.Join(args, ", ")))
+		}
+		return "BootstrapMethods:", lines
+	case *classy.RecordAttribute:
+		var lines []string
+		for _, component := range a.Components {
+			lines = append(lines, component.Descriptor(cp)+" "+component.Name(cp))
+		}
+		return "Record:", lines
+	case *classy.RuntimeVisibleAnnotationsAttribute:
+		return "RuntimeVisibleAnnotations:", annotationReprs(a.Annotations, cp)
+	case *classy.RuntimeInvisibleAnnotationsAttribute:
+		return "RuntimeInvisibleAnnotations:", annotationReprs(a.Annotations, cp)
+	case *classy.RuntimeVisibleTypeAnnotationsAttribute:
+		return "RuntimeVisibleTypeAnnotations:", typeAnnotationReprs(a.Annotations, cp)
+	case *classy.RuntimeInvisibleTypeAnnotationsAttribute:
+		return "RuntimeInvisibleTypeAnnotations:", typeAnnotationReprs(a.Annotations, cp)
+	case *classy.AnnotationDefaultAttribute:
+		return "AnnotationDefault: " + a.DefaultValue.Repr(cp), nil
+	case *classy.RawAttribute:
+		return fmt.Sprintf("%v: %v bytes", a.Name, len(a.Data)), nil
+	default:
+		return attr.AttrName(), nil
+	}
+}
+
+func annotationReprs(anns []classy.Annotation, cp []classy.CpEntry) []string {
+	var lines []string
+	for _, ann := range anns {
+		lines = append(lines, ann.Repr(cp))
+	}
+	return lines
+}
+
+func typeAnnotationReprs(anns []classy.TypeAnnotation, cp []classy.CpEntry) []string {
+	var lines []string
+	for _, ann := range anns {
+		lines = append(lines, fmt.Sprintf("%v (target_type 0x%02x)", ann.Repr(cp), ann.TargetType))
+	}
+	return lines
+}
+
+func printAttrs(cf *classy.ClassFile) {
+	cp := cf.ConstantPool
+	for i, info := range cf.Attrs {
+		branch, branch2 := "├──", "│"
+		if i == int(cf.AttrsCount)-1 {
+			branch, branch2 = "└──", " "
+		}
+		attr, err := info.Decode(cp)
+		if err != nil {
+			fmt.Printf("  %v %v ", branch, info.Name(cp))
+			ErrorColorizer.Printf("error decoding: %v", err)
+			fmt.Println()
+			continue
+		}
+		summary, lines := describeAttr(attr, cp)
+		fmt.Printf("  %v %v\n", branch, summary)
+		for _, line := range lines {
+			fmt.Printf("  %v     %v\n", branch2, line)
+		}
+	}
+}
+
+// printAnnotations prints the annotations of a method or field on their own lines above
+// it, returning the branch to print the member itself under.
+func printAnnotations(cf *classy.ClassFile, annotations func([]classy.CpEntry) ([]classy.Annotation, error), branch, indent string) string {
+	anns, err := annotations(cf.ConstantPool)
+	if err != nil {
+		fmt.Printf("  %v ", branch)
+		ErrorColorizer.Printf("error decoding annotations: %v", err)
+		fmt.Println()
+		return indent + "  "
+	}
+	for _, ann := range anns {
+		fmt.Printf("  %v %v\n", branch, AuxColorizer.Sprint(ann.Repr(cf.ConstantPool)))
+		branch = indent + "  "
+	}
+	return branch
+}
+
+// annotateParams prefixes each parameter type with its annotations. Compilers may leave
+// out leading synthetic parameters, such as the outer instance of an inner class, so the
+// annotations are lined up with the last parameters.
+func annotateParams(params []string, annotations [][]classy.Annotation, cp []classy.CpEntry) []string {
+	skip := len(params) - len(annotations)
+	if skip < 0 {
+		return params
+	}
+	annotated := append([]string(nil), params...)
+	for i, anns := range annotations {
+		var prefix []string
+		for _, ann := range anns {
+			prefix = append(prefix, ann.Repr(cp))
+		}
+		if len(prefix) > 0 {
+			annotated[skip+i] = strings.Join(prefix, " ") + " " + annotated[skip+i]
+		}
+	}
+	return annotated
+}
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/a10y/classy"
+)
+
+// disasm prints a class in the syntax read by asm.
+func disasm(args []string) {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	flags.Usage = usage
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		usage()
+	}
+
+	target := flags.Arg(0)
+	sources, closer, err := readSources(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening %v: %v\n", target, err)
+		os.Exit(-1)
+	}
+	defer closer()
+	if len(sources) != 1 {
+		fmt.Fprintf(os.Stderr, "Error opening %v: disasm takes a single class, e.g. app.jar!/com/foo/Bar.class\n", target)
+		os.Exit(-1)
+	}
+	classFile, err := readSource(sources[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing %v: %v\n", target, err)
+		os.Exit(1)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	newDisassembler(classFile).class(out)
+}
+
+// disassembler renders a ClassFile as a .j file. Every constant is pinned to its index
+// with a .const line, so that assembling the output gives back the same bytes, and the
+// rest of the class refers to constants by value wherever that resolves to the same
+// index. Attributes are rendered as directives only when assembling the directive gives
+// back the attribute's bytes, and as raw .attribute bytes otherwise.
+type disassembler struct {
+	cf *classy.ClassFile
+	// refs renders references for the assembler to read, and values renders them for
+	// comments.
+	refs, values constRenderer
+	// pool is the class's constant pool for checking attributes against; it is frozen so
+	// that a rendering needing new constants fails the check.
+	pool *asmPool
+}
+
+func newDisassembler(cf *classy.ClassFile) *disassembler {
+	keys := constantKeys(cf.ConstantPool)
+	return &disassembler{
+		cf:     cf,
+		refs:   constRenderer{cp: cf.ConstantPool, keys: keys},
+		values: constRenderer{cp: cf.ConstantPool, keys: keys, loose: true},
+		pool:   &asmPool{cp: cf.ConstantPool, keys: keys, frozen: true},
+	}
+}
+
+func (d *disassembler) class(out *bufio.Writer) {
+	cf := d.cf
+	fmt.Fprintf(out, ".version %v %v\n", cf.MajorVersion, cf.MinorVersion)
+	fmt.Fprintf(out, ".class %v\n", strings.Join(append(asmFlags(cf.AccessFlags, classFlagNames), d.refs.class(cf.ThisClass, 0)), " "))
+	fmt.Fprintf(out, ".super %v\n", d.refs.class(cf.SuperClass, 0))
+	for _, iface := range cf.Interfaces {
+		fmt.Fprintf(out, ".implements %v\n", d.refs.class(iface, 0))
+	}
+
+	for i := range cf.Fields {
+		out.WriteString("\n")
+		writeLines(out, "", d.field(&cf.Fields[i]))
+	}
+	for i := range cf.Methods {
+		out.WriteString("\n")
+		writeLines(out, "", d.method(&cf.Methods[i]))
+	}
+
+	if len(cf.Attrs) > 0 {
+		out.WriteString("\n")
+	}
+	for _, attr := range cf.Attrs {
+		writeLines(out, "", d.attr(attr))
+	}
+
+	out.WriteString("\n")
+	for i, entry := range cf.ConstantPool {
+		if entry != nil {
+			fmt.Fprintf(out, ".const [%v] = %v\n", i+1, d.pinned(entry, uint16(i+1)))
+		}
+	}
+	out.WriteString(".end class\n")
+}
+
+func writeLines(out *bufio.Writer, indent string, lines []string) {
+	for _, line := range lines {
+		out.WriteString(indent + line + "\n")
+	}
+}
+
+// indent indents lines for nesting inside a block.
+func indent(lines []string) []string {
+	indented := make([]string, len(lines))
+	for i, line := range lines {
+		indented[i] = "    " + line
+	}
+	return indented
+}
+
+// pinned renders a .const line, whose references are always by index, followed by a
+// comment giving the constant's value.
+func (d *disassembler) pinned(entry classy.CpEntry, index uint16) string {
+	raw := func(i uint16) string { return fmt.Sprintf("[%v]", i) }
+	var text string
+	switch e := entry.(type) {
+	case *classy.CONSTANT_Utf8_info:
+		return "Utf8 " + utf8Text(e.Bytes, false)
+	case *classy.CONSTANT_Integer_info:
+		return "Int " + strconv.Itoa(int(int32(e.Bytes)))
+	case *classy.CONSTANT_Float_info:
+		return "Float " + floatText(e.Bytes)
+	case *classy.CONSTANT_Long_info:
+		return "Long " + strconv.FormatInt(int64(uint64(e.HighBytes)<<32|uint64(e.LowBytes)), 10)
+	case *classy.CONSTANT_Double_info:
+		return "Double " + doubleText(uint64(e.HighBytes)<<32|uint64(e.LowBytes))
+	case *classy.CONSTANT_Class_info:
+		text = "Class " + raw(e.NameIndex)
+	case *classy.CONSTANT_String_info:
+		text = "String " + raw(e.StringIndex)
+	case *classy.CONSTANT_Fieldref_info:
+		text = "Field " + raw(e.ClassIndex) + " " + raw(e.NameAndTypeIndex)
+	case *classy.CONSTANT_Methodref_info:
+		text = "Method " + raw(e.ClassIndex) + " " + raw(e.NameAndTypeIndex)
+	case *classy.CONSTANT_InterfaceMethodref_info:
+		text = "InterfaceMethod " + raw(e.ClassIndex) + " " + raw(e.NameAndTypeIndex)
+	case *classy.CONSTANT_NameAndType_info:
+		text = "NameAndType " + raw(e.NameIndex) + " " + raw(e.DescriptorIndex)
+	case *classy.CONSTANT_MethodHandle_info:
+		text = "MethodHandle " + refKindName(e.ReferenceKind) + " " + raw(e.ReferenceIndex)
+	case *classy.CONSTANT_MethodType_info:
+		text = "MethodType " + raw(e.DescriptorIndex)
+	case *classy.CONSTANT_Dynamic_info:
+		text = fmt.Sprintf("Dynamic %v %v", e.BootstrapMethodAttrIndex, raw(e.NameAndTypeIndex))
+	case *classy.CONSTANT_InvokeDynamic_info:
+		text = fmt.Sprintf("InvokeDynamic %v %v", e.BootstrapMethodAttrIndex, raw(e.NameAndTypeIndex))
+	case *classy.CONSTANT_Module_info:
+		text = "Module " + raw(e.NameIndex)
+	case *classy.CONSTANT_Package_info:
+		text = "Package " + raw(e.NameIndex)
+	}
+	return text + " ; " + d.values.constant(index, 0)
+}
+
+func (d *disassembler) field(field *classy.FieldInfo) []string {
+	words := asmFlags(field.AccessFlags, fieldFlagNames)
+	words = append(words, d.refs.text(field.NameIndex, 0), d.refs.text(field.DescriptorIndex, 0))
+	attrs := field.Attrs
+	if len(attrs) > 0 {
+		// A leading ConstantValue is written after the descriptor
+		if value := d.attr(attrs[0]); strings.HasPrefix(value[0], ".constantvalue ") {
+			words = append(words, "=", strings.TrimPrefix(value[0], ".constantvalue "))
+			attrs = attrs[1:]
+		}
+	}
+	if len(attrs) == 0 {
+		return []string{".field " + strings.Join(words, " ")}
+	}
+
+	lines := []string{".field " + strings.Join(words, " ") + " .fieldattributes"}
+	for _, attr := range attrs {
+		lines = append(lines, indent(d.attr(attr))...)
+	}
+	return append(lines, ".end fieldattributes")
+}
+
+func (d *disassembler) method(method *classy.MethodInfo) []string {
+	words := asmFlags(method.AccessFlags, methodFlagNames)
+	words = append(words, d.refs.text(method.NameIndex, 0), ":", d.refs.text(method.DescriptorIndex, 0))
+	lines := []string{".method " + strings.Join(words, " ")}
+	for _, attr := range method.Attrs {
+		lines = append(lines, indent(d.attr(attr))...)
+	}
+	return append(lines, ".end method")
+}
+
+// attr renders an attribute as a directive if that assembles back to the same bytes,
+// and as raw bytes otherwise.
+func (d *disassembler) attr(attr classy.AttrInfo) []string {
+	if lines := d.directive(attr); lines != nil && d.check(lines, attr) {
+		return lines
+	}
+	return []string{d.rawAttr(attr)}
+}
+
+func (d *disassembler) rawAttr(attr classy.AttrInfo) string {
+	return ".attribute " + d.refs.text(attr.NameIndex, 0) + " " + quoteBytes(attr.AttrData)
+}
+
+// check assembles lines for an attribute and reports whether that gives back attr.
+func (d *disassembler) check(lines []string, attr classy.AttrInfo) bool {
+	toks, err := tokenize(strings.Join(lines, "\n"))
+	if err != nil || len(toks) == 0 {
+		return false
+	}
+	p := &asmParser{lines: toks, pool: d.pool}
+	r := p.next()
+	var got classy.AttrInfo
+	if r.peek(".code") {
+		got = p.code(r)
+	} else {
+		got = p.requireAttr(r)
+	}
+	return p.err == nil && p.pos == len(p.lines) && got.NameIndex == attr.NameIndex && bytes.Equal(got.AttrData, attr.AttrData)
+}
+
+// directive renders an attribute as a directive, or returns nil for attributes that
+// don't have one.
+func (d *disassembler) directive(attr classy.AttrInfo) []string {
+	decoded, err := attr.Decode(d.cf.ConstantPool)
+	if err != nil {
+		return nil
+	}
+	refs := &d.refs
+	classes := func(directive string, indices []uint16) []string {
+		words := []string{directive}
+		for _, index := range indices {
+			words = append(words, refs.class(index, 0))
+		}
+		return []string{strings.Join(words, " ")}
+	}
+
+	switch a := decoded.(type) {
+	case *classy.CodeAttribute:
+		return d.code(attr, a)
+	case *classy.SourceFileAttribute:
+		return []string{".sourcefile " + refs.text(a.SourceFileIndex, 0)}
+	case *classy.SignatureAttribute:
+		return []string{".signature " + refs.text(a.SignatureIndex, 0)}
+	case *classy.DeprecatedAttribute:
+		return []string{".deprecated"}
+	case *classy.SyntheticAttribute:
+		return []string{".synthetic"}
+	case *classy.ConstantValueAttribute:
+		return []string{".constantvalue " + refs.constant(a.ConstantValueIndex, 0)}
+	case *classy.NestHostAttribute:
+		return []string{".nesthost " + refs.class(a.HostClassIndex, 0)}
+	case *classy.ExceptionsAttribute:
+		return classes(".exceptions", a.ExceptionIndexTable)
+	case *classy.NestMembersAttribute:
+		return classes(".nestmembers", a.Classes)
+	case *classy.PermittedSubclassesAttribute:
+		return classes(".permittedsubclasses", a.Classes)
+	case *classy.EnclosingMethodAttribute:
+		return []string{".enclosing method " + refs.class(a.ClassIndex, 0) + " " + refs.nat(a.MethodIndex, 0)}
+	case *classy.InnerClassesAttribute:
+		lines := []string{".innerclasses"}
+		for _, c := range a.Classes {
+			words := []string{refs.class(c.InnerClassInfoIndex, 0), refs.class(c.OuterClassInfoIndex, 0), refs.text(c.InnerNameIndex, 0)}
+			words = append(words, asmFlags(c.InnerClassAccessFlags, innerClassFlagNames)...)
+			lines = append(lines, "    "+strings.Join(words, " "))
+		}
+		return append(lines, ".end innerclasses")
+	case *classy.BootstrapMethodsAttribute:
+		lines := []string{".bootstrapmethods"}
+		for _, method := range a.BootstrapMethods {
+			words := []string{refs.constant(method.BootstrapMethodRef, 0)}
+			for _, arg := range method.BootstrapArguments {
+				words = append(words, refs.constant(arg, 0))
+			}
+			lines = append(lines, "    "+strings.Join(words, " "))
+		}
+		return append(lines, ".end bootstrapmethods")
+	case *classy.MethodParametersAttribute:
+		lines := []string{".methodparameters"}
+		for _, param := range a.Parameters {
+			words := append([]string{refs.text(param.NameIndex, 0)}, asmFlags(param.AccessFlags, parameterFlagNames)...)
+			lines = append(lines, "    "+strings.Join(words, " "))
+		}
+		return append(lines, ".end methodparameters")
+	}
+	return nil
+}
+
+// code renders a Code attribute, with labels for the offsets that are referred to. If
+// the attributes of the code can't all be rendered as directives, they are all given as
+// raw bytes.
+func (d *disassembler) code(attr classy.AttrInfo, code *classy.CodeAttribute) []string {
+	insns, err := classy.DecodeInstructions(code.Code)
+	if err != nil {
+		return nil
+	}
+	starts := map[int]bool{len(code.Code): true}
+	for _, ins := range insns {
+		starts[ins.Offset] = true
+	}
+	// Offsets that aren't the start of an instruction, or the end of the code, are
+	// written as numbers
+	labels := map[int]string{}
+	label := func(pc int) string {
+		if !starts[pc] {
+			return strconv.Itoa(pc)
+		}
+		labels[pc] = fmt.Sprintf("L%v", pc)
+		return labels[pc]
+	}
+
+	var body []string
+	var insnLines [][]string
+	for i := range insns {
+		insnLines = append(insnLines, d.instruction(&insns[i], label))
+	}
+	for _, entry := range code.ExceptionTable {
+		catchType := "any"
+		if entry.CatchType != 0 {
+			catchType = d.refs.class(entry.CatchType, 0)
+		}
+		body = append(body, fmt.Sprintf("        .catch %v from %v to %v using %v",
+			catchType, label(int(entry.StartPC)), label(int(entry.EndPC)), label(int(entry.HandlerPC))))
+	}
+
+	// A StackMapTable is written as .stack directives before the instructions its frames
+	// are at, and the assembler puts it after the other attributes
+	subAttrs := code.Attrs
+	frames := map[int][]string{}
+	if n := len(subAttrs); n > 0 {
+		if table, ok := d.stackMapTable(subAttrs[n-1], starts); ok {
+			for i, offset := range table.Offsets() {
+				frames[offset] = append(frames[offset], "        .stack "+d.frame(&table.Entries[i], label))
+			}
+			subAttrs = subAttrs[:n-1]
+		}
+	}
+
+	header := fmt.Sprintf(".code stack %v locals %v", code.MaxStack, code.MaxLocals)
+	withAttrs := func(attrs []string, framePrefix string) []string {
+		lines := []string{header}
+		frameLines := func(offset int) {
+			for _, frame := range frames[offset] {
+				lines = append(lines, framePrefix+frame)
+			}
+		}
+		for i, ins := range insns {
+			frameLines(ins.Offset)
+			lines = append(lines, labelLine(labels[ins.Offset], insnLines[i][0]))
+			lines = append(lines, insnLines[i][1:]...)
+		}
+		frameLines(len(code.Code))
+		if end, ok := labels[len(code.Code)]; ok {
+			lines = append(lines, end+":")
+		}
+		lines = append(append(lines, body...), attrs...)
+		return append(lines, ".end code")
+	}
+
+	var attrs []string
+	for _, sub := range subAttrs {
+		attrs = append(attrs, indent(indent(d.codeAttr(sub, label)))...)
+	}
+	if lines := withAttrs(attrs, ""); d.check(lines, attr) {
+		return lines
+	}
+	// Frames are still shown, as comments
+	attrs = nil
+	for _, sub := range code.Attrs {
+		attrs = append(attrs, "        "+d.rawAttr(sub))
+	}
+	return withAttrs(attrs, ";")
+}
+
+// stackMapTable decodes a StackMapTable whose frames are all at the start of an
+// instruction, or the end of the code, so that they can be written as .stack directives.
+func (d *disassembler) stackMapTable(attr classy.AttrInfo, starts map[int]bool) (*classy.StackMapTableAttribute, bool) {
+	if attr.Name(d.cf.ConstantPool) != "StackMapTable" {
+		return nil, false
+	}
+	decoded, err := attr.Decode(d.cf.ConstantPool)
+	if err != nil {
+		return nil, false
+	}
+	table := decoded.(*classy.StackMapTableAttribute)
+	for _, offset := range table.Offsets() {
+		if !starts[offset] {
+			return nil, false
+		}
+	}
+	return table, true
+}
+
+var verificationTypeWords = map[byte]string{
+	classy.ItemTop:               "Top",
+	classy.ItemInteger:           "Integer",
+	classy.ItemFloat:             "Float",
+	classy.ItemDouble:            "Double",
+	classy.ItemLong:              "Long",
+	classy.ItemNull:              "Null",
+	classy.ItemUninitializedThis: "UninitializedThis",
+}
+
+// frame renders a stack map frame as the operands of a .stack directive.
+func (d *disassembler) frame(frame *classy.StackMapFrame, label func(int) string) string {
+	types := func(types []classy.VerificationTypeInfo) []string {
+		var words []string
+		for _, info := range types {
+			switch info.Tag {
+			case classy.ItemObject:
+				words = append(words, "Object", d.refs.class(info.CpoolIndex, 0))
+			case classy.ItemUninitialized:
+				words = append(words, "Uninitialized", label(int(info.Offset)))
+			default:
+				words = append(words, verificationTypeWords[info.Tag])
+			}
+		}
+		return words
+	}
+	words := []string{frame.Kind()}
+	switch kind := frame.Kind(); {
+	case kind == "full":
+		words = append(append(append(words, "locals"), types(frame.Locals)...), "stack")
+		words = append(words, types(frame.Stack)...)
+	case kind == "chop":
+		words = append(words, strconv.Itoa(frame.ChoppedLocals()))
+	default:
+		words = append(append(words, types(frame.Locals)...), types(frame.Stack)...)
+	}
+	return strings.Join(words, " ")
+}
+
+func labelLine(label, text string) string {
+	if label == "" {
+		return "        " + text
+	}
+	return fmt.Sprintf("%-8v%v", label+":", text)
+}
+
+// codeAttr renders an attribute of a Code attribute, whose offsets are given by label.
+func (d *disassembler) codeAttr(attr classy.AttrInfo, label func(int) string) []string {
+	decoded, err := attr.Decode(d.cf.ConstantPool)
+	if err != nil {
+		return []string{d.rawAttr(attr)}
+	}
+	refs := &d.refs
+	switch a := decoded.(type) {
+	case *classy.LineNumberTableAttribute:
+		lines := []string{".linenumbertable"}
+		for _, entry := range a.LineNumberTable {
+			lines = append(lines, fmt.Sprintf("    %v %v", label(int(entry.StartPC)), entry.LineNumber))
+		}
+		return append(lines, ".end linenumbertable")
+	case *classy.LocalVariableTableAttribute:
+		lines := []string{".localvariabletable"}
+		for _, v := range a.LocalVariableTable {
+			lines = append(lines, fmt.Sprintf("    %v is %v %v from %v to %v", v.Index, refs.text(v.NameIndex, 0),
+				refs.text(v.DescriptorIndex, 0), label(int(v.StartPC)), label(int(v.StartPC)+int(v.Length))))
+		}
+		return append(lines, ".end localvariabletable")
+	case *classy.LocalVariableTypeTableAttribute:
+		lines := []string{".localvariabletypetable"}
+		for _, v := range a.LocalVariableTypeTable {
+			lines = append(lines, fmt.Sprintf("    %v is %v %v from %v to %v", v.Index, refs.text(v.NameIndex, 0),
+				refs.text(v.SignatureIndex, 0), label(int(v.StartPC)), label(int(v.StartPC)+int(v.Length))))
+		}
+		return append(lines, ".end localvariabletypetable")
+	}
+	return d.attr(attr)
+}
+
+// instruction renders an instruction, and for switches the lines that follow it.
+func (d *disassembler) instruction(ins *classy.Instruction, label func(int) string) []string {
+	refs := &d.refs
+	words := []string{ins.Opcode.String()}
+	if ins.Wide {
+		words = append([]string{"wide"}, words...)
+	}
+	switch ins.Opcode.Operands() {
+	case classy.OperandLocal:
+		words = append(words, strconv.Itoa(int(ins.Index)))
+	case classy.OperandIinc:
+		words = append(words, strconv.Itoa(int(ins.Index)), strconv.Itoa(int(ins.Value)))
+	case classy.OperandByte, classy.OperandShort:
+		words = append(words, strconv.Itoa(int(ins.Value)))
+	case classy.OperandAtype:
+		if name := classy.ArrayTypeName(byte(ins.Value)); name != "" {
+			words = append(words, name)
+		} else {
+			words = append(words, strconv.Itoa(int(ins.Value)))
+		}
+	case classy.OperandCpByte, classy.OperandCp, classy.OperandDynamic:
+		if classOperands[ins.Opcode] {
+			words = append(words, refs.class(ins.Index, 0))
+		} else {
+			words = append(words, refs.constant(ins.Index, 0))
+		}
+	case classy.OperandInterface:
+		words = append(words, refs.constant(ins.Index, 0), strconv.Itoa(int(ins.Value)))
+	case classy.OperandMultiArray:
+		words = append(words, refs.class(ins.Index, 0), strconv.Itoa(int(ins.Value)))
+	case classy.OperandBranch, classy.OperandBranchWide:
+		words = append(words, label(ins.Target))
+	case classy.OperandTableswitch:
+		lines := []string{strings.Join(append(words, strconv.Itoa(int(ins.Switch.Low))), " ")}
+		for _, target := range ins.Switch.Targets {
+			lines = append(lines, "            "+label(target))
+		}
+		return append(lines, "            default : "+label(ins.Switch.Default))
+	case classy.OperandLookupswitch:
+		lines := []string{strings.Join(words, " ")}
+		for i, target := range ins.Switch.Targets {
+			lines = append(lines, fmt.Sprintf("            %v : %v", ins.Switch.Keys[i], label(target)))
+		}
+		return append(lines, "            default : "+label(ins.Switch.Default))
+	}
+	return []string{strings.Join(words, " ")}
+}
+
+// constRenderer renders references to constants. A reference is written by value when
+// the assembler would resolve the value back to the same index, which is when the index
+// is the first holding the value, and as [index] otherwise. A loose renderer writes
+// every valid reference by value, for comments.
+type constRenderer struct {
+	cp    []classy.CpEntry
+	keys  map[string]uint16
+	loose bool
+}
+
+// entry gets the constant at index if it can be written by value.
+func (c *constRenderer) entry(index uint16, depth int) classy.CpEntry {
+	ent := classy.LookupEntry(c.cp, index)
+	if ent == nil || depth > maxKeyDepth {
+		return nil
+	}
+	if !c.loose && c.keys[constantKey(c.cp, index, 0)] != index {
+		return nil
+	}
+	return ent
+}
+
+func rawIndex(index uint16) string {
+	return fmt.Sprintf("[%v]", index)
+}
+
+// text renders a Utf8 constant as a word or string.
+func (c *constRenderer) text(index uint16, depth int) string {
+	if utf8, ok := c.entry(index, depth).(*classy.CONSTANT_Utf8_info); ok {
+		return utf8Text(utf8.Bytes, false)
+	}
+	return rawIndex(index)
+}
+
+// class renders a Class constant as the name of the class.
+func (c *constRenderer) class(index uint16, depth int) string {
+	if class, ok := c.entry(index, depth).(*classy.CONSTANT_Class_info); ok {
+		if name := c.text(class.NameIndex, depth+1); !rawRef.MatchString(name) {
+			return name
+		}
+	}
+	return rawIndex(index)
+}
+
+// nat renders a NameAndType constant as its name followed by its descriptor.
+func (c *constRenderer) nat(index uint16, depth int) string {
+	if nat, ok := c.entry(index, depth).(*classy.CONSTANT_NameAndType_info); ok {
+		name, desc := c.text(nat.NameIndex, depth+1), c.text(nat.DescriptorIndex, depth+1)
+		if !rawRef.MatchString(name) && !rawRef.MatchString(desc) {
+			return name + " " + desc
+		}
+	}
+	return rawIndex(index)
+}
+
+// constant renders any constant, as its tag followed by its contents. Numbers and
+// strings are written as literals.
+func (c *constRenderer) constant(index uint16, depth int) string {
+	switch e := c.entry(index, depth).(type) {
+	case *classy.CONSTANT_Utf8_info:
+		return "Utf8 " + utf8Text(e.Bytes, false)
+	case *classy.CONSTANT_Integer_info:
+		return strconv.Itoa(int(int32(e.Bytes)))
+	case *classy.CONSTANT_Float_info:
+		return floatText(e.Bytes)
+	case *classy.CONSTANT_Long_info:
+		return strconv.FormatInt(int64(uint64(e.HighBytes)<<32|uint64(e.LowBytes)), 10) + "L"
+	case *classy.CONSTANT_Double_info:
+		return doubleText(uint64(e.HighBytes)<<32 | uint64(e.LowBytes))
+	case *classy.CONSTANT_String_info:
+		if utf8, ok := c.entry(e.StringIndex, depth+1).(*classy.CONSTANT_Utf8_info); ok {
+			return utf8Text(utf8.Bytes, true)
+		}
+		return "String " + rawIndex(e.StringIndex)
+	case *classy.CONSTANT_Class_info:
+		return "Class " + c.text(e.NameIndex, depth+1)
+	case *classy.CONSTANT_Fieldref_info:
+		return "Field " + c.class(e.ClassIndex, depth+1) + " " + c.nat(e.NameAndTypeIndex, depth+1)
+	case *classy.CONSTANT_Methodref_info:
+		return "Method " + c.class(e.ClassIndex, depth+1) + " " + c.nat(e.NameAndTypeIndex, depth+1)
+	case *classy.CONSTANT_InterfaceMethodref_info:
+		return "InterfaceMethod " + c.class(e.ClassIndex, depth+1) + " " + c.nat(e.NameAndTypeIndex, depth+1)
+	case *classy.CONSTANT_NameAndType_info:
+		return "NameAndType " + c.text(e.NameIndex, depth+1) + " " + c.text(e.DescriptorIndex, depth+1)
+	case *classy.CONSTANT_MethodHandle_info:
+		return "MethodHandle " + refKindName(e.ReferenceKind) + " " + c.constant(e.ReferenceIndex, depth+1)
+	case *classy.CONSTANT_MethodType_info:
+		return "MethodType " + c.text(e.DescriptorIndex, depth+1)
+	case *classy.CONSTANT_Dynamic_info:
+		return fmt.Sprintf("Dynamic %v %v", e.BootstrapMethodAttrIndex, c.nat(e.NameAndTypeIndex, depth+1))
+	case *classy.CONSTANT_InvokeDynamic_info:
+		return fmt.Sprintf("InvokeDynamic %v %v", e.BootstrapMethodAttrIndex, c.nat(e.NameAndTypeIndex, depth+1))
+	case *classy.CONSTANT_Module_info:
+		return "Module " + c.text(e.NameIndex, depth+1)
+	case *classy.CONSTANT_Package_info:
+		return "Package " + c.text(e.NameIndex, depth+1)
+	}
+	return rawIndex(index)
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/a10y/classy"
+)
+
+// schemaVersion is the version of the document produced by --format=json|yaml. It is
+// bumped whenever a key is renamed or removed or its meaning changes; adding keys does
+// not change it. The schema is described in the README.
+const schemaVersion = 1
+
+// dumpClass builds the machine readable document for a class. Every index into the
+// constant pool is given as a number alongside the value it resolves to, so the
+// document can be read without chasing references but still rebuilt exactly.
+func dumpClass(cf *classy.ClassFile, source string) object {
+	cp := cf.ConstantPool
+	doc := object{
+		{"schema_version", number(schemaVersion)},
+		{"source", source},
+		{"magic", fmt.Sprintf("0x%08x", cf.Magic)},
+		{"minor_version", number(cf.MinorVersion)},
+		{"major_version", number(cf.MajorVersion)},
+	}
+
+	var pool []interface{}
+	for i, entry := range cp {
+		// Skip over empty continuation slots for 8-byte constants
+		if entry == nil {
+			continue
+		}
+		pool = append(pool, dumpConstant(uint16(i+1), entry, cp))
+	}
+	doc = append(doc, field{"constant_pool", list(pool)})
+
+	doc = append(doc,
+		field{"access_flags", number(cf.AccessFlags)},
+		field{"flags", stringList(flagNames(cf.AccessFlags, classFlagNames))},
+		field{"this_class", number(cf.ThisClass)},
+		field{"this_class_name", className(cf.ThisClass, cp)},
+		field{"super_class", number(cf.SuperClass)},
+		field{"super_class_name", className(cf.SuperClass, cp)},
+	)
+
+	var interfaces []interface{}
+	for _, iface := range cf.Interfaces {
+		interfaces = append(interfaces, object{{"index", number(iface)}, {"name", className(iface, cp)}})
+	}
+	doc = append(doc, field{"interfaces", list(interfaces)})
+
+	var fields []interface{}
+	for i := range cf.Fields {
+		f := &cf.Fields[i]
+		fields = append(fields, dumpMember(f.AccessFlags, fieldFlagNames, f.NameIndex, f.DescriptorIndex, f.Attrs, cp))
+	}
+	doc = append(doc, field{"fields", list(fields)})
+
+	var methods []interface{}
+	for i := range cf.Methods {
+		m := &cf.Methods[i]
+		methods = append(methods, dumpMember(m.AccessFlags, methodFlagNames, m.NameIndex, m.DescriptorIndex, m.Attrs, cp))
+	}
+	doc = append(doc, field{"methods", list(methods)})
+
+	doc = append(doc, field{"attributes", dumpAttrs(cf.Attrs, cp)})
+	return doc
+}
+
+// className resolves a CONSTANT_Class index to its name, or nil for index 0.
+func className(index uint16, cp []classy.CpEntry) interface{} {
+	if class, ok := classy.LookupEntry(cp, index).(*classy.CONSTANT_Class_info); ok {
+		return class.Name(cp)
+	}
+	return nil
+}
+
+// utf8Value resolves a CONSTANT_Utf8 index to its string, or nil for index 0.
+func utf8Value(index uint16, cp []classy.CpEntry) interface{} {
+	if utf8, ok := classy.LookupEntry(cp, index).(*classy.CONSTANT_Utf8_info); ok {
+		return utf8.Value()
+	}
+	return nil
+}
+
+func dumpMember(acc classy.Access, names []flagName, nameIndex, descriptorIndex uint16, attrs []classy.AttrInfo, cp []classy.CpEntry) object {
+	return object{
+		{"access_flags", number(acc)},
+		{"flags", stringList(flagNames(acc, names))},
+		{"name_index", number(nameIndex)},
+		{"name", utf8Value(nameIndex, cp)},
+		{"descriptor_index", number(descriptorIndex)},
+		{"descriptor", utf8Value(descriptorIndex, cp)},
+		{"attributes", dumpAttrs(attrs, cp)},
+	}
+}
+
+// dumpConstant renders a constant pool entry as its tag, its index fields and its
+// resolved value. The value of a Utf8, Integer, Long, Float or Double entry is the
+// constant itself, given as a number apart from the strings "NaN", "Infinity" and
+// "-Infinity"; for every other entry it is informational.
+func dumpConstant(index uint16, entry classy.CpEntry, cp []classy.CpEntry) object {
+	obj := object{
+		{"index", number(index)},
+		{"tag", strings.TrimPrefix(entry.StringTag(), "CONSTANT_")},
+	}
+	switch e := entry.(type) {
+	case *classy.CONSTANT_Utf8_info:
+		return append(obj, field{"value", e.Value()})
+	case *classy.CONSTANT_Integer_info:
+		return append(obj, field{"value", number(e.Value())})
+	case *classy.CONSTANT_Long_info:
+		return append(obj, field{"value", number(e.Value())})
+	case *classy.CONSTANT_Float_info:
+		return append(obj, field{"value", floatValue(float64(e.Value()), e.Repr(cp))})
+	case *classy.CONSTANT_Double_info:
+		return append(obj, field{"value", floatValue(e.Value(), e.Repr(cp))})
+	}
+	v := reflect.ValueOf(entry).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if name := v.Type().Field(i).Name; name != "Tag" {
+			obj = append(obj, field{snakeCase(name), number(v.Field(i).Interface())})
+		}
+	}
+	if e, ok := entry.(*classy.CONSTANT_String_info); ok {
+		return append(obj, field{"value", utf8Value(e.StringIndex, cp)})
+	}
+	return append(obj, field{"value", entry.Repr(cp)})
+}
+
+// floatValue gives a float constant as a number, using its Java representation, or as
+// a string for the values JSON has no numbers for. Exponents are signed, as YAML 1.1
+// readers insist on it.
+func floatValue(f float64, repr string) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return repr
+	}
+	if i := strings.IndexByte(repr, 'E'); i >= 0 && repr[i+1] != '-' {
+		repr = repr[:i+1] + "+" + repr[i+1:]
+	}
+	return rawNumber(repr)
+}
+
+func dumpAttrs(attrs []classy.AttrInfo, cp []classy.CpEntry) list {
+	var dumped []interface{}
+	for i := range attrs {
+		dumped = append(dumped, dumpAttr(&attrs[i], cp))
+	}
+	return list(dumped)
+}
+
+// dumpAttr renders an attribute as its name and raw contents, which are authoritative,
+// along with its decoded form for standard attributes.
+func dumpAttr(info *classy.AttrInfo, cp []classy.CpEntry) object {
+	obj := object{
+		{"name_index", number(info.NameIndex)},
+		{"name", utf8Value(info.NameIndex, cp)},
+		{"data", hex.EncodeToString(info.AttrData)},
+	}
+	attr, err := info.Decode(cp)
+	if err != nil {
+		return append(obj, field{"error", err.Error()})
+	}
+	if _, ok := attr.(*classy.RawAttribute); ok {
+		return obj
+	}
+	return append(obj, field{"decoded", dumpValue(reflect.ValueOf(attr), cp)})
+}
+
+// dumpValue renders a decoded attribute, or a part of one, generically: structs become
+// objects whose keys are the snake_case field names, which follow the JVMS names of
+// the structures, byte slices become hex strings and nested attributes are dumped in
+// full.
+func dumpValue(v reflect.Value, cp []classy.CpEntry) interface{} {
+	switch x := v.Interface().(type) {
+	case []classy.AttrInfo:
+		return dumpAttrs(x, cp)
+	case *classy.CodeAttribute:
+		return dumpCode(x, cp)
+	case classy.ElementValue:
+		return dumpElementValue(&x, cp)
+	case []byte:
+		return hex.EncodeToString(x)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return dumpValue(v.Elem(), cp)
+	case reflect.Struct:
+		return dumpStruct(v, cp)
+	case reflect.Slice:
+		var items []interface{}
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, dumpValue(v.Index(i), cp))
+		}
+		return list(items)
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	default:
+		return number(v.Interface())
+	}
+}
+
+// dumpStruct renders a struct as an object, flattening embedded structs into it as
+// encoding/json does.
+func dumpStruct(v reflect.Value, cp []classy.CpEntry) object {
+	obj := object{}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Anonymous {
+			obj = append(obj, dumpStruct(v.Field(i), cp)...)
+			continue
+		}
+		obj = append(obj, field{snakeCase(f.Name), dumpValue(v.Field(i), cp)})
+	}
+	return obj
+}
+
+// dumpCode renders a Code attribute with its bytecode decoded into instructions.
+func dumpCode(code *classy.CodeAttribute, cp []classy.CpEntry) object {
+	obj := object{
+		{"max_stack", number(code.MaxStack)},
+		{"max_locals", number(code.MaxLocals)},
+		{"code_length", number(code.CodeLength)},
+	}
+	insns, err := code.Instructions()
+	if err != nil {
+		obj = append(obj, field{"code", hex.EncodeToString(code.Code)}, field{"error", err.Error()})
+	} else {
+		var dumped []interface{}
+		for i := range insns {
+			dumped = append(dumped, dumpInstruction(&insns[i], cp))
+		}
+		obj = append(obj, field{"instructions", list(dumped)})
+	}
+
+	var handlers []interface{}
+	for _, handler := range code.ExceptionTable {
+		handlers = append(handlers, object{
+			{"start_pc", number(handler.StartPC)},
+			{"end_pc", number(handler.EndPC)},
+			{"handler_pc", number(handler.HandlerPC)},
+			{"catch_type", number(handler.CatchType)},
+			{"catch_type_name", className(handler.CatchType, cp)},
+		})
+	}
+	return append(obj, field{"exception_table", list(handlers)}, field{"attributes", dumpAttrs(code.Attrs, cp)})
+}
+
+// dumpInstruction renders an instruction as its offset and mnemonic followed by the
+// operands its opcode takes.
+func dumpInstruction(ins *classy.Instruction, cp []classy.CpEntry) object {
+	obj := object{
+		{"offset", number(ins.Offset)},
+		{"opcode", ins.Opcode.String()},
+	}
+	if ins.Wide {
+		obj = append(obj, field{"wide", true})
+	}
+	constant := func() {
+		obj = append(obj, field{"index", number(ins.Index)})
+		if ent := classy.LookupEntry(cp, ins.Index); ent != nil {
+			obj = append(obj, field{"constant", ent.Repr(cp)})
+		}
+	}
+
+	switch ins.Opcode.Operands() {
+	case classy.OperandLocal:
+		obj = append(obj, field{"local", number(ins.Index)})
+	case classy.OperandIinc:
+		obj = append(obj, field{"local", number(ins.Index)}, field{"increment", number(ins.Value)})
+	case classy.OperandByte, classy.OperandShort:
+		obj = append(obj, field{"value", number(ins.Value)})
+	case classy.OperandAtype:
+		obj = append(obj, field{"atype", classy.ArrayTypeName(byte(ins.Value))})
+	case classy.OperandCpByte, classy.OperandCp, classy.OperandDynamic:
+		constant()
+	case classy.OperandInterface:
+		constant()
+		obj = append(obj, field{"count", number(ins.Value)})
+	case classy.OperandMultiArray:
+		constant()
+		obj = append(obj, field{"dimensions", number(ins.Value)})
+	case classy.OperandBranch, classy.OperandBranchWide:
+		obj = append(obj, field{"target", number(ins.Target)})
+	case classy.OperandTableswitch:
+		var targets []interface{}
+		for _, target := range ins.Switch.Targets {
+			targets = append(targets, number(target))
+		}
+		obj = append(obj,
+			field{"default", number(ins.Switch.Default)},
+			field{"low", number(ins.Switch.Low)},
+			field{"high", number(ins.Switch.High)},
+			field{"targets", list(targets)},
+		)
+	case classy.OperandLookupswitch:
+		var pairs []interface{}
+		for i, target := range ins.Switch.Targets {
+			pairs = append(pairs, object{{"key", number(ins.Switch.Keys[i])}, {"target", number(target)}})
+		}
+		obj = append(obj, field{"default", number(ins.Switch.Default)}, field{"pairs", list(pairs)})
+	}
+	return obj
+}
+
+// dumpElementValue renders an annotation element value with just the fields its tag
+// uses.
+func dumpElementValue(v *classy.ElementValue, cp []classy.CpEntry) object {
+	obj := object{{"tag", string(rune(v.Tag))}}
+	switch v.Tag {
+	case 'e':
+		obj = append(obj, field{"type_name_index", number(v.TypeNameIndex)}, field{"const_name_index", number(v.ConstNameIndex)})
+	case 'c':
+		obj = append(obj, field{"class_info_index", number(v.ClassInfoIndex)})
+	case '@':
+		obj = append(obj, field{"annotation_value", dumpValue(reflect.ValueOf(v.AnnotationValue), cp)})
+	case '[':
+		obj = append(obj, field{"num_values", number(v.NumValues)}, field{"values", dumpValue(reflect.ValueOf(v.Values), cp)})
+	default:
+		obj = append(obj, field{"const_value_index", number(v.ConstValueIndex)})
+	}
+	return append(obj, field{"value", v.Repr(cp)})
+}
+
+// snakeCase converts a Go field name to the snake_case key used in the document, e.g.
+// StartPC to start_pc. Attrs is spelled out as attributes, as in the JVMS.
+func snakeCase(name string) string {
+	switch name {
+	case "Attrs":
+		return "attributes"
+	case "AttrsCount":
+		return "attributes_count"
+	}
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prevLower := !unicode.IsUpper(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || nextLower {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// number converts an integer of any type to a rawNumber.
+func number(n interface{}) rawNumber {
+	v := reflect.ValueOf(n)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rawNumber(strconv.FormatInt(v.Int(), 10))
+	default:
+		return rawNumber(strconv.FormatUint(v.Uint(), 10))
+	}
+}
+
+func stringList(items []string) list {
+	l := list{}
+	for _, item := range items {
+		l = append(l, item)
+	}
+	return l
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// The documents printed by --format are built from these types, plus strings, bools
+// and nil, so that JSON and YAML output share one schema and keep keys in order.
+type (
+	// object is a mapping whose keys are printed in order.
+	object []field
+	field  struct {
+		Key   string
+		Value interface{}
+	}
+	// list is a sequence, printed as [] rather than null when empty.
+	list []interface{}
+	// rawNumber is a number already formatted as a JSON number.
+	rawNumber string
+)
+
+// writeJSON prints v as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	var buf bytes.Buffer
+	encodeJSON(&buf, v, "")
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func encodeJSON(buf *bytes.Buffer, v interface{}, indent string) {
+	switch x := v.(type) {
+	case object:
+		if len(x) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		buf.WriteString("{\n")
+		for i, f := range x {
+			buf.WriteString(indent + "  " + jsonString(f.Key) + ": ")
+			encodeJSON(buf, f.Value, indent+"  ")
+			if i < len(x)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(indent + "}")
+	case list:
+		if len(x) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		buf.WriteString("[\n")
+		for i, item := range x {
+			buf.WriteString(indent + "  ")
+			encodeJSON(buf, item, indent+"  ")
+			if i < len(x)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(indent + "]")
+	default:
+		buf.WriteString(scalar(v, jsonString))
+	}
+}
+
+// jsonString quotes s as a JSON string, leaving <, > and & as they are.
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func scalar(v interface{}, quote func(string) string) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		if x {
+			return "true"
+		}
+		return "false"
+	case rawNumber:
+		return string(x)
+	case string:
+		return quote(x)
+	case object:
+		return "{}"
+	case list:
+		return "[]"
+	default:
+		panic("unexpected document value")
+	}
+}
+
+// writeYAML prints v as a YAML document in block style. Strings are left unquoted only
+// when they can't be mistaken for anything else, and are otherwise double quoted using
+// JSON's escapes, which YAML shares.
+func writeYAML(w io.Writer, v interface{}) error {
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	encodeYAML(&buf, v, "", false)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// encodeYAML prints a value that is an object or list with entries. When afterDash is
+// set the first line continues a "- " already printed for a list item.
+func encodeYAML(buf *bytes.Buffer, v interface{}, indent string, afterDash bool) {
+	switch x := v.(type) {
+	case object:
+		for i, f := range x {
+			if i > 0 || !afterDash {
+				buf.WriteString(indent)
+			}
+			buf.WriteString(yamlString(f.Key) + ":")
+			if isYAMLScalar(f.Value) {
+				buf.WriteString(" " + scalar(f.Value, yamlString) + "\n")
+			} else {
+				buf.WriteString("\n")
+				encodeYAML(buf, f.Value, indent+"  ", false)
+			}
+		}
+	case list:
+		for i, item := range x {
+			if i > 0 || !afterDash {
+				buf.WriteString(indent)
+			}
+			buf.WriteString("-")
+			if isYAMLScalar(item) {
+				buf.WriteString(" " + scalar(item, yamlString) + "\n")
+			} else {
+				buf.WriteString(" ")
+				encodeYAML(buf, item, indent+"  ", true)
+			}
+		}
+	}
+}
+
+// isYAMLScalar reports whether v is printed on the same line as its key or dash.
+func isYAMLScalar(v interface{}) bool {
+	switch x := v.(type) {
+	case object:
+		return len(x) == 0
+	case list:
+		return len(x) == 0
+	default:
+		return true
+	}
+}
+
+var (
+	yamlPlain    = regexp.MustCompile(`^[A-Za-z_$/(<][A-Za-z0-9_$/.<>;()\[\]-]*$`)
+	yamlReserved = regexp.MustCompile(`^(?i:true|false|yes|no|on|off|y|n|null)$`)
+)
+
+func yamlString(s string) string {
+	if yamlPlain.MatchString(s) && !yamlReserved.MatchString(s) {
+		return s
+	}
+	return jsonString(s)
+}
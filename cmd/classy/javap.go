@@ -0,0 +1,772 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/a10y/classy"
+)
+
+// javap prints each class named by args in the layout of the JDK's `javap -v -p -c`, so
+// that the output can be diffed against javap's. The -v, -p and -c flags are accepted
+// and ignored, as they are always in effect.
+func javap(args []string) {
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	var targets []string
+	for _, arg := range args {
+		switch arg {
+		case "-v", "-verbose", "-p", "-private", "-c":
+		default:
+			targets = append(targets, arg)
+		}
+	}
+	if len(targets) == 0 {
+		usage()
+	}
+
+	failed := false
+	for _, target := range targets {
+		sources, closer, err := readSources(target)
+		if err != nil {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "Error opening %v: %v\n", target, err)
+			failed = true
+			continue
+		}
+		for _, source := range sources {
+			if err := javapClass(out, source); err != nil {
+				out.Flush()
+				fmt.Fprintf(os.Stderr, "Error parsing %v: %v\n", source.Name, err)
+				failed = true
+			}
+		}
+		closer()
+	}
+	if failed {
+		out.Flush()
+		os.Exit(1)
+	}
+}
+
+func javapClass(out *bufio.Writer, source *classSource) error {
+	data, err := source.Read()
+	if err != nil {
+		return err
+	}
+	cf, err := classy.ReadClassFile(data)
+	if err != nil {
+		return err
+	}
+	w := &javapWriter{lineWriter: lineWriter{out: out}, cf: cf, cp: cf.ConstantPool}
+
+	w.println("Classfile " + source.URI)
+	w.indent(+1)
+	w.println(fmt.Sprintf("Last modified %v; size %v bytes", source.Modified.Format("Jan 2, 2006"), len(data)))
+	w.println(fmt.Sprintf("SHA-256 checksum %x", sha256.Sum256(data)))
+	if attr, ok := w.findAttr(cf.Attrs).(*classy.SourceFileAttribute); ok {
+		w.println(`Compiled from "` + attr.SourceFile(w.cp) + `"`)
+	}
+	w.indent(-1)
+
+	w.classDeclaration()
+	w.indent(+1)
+	w.println(fmt.Sprintf("minor version: %v", cf.MinorVersion))
+	w.println(fmt.Sprintf("major version: %v", cf.MajorVersion))
+	w.writeList(fmt.Sprintf("flags: (0x%04x) ", uint16(cf.AccessFlags)), flagNames(cf.AccessFlags, classFlagNames), "\n")
+	w.print(fmt.Sprintf("this_class: #%v", cf.ThisClass))
+	w.tab()
+	w.println("// " + w.stringValue(cf.ThisClass))
+	w.print(fmt.Sprintf("super_class: #%v", cf.SuperClass))
+	if cf.SuperClass != 0 {
+		w.tab()
+		w.print("// " + w.stringValue(cf.SuperClass))
+	}
+	w.println("")
+	w.println(fmt.Sprintf("interfaces: %v, fields: %v, methods: %v, attributes: %v",
+		cf.InterfacesCount, cf.FieldsCount, cf.MethodsCount, cf.AttrsCount))
+	w.indent(-1)
+
+	w.constantPool()
+
+	w.println("{")
+	w.indent(+1)
+	for i := range cf.Fields {
+		w.field(&cf.Fields[i])
+	}
+	for i := range cf.Methods {
+		w.method(&cf.Methods[i])
+	}
+	w.setPendingNewline(false)
+	w.indent(-1)
+	w.println("}")
+	w.attrs(cf.Attrs)
+	return nil
+}
+
+// lineWriter is a port of javap's LineWriter, which indents lines, collapses trailing
+// spaces and aligns comments at a tab column.
+type lineWriter struct {
+	out            *bufio.Writer
+	buf            []rune
+	indentCount    int
+	pendingSpaces  int
+	pendingNewline bool
+}
+
+const (
+	javapIndentWidth = 2
+	javapTabColumn   = 40
+)
+
+func (w *lineWriter) print(s string) {
+	if w.pendingNewline {
+		w.pendingNewline = false
+		w.println("")
+	}
+	for _, c := range s {
+		switch c {
+		case ' ':
+			w.pendingSpaces++
+		case '\n':
+			w.println("")
+		default:
+			if len(w.buf) == 0 {
+				w.buf = append(w.buf, []rune(strings.Repeat(" ", w.indentCount*javapIndentWidth))...)
+			}
+			w.buf = append(w.buf, []rune(strings.Repeat(" ", w.pendingSpaces))...)
+			w.pendingSpaces = 0
+			w.buf = append(w.buf, c)
+		}
+	}
+}
+
+// println prints s and ends the line, discarding any spaces it ended with.
+func (w *lineWriter) println(s string) {
+	w.print(s)
+	w.pendingSpaces = 0
+	w.out.WriteString(string(w.buf))
+	w.out.WriteByte('\n')
+	w.buf = w.buf[:0]
+}
+
+func (w *lineWriter) indent(delta int) {
+	w.indentCount += delta
+}
+
+// tab pads the line out to the tab column, or by a single space if it is already past
+// it.
+func (w *lineWriter) tab() {
+	col := w.indentCount*javapIndentWidth + javapTabColumn
+	if col <= len(w.buf) {
+		w.pendingSpaces++
+	} else {
+		w.pendingSpaces += col - len(w.buf)
+	}
+}
+
+// setPendingNewline arranges for a blank line before whatever is printed next.
+func (w *lineWriter) setPendingNewline(pending bool) {
+	w.pendingNewline = pending
+}
+
+func (w *lineWriter) writeList(prefix string, items []string, suffix string) {
+	w.print(prefix + strings.Join(items, ", ") + suffix)
+}
+
+type javapWriter struct {
+	lineWriter
+	cf *classy.ClassFile
+	cp []classy.CpEntry
+	// current is the method whose attributes are being printed, if any.
+	current *classy.MethodInfo
+}
+
+type flagName struct {
+	flag classy.Access
+	name string
+}
+
+var classFlagNames = []flagName{
+	{classy.AccPublic, "ACC_PUBLIC"},
+	{classy.AccFinal, "ACC_FINAL"},
+	{classy.AccSuper, "ACC_SUPER"},
+	{classy.AccInterface, "ACC_INTERFACE"},
+	{classy.AccAbstract, "ACC_ABSTRACT"},
+	{classy.AccSynthetic, "ACC_SYNTHETIC"},
+	{classy.AccAnnotation, "ACC_ANNOTATION"},
+	{classy.AccEnum, "ACC_ENUM"},
+	{classy.AccModule, "ACC_MODULE"},
+}
+
+var fieldFlagNames = []flagName{
+	{classy.AccPublic, "ACC_PUBLIC"},
+	{classy.AccPrivate, "ACC_PRIVATE"},
+	{classy.AccProtected, "ACC_PROTECTED"},
+	{classy.AccStatic, "ACC_STATIC"},
+	{classy.AccFinal, "ACC_FINAL"},
+	{classy.AccVolatile, "ACC_VOLATILE"},
+	{classy.AccTransient, "ACC_TRANSIENT"},
+	{classy.AccSynthetic, "ACC_SYNTHETIC"},
+	{classy.AccEnum, "ACC_ENUM"},
+}
+
+var methodFlagNames = []flagName{
+	{classy.AccPublic, "ACC_PUBLIC"},
+	{classy.AccPrivate, "ACC_PRIVATE"},
+	{classy.AccProtected, "ACC_PROTECTED"},
+	{classy.AccStatic, "ACC_STATIC"},
+	{classy.AccFinal, "ACC_FINAL"},
+	{classy.AccSynchronized, "ACC_SYNCHRONIZED"},
+	{classy.AccBridge, "ACC_BRIDGE"},
+	{classy.AccVarargs, "ACC_VARARGS"},
+	{classy.AccNative, "ACC_NATIVE"},
+	{classy.AccAbstract, "ACC_ABSTRACT"},
+	{classy.AccStrict, "ACC_STRICT"},
+	{classy.AccSynthetic, "ACC_SYNTHETIC"},
+}
+
+var classModifiers = []flagName{
+	{classy.AccPublic, "public"},
+	{classy.AccFinal, "final"},
+	{classy.AccAbstract, "abstract"},
+}
+
+var innerClassModifiers = []flagName{
+	{classy.AccPublic, "public"},
+	{classy.AccPrivate, "private"},
+	{classy.AccProtected, "protected"},
+	{classy.AccStatic, "static"},
+	{classy.AccFinal, "final"},
+	{classy.AccAbstract, "abstract"},
+}
+
+var fieldModifiers = []flagName{
+	{classy.AccPublic, "public"},
+	{classy.AccPrivate, "private"},
+	{classy.AccProtected, "protected"},
+	{classy.AccStatic, "static"},
+	{classy.AccFinal, "final"},
+	{classy.AccVolatile, "volatile"},
+	{classy.AccTransient, "transient"},
+}
+
+var methodModifiers = []flagName{
+	{classy.AccPublic, "public"},
+	{classy.AccPrivate, "private"},
+	{classy.AccProtected, "protected"},
+	{classy.AccStatic, "static"},
+	{classy.AccFinal, "final"},
+	{classy.AccSynchronized, "synchronized"},
+	{classy.AccNative, "native"},
+	{classy.AccAbstract, "abstract"},
+	{classy.AccStrict, "strictfp"},
+}
+
+func flagNames(acc classy.Access, names []flagName) []string {
+	var set []string
+	for _, name := range names {
+		if acc&name.flag != 0 {
+			set = append(set, name.name)
+		}
+	}
+	return set
+}
+
+func (w *javapWriter) writeModifiers(modifiers []string) {
+	for _, modifier := range modifiers {
+		w.print(modifier + " ")
+	}
+}
+
+func (w *javapWriter) classDeclaration() {
+	cf := w.cf
+	flags := cf.AccessFlags
+	if flags&classy.AccInterface != 0 {
+		flags &^= classy.AccAbstract
+	}
+	w.writeModifiers(flagNames(flags, classModifiers))
+	switch {
+	case cf.AccessFlags&classy.AccModule != 0:
+		// A module is named by its Module attribute rather than its this_class
+		if module, err := cf.Module(); err == nil && module != nil {
+			name := module.Name(w.cp)
+			if version := module.Version(w.cp); version != "" {
+				name += "@" + version
+			}
+			w.println("module " + name)
+			return
+		}
+		w.print("module ")
+	case cf.AccessFlags&classy.AccInterface != 0:
+		w.print("interface ")
+	default:
+		w.print("class ")
+	}
+	w.print(javaName(w.className(cf.ThisClass)))
+
+	isInterface := cf.AccessFlags&classy.AccInterface != 0
+	if sig, err := cf.GenericSignature(); err == nil && sig != nil {
+		w.print(sig.TypeParameters.String())
+		var interfaces []string
+		for _, iface := range sig.Interfaces {
+			interfaces = append(interfaces, iface.String())
+		}
+		if !isInterface && sig.Superclass != nil && sig.Superclass.Name() != "java/lang/Object" {
+			w.print(" extends " + sig.Superclass.String())
+		}
+		w.printSupertypes(isInterface, interfaces, ", ")
+	} else {
+		if !isInterface && cf.SuperClass != 0 && w.className(cf.SuperClass) != "java/lang/Object" {
+			w.print(" extends " + javaName(w.className(cf.SuperClass)))
+		}
+		var interfaces []string
+		for _, iface := range cf.Interfaces {
+			interfaces = append(interfaces, javaName(w.className(iface)))
+		}
+		w.printSupertypes(isInterface, interfaces, ",")
+	}
+	w.println("")
+}
+
+// printSupertypes prints the interfaces a class implements or an interface extends.
+// Like javap, sep is "," when they come from the interfaces table rather than a generic
+// signature.
+func (w *javapWriter) printSupertypes(isInterface bool, interfaces []string, sep string) {
+	if len(interfaces) == 0 {
+		return
+	}
+	if isInterface {
+		w.print(" extends ")
+	} else {
+		w.print(" implements ")
+	}
+	w.print(strings.Join(interfaces, sep))
+}
+
+func (w *javapWriter) constantPool() {
+	w.println("Constant pool:")
+	w.indent(+1)
+	width := len(strconv.Itoa(int(w.cf.ConstantPoolCount))) + 1
+	for i, entry := range w.cp {
+		// Skip over empty continuation slots for 8-byte constants
+		if entry == nil {
+			continue
+		}
+		index := uint16(i + 1)
+		w.print(fmt.Sprintf("%*s", width, fmt.Sprintf("#%v", index)))
+		w.print(fmt.Sprintf(" = %-18s ", strings.TrimPrefix(entry.StringTag(), "CONSTANT_")))
+		switch e := entry.(type) {
+		case *classy.CONSTANT_Class_info:
+			w.printRef(index, fmt.Sprintf("#%v", e.NameIndex))
+		case *classy.CONSTANT_String_info:
+			w.printRef(index, fmt.Sprintf("#%v", e.StringIndex))
+		case *classy.CONSTANT_MethodType_info:
+			w.printRef(index, fmt.Sprintf("#%v", e.DescriptorIndex))
+		case *classy.CONSTANT_Module_info:
+			w.printRef(index, fmt.Sprintf("#%v", e.NameIndex))
+		case *classy.CONSTANT_Package_info:
+			w.printRef(index, fmt.Sprintf("#%v", e.NameIndex))
+		case *classy.CONSTANT_Fieldref_info:
+			w.printRef(index, fmt.Sprintf("#%v.#%v", e.ClassIndex, e.NameAndTypeIndex))
+		case *classy.CONSTANT_Methodref_info:
+			w.printRef(index, fmt.Sprintf("#%v.#%v", e.ClassIndex, e.NameAndTypeIndex))
+		case *classy.CONSTANT_InterfaceMethodref_info:
+			w.printRef(index, fmt.Sprintf("#%v.#%v", e.ClassIndex, e.NameAndTypeIndex))
+		case *classy.CONSTANT_NameAndType_info:
+			w.printRef(index, fmt.Sprintf("#%v:#%v", e.NameIndex, e.DescriptorIndex))
+		case *classy.CONSTANT_MethodHandle_info:
+			w.printRef(index, fmt.Sprintf("%v:#%v", e.ReferenceKind, e.ReferenceIndex))
+		case *classy.CONSTANT_InvokeDynamic_info:
+			w.printRef(index, fmt.Sprintf("#%v:#%v", e.BootstrapMethodAttrIndex, e.NameAndTypeIndex))
+		case *classy.CONSTANT_Dynamic_info:
+			w.printRef(index, fmt.Sprintf("#%v:#%v", e.BootstrapMethodAttrIndex, e.NameAndTypeIndex))
+		default:
+			w.println(w.stringValue(index))
+		}
+	}
+	w.indent(-1)
+}
+
+// printRef prints the raw operands of a constant pool entry followed by a comment
+// resolving them.
+func (w *javapWriter) printRef(index uint16, operands string) {
+	w.print(operands)
+	w.tab()
+	w.println("// " + w.stringValue(index))
+}
+
+// entry gets the constant pool entry at index, or nil if there is none.
+func (w *javapWriter) entry(index uint16) classy.CpEntry {
+	return classy.LookupEntry(w.cp, index)
+}
+
+// className gets the internal name of the class at index, or "" if it isn't a class.
+func (w *javapWriter) className(index uint16) string {
+	if class, ok := w.entry(index).(*classy.CONSTANT_Class_info); ok {
+		return class.Name(w.cp)
+	}
+	return ""
+}
+
+// stringValue renders a constant pool entry the way javap does in comments.
+func (w *javapWriter) stringValue(index uint16) string {
+	switch e := w.entry(index).(type) {
+	case nil:
+		return fmt.Sprintf("#%v", index)
+	case *classy.CONSTANT_Utf8_info:
+		return javapEscape(e.Value())
+	case *classy.CONSTANT_Class_info:
+		return checkName(e.Name(w.cp))
+	case *classy.CONSTANT_String_info:
+		return w.stringValue(e.StringIndex)
+	case *classy.CONSTANT_Float_info:
+		return e.Repr(w.cp) + "f"
+	case *classy.CONSTANT_Long_info:
+		return e.Repr(w.cp) + "l"
+	case *classy.CONSTANT_Double_info:
+		return e.Repr(w.cp) + "d"
+	case *classy.CONSTANT_Fieldref_info:
+		return checkName(e.ClassName(w.cp)) + "." + w.stringValue(e.NameAndTypeIndex)
+	case *classy.CONSTANT_Methodref_info:
+		return checkName(e.ClassName(w.cp)) + "." + w.stringValue(e.NameAndTypeIndex)
+	case *classy.CONSTANT_InterfaceMethodref_info:
+		return checkName(e.ClassName(w.cp)) + "." + w.stringValue(e.NameAndTypeIndex)
+	case *classy.CONSTANT_NameAndType_info:
+		return checkName(e.Name(w.cp)) + ":" + e.Descriptor(w.cp)
+	case *classy.CONSTANT_MethodHandle_info:
+		return classy.ReferenceKindName(e.ReferenceKind) + " " + w.stringValue(e.ReferenceIndex)
+	case *classy.CONSTANT_MethodType_info:
+		return e.Descriptor(w.cp)
+	case *classy.CONSTANT_InvokeDynamic_info:
+		return fmt.Sprintf("#%v:%v", e.BootstrapMethodAttrIndex, w.stringValue(e.NameAndTypeIndex))
+	case *classy.CONSTANT_Dynamic_info:
+		return fmt.Sprintf("#%v:%v", e.BootstrapMethodAttrIndex, w.stringValue(e.NameAndTypeIndex))
+	case *classy.CONSTANT_Module_info:
+		return checkName(e.Name(w.cp))
+	case *classy.CONSTANT_Package_info:
+		return checkName(e.Name(w.cp))
+	default:
+		return e.Repr(w.cp)
+	}
+}
+
+var javapTagNames = map[classy.ConstantTag]string{
+	classy.CONSTANT_Utf8:               "Utf8",
+	classy.CONSTANT_Integer:            "int",
+	classy.CONSTANT_Float:              "float",
+	classy.CONSTANT_Long:               "long",
+	classy.CONSTANT_Double:             "double",
+	classy.CONSTANT_Class:              "class",
+	classy.CONSTANT_String:             "String",
+	classy.CONSTANT_Fieldref:           "Field",
+	classy.CONSTANT_Methodref:          "Method",
+	classy.CONSTANT_InterfaceMethodref: "InterfaceMethod",
+	classy.CONSTANT_NameAndType:        "NameAndType",
+	classy.CONSTANT_MethodHandle:       "MethodHandle",
+	classy.CONSTANT_MethodType:         "MethodType",
+	classy.CONSTANT_Dynamic:            "Dynamic",
+	classy.CONSTANT_InvokeDynamic:      "InvokeDynamic",
+	classy.CONSTANT_Module:             "Module",
+	classy.CONSTANT_Package:            "Package",
+}
+
+// write prints a reference to a constant pool entry as its kind and value, e.g.
+// "Method java/lang/Object."<init>":()V". Members of the class itself are shown by
+// name and type alone.
+func (w *javapWriter) write(index uint16) {
+	entry := w.entry(index)
+	if entry == nil {
+		w.print(fmt.Sprintf("#%v", index))
+		return
+	}
+	tag := javapTagNames[entry.RawTag()]
+	switch e := entry.(type) {
+	case *classy.CONSTANT_Fieldref_info:
+		if e.ClassIndex == w.cf.ThisClass {
+			index = e.NameAndTypeIndex
+		}
+	case *classy.CONSTANT_Methodref_info:
+		if e.ClassIndex == w.cf.ThisClass {
+			index = e.NameAndTypeIndex
+		}
+	case *classy.CONSTANT_InterfaceMethodref_info:
+		if e.ClassIndex == w.cf.ThisClass {
+			index = e.NameAndTypeIndex
+		}
+	}
+	w.print(tag + " " + w.stringValue(index))
+}
+
+// javapEscape escapes the contents of a CONSTANT_Utf8 as javap does.
+func javapEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '\\':
+			b.WriteString(`\\`)
+		default:
+			if unicode.IsControl(c) {
+				fmt.Fprintf(&b, `\u%04x`, c)
+			} else {
+				b.WriteRune(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+// checkName quotes a class or member name unless it is made of Java identifiers
+// separated by slashes.
+func checkName(name string) string {
+	if name == "" {
+		return `""`
+	}
+	prev := '/'
+	for _, c := range name {
+		if prev == '/' && !isJavaIdentifierStart(c) || c != '/' && !isJavaIdentifierPart(c) {
+			return `"` + javapEscape(name) + `"`
+		}
+		prev = c
+	}
+	return name
+}
+
+func isJavaIdentifierStart(c rune) bool {
+	return unicode.IsLetter(c) || unicode.Is(unicode.Nl, c) || unicode.Is(unicode.Sc, c) || unicode.Is(unicode.Pc, c)
+}
+
+func isJavaIdentifierPart(c rune) bool {
+	return isJavaIdentifierStart(c) || unicode.IsDigit(c) || unicode.Is(unicode.Mn, c) || unicode.Is(unicode.Mc, c)
+}
+
+// javaName converts an internal class name to the dotted form used in declarations.
+func javaName(name string) string {
+	return strings.Replace(name, "/", ".", -1)
+}
+
+// javaType renders a field descriptor as a Java type, falling back to the descriptor
+// itself if it is malformed.
+func javaType(descriptor string) string {
+	t, err := classy.ParseFieldDescriptor(descriptor)
+	if err != nil {
+		return descriptor
+	}
+	return t.String()
+}
+
+func (w *javapWriter) field(field *classy.FieldInfo) {
+	w.writeModifiers(flagNames(field.AccessFlags, fieldModifiers))
+	if sig, err := field.GenericSignature(w.cp); err == nil && sig != nil {
+		w.print(sig.String())
+	} else {
+		w.print(javaType(field.Descriptor(w.cp)))
+	}
+	w.print(" " + field.Name(w.cp) + ";")
+	w.println("")
+
+	w.indent(+1)
+	w.println("descriptor: " + field.Descriptor(w.cp))
+	w.writeList(fmt.Sprintf("flags: (0x%04x) ", uint16(field.AccessFlags)), flagNames(field.AccessFlags, fieldFlagNames), "\n")
+	w.attrs(field.Attrs)
+	w.indent(-1)
+	w.setPendingNewline(true)
+}
+
+func (w *javapWriter) method(method *classy.MethodInfo) {
+	w.current = method
+	defer func() { w.current = nil }()
+
+	name := method.Name(w.cp)
+	modifiers := flagNames(method.AccessFlags, methodModifiers)
+	if w.cf.AccessFlags&classy.AccInterface != 0 && method.AccessFlags&(classy.AccAbstract|classy.AccStatic|classy.AccPrivate) == 0 &&
+		name != "<clinit>" && w.cf.MajorVersion >= 52 {
+		modifiers = append(modifiers, "default")
+	}
+	w.writeModifiers(modifiers)
+
+	var ret string
+	var params, throws []string
+	if desc, err := classy.ParseMethodDescriptor(method.Descriptor(w.cp)); err == nil {
+		ret = desc.Return.String()
+		for _, param := range desc.Params {
+			params = append(params, param.String())
+		}
+	} else {
+		ret = method.Descriptor(w.cp)
+	}
+	if sig, err := method.GenericSignature(w.cp); err == nil && sig != nil {
+		w.print(sig.TypeParameters.String())
+		if len(sig.TypeParameters) > 0 {
+			w.print(" ")
+		}
+		ret, params = sig.Result.String(), nil
+		for _, param := range sig.Params {
+			params = append(params, param.String())
+		}
+		for _, t := range sig.Throws {
+			throws = append(throws, t.String())
+		}
+	}
+	paramList := "(" + strings.Join(params, ", ") + ")"
+	if method.AccessFlags&classy.AccVarargs != 0 {
+		if i := strings.LastIndex(paramList, "[]"); i > 0 {
+			paramList = paramList[:i] + "..." + paramList[i+2:]
+		}
+	}
+
+	switch name {
+	case "<init>":
+		w.print(javaName(w.className(w.cf.ThisClass)) + paramList)
+	case "<clinit>":
+		w.print("{}")
+	default:
+		w.print(ret + " " + name + paramList)
+	}
+	if attr, ok := w.findAttr(method.Attrs).(*classy.ExceptionsAttribute); ok {
+		if throws == nil {
+			for _, name := range attr.Names(w.cp) {
+				throws = append(throws, javaName(name))
+			}
+		}
+		w.print(" throws " + strings.Join(throws, ", "))
+	}
+	w.println(";")
+
+	w.indent(+1)
+	w.println("descriptor: " + method.Descriptor(w.cp))
+	w.writeList(fmt.Sprintf("flags: (0x%04x) ", uint16(method.AccessFlags)), flagNames(method.AccessFlags, methodFlagNames), "\n")
+	w.attrs(method.Attrs)
+	w.indent(-1)
+	w.setPendingNewline(true)
+}
+
+// findAttr decodes the first attribute of a type javap consults when printing
+// declarations, returning nil if there is none or it can't be decoded.
+func (w *javapWriter) findAttr(attrs []classy.AttrInfo) classy.Attribute {
+	for _, info := range attrs {
+		switch info.Name(w.cp) {
+		case "SourceFile", "Exceptions":
+			attr, err := info.Decode(w.cp)
+			if err != nil {
+				return nil
+			}
+			return attr
+		}
+	}
+	return nil
+}
+
+func (w *javapWriter) code(code *classy.CodeAttribute) {
+	w.println("Code:")
+	w.indent(+1)
+
+	// args_size counts parameters rather than the slots they take, as javap does
+	args := 0
+	if w.current != nil {
+		if desc, err := classy.ParseMethodDescriptor(w.current.Descriptor(w.cp)); err == nil {
+			args = len(desc.Params)
+		}
+		if w.current.AccessFlags&classy.AccStatic == 0 {
+			args++
+		}
+	}
+	w.println(fmt.Sprintf("stack=%v, locals=%v, args_size=%v", code.MaxStack, code.MaxLocals, args))
+
+	insns, err := code.Instructions()
+	if err != nil {
+		w.println("Error: " + err.Error())
+	}
+	for i := range insns {
+		w.instruction(&insns[i])
+	}
+
+	if code.ExceptionTableLength > 0 {
+		w.println("Exception table:")
+		w.indent(+1)
+		w.println(" from    to  target type")
+		for _, handler := range code.ExceptionTable {
+			w.print(fmt.Sprintf(" %5d %5d %5d   ", handler.StartPC, handler.EndPC, handler.HandlerPC))
+			if handler.CatchType == 0 {
+				w.print("any")
+			} else {
+				w.print("Class " + w.stringValue(handler.CatchType))
+			}
+			w.println("")
+		}
+		w.indent(-1)
+	}
+
+	w.attrs(code.Attrs)
+	w.indent(-1)
+}
+
+func (w *javapWriter) instruction(ins *classy.Instruction) {
+	name := ins.Opcode.String()
+	if ins.Wide {
+		name += "_w"
+	}
+	w.print(fmt.Sprintf("%4d: %-13s ", ins.Offset, name))
+
+	switch ins.Opcode.Operands() {
+	case classy.OperandLocal:
+		w.print(strconv.Itoa(int(ins.Index)))
+	case classy.OperandIinc:
+		w.print(fmt.Sprintf("%v, %v", ins.Index, ins.Value))
+	case classy.OperandByte, classy.OperandShort:
+		w.print(strconv.Itoa(int(ins.Value)))
+	case classy.OperandAtype:
+		w.print(" " + classy.ArrayTypeName(byte(ins.Value)))
+	case classy.OperandCpByte, classy.OperandCp:
+		w.print(fmt.Sprintf("#%v", ins.Index))
+		w.tab()
+		w.print("// ")
+		w.write(ins.Index)
+	case classy.OperandInterface, classy.OperandMultiArray, classy.OperandDynamic:
+		w.print(fmt.Sprintf("#%v,  %v", ins.Index, ins.Value))
+		w.tab()
+		w.print("// ")
+		w.write(ins.Index)
+	case classy.OperandBranch, classy.OperandBranchWide:
+		w.print(strconv.Itoa(ins.Target))
+	case classy.OperandTableswitch:
+		sw := ins.Switch
+		w.print(fmt.Sprintf("{ // %v to %v", sw.Low, sw.High))
+		w.indent(+3)
+		for i, target := range sw.Targets {
+			w.print(fmt.Sprintf("\n%12d: %d", int64(sw.Low)+int64(i), target))
+		}
+		w.print(fmt.Sprintf("\n     default: %v\n}", sw.Default))
+		w.indent(-3)
+	case classy.OperandLookupswitch:
+		sw := ins.Switch
+		w.print(fmt.Sprintf("{ // %v", len(sw.Keys)))
+		w.indent(+3)
+		for i, target := range sw.Targets {
+			w.print(fmt.Sprintf("\n%12d: %d", sw.Keys[i], target))
+		}
+		w.print(fmt.Sprintf("\n     default: %v\n}", sw.Default))
+		w.indent(-3)
+	}
+	w.println("")
+}
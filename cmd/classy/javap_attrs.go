@@ -0,0 +1,655 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/a10y/classy"
+)
+
+// attrs prints attributes the way javap -v does.
+func (w *javapWriter) attrs(attrs []classy.AttrInfo) {
+	for i := range attrs {
+		info := &attrs[i]
+		attr, err := info.Decode(w.cp)
+		if err != nil {
+			w.println(fmt.Sprintf("Error decoding %v: %v", info.Name(w.cp), err))
+			continue
+		}
+		w.attr(attr)
+	}
+}
+
+func (w *javapWriter) attr(attr classy.Attribute) {
+	switch a := attr.(type) {
+	case *classy.CodeAttribute:
+		w.code(a)
+	case *classy.ConstantValueAttribute:
+		w.print("ConstantValue: ")
+		w.write(a.ConstantValueIndex)
+		w.println("")
+	case *classy.SourceFileAttribute:
+		w.println(`SourceFile: "` + a.SourceFile(w.cp) + `"`)
+	case *classy.SignatureAttribute:
+		w.print(fmt.Sprintf("Signature: #%v", a.SignatureIndex))
+		w.tab()
+		w.println("// " + a.Signature(w.cp))
+	case *classy.DeprecatedAttribute:
+		w.println("Deprecated: true")
+	case *classy.SyntheticAttribute:
+		w.println("Synthetic: true")
+	case *classy.ExceptionsAttribute:
+		w.println("Exceptions:")
+		w.indent(+1)
+		var names []string
+		for _, name := range a.Names(w.cp) {
+			names = append(names, javaName(name))
+		}
+		w.println("throws " + strings.Join(names, ", "))
+		w.indent(-1)
+	case *classy.LineNumberTableAttribute:
+		w.println("LineNumberTable:")
+		w.indent(+1)
+		for _, entry := range a.LineNumberTable {
+			w.println(fmt.Sprintf("line %v: %v", entry.LineNumber, entry.StartPC))
+		}
+		w.indent(-1)
+	case *classy.LocalVariableTableAttribute:
+		w.println("LocalVariableTable:")
+		w.indent(+1)
+		w.println("Start  Length  Slot  Name   Signature")
+		for _, v := range a.LocalVariableTable {
+			w.println(fmt.Sprintf("%5d %7d %5d %5s   %s", v.StartPC, v.Length, v.Index,
+				w.stringValue(v.NameIndex), w.stringValue(v.DescriptorIndex)))
+		}
+		w.indent(-1)
+	case *classy.LocalVariableTypeTableAttribute:
+		w.println("LocalVariableTypeTable:")
+		w.indent(+1)
+		w.println("Start  Length  Slot  Name   Signature")
+		for _, v := range a.LocalVariableTypeTable {
+			w.println(fmt.Sprintf("%5d %7d %5d %5s   %s", v.StartPC, v.Length, v.Index,
+				w.stringValue(v.NameIndex), w.stringValue(v.SignatureIndex)))
+		}
+		w.indent(-1)
+	case *classy.StackMapTableAttribute:
+		w.println(fmt.Sprintf("StackMapTable: number_of_entries = %v", a.NumberOfEntries))
+		w.indent(+1)
+		for i := range a.Entries {
+			w.stackMapFrame(&a.Entries[i])
+		}
+		w.indent(-1)
+	case *classy.InnerClassesAttribute:
+		w.innerClasses(a)
+	case *classy.EnclosingMethodAttribute:
+		w.print(fmt.Sprintf("EnclosingMethod: #%v.#%v", a.ClassIndex, a.MethodIndex))
+		w.tab()
+		w.print("// " + javaName(a.ClassName(w.cp)))
+		if nat, ok := w.entry(a.MethodIndex).(*classy.CONSTANT_NameAndType_info); ok {
+			w.print("." + nat.Name(w.cp))
+		}
+		w.println("")
+	case *classy.SourceDebugExtensionAttribute:
+		w.println("SourceDebugExtension:")
+		w.indent(+1)
+		for _, line := range lineBreaks.Split(string(a.DebugExtension), -1) {
+			w.println(line)
+		}
+		w.indent(-1)
+	case *classy.BootstrapMethodsAttribute:
+		w.println("BootstrapMethods:")
+		for i, bsm := range a.BootstrapMethods {
+			w.indent(+1)
+			w.print(fmt.Sprintf("%v: #%v ", i, bsm.BootstrapMethodRef))
+			w.println(w.stringValue(bsm.BootstrapMethodRef))
+			w.indent(+1)
+			w.println("Method arguments:")
+			w.indent(+1)
+			for _, arg := range bsm.BootstrapArguments {
+				w.print(fmt.Sprintf("#%v ", arg))
+				w.println(w.stringValue(arg))
+			}
+			w.indent(-3)
+		}
+	case *classy.MethodParametersAttribute:
+		w.println("MethodParameters:")
+		w.indent(+1)
+		w.println(fmt.Sprintf("%-31s%s", "Name", "Flags"))
+		for _, param := range a.Parameters {
+			name := "<no name>"
+			if param.NameIndex != 0 {
+				name = w.stringValue(param.NameIndex)
+			}
+			var flags string
+			if param.AccessFlags&classy.AccFinal != 0 {
+				flags += "final "
+			}
+			if param.AccessFlags&classy.AccMandated != 0 {
+				flags += "mandated "
+			}
+			if param.AccessFlags&classy.AccSynthetic != 0 {
+				flags += "synthetic"
+			}
+			w.println(fmt.Sprintf("%-31s%s", name, flags))
+		}
+		w.indent(-1)
+	case *classy.NestHostAttribute:
+		w.print("NestHost: ")
+		w.write(a.HostClassIndex)
+		w.println("")
+	case *classy.NestMembersAttribute:
+		w.classList("NestMembers:", a.Classes)
+	case *classy.PermittedSubclassesAttribute:
+		w.classList("PermittedSubclasses:", a.Classes)
+	case *classy.RecordAttribute:
+		w.println("Record:")
+		w.indent(+1)
+		for _, component := range a.Components {
+			w.print(javaType(component.Descriptor(w.cp)) + " " + component.Name(w.cp) + ";")
+			w.println("")
+			w.indent(+1)
+			w.println("descriptor: " + component.Descriptor(w.cp))
+			w.attrs(component.Attrs)
+			w.println("")
+			w.indent(-1)
+		}
+		w.indent(-1)
+	case *classy.RuntimeVisibleAnnotationsAttribute:
+		w.annotations("RuntimeVisibleAnnotations:", a.Annotations)
+	case *classy.RuntimeInvisibleAnnotationsAttribute:
+		w.annotations("RuntimeInvisibleAnnotations:", a.Annotations)
+	case *classy.RuntimeVisibleParameterAnnotationsAttribute:
+		w.parameterAnnotations("RuntimeVisibleParameterAnnotations:", a.ParameterAnnotations)
+	case *classy.RuntimeInvisibleParameterAnnotationsAttribute:
+		w.parameterAnnotations("RuntimeInvisibleParameterAnnotations:", a.ParameterAnnotations)
+	case *classy.RuntimeVisibleTypeAnnotationsAttribute:
+		w.typeAnnotations("RuntimeVisibleTypeAnnotations:", a.Annotations)
+	case *classy.RuntimeInvisibleTypeAnnotationsAttribute:
+		w.typeAnnotations("RuntimeInvisibleTypeAnnotations:", a.Annotations)
+	case *classy.AnnotationDefaultAttribute:
+		w.println("AnnotationDefault:")
+		w.indent(+1)
+		w.print("default_value: ")
+		w.elementValue(&a.DefaultValue, false)
+		w.print("\n")
+		w.indent(+1)
+		w.elementValue(&a.DefaultValue, true)
+		w.indent(-1)
+		w.indent(-1)
+		w.println("")
+	case *classy.ModuleAttribute:
+		w.module(a)
+	case *classy.ModulePackagesAttribute:
+		w.println("ModulePackages: ")
+		w.indent(+1)
+		for _, pkg := range a.PackageIndex {
+			w.print(fmt.Sprintf("#%v", pkg))
+			w.tab()
+			w.println("// " + javaName(w.stringValue(pkg)))
+		}
+		w.indent(-1)
+	case *classy.ModuleMainClassAttribute:
+		w.print(fmt.Sprintf("ModuleMainClass: #%v", a.MainClassIndex))
+		w.tab()
+		w.println("// " + javaName(w.className(a.MainClassIndex)))
+	case *classy.RawAttribute:
+		w.print(fmt.Sprintf("  %v: length = 0x%X", a.Name, len(a.Data)))
+		w.println("")
+		w.print("   ")
+		for i, b := range a.Data {
+			w.print(fmt.Sprintf("%02x", b))
+			if (i+1)%16 == 0 {
+				w.println("")
+				w.print("   ")
+			} else {
+				w.print(" ")
+			}
+		}
+		w.println("")
+	}
+}
+
+var lineBreaks = regexp.MustCompile("[\r\n]+")
+
+func (w *javapWriter) classList(header string, classes []uint16) {
+	w.println(header)
+	w.indent(+1)
+	for _, class := range classes {
+		w.println(w.stringValue(class))
+	}
+	w.indent(-1)
+}
+
+func (w *javapWriter) innerClasses(a *classy.InnerClassesAttribute) {
+	if len(a.Classes) == 0 {
+		return
+	}
+	w.println("InnerClasses:")
+	w.indent(+1)
+	for _, class := range a.Classes {
+		flags := class.InnerClassAccessFlags
+		if flags&classy.AccInterface != 0 {
+			flags &^= classy.AccAbstract
+		}
+		w.writeModifiers(flagNames(flags, innerClassModifiers))
+		if class.InnerNameIndex != 0 {
+			w.print(fmt.Sprintf("#%v= ", class.InnerNameIndex))
+		}
+		w.print(fmt.Sprintf("#%v", class.InnerClassInfoIndex))
+		if class.OuterClassInfoIndex != 0 {
+			w.print(fmt.Sprintf(" of #%v", class.OuterClassInfoIndex))
+		}
+		w.print(";")
+		w.tab()
+		w.print("// ")
+		if class.InnerNameIndex != 0 {
+			w.print(class.InnerName(w.cp) + "=")
+		}
+		w.write(class.InnerClassInfoIndex)
+		if class.OuterClassInfoIndex != 0 {
+			w.print(" of ")
+			w.write(class.OuterClassInfoIndex)
+		}
+		w.println("")
+	}
+	w.indent(-1)
+}
+
+var frameKinds = []struct {
+	last byte
+	name string
+}{
+	{63, "same"},
+	{127, "same_locals_1_stack_item"},
+	{246, "reserved"},
+	{247, "same_locals_1_stack_item_frame_extended"},
+	{250, "chop"},
+	{251, "same_frame_extended"},
+	{254, "append"},
+	{255, "full_frame"},
+}
+
+func (w *javapWriter) stackMapFrame(frame *classy.StackMapFrame) {
+	var kind string
+	for _, k := range frameKinds {
+		if frame.FrameType <= k.last {
+			kind = k.name
+			break
+		}
+	}
+	w.print(fmt.Sprintf("frame_type = %v ", frame.FrameType))
+	w.println("/* " + kind + " */")
+	w.indent(+1)
+	if frame.FrameType >= classy.SameLocals1StackItemExtended {
+		w.println(fmt.Sprintf("offset_delta = %v", frame.OffsetDelta))
+	}
+	if frame.FrameType > classy.SameFrameExtended {
+		w.verificationTypes("locals", frame.Locals)
+	}
+	if frame.FrameType >= 64 && frame.FrameType <= classy.SameLocals1StackItemExtended || frame.FrameType == classy.FullFrame {
+		w.verificationTypes("stack", frame.Stack)
+	}
+	w.indent(-1)
+}
+
+var verificationTypeNames = map[byte]string{
+	classy.ItemTop:               "top",
+	classy.ItemInteger:           "int",
+	classy.ItemFloat:             "float",
+	classy.ItemLong:              "long",
+	classy.ItemDouble:            "double",
+	classy.ItemNull:              "null",
+	classy.ItemUninitializedThis: "this",
+	classy.ItemUninitialized:     "uninitialized",
+}
+
+func (w *javapWriter) verificationTypes(name string, types []classy.VerificationTypeInfo) {
+	w.print(name + " = [")
+	for i, info := range types {
+		switch info.Tag {
+		case classy.ItemObject:
+			w.print(" ")
+			w.write(info.CpoolIndex)
+		case classy.ItemUninitialized:
+			w.print(fmt.Sprintf(" uninitialized %v", info.Offset))
+		default:
+			w.print(" " + verificationTypeNames[info.Tag])
+		}
+		if i == len(types)-1 {
+			w.print(" ")
+		} else {
+			w.print(",")
+		}
+	}
+	w.println("]")
+}
+
+func (w *javapWriter) annotations(header string, anns []classy.Annotation) {
+	w.println(header)
+	w.indent(+1)
+	for i := range anns {
+		w.print(fmt.Sprintf("%v: ", i))
+		w.annotation(&anns[i], false)
+		w.print("\n")
+		w.indent(+1)
+		w.annotation(&anns[i], true)
+		w.indent(-1)
+		w.println("")
+	}
+	w.indent(-1)
+}
+
+func (w *javapWriter) parameterAnnotations(header string, params []classy.ParameterAnnotations) {
+	w.println(header)
+	w.indent(+1)
+	for param, anns := range params {
+		w.println(fmt.Sprintf("parameter %v: ", param))
+		w.indent(+1)
+		for i := range anns.Annotations {
+			w.print(fmt.Sprintf("%v: ", i))
+			w.annotation(&anns.Annotations[i], false)
+			w.print("\n")
+			w.indent(+1)
+			w.annotation(&anns.Annotations[i], true)
+			w.indent(-1)
+			w.println("")
+		}
+		w.indent(-1)
+	}
+	w.indent(-1)
+}
+
+var targetTypeNames = map[byte]string{
+	classy.TargetClassTypeParameter:                "CLASS_TYPE_PARAMETER",
+	classy.TargetMethodTypeParameter:               "METHOD_TYPE_PARAMETER",
+	classy.TargetClassExtends:                      "CLASS_EXTENDS",
+	classy.TargetClassTypeParameterBound:           "CLASS_TYPE_PARAMETER_BOUND",
+	classy.TargetMethodTypeParameterBound:          "METHOD_TYPE_PARAMETER_BOUND",
+	classy.TargetField:                             "FIELD",
+	classy.TargetMethodReturn:                      "METHOD_RETURN",
+	classy.TargetMethodReceiver:                    "METHOD_RECEIVER",
+	classy.TargetMethodFormalParameter:             "METHOD_FORMAL_PARAMETER",
+	classy.TargetThrows:                            "THROWS",
+	classy.TargetLocalVariable:                     "LOCAL_VARIABLE",
+	classy.TargetResourceVariable:                  "RESOURCE_VARIABLE",
+	classy.TargetExceptionParameter:                "EXCEPTION_PARAMETER",
+	classy.TargetInstanceof:                        "INSTANCEOF",
+	classy.TargetNew:                               "NEW",
+	classy.TargetConstructorReference:              "CONSTRUCTOR_REFERENCE",
+	classy.TargetMethodReference:                   "METHOD_REFERENCE",
+	classy.TargetCast:                              "CAST",
+	classy.TargetConstructorInvocationTypeArgument: "CONSTRUCTOR_INVOCATION_TYPE_ARGUMENT",
+	classy.TargetMethodInvocationTypeArgument:      "METHOD_INVOCATION_TYPE_ARGUMENT",
+	classy.TargetConstructorReferenceTypeArgument:  "CONSTRUCTOR_REFERENCE_TYPE_ARGUMENT",
+	classy.TargetMethodReferenceTypeArgument:       "METHOD_REFERENCE_TYPE_ARGUMENT",
+}
+
+var typePathNames = map[byte]string{
+	classy.TypePathArray:    "ARRAY",
+	classy.TypePathNested:   "INNER_TYPE",
+	classy.TypePathWildcard: "WILDCARD",
+}
+
+func (w *javapWriter) typeAnnotations(header string, anns []classy.TypeAnnotation) {
+	w.println(header)
+	w.indent(+1)
+	for i := range anns {
+		ann := &anns[i]
+		w.print(fmt.Sprintf("%v: ", i))
+		w.annotation(&ann.Annotation, false)
+		w.print(": " + typeAnnotationTarget(ann))
+		w.print("\n")
+		w.indent(+1)
+		w.annotation(&ann.Annotation, true)
+		w.indent(-1)
+		w.println("")
+	}
+	w.indent(-1)
+}
+
+// typeAnnotationTarget describes what a type annotation applies to as javap does, e.g.
+// "METHOD_FORMAL_PARAMETER, param_index=0".
+func typeAnnotationTarget(ann *classy.TypeAnnotation) string {
+	info := &ann.TargetInfo
+	target := targetTypeNames[ann.TargetType]
+	if target == "" {
+		target = fmt.Sprintf("UNKNOWN(0x%02x)", ann.TargetType)
+	}
+	switch ann.TargetType {
+	case classy.TargetInstanceof, classy.TargetNew, classy.TargetConstructorReference, classy.TargetMethodReference:
+		target += fmt.Sprintf(", offset=%v", info.Offset)
+	case classy.TargetLocalVariable, classy.TargetResourceVariable:
+		var ranges []string
+		for _, v := range info.Table {
+			ranges = append(ranges, fmt.Sprintf("start_pc=%v, length=%v, index=%v", v.StartPC, v.Length, v.Index))
+		}
+		target += ", {" + strings.Join(ranges, "; ") + "}"
+	case classy.TargetExceptionParameter:
+		target += fmt.Sprintf(", exception_index=%v", info.ExceptionTableIndex)
+	case classy.TargetClassTypeParameter, classy.TargetMethodTypeParameter:
+		target += fmt.Sprintf(", param_index=%v", info.TypeParameterIndex)
+	case classy.TargetClassTypeParameterBound, classy.TargetMethodTypeParameterBound:
+		target += fmt.Sprintf(", param_index=%v, bound_index=%v", info.TypeParameterIndex, info.BoundIndex)
+	case classy.TargetClassExtends:
+		target += fmt.Sprintf(", type_index=%v", int16(info.SupertypeIndex))
+	case classy.TargetThrows:
+		target += fmt.Sprintf(", type_index=%v", info.ThrowsTypeIndex)
+	case classy.TargetMethodFormalParameter:
+		target += fmt.Sprintf(", param_index=%v", info.FormalParameterIndex)
+	case classy.TargetCast, classy.TargetConstructorInvocationTypeArgument, classy.TargetMethodInvocationTypeArgument,
+		classy.TargetConstructorReferenceTypeArgument, classy.TargetMethodReferenceTypeArgument:
+		target += fmt.Sprintf(", offset=%v, type_index=%v", info.Offset, info.TypeArgumentIndex)
+	}
+	if len(ann.TargetPath.Path) > 0 {
+		var path []string
+		for _, entry := range ann.TargetPath.Path {
+			if entry.TypePathKind == classy.TypePathTypeArgument {
+				path = append(path, fmt.Sprintf("TYPE_ARGUMENT(%v)", entry.TypeArgumentIndex))
+			} else {
+				path = append(path, typePathNames[entry.TypePathKind])
+			}
+		}
+		target += ", location=[" + strings.Join(path, ", ") + "]"
+	}
+	return target
+}
+
+// annotation prints an annotation either with raw constant pool indices, e.g.
+// "#12(#13=s#14)", or resolved with one element per line.
+func (w *javapWriter) annotation(ann *classy.Annotation, resolve bool) {
+	if !resolve {
+		w.print(fmt.Sprintf("#%v(", ann.TypeIndex))
+		for i := range ann.ElementValuePairs {
+			if i > 0 {
+				w.print(",")
+			}
+			pair := &ann.ElementValuePairs[i]
+			w.print(fmt.Sprintf("#%v=", pair.ElementNameIndex))
+			w.elementValue(&pair.Value, false)
+		}
+		w.print(")")
+		return
+	}
+
+	w.print(javaType(w.stringValue(ann.TypeIndex)))
+	if len(ann.ElementValuePairs) == 0 {
+		return
+	}
+	w.println("(")
+	w.indent(+1)
+	for i := range ann.ElementValuePairs {
+		pair := &ann.ElementValuePairs[i]
+		w.print(w.stringValue(pair.ElementNameIndex) + "=")
+		w.elementValue(&pair.Value, true)
+		w.println("")
+	}
+	w.indent(-1)
+	w.print(")")
+}
+
+func (w *javapWriter) elementValue(v *classy.ElementValue, resolve bool) {
+	switch v.Tag {
+	case 'e':
+		if resolve {
+			w.print(javaType(w.stringValue(v.TypeNameIndex)) + "." + w.stringValue(v.ConstNameIndex))
+		} else {
+			w.print(fmt.Sprintf("e#%v.#%v", v.TypeNameIndex, v.ConstNameIndex))
+		}
+	case 'c':
+		if resolve {
+			w.print("class " + javaType(w.stringValue(v.ClassInfoIndex)))
+		} else {
+			w.print(fmt.Sprintf("c#%v", v.ClassInfoIndex))
+		}
+	case '@':
+		w.print("@")
+		w.annotation(v.AnnotationValue, resolve)
+	case '[':
+		w.print("[")
+		for i := range v.Values {
+			if i > 0 {
+				w.print(",")
+			}
+			w.elementValue(&v.Values[i], resolve)
+		}
+		w.print("]")
+	default:
+		if !resolve {
+			w.print(fmt.Sprintf("%c#%v", v.Tag, v.ConstValueIndex))
+			return
+		}
+		value := w.stringValue(v.ConstValueIndex)
+		switch v.Tag {
+		case 'B':
+			w.print("(byte) " + value)
+		case 'S':
+			w.print("(short) " + value)
+		case 'C':
+			if n, err := strconv.Atoi(value); err == nil {
+				value = string(rune(n))
+			}
+			w.print("'" + value + "'")
+		case 'Z':
+			w.print(strconv.FormatBool(value != "0"))
+		case 's':
+			w.print(`"` + value + `"`)
+		default:
+			w.print(value)
+		}
+	}
+}
+
+func (w *javapWriter) module(a *classy.ModuleAttribute) {
+	w.println("Module:")
+	w.indent(+1)
+	w.print(fmt.Sprintf("#%v,%x", a.ModuleNameIndex, uint16(a.ModuleFlags)))
+	w.tab()
+	w.print("// " + w.stringValue(a.ModuleNameIndex))
+	w.printModuleFlags(a.ModuleFlags, []flagName{
+		{classy.AccOpen, "ACC_OPEN"},
+		{classy.AccMandated, "ACC_MANDATED"},
+		{classy.AccSynthetic, "ACC_SYNTHETIC"},
+	})
+	w.println("")
+	w.printVersion(a.ModuleVersionIndex)
+
+	w.moduleTableHeader(len(a.Requires), "requires")
+	for _, req := range a.Requires {
+		w.print(fmt.Sprintf("#%v,%x", req.RequiresIndex, uint16(req.RequiresFlags)))
+		w.tab()
+		w.print("// " + w.stringValue(req.RequiresIndex))
+		w.printModuleFlags(req.RequiresFlags, []flagName{
+			{classy.AccTransitive, "ACC_TRANSITIVE"},
+			{classy.AccStaticPhase, "ACC_STATIC_PHASE"},
+			{classy.AccSynthetic, "ACC_SYNTHETIC"},
+			{classy.AccMandated, "ACC_MANDATED"},
+		})
+		w.println("")
+		w.printVersion(req.RequiresVersionIndex)
+	}
+	w.indent(-1)
+
+	w.moduleTableHeader(len(a.Exports), "exports")
+	for _, export := range a.Exports {
+		w.exportOpenEntry(export.ExportsIndex, export.ExportsFlags, export.ExportsToIndex)
+	}
+	w.indent(-1)
+
+	w.moduleTableHeader(len(a.Opens), "opens")
+	for _, open := range a.Opens {
+		w.exportOpenEntry(open.OpensIndex, open.OpensFlags, open.OpensToIndex)
+	}
+	w.indent(-1)
+
+	w.moduleTableHeader(len(a.UsesIndex), "uses")
+	for _, use := range a.UsesIndex {
+		w.print(fmt.Sprintf("#%v", use))
+		w.tab()
+		w.println("// " + w.stringValue(use))
+	}
+	w.indent(-1)
+
+	w.moduleTableHeader(len(a.Provides), "provides")
+	for _, provides := range a.Provides {
+		w.print(fmt.Sprintf("#%v", provides.ProvidesIndex))
+		w.tab()
+		w.println(fmt.Sprintf("// %v with ... %v", w.stringValue(provides.ProvidesIndex), provides.ProvidesWithCount))
+		w.indent(+1)
+		for _, with := range provides.ProvidesWithIndex {
+			w.print(fmt.Sprintf("#%v", with))
+			w.tab()
+			w.println("// ... with " + w.stringValue(with))
+		}
+		w.indent(-1)
+	}
+	w.indent(-1)
+	w.indent(-1)
+}
+
+// moduleTableHeader prints the size of one of the Module attribute's tables and
+// indents for its entries.
+func (w *javapWriter) moduleTableHeader(count int, name string) {
+	w.print(strconv.Itoa(count))
+	w.tab()
+	w.println("// " + name)
+	w.indent(+1)
+}
+
+func (w *javapWriter) printModuleFlags(acc classy.Access, names []flagName) {
+	for _, name := range flagNames(acc, names) {
+		w.print(" " + name)
+	}
+}
+
+func (w *javapWriter) printVersion(index uint16) {
+	w.print(fmt.Sprintf("#%v", index))
+	if index != 0 {
+		w.tab()
+		w.print("// " + w.stringValue(index))
+	}
+	w.println("")
+}
+
+func (w *javapWriter) exportOpenEntry(index uint16, flags classy.Access, to []uint16) {
+	w.print(fmt.Sprintf("#%v,%x", index, uint16(flags)))
+	w.tab()
+	w.print("// " + w.stringValue(index))
+	w.printModuleFlags(flags, []flagName{
+		{classy.AccMandated, "ACC_MANDATED"},
+		{classy.AccSynthetic, "ACC_SYNTHETIC"},
+	})
+	if len(to) == 0 {
+		w.println("")
+		return
+	}
+	w.println(fmt.Sprintf(" to ... %v", len(to)))
+	w.indent(+1)
+	for _, module := range to {
+		w.print(fmt.Sprintf("#%v", module))
+		w.tab()
+		w.println("// ... to " + w.stringValue(module))
+	}
+	w.indent(-1)
+}
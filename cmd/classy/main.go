@@ -1,9 +1,11 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/a10y/classy"
@@ -21,33 +23,220 @@ var (
 )
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "Usage: %v FILENAME\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Usage: %v [--format=text|json|yaml] [--strict] FILENAME\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  FILENAME may be a .class file, an archive (app.jar), or a class inside an\n")
+	fmt.Fprintf(os.Stderr, "  archive (app.jar!/com/foo/Bar.class or app.jar!/com.foo.Bar)\n")
+	fmt.Fprintf(os.Stderr, "  --format=json or yaml prints the whole parsed class file, see the README\n")
+	fmt.Fprintf(os.Stderr, "  --strict fails on classes that break the format checks of JVMS 4.8\n")
+	fmt.Fprintf(os.Stderr, "       %v javap FILENAME...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  prints classes in the layout of javap -v -p -c\n")
+	fmt.Fprintf(os.Stderr, "       %v assemble-json [-o OUTPUT] FILENAME.json\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  rebuilds a classfile from the output of --format=json\n")
+	fmt.Fprintf(os.Stderr, "       %v disasm FILENAME\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  prints a class as assembly in the syntax read by asm, see the README\n")
+	fmt.Fprintf(os.Stderr, "       %v asm [-o OUTPUT] FILENAME.j\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  assembles a classfile from the output of disasm\n")
+	fmt.Fprintf(os.Stderr, "       %v verify [--classpath PATH] FILENAME...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  checks the bytecode of classes as the JVM's verifier does\n")
+	fmt.Fprintf(os.Stderr, "       %v upgrade -o OUTPUT [--version N] [--classpath PATH] FILENAME\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  raises classes, or those of an archive, to version N (52) with stack map frames\n")
 	os.Exit(-1)
 }
 
 func main() {
-	if len(os.Args) == 1 {
+	if len(os.Args) > 1 && os.Args[1] == "javap" {
+		javap(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "assemble-json" {
+		assembleJSON(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "disasm" {
+		disasm(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "asm" {
+		asm(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		verify(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "upgrade" {
+		upgrade(os.Args[2:])
+		return
+	}
+	flag.Usage = usage
+	format := flag.String("format", "text", "output format: text, json or yaml")
+	strict := flag.Bool("strict", false, "fail on classes that break the classfile format constraints")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+	}
+	if *format != "text" && *format != "json" && *format != "yaml" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
 		usage()
 	}
 
-	data, err := ioutil.ReadFile(os.Args[1])
+	target := flag.Arg(0)
+	sources, closer, err := readSources(target)
 	if err != nil {
-		panic(err)
-	}
-	classFile, err := classy.ReadClassFile(data)
-	if classFile == nil {
-		fmt.Fprintf(os.Stderr, "Error parsing %v: %v\n", os.Args[1], err.Error())
+		fmt.Fprintf(os.Stderr, "Error opening %v: %v\n", target, err)
 		os.Exit(-1)
 	}
+	defer closer()
+
+	if *format != "text" {
+		if !dump(*format, target, sources, *strict) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// A whole archive is listed class by class, carrying on past classes that fail
+	if !classy.IsArchive(target) {
+		classFile, err := readSource(sources[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing %v: %v\n", target, err)
+			os.Exit(-1)
+		}
+		if err := validate(classFile, *strict); err != nil {
+			fmt.Fprintf(os.Stderr, "Error validating %v: %v\n", target, err)
+			os.Exit(1)
+		}
+		printClassFile(classFile)
+		return
+	}
+
+	failed := false
+	for i, source := range sources {
+		if i > 0 {
+			fmt.Println()
+		}
+		HeaderColorizer.Printf("== %v ==\n", source.Name)
+		classFile, err := readSource(source)
+		if err != nil {
+			ErrorColorizer.Printf("Error parsing %v: %v", source.Name, err)
+			fmt.Println()
+			failed = true
+			continue
+		}
+		if err := validate(classFile, *strict); err != nil {
+			ErrorColorizer.Printf("Error validating %v: %v", source.Name, err)
+			fmt.Println()
+			failed = true
+			continue
+		}
+		printClassFile(classFile)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// dump prints sources as JSON or YAML documents: a single class as one document, and an
+// archive as a JSON array or a YAML stream holding a document per class. Classes that
+// fail to parse are reported in place as {source, error} documents, and dump returns
+// false when there were any. With strict, classes that fail validation are reported the
+// same way.
+func dump(format, target string, sources []*classSource, strict bool) bool {
+	write := writeJSON
+	if format == "yaml" {
+		write = writeYAML
+	}
+
+	if !classy.IsArchive(target) {
+		classFile, err := readSource(sources[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing %v: %v\n", target, err)
+			os.Exit(-1)
+		}
+		if err := validate(classFile, strict); err != nil {
+			fmt.Fprintf(os.Stderr, "Error validating %v: %v\n", target, err)
+			os.Exit(1)
+		}
+		write(os.Stdout, dumpClass(classFile, sources[0].Name))
+		return true
+	}
+
+	ok := true
+	docs := list{}
+	for _, source := range sources {
+		classFile, err := readSource(source)
+		if err == nil {
+			err = validate(classFile, strict)
+		}
+		if err != nil {
+			docs = append(docs, object{{"source", source.Name}, {"error", err.Error()}})
+			ok = false
+			continue
+		}
+		docs = append(docs, dumpClass(classFile, source.Name))
+	}
+	if format == "json" {
+		writeJSON(os.Stdout, docs)
+		return ok
+	}
+	for _, doc := range docs {
+		writeYAML(os.Stdout, doc)
+	}
+	return ok
+}
+
+func readSource(source *classSource) (*classy.ClassFile, error) {
+	data, err := source.Read()
+	if err != nil {
+		return nil, err
+	}
+	return classy.ReadClassFile(data)
+}
+
+// validate lists the problems Validate finds with a class as an error, when strict is
+// set.
+func validate(classFile *classy.ClassFile, strict bool) error {
+	if !strict {
+		return nil
+	}
+	problems := classFile.Validate()
+	if len(problems) == 0 {
+		return nil
+	}
+	lines := []string{fmt.Sprintf("%v format problems", len(problems))}
+	for _, problem := range problems {
+		lines = append(lines, "  "+problem.String())
+	}
+	return errors.New(strings.Join(lines, "\n"))
+}
 
+func printClassFile(classFile *classy.ClassFile) {
 	AuxColorizer.Printf("Binary Name:")
 	fmt.Printf(" %v\n", classFile.GetBinaryName())
 
+	if sig, err := classFile.GenericSignature(); err == nil && sig != nil {
+		AuxColorizer.Printf("Signature:")
+		fmt.Printf(" %v\n", sig)
+	}
+
+	if anns, err := classFile.Annotations(); err == nil && len(anns) > 0 {
+		AuxColorizer.Printf("Annotations:")
+		for _, ann := range anns {
+			fmt.Printf(" %v", ann.Repr(classFile.ConstantPool))
+		}
+		fmt.Println()
+	}
+
 	AuxColorizer.Printf("Major:")
 	fmt.Printf(" %v\n", classFile.MajorVersion)
 	AuxColorizer.Printf("Minor:")
 	fmt.Printf(" %v\n", classFile.MinorVersion)
 
+	if classFile.AccessFlags&classy.AccModule != 0 {
+		HeaderColorizer.Printf("\nModule:\n")
+		printModule(classFile)
+	}
+
 	HeaderColorizer.Printf("\nConstantPool:")
 	fmt.Printf(" (%v entries)\n", classFile.ConstantPoolCount-1)
 	printCP(classFile)
@@ -89,16 +278,119 @@ func printMethods(cf *classy.ClassFile) {
 			branch = "└──"
 		}
 		name := meth.Name(cf.ConstantPool)
-		paramSlice, ret := classy.ParseMethodDescriptor(meth.Descriptor(cf.ConstantPool))
+		var ret, throws string
+		var params []string
+		if desc, err := classy.ParseMethodDescriptor(meth.Descriptor(cf.ConstantPool)); err == nil {
+			ret = desc.Return.String()
+			for _, param := range desc.Params {
+				params = append(params, param.String())
+			}
+		} else {
+			ret, params = "<invalid>", []string{err.Error()}
+		}
+		// Prefer the generic signature, when there is one
+		if sig, err := meth.GenericSignature(cf.ConstantPool); err == nil && sig != nil {
+			ret = sig.Result.String()
+			if len(sig.TypeParameters) > 0 {
+				ret = sig.TypeParameters.String() + " " + ret
+			}
+			params = nil
+			for _, param := range sig.Params {
+				params = append(params, param.String())
+			}
+			if len(sig.Throws) > 0 {
+				throws = " throws " + sig.ThrowsString()
+			}
+		}
+		if paramAnns, err := meth.ParameterAnnotations(cf.ConstantPool); err == nil {
+			params = annotateParams(params, paramAnns, cf.ConstantPool)
+		}
+		indent := "│"
+		if i == int(cf.MethodsCount)-1 {
+			indent = " "
+		}
+		branch = printAnnotations(cf, meth.Annotations, branch, indent)
+
 		flags := FieldTypeColor.Sprint(classy.MethodFlagsRepr(meth.AccessFlags))
 		ret = FieldTypeColor.Sprint(ret)
 		name = FieldNameColor.Sprint(name)
-		params := ParamTypeColor.Sprint(strings.Join(paramSlice, ", "))
-		repr := fmt.Sprintf("%v %v %v(%v)\n", flags, ret, name, params)
+		paramList := ParamTypeColor.Sprint(strings.Join(params, ", "))
+		repr := fmt.Sprintf("%v %v %v(%v)%v\n", flags, ret, name, paramList, throws)
 		fmt.Printf("  %v %v", branch, repr)
+
+		printCode(cf, &meth, indent)
 	}
 }
 
+func printCode(cf *classy.ClassFile, meth *classy.MethodInfo, indent string) {
+	code, err := meth.Code(cf.ConstantPool)
+	if err != nil {
+		ErrorColorizer.Printf("  %v   error decoding Code: %v", indent, err)
+		fmt.Println()
+		return
+	}
+	if code == nil {
+		return
+	}
+	insns, err := code.Instructions()
+	if err != nil {
+		ErrorColorizer.Printf("  %v   error decoding bytecode: %v", indent, err)
+		fmt.Println()
+		return
+	}
+	AuxColorizer.Printf("  %v   ", indent)
+	fmt.Printf("stack=%v, locals=%v\n", code.MaxStack, code.MaxLocals)
+	frames, err := stackMapFrames(cf, code)
+	if err != nil {
+		ErrorColorizer.Printf("  %v   error decoding StackMapTable: %v", indent, err)
+		fmt.Println()
+	}
+	for _, ins := range insns {
+		for _, frame := range frames[ins.Offset] {
+			AuxColorizer.Printf("  %v          frame %v", indent, frame)
+			fmt.Println()
+		}
+		delete(frames, ins.Offset)
+		fmt.Printf("  %v   %5d: %v\n", indent, ins.Offset, ins.Repr(cf.ConstantPool))
+	}
+	var stray []int
+	for offset := range frames {
+		stray = append(stray, offset)
+	}
+	sort.Ints(stray)
+	for _, offset := range stray {
+		for _, frame := range frames[offset] {
+			ErrorColorizer.Printf("  %v   frame at %v, which isn't an instruction: %v", indent, offset, frame)
+			fmt.Println()
+		}
+	}
+	for _, handler := range code.ExceptionTable {
+		fmt.Printf("  %v   catch %v [%v, %v) -> %v\n", indent, handler.CatchTypeName(cf.ConstantPool),
+			handler.StartPC, handler.EndPC, handler.HandlerPC)
+	}
+}
+
+// stackMapFrames renders the frames of a Code attribute's StackMapTable, by offset,
+// stopping at a table that can't be decoded.
+func stackMapFrames(cf *classy.ClassFile, code *classy.CodeAttribute) (map[int][]string, error) {
+	frames := map[int][]string{}
+	for _, info := range code.Attrs {
+		if info.Name(cf.ConstantPool) != "StackMapTable" {
+			continue
+		}
+		attr, err := info.Decode(cf.ConstantPool)
+		if err != nil {
+			return frames, err
+		}
+		if table, ok := attr.(*classy.StackMapTableAttribute); ok {
+			for i, offset := range table.Offsets() {
+				frames[offset] = append(frames[offset], table.Entries[i].Repr(cf.ConstantPool))
+			}
+		}
+	}
+	return frames, nil
+}
+
 func printFields(cf *classy.ClassFile) {
 	for i, field := range cf.Fields {
 		branch := "├──"
@@ -106,23 +398,30 @@ func printFields(cf *classy.ClassFile) {
 			branch = "└──"
 		}
 		flags := FieldTypeColor.Sprint(classy.FieldFlagsRepr(field.AccessFlags))
-		desc := FieldTypeColor.Sprint(classy.ParseFieldDescriptor(field.Descriptor(cf.ConstantPool)))
-		name := FieldNameColor.Sprint(field.Name(cf.ConstantPool))
-		fmt.Printf("  %v %v %v %v\n", branch, flags, desc, name)
-	}
-}
+		var desc string
+		if t, err := classy.ParseFieldDescriptor(field.Descriptor(cf.ConstantPool)); err == nil {
+			desc = t.String()
+		} else {
+			desc = "<invalid: " + err.Error() + ">"
+		}
+		if sig, err := field.GenericSignature(cf.ConstantPool); err == nil && sig != nil {
+			desc = sig.String()
+		}
+		indent := "│"
+		if i == int(cf.FieldsCount)-1 {
+			indent = " "
+		}
+		branch = printAnnotations(cf, field.Annotations, branch, indent)
 
-func printAttrs(cf *classy.ClassFile) {
-	for i, attr := range cf.Attrs {
-		branch := "├──"
-		if i == int(cf.AttrsCount)-1 {
-			branch = "└──"
+		desc = FieldTypeColor.Sprint(desc)
+		name := FieldNameColor.Sprint(field.Name(cf.ConstantPool))
+		var value string
+		if constant, err := field.ConstantValue(cf.ConstantPool); err == nil && constant != nil {
+			value = " = " + constant.Repr(cf.ConstantPool)
 		}
-		fmt.Printf("  %v %v\n", branch, attr.Name(cf.ConstantPool))
+		fmt.Printf("  %v %v %v %v%v\n", branch, flags, desc, name, value)
 	}
 }
 
-// TODO: disassembly?
 // TODO: colorize attributes and constant pool entries
-// TODO: add constant pool info for methodref, instancemethodref, etc.
 // TODO: show access flags for fields/methods
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/a10y/classy"
+)
+
+// describeModule renders a module descriptor in the same layout as
+// `jar --describe-module`.
+func describeModule(cf *classy.ClassFile) ([]string, error) {
+	cp := cf.ConstantPool
+	module, err := cf.Module()
+	if err != nil || module == nil {
+		return nil, err
+	}
+	packages, err := cf.ModulePackages()
+	if err != nil {
+		return nil, err
+	}
+	mainClass, err := cf.ModuleMainClass()
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	header := module.Name(cp)
+	if version := module.Version(cp); version != "" {
+		header += "@" + version
+	}
+	if module.ModuleFlags&classy.AccOpen != 0 {
+		header += " open"
+	}
+	lines = append(lines, header)
+
+	mentioned := make(map[string]bool)
+	var exports, qualifiedExports []string
+	for _, exp := range module.Exports {
+		pkg := dottedName(cp, exp.ExportsIndex)
+		mentioned[pkg] = true
+		if len(exp.ExportsToIndex) == 0 {
+			exports = append(exports, "exports "+pkg)
+		} else {
+			qualifiedExports = append(qualifiedExports, "qualified exports "+pkg+" to "+moduleNames(cp, exp.ExportsToIndex))
+		}
+	}
+	sort.Strings(exports)
+	lines = append(lines, exports...)
+
+	var requires []string
+	for _, req := range module.Requires {
+		line := "requires " + req.Name(cp)
+		if flags := classy.RequiresFlagsRepr(req.RequiresFlags); flags != "" {
+			line += " " + flags
+		}
+		requires = append(requires, line)
+	}
+	sort.Strings(requires)
+	lines = append(lines, requires...)
+
+	var uses []string
+	for _, index := range module.UsesIndex {
+		uses = append(uses, "uses "+dottedName(cp, index))
+	}
+	sort.Strings(uses)
+	lines = append(lines, uses...)
+
+	var provides []string
+	for _, prov := range module.Provides {
+		var impls []string
+		for _, index := range prov.ProvidesWithIndex {
+			impls = append(impls, dottedName(cp, index))
+		}
+		provides = append(provides, "provides "+dottedName(cp, prov.ProvidesIndex)+" with "+strings.Join(impls, " "))
+	}
+	sort.Strings(provides)
+	lines = append(lines, provides...)
+
+	sort.Strings(qualifiedExports)
+	lines = append(lines, qualifiedExports...)
+
+	var opens, qualifiedOpens []string
+	for _, open := range module.Opens {
+		pkg := dottedName(cp, open.OpensIndex)
+		mentioned[pkg] = true
+		if len(open.OpensToIndex) == 0 {
+			opens = append(opens, "opens "+pkg)
+		} else {
+			qualifiedOpens = append(qualifiedOpens, "qualified opens "+pkg+" to "+moduleNames(cp, open.OpensToIndex))
+		}
+	}
+	sort.Strings(opens)
+	sort.Strings(qualifiedOpens)
+	lines = append(lines, opens...)
+	lines = append(lines, qualifiedOpens...)
+
+	if packages != nil {
+		var contains []string
+		for _, index := range packages.PackageIndex {
+			if pkg := dottedName(cp, index); !mentioned[pkg] {
+				contains = append(contains, "contains "+pkg)
+			}
+		}
+		sort.Strings(contains)
+		lines = append(lines, contains...)
+	}
+
+	if mainClass != nil {
+		lines = append(lines, "main-class "+dottedName(cp, mainClass.MainClassIndex))
+	}
+	return lines, nil
+}
+
+func printModule(cf *classy.ClassFile) {
+	lines, err := describeModule(cf)
+	if err != nil {
+		ErrorColorizer.Printf("  error decoding module: %v", err)
+		fmt.Println()
+		return
+	}
+	for i, line := range lines {
+		branch := "├──"
+		if i == len(lines)-1 {
+			branch = "└──"
+		}
+		fmt.Printf("  %v %v\n", branch, line)
+	}
+}
+
+// dottedName renders a Class or Package constant with dots in place of slashes.
+func dottedName(cp []classy.CpEntry, index uint16) string {
+	return strings.Replace(constantRepr(cp, index), "/", ".", -1)
+}
+
+func moduleNames(cp []classy.CpEntry, indices []uint16) string {
+	var names []string
+	for _, index := range indices {
+		names = append(names, constantRepr(cp, index))
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+// constantRepr renders the constant at index, or #index if there is none.
+func constantRepr(cp []classy.CpEntry, index uint16) string {
+	ent := classy.LookupEntry(cp, index)
+	if ent == nil {
+		return fmt.Sprintf("#%v", index)
+	}
+	return ent.Repr(cp)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/a10y/classy"
+)
+
+// classSource is a classfile named on the command line, either a file on disk or an
+// entry of an archive.
+type classSource struct {
+	// Name is how the class was named on the command line, or archive!/path for each
+	// class of an archive.
+	Name string
+	// URI locates the classfile the way javap does, e.g. jar:file:/abs/app.jar!/Foo.class.
+	URI      string
+	Modified time.Time
+	read     func() ([]byte, error)
+}
+
+// Read reads the raw contents of the classfile.
+func (s *classSource) Read() ([]byte, error) {
+	return s.read()
+}
+
+// readSources resolves a command line argument to the classes it names: a .class file,
+// every class of an archive, or a single class inside an archive given as
+// app.jar!/com/foo/Bar.class or app.jar!/com.foo.Bar. The returned function closes any
+// archive that was opened.
+func readSources(target string) ([]*classSource, func(), error) {
+	archivePath, inner := target, ""
+	if i := strings.Index(target, classy.ArchiveSeparator); i >= 0 {
+		archivePath, inner = target[:i], target[i+len(classy.ArchiveSeparator):]
+	}
+
+	if !classy.IsArchive(archivePath) {
+		info, err := os.Stat(target)
+		if err != nil {
+			return nil, nil, err
+		}
+		abs, _ := filepath.Abs(target)
+		source := &classSource{
+			Name:     target,
+			URI:      abs,
+			Modified: info.ModTime(),
+			read:     func() ([]byte, error) { return ioutil.ReadFile(target) },
+		}
+		return []*classSource{source}, func() {}, nil
+	}
+
+	archive, err := classy.OpenArchive(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	closer := func() { archive.Close() }
+
+	var classes []*classy.ArchiveClass
+	switch {
+	case inner == "":
+		classes = archive.Classes()
+	case strings.HasSuffix(inner, ".class"):
+		class, err := archive.FindPath(inner)
+		if err != nil {
+			closer()
+			return nil, nil, err
+		}
+		classes = append(classes, class)
+	default:
+		class, err := archive.FindName(inner)
+		if err != nil {
+			closer()
+			return nil, nil, err
+		}
+		classes = append(classes, class)
+	}
+
+	abs, _ := filepath.Abs(archivePath)
+	var sources []*classSource
+	for _, class := range classes {
+		sources = append(sources, &classSource{
+			Name:     archivePath + classy.ArchiveSeparator + class.Path,
+			URI:      "jar:file:" + abs + classy.ArchiveSeparator + class.Path,
+			Modified: class.Modified(),
+			read:     class.Bytes,
+		})
+	}
+	return sources, closer, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/a10y/classy"
+)
+
+// upgrade raises the version of old classes, inlining their subroutines and computing
+// their frames, so that they load where the JVM won't fall back to the old verifier. The
+// classes of an archive are upgraded in a copy of it.
+func upgrade(args []string) {
+	flags := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	flags.Usage = usage
+	output := flags.String("o", "", "where to write the classfile or archive, - for stdout")
+	version := flags.Uint("version", 52, "the classfile version to upgrade to")
+	classpath := flags.String("classpath", "", "classfiles and archives that the upgraded classes use")
+	input := inputArg(flags, args)
+	if *output == "" {
+		usage()
+	}
+	if *version < 50 || *version > math.MaxUint16 {
+		fmt.Fprintf(os.Stderr, "--version must be 50 or above\n")
+		os.Exit(-1)
+	}
+
+	hierarchy := classy.NewClassSet(classy.CoreClasses)
+	if *classpath != "" {
+		for _, entry := range filepath.SplitList(*classpath) {
+			if _, failures := loadClasses(entry, hierarchy); failures > 0 {
+				os.Exit(-1)
+			}
+		}
+	}
+	if classy.IsArchive(input) {
+		upgradeArchive(input, *output, uint16(*version), hierarchy)
+		return
+	}
+	classFile, err := classy.ReadClassFile(readInput(input))
+	if err == nil {
+		err = hierarchy.Add(classFile)
+	}
+	if err == nil {
+		err = upgradeClass(classFile, uint16(*version), hierarchy)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error upgrading %v: %v\n", input, err)
+		os.Exit(1)
+	}
+	writeAssembled(classFile, input, *output)
+}
+
+// upgradeClass upgrades a class older than version, leaving newer ones alone.
+func upgradeClass(classFile *classy.ClassFile, version uint16, hierarchy classy.ClassHierarchy) error {
+	if classFile.MajorVersion >= version {
+		return nil
+	}
+	return classFile.Upgrade(version, hierarchy)
+}
+
+// upgradeArchive writes a copy of an archive with each of the classes at its top level
+// upgraded. Other entries, including nested archives, are copied as they are.
+func upgradeArchive(input, output string, version uint16, hierarchy *classy.ClassSet) {
+	if _, failures := loadClasses(input, hierarchy); failures > 0 {
+		os.Exit(-1)
+	}
+	zr, err := zip.OpenReader(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening %v: %v\n", input, err)
+		os.Exit(-1)
+	}
+	defer zr.Close()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	failed := 0
+	for _, f := range zr.File {
+		data, err := readZipEntry(f)
+		if err == nil && strings.HasSuffix(f.Name, ".class") {
+			data, err = upgradeClassBytes(data, version, hierarchy)
+		}
+		if err == nil {
+			header := f.FileHeader
+			w, createErr := zw.CreateHeader(&header)
+			if err = createErr; err == nil {
+				_, err = w.Write(data)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error upgrading %v%v%v: %v\n", input, classy.ArchiveSeparator, f.Name, err)
+			failed++
+		}
+	}
+	if err := zw.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %v: %v\n", output, err)
+		os.Exit(-1)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+
+	if output == "-" {
+		_, err = os.Stdout.Write(buf.Bytes())
+	} else {
+		err = ioutil.WriteFile(output, buf.Bytes(), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %v: %v\n", output, err)
+		os.Exit(-1)
+	}
+}
+
+func readZipEntry(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// upgradeClassBytes upgrades the classfile in data, returning it unchanged if it's
+// already new enough.
+func upgradeClassBytes(data []byte, version uint16, hierarchy classy.ClassHierarchy) ([]byte, error) {
+	classFile, err := classy.ReadClassFile(data)
+	if err != nil {
+		return nil, err
+	}
+	if classFile.MajorVersion >= version {
+		return data, nil
+	}
+	if err := classFile.Upgrade(version, hierarchy); err != nil {
+		return nil, err
+	}
+	return classy.WriteClassFile(classFile)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/a10y/classy"
+)
+
+// verify checks every class named on the command line with the type-checking verifier,
+// listing the problems of the classes that fail.
+func verify(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	flags.Usage = usage
+	classpath := flags.String("classpath", "", "classfiles and archives that the verified classes use")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		usage()
+	}
+
+	hierarchy := classy.NewClassSet(classy.CoreClasses)
+	if *classpath != "" {
+		for _, entry := range filepath.SplitList(*classpath) {
+			if _, failures := loadClasses(entry, hierarchy); failures > 0 {
+				os.Exit(-1)
+			}
+		}
+	}
+	var classes []loadedClass
+	failed := 0
+	for _, target := range flags.Args() {
+		loaded, failures := loadClasses(target, hierarchy)
+		classes = append(classes, loaded...)
+		failed += failures
+	}
+
+	for _, class := range classes {
+		errs := class.cf.Verify(hierarchy)
+		if len(errs) == 0 {
+			continue
+		}
+		failed++
+		HeaderColorizer.Println(class.name)
+		for _, err := range errs {
+			ErrorColorizer.Printf("  %v", err)
+			fmt.Println()
+			if verifyErr, ok := err.(*classy.VerifyError); ok && verifyErr.Frame != nil {
+				AuxColorizer.Printf("    %v\n", verifyErr.Frame)
+			}
+		}
+	}
+	fmt.Printf("classes verified: %v, failed: %v\n", len(classes), failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+type loadedClass struct {
+	name string
+	cf   *classy.ClassFile
+}
+
+// loadClasses reads the classes a command line argument names and adds them to
+// hierarchy, reporting and counting those that can't be read.
+func loadClasses(target string, hierarchy *classy.ClassSet) ([]loadedClass, int) {
+	sources, closer, err := readSources(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening %v: %v\n", target, err)
+		return nil, 1
+	}
+	defer closer()
+	var classes []loadedClass
+	failures := 0
+	for _, source := range sources {
+		cf, err := readSource(source)
+		if err == nil {
+			err = hierarchy.Add(cf)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing %v: %v\n", source.Name, err)
+			failures++
+			continue
+		}
+		classes = append(classes, loadedClass{source.Name, cf})
+	}
+	return classes, failures
+}
@@ -0,0 +1,99 @@
+package classy
+
+import "fmt"
+
+// CodeAttribute is the decoded form of a method's Code attribute. It holds the bytecode
+// for the method along with the operand stack and local variable sizing and the
+// exception handlers that cover the bytecode.
+type CodeAttribute struct {
+	MaxStack             uint16
+	MaxLocals            uint16
+	CodeLength           uint32
+	Code                 []byte
+	ExceptionTableLength uint16
+	ExceptionTable       []ExceptionTableEntry
+	AttrsCount           uint16
+	Attrs                []AttrInfo
+}
+
+// ExceptionTableEntry is a single exception handler in the Code attribute. StartPC is
+// inclusive and EndPC is exclusive. A CatchType of 0 catches every exception, otherwise
+// it is the index of a CONSTANT_Class entry for the exception type.
+type ExceptionTableEntry struct {
+	StartPC   uint16
+	EndPC     uint16
+	HandlerPC uint16
+	CatchType uint16
+}
+
+// ReadCodeAttribute decodes the contents of a Code attribute from its raw attribute
+// data. Failures are reported as a *ParseError with offsets relative to data.
+func ReadCodeAttribute(data []byte) (*CodeAttribute, error) {
+	d := &decoder{data: data, path: []string{"Code"}}
+	code := readCode(d)
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return code, nil
+}
+
+func readCode(d *decoder) *CodeAttribute {
+	code := new(CodeAttribute)
+
+	code.MaxStack = d.u2()
+	code.MaxLocals = d.u2()
+	code.CodeLength = d.u4()
+	code.Code = d.bytes(int(code.CodeLength))
+
+	code.ExceptionTableLength = d.u2()
+	for i := uint16(0); d.err == nil && i < code.ExceptionTableLength; i++ {
+		var entry ExceptionTableEntry
+		entry.StartPC = d.u2()
+		entry.EndPC = d.u2()
+		entry.HandlerPC = d.u2()
+		entry.CatchType = d.u2()
+		code.ExceptionTable = append(code.ExceptionTable, entry)
+	}
+
+	code.AttrsCount = d.u2()
+	code.Attrs = readAttrs(d, code.AttrsCount)
+	return code
+}
+
+// Instructions decodes the bytecode held in the attribute.
+func (c *CodeAttribute) Instructions() ([]Instruction, error) {
+	return DecodeInstructions(c.Code)
+}
+
+// CatchTypeName gets the internal name of the exception class caught by the handler,
+// or "any" for handlers that catch everything (such as those generated for finally).
+// A catch_type that doesn't refer to a CONSTANT_Class is rendered as #n.
+func (e *ExceptionTableEntry) CatchTypeName(cp []CpEntry) string {
+	if e.CatchType == 0 {
+		return "any"
+	}
+	if name, ok := lookupClassName(cp, e.CatchType); ok {
+		return name
+	}
+	return fmt.Sprintf("#%v", e.CatchType)
+}
+
+// Code finds and decodes the method's Code attribute. Abstract and native methods have
+// no code, in which case nil is returned with no error.
+func (i *MethodInfo) Code(cp []CpEntry) (*CodeAttribute, error) {
+	attr := findAttr(i.Attrs, cp, "Code")
+	if attr == nil {
+		return nil, nil
+	}
+	return ReadCodeAttribute(attr.AttrData)
+}
+
+// findAttr returns the first attribute in attrs with the given name, or nil.
+func findAttr(attrs []AttrInfo, cp []CpEntry, name string) *AttrInfo {
+	for i := range attrs {
+		if utf8, ok := lookupUtf8(cp, attrs[i].NameIndex); ok && utf8.Value() == name {
+			return &attrs[i]
+		}
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 )
 
 // ConstantTag is a 1-byte header before every entry in the constant pool conveying type
@@ -24,9 +25,60 @@ const (
 	CONSTANT_Utf8                           = 1
 	CONSTANT_MethodHandle                   = 15
 	CONSTANT_MethodType                     = 16
+	CONSTANT_Dynamic                        = 17
 	CONSTANT_InvokeDynamic                  = 18
+	CONSTANT_Module                         = 19
+	CONSTANT_Package                        = 20
 )
 
+// MemberRef is implemented by the constant pool entries that refer to a field or
+// method: CONSTANT_Fieldref_info, CONSTANT_Methodref_info and
+// CONSTANT_InterfaceMethodref_info.
+type MemberRef interface {
+	CpEntry
+	// ClassName gets the internal name of the class declaring the member.
+	ClassName(cp []CpEntry) string
+	// Name gets the name of the member.
+	Name(cp []CpEntry) string
+	// Descriptor gets the field or method descriptor of the member.
+	Descriptor(cp []CpEntry) string
+}
+
+// Reference kinds of a CONSTANT_MethodHandle_info, which determine what kind of member
+// the handle refers to and how it is invoked.
+const (
+	RefGetField         byte = 1
+	RefGetStatic        byte = 2
+	RefPutField         byte = 3
+	RefPutStatic        byte = 4
+	RefInvokeVirtual    byte = 5
+	RefInvokeStatic     byte = 6
+	RefInvokeSpecial    byte = 7
+	RefNewInvokeSpecial byte = 8
+	RefInvokeInterface  byte = 9
+)
+
+var referenceKindNames = map[byte]string{
+	RefGetField:         "REF_getField",
+	RefGetStatic:        "REF_getStatic",
+	RefPutField:         "REF_putField",
+	RefPutStatic:        "REF_putStatic",
+	RefInvokeVirtual:    "REF_invokeVirtual",
+	RefInvokeStatic:     "REF_invokeStatic",
+	RefInvokeSpecial:    "REF_invokeSpecial",
+	RefNewInvokeSpecial: "REF_newInvokeSpecial",
+	RefInvokeInterface:  "REF_invokeInterface",
+}
+
+// ReferenceKindName returns the name the spec gives a method handle reference kind,
+// e.g. "REF_invokeStatic".
+func ReferenceKindName(kind byte) string {
+	if name, ok := referenceKindNames[kind]; ok {
+		return name
+	}
+	return fmt.Sprintf("REF_unknown(%v)", kind)
+}
+
 // CONSTANT_Class_info represents constant pool entries for classes.
 // Corresponds to eponymous struct in the spec.
 type CONSTANT_Class_info struct {
@@ -61,16 +113,19 @@ type CONSTANT_String_info struct {
 	StringIndex uint16
 }
 
-// CONSTANT_Integer_info corresponds to eponymous struct in the spec.
+// CONSTANT_Integer_info corresponds to eponymous struct in the spec. Bytes holds the
+// big-endian two's complement value; use Value to get it as a signed int.
 type CONSTANT_Integer_info struct {
 	Tag   ConstantTag
-	Value uint32
+	Bytes uint32
 }
 
-// CONSTANT_Float_info corresponds to eponymous struct in the spec.
+// CONSTANT_Float_info corresponds to eponymous struct in the spec. Bytes holds the
+// IEEE 754 single precision bit pattern, kept as-is so that NaN payloads survive a
+// round trip.
 type CONSTANT_Float_info struct {
 	Tag   ConstantTag
-	Value float32
+	Bytes uint32
 }
 
 // CONSTANT_Long_info corresponds to eponymous struct in the spec.
@@ -121,6 +176,29 @@ type CONSTANT_InvokeDynamic_info struct {
 	NameAndTypeIndex         uint16
 }
 
+// CONSTANT_Dynamic_info corresponds to eponymous struct in the spec. It is a constant
+// computed by invoking a bootstrap method ("condy"); BootstrapMethodAttrIndex indexes
+// the class's BootstrapMethods attribute.
+type CONSTANT_Dynamic_info struct {
+	Tag                      ConstantTag
+	BootstrapMethodAttrIndex uint16
+	NameAndTypeIndex         uint16
+}
+
+// CONSTANT_Module_info corresponds to eponymous struct in the spec. It only appears in
+// the constant pool of a module-info classfile.
+type CONSTANT_Module_info struct {
+	Tag       ConstantTag
+	NameIndex uint16
+}
+
+// CONSTANT_Package_info corresponds to eponymous struct in the spec. It only appears in
+// the constant pool of a module-info classfile.
+type CONSTANT_Package_info struct {
+	Tag       ConstantTag
+	NameIndex uint16
+}
+
 func (i *CONSTANT_Class_info) StringTag() string {
 	return "CONSTANT_Class"
 }
@@ -129,14 +207,14 @@ func (i *CONSTANT_Class_info) RawTag() ConstantTag {
 	return i.Tag
 }
 
+// Name gets the internal name of the class, or "" if name_index doesn't refer to a
+// CONSTANT_Utf8.
 func (i *CONSTANT_Class_info) Name(cp []CpEntry) string {
-	idx := i.NameIndex - 1
-	ent := cp[idx].(*CONSTANT_Utf8_info)
-	return string(ent.Bytes[:ent.Length])
+	return utf8Value(cp, i.NameIndex)
 }
 
 func (i *CONSTANT_Class_info) Repr(cp []CpEntry) string {
-	return i.Name(cp)
+	return utf8Repr(cp, i.NameIndex)
 }
 
 func (i *CONSTANT_Fieldref_info) StringTag() string {
@@ -147,8 +225,26 @@ func (i *CONSTANT_Fieldref_info) RawTag() ConstantTag {
 	return i.Tag
 }
 
-func (i *CONSTANT_Fieldref_info) Repr(ignored []CpEntry) string {
-	return ""
+// ClassName gets the internal name of the class declaring the field.
+func (i *CONSTANT_Fieldref_info) ClassName(cp []CpEntry) string {
+	name, _ := lookupClassName(cp, i.ClassIndex)
+	return name
+}
+
+// Name gets the name of the field.
+func (i *CONSTANT_Fieldref_info) Name(cp []CpEntry) string {
+	return lookupNameAndType(cp, i.NameAndTypeIndex).Name(cp)
+}
+
+// Descriptor gets the field descriptor of the field.
+func (i *CONSTANT_Fieldref_info) Descriptor(cp []CpEntry) string {
+	return lookupNameAndType(cp, i.NameAndTypeIndex).Descriptor(cp)
+}
+
+// Repr renders the reference as owner.name:descriptor, e.g.
+// "java/lang/System.out:Ljava/io/PrintStream;".
+func (i *CONSTANT_Fieldref_info) Repr(cp []CpEntry) string {
+	return memberRefRepr(cp, i.ClassIndex, i.NameAndTypeIndex)
 }
 
 func (i *CONSTANT_Methodref_info) StringTag() string {
@@ -159,9 +255,26 @@ func (i *CONSTANT_Methodref_info) RawTag() ConstantTag {
 	return i.Tag
 }
 
-func (i *CONSTANT_Methodref_info) Repr(ignored []CpEntry) string {
-	// Fill me in
-	return ""
+// ClassName gets the internal name of the class declaring the method.
+func (i *CONSTANT_Methodref_info) ClassName(cp []CpEntry) string {
+	name, _ := lookupClassName(cp, i.ClassIndex)
+	return name
+}
+
+// Name gets the name of the method.
+func (i *CONSTANT_Methodref_info) Name(cp []CpEntry) string {
+	return lookupNameAndType(cp, i.NameAndTypeIndex).Name(cp)
+}
+
+// Descriptor gets the method descriptor of the method.
+func (i *CONSTANT_Methodref_info) Descriptor(cp []CpEntry) string {
+	return lookupNameAndType(cp, i.NameAndTypeIndex).Descriptor(cp)
+}
+
+// Repr renders the reference as owner.name:descriptor, e.g.
+// "java/io/PrintStream.println:(Ljava/lang/String;)V".
+func (i *CONSTANT_Methodref_info) Repr(cp []CpEntry) string {
+	return memberRefRepr(cp, i.ClassIndex, i.NameAndTypeIndex)
 }
 
 func (i *CONSTANT_InterfaceMethodref_info) StringTag() string {
@@ -172,9 +285,26 @@ func (i *CONSTANT_InterfaceMethodref_info) RawTag() ConstantTag {
 	return i.Tag
 }
 
-func (i *CONSTANT_InterfaceMethodref_info) Repr(ignored []CpEntry) string {
-	// Fill me in
-	return ""
+// ClassName gets the internal name of the class declaring the interface method.
+func (i *CONSTANT_InterfaceMethodref_info) ClassName(cp []CpEntry) string {
+	name, _ := lookupClassName(cp, i.ClassIndex)
+	return name
+}
+
+// Name gets the name of the interface method.
+func (i *CONSTANT_InterfaceMethodref_info) Name(cp []CpEntry) string {
+	return lookupNameAndType(cp, i.NameAndTypeIndex).Name(cp)
+}
+
+// Descriptor gets the method descriptor of the interface method.
+func (i *CONSTANT_InterfaceMethodref_info) Descriptor(cp []CpEntry) string {
+	return lookupNameAndType(cp, i.NameAndTypeIndex).Descriptor(cp)
+}
+
+// Repr renders the reference as owner.name:descriptor, e.g.
+// "java/util/List.size:()I".
+func (i *CONSTANT_InterfaceMethodref_info) Repr(cp []CpEntry) string {
+	return memberRefRepr(cp, i.ClassIndex, i.NameAndTypeIndex)
 }
 
 func (i *CONSTANT_String_info) StringTag() string {
@@ -186,9 +316,11 @@ func (i *CONSTANT_String_info) RawTag() ConstantTag {
 }
 
 func (i *CONSTANT_String_info) Repr(cp []CpEntry) string {
-	idx := i.StringIndex - 1
-	ent := cp[idx].(*CONSTANT_Utf8_info)
-	return quoted(ent.Bytes, ent.Length)
+	utf8, ok := lookupUtf8(cp, i.StringIndex)
+	if !ok {
+		return fmt.Sprintf("#%v", i.StringIndex)
+	}
+	return strconv.Quote(utf8.Value())
 }
 
 func (i *CONSTANT_Integer_info) StringTag() string {
@@ -199,9 +331,13 @@ func (i *CONSTANT_Integer_info) RawTag() ConstantTag {
 	return i.Tag
 }
 
+// Value gets the signed value of the constant.
+func (i *CONSTANT_Integer_info) Value() int32 {
+	return int32(i.Bytes)
+}
+
 func (i *CONSTANT_Integer_info) Repr(ignored []CpEntry) string {
-	// Fill me in
-	return fmt.Sprintf("%v", i.Value)
+	return strconv.FormatInt(int64(i.Value()), 10)
 }
 
 func (i *CONSTANT_Float_info) StringTag() string {
@@ -212,15 +348,22 @@ func (i *CONSTANT_Float_info) RawTag() ConstantTag {
 	return i.Tag
 }
 
+// Value gets the value of the constant. Per JVMS 4.4.4, 0x7f800000 and 0xff800000 are
+// positive and negative infinity, and every other pattern with an all-ones exponent is
+// NaN.
+func (i *CONSTANT_Float_info) Value() float32 {
+	return math.Float32frombits(i.Bytes)
+}
+
+// Repr renders the value the way Java's Float.toString does, e.g. "1.0E10", "NaN" or
+// "-Infinity".
 func (i *CONSTANT_Float_info) Repr(ignored []CpEntry) string {
-	// Fill me in
-	return fmt.Sprintf("%v", i.Value)
+	return javaFloatString(float64(i.Value()), 32)
 }
 
-func (li *CONSTANT_Long_info) Value() uint64 {
-	res := uint64(li.HighBytes) << 32
-	res &= uint64(li.LowBytes)
-	return res
+// Value gets the signed value of the constant.
+func (li *CONSTANT_Long_info) Value() int64 {
+	return int64(uint64(li.HighBytes)<<32 | uint64(li.LowBytes))
 }
 
 func (i *CONSTANT_Long_info) StringTag() string {
@@ -232,14 +375,13 @@ func (i *CONSTANT_Long_info) RawTag() ConstantTag {
 }
 
 func (i *CONSTANT_Long_info) Repr(ignored []CpEntry) string {
-	// Fill me in
-	return fmt.Sprintf("%v", i.Value())
+	return strconv.FormatInt(i.Value(), 10)
 }
 
+// Value gets the value of the constant. As with floats, JVMS 4.4.5 gives infinities
+// and NaNs their usual IEEE 754 bit patterns.
 func (i *CONSTANT_Double_info) Value() float64 {
-	binary := uint64(i.HighBytes)
-	binary &= uint64(i.LowBytes)
-	return math.Float64frombits(binary)
+	return math.Float64frombits(uint64(i.HighBytes)<<32 | uint64(i.LowBytes))
 }
 
 func (i *CONSTANT_Double_info) StringTag() string {
@@ -250,17 +392,28 @@ func (i *CONSTANT_Double_info) RawTag() ConstantTag {
 	return i.Tag
 }
 
+// Repr renders the value the way Java's Double.toString does.
 func (i *CONSTANT_Double_info) Repr(ignored []CpEntry) string {
-	return fmt.Sprintf("%v", i.Value())
+	return javaFloatString(i.Value(), 64)
 }
 
 func (i *CONSTANT_NameAndType_info) StringTag() string {
 	return "CONSTANT_NameAndType"
 }
 
-func (i *CONSTANT_NameAndType_info) Repr(ignored []CpEntry) string {
-	// Fill me in
-	return ""
+// Name gets the name of the field or method.
+func (i *CONSTANT_NameAndType_info) Name(cp []CpEntry) string {
+	return utf8Value(cp, i.NameIndex)
+}
+
+// Descriptor gets the field or method descriptor.
+func (i *CONSTANT_NameAndType_info) Descriptor(cp []CpEntry) string {
+	return utf8Value(cp, i.DescriptorIndex)
+}
+
+// Repr renders the entry as name:descriptor, e.g. "main:([Ljava/lang/String;)V".
+func (i *CONSTANT_NameAndType_info) Repr(cp []CpEntry) string {
+	return utf8Repr(cp, i.NameIndex) + ":" + utf8Repr(cp, i.DescriptorIndex)
 }
 
 func (i *CONSTANT_NameAndType_info) RawTag() ConstantTag {
@@ -275,12 +428,25 @@ func (i *CONSTANT_Utf8_info) RawTag() ConstantTag {
 	return i.Tag
 }
 
+// Value decodes the modified UTF-8 contents of the constant. Entries read by
+// ReadClassFile are known to be valid; for others, invalid bytes are passed through
+// as-is.
 func (i *CONSTANT_Utf8_info) Value() string {
-	return string(i.Bytes[:i.Length])
+	s, err := DecodeModifiedUTF8(i.Bytes[:i.Length])
+	if err != nil {
+		return string(i.Bytes[:i.Length])
+	}
+	return s
 }
 
 func (i *CONSTANT_Utf8_info) Repr(ignored []CpEntry) string {
-	return quoted(i.Bytes, i.Length)
+	return strconv.Quote(i.Value())
+}
+
+// NewUtf8 creates a CONSTANT_Utf8 entry holding s, encoded in modified UTF-8.
+func NewUtf8(s string) *CONSTANT_Utf8_info {
+	b := EncodeModifiedUTF8(s)
+	return &CONSTANT_Utf8_info{Tag: CONSTANT_Utf8, Length: uint16(len(b)), Bytes: b}
 }
 
 func (i *CONSTANT_MethodHandle_info) StringTag() string {
@@ -291,11 +457,47 @@ func (i *CONSTANT_MethodHandle_info) RawTag() ConstantTag {
 	return i.Tag
 }
 
-func (i *CONSTANT_MethodHandle_info) Repr(ignored []CpEntry) string {
-	// Fill me in
+// Reference gets the field or method reference the handle refers to, and whether
+// reference_index refers to one.
+func (i *CONSTANT_MethodHandle_info) Reference(cp []CpEntry) (MemberRef, bool) {
+	ref, ok := LookupEntry(cp, i.ReferenceIndex).(MemberRef)
+	return ref, ok
+}
+
+// ClassName gets the internal name of the class declaring the referenced member.
+func (i *CONSTANT_MethodHandle_info) ClassName(cp []CpEntry) string {
+	if ref, ok := i.Reference(cp); ok {
+		return ref.ClassName(cp)
+	}
+	return ""
+}
+
+// Name gets the name of the referenced member.
+func (i *CONSTANT_MethodHandle_info) Name(cp []CpEntry) string {
+	if ref, ok := i.Reference(cp); ok {
+		return ref.Name(cp)
+	}
 	return ""
 }
 
+// Descriptor gets the descriptor of the referenced member.
+func (i *CONSTANT_MethodHandle_info) Descriptor(cp []CpEntry) string {
+	if ref, ok := i.Reference(cp); ok {
+		return ref.Descriptor(cp)
+	}
+	return ""
+}
+
+// Repr renders the handle as its kind followed by the referenced member, e.g.
+// "REF_invokeStatic Foo.bar:()V".
+func (i *CONSTANT_MethodHandle_info) Repr(cp []CpEntry) string {
+	ref, ok := i.Reference(cp)
+	if !ok {
+		return ReferenceKindName(i.ReferenceKind) + fmt.Sprintf(" #%v", i.ReferenceIndex)
+	}
+	return ReferenceKindName(i.ReferenceKind) + " " + ref.Repr(cp)
+}
+
 func (i *CONSTANT_MethodType_info) StringTag() string {
 	return "CONSTANT_MethodType"
 }
@@ -304,9 +506,13 @@ func (i *CONSTANT_MethodType_info) RawTag() ConstantTag {
 	return i.Tag
 }
 
-func (i *CONSTANT_MethodType_info) Repr(ignored []CpEntry) string {
-	// Fill me in
-	return ""
+// Descriptor gets the method descriptor.
+func (i *CONSTANT_MethodType_info) Descriptor(cp []CpEntry) string {
+	return utf8Value(cp, i.DescriptorIndex)
+}
+
+func (i *CONSTANT_MethodType_info) Repr(cp []CpEntry) string {
+	return utf8Repr(cp, i.DescriptorIndex)
 }
 
 func (i *CONSTANT_InvokeDynamic_info) StringTag() string {
@@ -317,11 +523,210 @@ func (i *CONSTANT_InvokeDynamic_info) RawTag() ConstantTag {
 	return i.Tag
 }
 
-func (i *CONSTANT_InvokeDynamic_info) Repr(ignored []CpEntry) string {
-	// Fill me in
-	return ""
+// Name gets the name of the call site's method.
+func (i *CONSTANT_InvokeDynamic_info) Name(cp []CpEntry) string {
+	return lookupNameAndType(cp, i.NameAndTypeIndex).Name(cp)
+}
+
+// Descriptor gets the method descriptor of the call site.
+func (i *CONSTANT_InvokeDynamic_info) Descriptor(cp []CpEntry) string {
+	return lookupNameAndType(cp, i.NameAndTypeIndex).Descriptor(cp)
+}
+
+// Repr renders the call site as #bootstrap:name:descriptor, e.g.
+// "#0:apply:()Ljava/util/function/Function;".
+func (i *CONSTANT_InvokeDynamic_info) Repr(cp []CpEntry) string {
+	return fmt.Sprintf("#%v:%v", i.BootstrapMethodAttrIndex, nameAndTypeRepr(cp, i.NameAndTypeIndex))
+}
+
+func (i *CONSTANT_Dynamic_info) StringTag() string {
+	return "CONSTANT_Dynamic"
+}
+
+func (i *CONSTANT_Dynamic_info) RawTag() ConstantTag {
+	return i.Tag
+}
+
+// Name gets the name of the dynamic constant.
+func (i *CONSTANT_Dynamic_info) Name(cp []CpEntry) string {
+	return lookupNameAndType(cp, i.NameAndTypeIndex).Name(cp)
+}
+
+// Descriptor gets the field descriptor of the dynamic constant's type.
+func (i *CONSTANT_Dynamic_info) Descriptor(cp []CpEntry) string {
+	return lookupNameAndType(cp, i.NameAndTypeIndex).Descriptor(cp)
+}
+
+func (i *CONSTANT_Dynamic_info) Repr(cp []CpEntry) string {
+	return fmt.Sprintf("#%v:%v", i.BootstrapMethodAttrIndex, nameAndTypeRepr(cp, i.NameAndTypeIndex))
+}
+
+func (i *CONSTANT_Module_info) StringTag() string {
+	return "CONSTANT_Module"
+}
+
+func (i *CONSTANT_Module_info) RawTag() ConstantTag {
+	return i.Tag
+}
+
+// Name gets the name of the module, e.g. "java.base".
+func (i *CONSTANT_Module_info) Name(cp []CpEntry) string {
+	return utf8Value(cp, i.NameIndex)
+}
+
+func (i *CONSTANT_Module_info) Repr(cp []CpEntry) string {
+	return i.Name(cp)
+}
+
+func (i *CONSTANT_Package_info) StringTag() string {
+	return "CONSTANT_Package"
+}
+
+func (i *CONSTANT_Package_info) RawTag() ConstantTag {
+	return i.Tag
+}
+
+// Name gets the name of the package in internal form, e.g. "java/lang".
+func (i *CONSTANT_Package_info) Name(cp []CpEntry) string {
+	return utf8Value(cp, i.NameIndex)
+}
+
+func (i *CONSTANT_Package_info) Repr(cp []CpEntry) string {
+	return i.Name(cp)
 }
 
-func quoted(original []byte, size uint16) string {
-	return strconv.Quote(string(original[:size]))
+// MinVersion returns the earliest classfile major version in which the tag may appear
+// in the constant pool, or 0 if the tag is unknown.
+func (tag ConstantTag) MinVersion() uint16 {
+	switch tag {
+	case CONSTANT_Utf8, CONSTANT_Integer, CONSTANT_Float, CONSTANT_Long, CONSTANT_Double,
+		CONSTANT_Class, CONSTANT_String, CONSTANT_Fieldref, CONSTANT_Methodref,
+		CONSTANT_InterfaceMethodref, CONSTANT_NameAndType:
+		return 45
+	case CONSTANT_MethodHandle, CONSTANT_MethodType, CONSTANT_InvokeDynamic:
+		return 51
+	case CONSTANT_Module, CONSTANT_Package:
+		return 53
+	case CONSTANT_Dynamic:
+		return 55
+	default:
+		return 0
+	}
+}
+
+// CheckConstantTag reports whether an entry with the given tag may appear in the
+// constant pool of a classfile with the given major version and access flags.
+// CONSTANT_Module and CONSTANT_Package are only permitted in module descriptors.
+func CheckConstantTag(tag ConstantTag, majorVersion uint16, flags Access) error {
+	min := tag.MinVersion()
+	if min == 0 {
+		return fmt.Errorf("unknown tag %v", tag)
+	}
+	if majorVersion < min {
+		return fmt.Errorf("tag %v requires classfile version %v, found %v", tag, min, majorVersion)
+	}
+	if (tag == CONSTANT_Module || tag == CONSTANT_Package) && flags&AccModule == 0 {
+		return fmt.Errorf("tag %v is only permitted in a module descriptor", tag)
+	}
+	return nil
+}
+
+// memberRefRepr renders a field or method reference as owner.name:descriptor, with
+// #index standing in for the class or NameAndType if an index doesn't refer to one.
+func memberRefRepr(cp []CpEntry, classIndex, natIndex uint16) string {
+	owner, ok := lookupClassName(cp, classIndex)
+	if !ok {
+		owner = fmt.Sprintf("#%v", classIndex)
+	}
+	return owner + "." + nameAndTypeRepr(cp, natIndex)
+}
+
+// lookupNameAndType gets the CONSTANT_NameAndType at index. If there isn't one, it gets
+// an empty entry, whose name and descriptor are "".
+func lookupNameAndType(cp []CpEntry, index uint16) *CONSTANT_NameAndType_info {
+	if nat, ok := LookupEntry(cp, index).(*CONSTANT_NameAndType_info); ok {
+		return nat
+	}
+	return &CONSTANT_NameAndType_info{}
+}
+
+// nameAndTypeRepr renders the CONSTANT_NameAndType at index, or #index if there isn't
+// one.
+func nameAndTypeRepr(cp []CpEntry, index uint16) string {
+	if nat, ok := LookupEntry(cp, index).(*CONSTANT_NameAndType_info); ok {
+		return nat.Repr(cp)
+	}
+	return fmt.Sprintf("#%v", index)
+}
+
+// utf8Repr gets the string held by the CONSTANT_Utf8 at index, or #index if there
+// isn't one.
+func utf8Repr(cp []CpEntry, index uint16) string {
+	if utf8, ok := lookupUtf8(cp, index); ok {
+		return utf8.Value()
+	}
+	return fmt.Sprintf("#%v", index)
+}
+
+// javaFloatString formats a float or double the way Java's Float.toString and
+// Double.toString do: plain decimal with at least one fractional digit for magnitudes
+// in [1e-3, 1e7), scientific notation like "1.0E-5" otherwise, and the names NaN,
+// Infinity and -Infinity for the special values.
+func javaFloatString(f float64, bitSize int) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0 && math.Signbit(f):
+		return "-0.0"
+	case f == 0:
+		return "0.0"
+	}
+
+	sign := ""
+	if f < 0 {
+		sign, f = "-", -f
+	}
+
+	// Java picks the shortest decimal that rounds to f, but never settles for a single
+	// digit when a two digit decimal is closer, so Double.MIN_VALUE is 4.9E-324 and not
+	// 5.0E-324.
+	digits, exp := decimalDigits(strconv.FormatFloat(f, 'e', -1, bitSize))
+	if len(digits) == 1 {
+		s := strconv.FormatFloat(f, 'e', 1, bitSize)
+		if g, err := strconv.ParseFloat(s, bitSize); err == nil && g == f {
+			digits, exp = decimalDigits(s)
+			digits = strings.TrimRight(digits, "0")
+		}
+	}
+
+	if f < 1e-3 || f >= 1e7 {
+		frac := digits[1:]
+		if frac == "" {
+			frac = "0"
+		}
+		return sign + digits[:1] + "." + frac + "E" + strconv.Itoa(exp)
+	}
+	if exp < 0 {
+		return sign + "0." + strings.Repeat("0", -exp-1) + digits
+	}
+	for len(digits) <= exp {
+		digits += "0"
+	}
+	frac := digits[exp+1:]
+	if frac == "" {
+		frac = "0"
+	}
+	return sign + digits[:exp+1] + "." + frac
+}
+
+// decimalDigits splits a number formatted by strconv.FormatFloat with the 'e' verb into
+// its significant digits and decimal exponent.
+func decimalDigits(s string) (string, int) {
+	pos := strings.IndexByte(s, 'e')
+	exp, _ := strconv.Atoi(s[pos+1:])
+	return strings.Replace(s[:pos], ".", "", 1), exp
 }
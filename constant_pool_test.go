@@ -0,0 +1,74 @@
+package classy
+
+import (
+	"math"
+	"testing"
+)
+
+func TestJavaFloatString(t *testing.T) {
+	tests := []struct {
+		f       float64
+		bitSize int
+		want    string
+	}{
+		{float64(math.SmallestNonzeroFloat32), 32, "1.4E-45"},
+		{float64(math.MaxFloat32), 32, "3.4028235E38"},
+		{float64(float32(0.1)), 32, "0.1"},
+		{math.SmallestNonzeroFloat64, 64, "4.9E-324"},
+		{math.MaxFloat64, 64, "1.7976931348623157E308"},
+		{0.1, 64, "0.1"},
+		{math.Copysign(0, -1), 64, "-0.0"},
+		{0, 64, "0.0"},
+		{math.NaN(), 64, "NaN"},
+		{math.Inf(1), 64, "Infinity"},
+		{math.Inf(-1), 32, "-Infinity"},
+		{1e7, 64, "1.0E7"},
+		{9999999, 64, "9999999.0"},
+		{1e-3, 64, "0.001"},
+		{1e-4, 64, "1.0E-4"},
+		{-1.5, 64, "-1.5"},
+	}
+	for _, test := range tests {
+		if got := javaFloatString(test.f, test.bitSize); got != test.want {
+			t.Errorf("javaFloatString(%v, %v) = %q, want %q", test.f, test.bitSize, got, test.want)
+		}
+	}
+}
+
+func TestIntegerValue(t *testing.T) {
+	tests := []struct {
+		bytes uint32
+		want  int32
+	}{
+		{0, 0},
+		{0x7fffffff, math.MaxInt32},
+		{0x80000000, math.MinInt32},
+		{0xffffffff, -1},
+	}
+	for _, test := range tests {
+		i := &CONSTANT_Integer_info{Tag: CONSTANT_Integer, Bytes: test.bytes}
+		if got := i.Value(); got != test.want {
+			t.Errorf("Integer %#x: Value() = %v, want %v", test.bytes, got, test.want)
+		}
+	}
+}
+
+func TestLongValue(t *testing.T) {
+	tests := []struct {
+		high, low uint32
+		want      int64
+	}{
+		{0, 0, 0},
+		{0x7fffffff, 0xffffffff, math.MaxInt64},
+		{0x80000000, 0, math.MinInt64},
+		{0xffffffff, 0xffffffff, -1},
+		{1, 0, 0x100000000},
+		{0, 0xffffffff, 0xffffffff},
+	}
+	for _, test := range tests {
+		l := &CONSTANT_Long_info{Tag: CONSTANT_Long, HighBytes: test.high, LowBytes: test.low}
+		if got := l.Value(); got != test.want {
+			t.Errorf("Long %#x %#x: Value() = %v, want %v", test.high, test.low, got, test.want)
+		}
+	}
+}
@@ -5,77 +5,253 @@ import (
 	"strings"
 )
 
-// ParseFieldDescriptor returns the type for a field parsed from its UTF-8 text
-// descriptor.
-func ParseFieldDescriptor(descriptor string) string {
-	name, _ := parseFieldDescriptor(descriptor)
-	return name
-}
-
-// ParseMethodDescriptor parses the descriptor string for a method to return the
-// fully qualified parameter and return types.
-func ParseMethodDescriptor(descriptor string) ([]string, string) {
-	var paramTypes []string
-	if descriptor[0] != '(' {
-		panic(fmt.Errorf("Invalid method descriptor '%v'", descriptor))
+// Limits imposed by the JVM on descriptors.
+const (
+	// MaxArrayDimensions is the greatest number of dimensions an array type may have.
+	MaxArrayDimensions = 255
+	// MaxParamSlots is the greatest number of local variable slots a method's
+	// parameters may occupy. For instance methods the JVM counts the slot of this as
+	// well, which only callers that know the access flags can check.
+	MaxParamSlots = 255
+)
+
+// FieldType is the type of a field, parameter or local variable as encoded in a
+// descriptor: a BaseType, an ObjectType or an ArrayType.
+type FieldType interface {
+	// String renders the type as it would be written in Java source.
+	String() string
+	// Descriptor encodes the type back into descriptor form.
+	Descriptor() string
+	// Slots is the number of local variable or operand stack slots a value of the
+	// type occupies: 2 for long and double, 0 for void and 1 for everything else.
+	Slots() int
+}
+
+// BaseType is one of the primitive types, identified by its descriptor character, or
+// 'V' for void when used as a method's return type.
+type BaseType byte
+
+// The primitive types and void.
+const (
+	Byte    BaseType = 'B'
+	Char    BaseType = 'C'
+	Double  BaseType = 'D'
+	Float   BaseType = 'F'
+	Int     BaseType = 'I'
+	Long    BaseType = 'J'
+	Short   BaseType = 'S'
+	Boolean BaseType = 'Z'
+	Void    BaseType = 'V'
+)
+
+var baseTypeNames = map[BaseType]string{
+	Byte:    "byte",
+	Char:    "char",
+	Double:  "double",
+	Float:   "float",
+	Int:     "int",
+	Long:    "long",
+	Short:   "short",
+	Boolean: "boolean",
+	Void:    "void",
+}
+
+// ObjectType is a class or interface type. ClassName is in internal form, e.g.
+// "java/lang/String".
+type ObjectType struct {
+	ClassName string
+}
+
+// ArrayType is an array type with the given number of dimensions. Elem is the
+// innermost element type, which is never itself an ArrayType.
+type ArrayType struct {
+	Dimensions int
+	Elem       FieldType
+}
+
+// MethodDescriptor is the parsed descriptor of a method. Return is Void for methods
+// that return nothing.
+type MethodDescriptor struct {
+	Params []FieldType
+	Return FieldType
+}
+
+func (t BaseType) String() string {
+	return baseTypeNames[t]
+}
+
+func (t BaseType) Descriptor() string {
+	return string(t)
+}
+
+func (t BaseType) Slots() int {
+	switch t {
+	case Long, Double:
+		return 2
+	case Void:
+		return 0
+	default:
+		return 1
+	}
+}
+
+func (t ObjectType) String() string {
+	return strings.Replace(t.ClassName, "/", ".", -1)
+}
+
+func (t ObjectType) Descriptor() string {
+	return "L" + t.ClassName + ";"
+}
+
+func (t ObjectType) Slots() int {
+	return 1
+}
+
+func (t ArrayType) String() string {
+	return t.Elem.String() + strings.Repeat("[]", t.Dimensions)
+}
+
+func (t ArrayType) Descriptor() string {
+	return strings.Repeat("[", t.Dimensions) + t.Elem.Descriptor()
+}
+
+func (t ArrayType) Slots() int {
+	return 1
+}
+
+// String renders the descriptor in the form of a method declaration without its name,
+// e.g. "void (int, java.lang.String[])".
+func (m *MethodDescriptor) String() string {
+	return m.Return.String() + " (" + m.ParamsString() + ")"
+}
+
+// ParamsString renders the parameter types separated by commas.
+func (m *MethodDescriptor) ParamsString() string {
+	var names []string
+	for _, param := range m.Params {
+		names = append(names, param.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// Descriptor encodes the method descriptor back into its string form.
+func (m *MethodDescriptor) Descriptor() string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, param := range m.Params {
+		b.WriteString(param.Descriptor())
+	}
+	b.WriteByte(')')
+	b.WriteString(m.Return.Descriptor())
+	return b.String()
+}
+
+// ParamSlots is the number of local variable slots taken by the parameters, not
+// counting the slot for this in instance methods.
+func (m *MethodDescriptor) ParamSlots() int {
+	slots := 0
+	for _, param := range m.Params {
+		slots += param.Slots()
+	}
+	return slots
+}
+
+// ParseFieldDescriptor parses the descriptor of a field, such as "[Ljava/lang/String;".
+func ParseFieldDescriptor(descriptor string) (FieldType, error) {
+	t, rest, err := parseFieldType(descriptor, descriptor)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("invalid descriptor %q: unexpected trailing %q", descriptor, rest)
+	}
+	return t, nil
+}
+
+// ParseMethodDescriptor parses the descriptor of a method, such as
+// "(I[Ljava/lang/String;)V". The parameters may occupy at most MaxParamSlots slots,
+// counting only those of the descriptor.
+func ParseMethodDescriptor(descriptor string) (*MethodDescriptor, error) {
+	if !strings.HasPrefix(descriptor, "(") {
+		return nil, fmt.Errorf("invalid method descriptor %q: missing '('", descriptor)
 	}
 
-	descriptor = descriptor[1:]
-	finished := false
-	for !finished {
-		if len(descriptor) == 0 {
-			panic(fmt.Errorf("this shouldn't happen!!!"))
+	m := new(MethodDescriptor)
+	rest := descriptor[1:]
+	for !strings.HasPrefix(rest, ")") {
+		if rest == "" {
+			return nil, fmt.Errorf("invalid method descriptor %q: missing ')'", descriptor)
 		}
+		param, next, err := parseFieldType(rest, descriptor)
+		if err != nil {
+			return nil, err
+		}
+		m.Params = append(m.Params, param)
+		rest = next
+	}
+	rest = rest[1:]
 
-		switch descriptor[0] {
-		case ')':
-			descriptor = descriptor[1:]
-			finished = true
-			break
-		default:
-			name, desc := parseFieldDescriptor(descriptor)
-			paramTypes = append(paramTypes, name)
-			descriptor = desc
+	if rest == "V" {
+		m.Return = Void
+	} else {
+		ret, next, err := parseFieldType(rest, descriptor)
+		if err != nil {
+			return nil, err
 		}
+		if next != "" {
+			return nil, fmt.Errorf("invalid method descriptor %q: unexpected trailing %q", descriptor, next)
+		}
+		m.Return = ret
+	}
+
+	if slots := m.ParamSlots(); slots > MaxParamSlots {
+		return nil, fmt.Errorf("invalid method descriptor %q: parameters take %v slots, the limit is %v", descriptor, slots, MaxParamSlots)
+	}
+	return m, nil
+}
+
+// parseFieldType parses the field type at the start of s, returning it along with the
+// unparsed remainder. descriptor is the whole descriptor, for error messages.
+func parseFieldType(s, descriptor string) (FieldType, string, error) {
+	dims := 0
+	for dims < len(s) && s[dims] == '[' {
+		dims++
+	}
+	if dims > MaxArrayDimensions {
+		return nil, "", fmt.Errorf("invalid descriptor %q: array has %v dimensions, the limit is %v", descriptor, dims, MaxArrayDimensions)
+	}
+	s = s[dims:]
+	if s == "" {
+		return nil, "", fmt.Errorf("invalid descriptor %q: missing type", descriptor)
 	}
-	retType, _ := parseFieldDescriptor(descriptor)
-	return paramTypes, retType
-}
-
-func parseFieldDescriptor(descriptor string) (string, string) {
-	// Read the field type
-	switch descriptor[0] {
-	case 'B':
-		return "byte", descriptor[1:]
-	case 'C':
-		return "char", descriptor[1:]
-	case 'D':
-		return "double", descriptor[1:]
-	case 'F':
-		return "float", descriptor[1:]
-	case 'I':
-		return "int", descriptor[1:]
-	case 'J':
-		return "long", descriptor[1:]
-	case 'S':
-		return "short", descriptor[1:]
-	case 'Z':
-		return "boolean", descriptor[1:]
+
+	var elem FieldType
+	switch c := BaseType(s[0]); c {
+	case Byte, Char, Double, Float, Int, Long, Short, Boolean:
+		elem = c
+		s = s[1:]
 	case 'L':
 		// Continue reading until we encounter a ';'
-		pos := strings.IndexRune(descriptor, ';')
+		pos := strings.IndexByte(s, ';')
 		if pos < 0 {
-			panic(fmt.Errorf("Cannot find termination to descriptor"))
+			return nil, "", fmt.Errorf("invalid descriptor %q: unterminated class name", descriptor)
+		}
+		// Each part of the name between slashes must be a non-empty unqualified name
+		// (JVMS 4.2.1)
+		name := s[1:pos]
+		for _, part := range strings.Split(name, "/") {
+			if !validUnqualifiedName(part, false) {
+				return nil, "", fmt.Errorf("invalid descriptor %q: bad class name %q", descriptor, name)
+			}
 		}
-		// Replace '/' with '.' for qualified class names
-		clsName := strings.Replace(descriptor[1:pos], "/", ".", -1)
-		return clsName, descriptor[pos+1:]
-	case '[':
-		name, rest := parseFieldDescriptor(descriptor[1:])
-		return name + "[]", rest
-	case 'V':
-		return "void", descriptor[1:]
+		elem = ObjectType{ClassName: name}
+		s = s[pos+1:]
 	default:
-		panic(fmt.Errorf("Invalid basetype '%v' for descriptor '%v'", descriptor[0], descriptor))
+		return nil, "", fmt.Errorf("invalid descriptor %q: unexpected '%c'", descriptor, s[0])
+	}
+
+	if dims > 0 {
+		return ArrayType{Dimensions: dims, Elem: elem}, s, nil
 	}
+	return elem, s, nil
 }
@@ -0,0 +1,104 @@
+package classy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFieldDescriptor(t *testing.T) {
+	tests := []struct {
+		descriptor string
+		want       string
+	}{
+		{"I", "int"},
+		{"J", "long"},
+		{"Ljava/lang/String;", "java.lang.String"},
+		{"LFoo;", "Foo"},
+		{"[[D", "double[][]"},
+		{"[Ljava/util/Map$Entry;", "java.util.Map$Entry[]"},
+	}
+	for _, test := range tests {
+		got, err := ParseFieldDescriptor(test.descriptor)
+		if err != nil {
+			t.Errorf("ParseFieldDescriptor(%q): %v", test.descriptor, err)
+			continue
+		}
+		if got.String() != test.want || got.Descriptor() != test.descriptor {
+			t.Errorf("ParseFieldDescriptor(%q) = %v, %v; want %v", test.descriptor, got, got.Descriptor(), test.want)
+		}
+	}
+}
+
+func TestParseFieldDescriptorErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"V",
+		"Q",
+		"II",
+		"[",
+		"L;",
+		"Ljava/lang/String",
+		"Ljava.lang.String;",
+		"La[b;",
+		"L/;",
+		"La//b;",
+		"L/a;",
+		"La/;",
+		"La;b;",
+		strings.Repeat("[", MaxArrayDimensions+1) + "I",
+	}
+	for _, descriptor := range tests {
+		if got, err := ParseFieldDescriptor(descriptor); err == nil {
+			t.Errorf("ParseFieldDescriptor(%q) = %v, want an error", descriptor, got)
+		}
+	}
+}
+
+func TestParseMethodDescriptor(t *testing.T) {
+	tests := []struct {
+		descriptor string
+		want       string
+		slots      int
+	}{
+		{"()V", "void ()", 0},
+		{"(I[Ljava/lang/String;)V", "void (int, java.lang.String[])", 2},
+		{"(JD)Ljava/lang/Object;", "java.lang.Object (long, double)", 4},
+		{"(" + strings.Repeat("I", MaxParamSlots) + ")V", "", MaxParamSlots},
+	}
+	for _, test := range tests {
+		got, err := ParseMethodDescriptor(test.descriptor)
+		if err != nil {
+			t.Errorf("ParseMethodDescriptor(%q): %v", test.descriptor, err)
+			continue
+		}
+		if test.want != "" && got.String() != test.want {
+			t.Errorf("ParseMethodDescriptor(%q) = %v, want %v", test.descriptor, got, test.want)
+		}
+		if got.ParamSlots() != test.slots {
+			t.Errorf("ParseMethodDescriptor(%q).ParamSlots() = %v, want %v", test.descriptor, got.ParamSlots(), test.slots)
+		}
+		if got.Descriptor() != test.descriptor {
+			t.Errorf("ParseMethodDescriptor(%q).Descriptor() = %v", test.descriptor, got.Descriptor())
+		}
+	}
+}
+
+func TestParseMethodDescriptorErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"V",
+		"()",
+		"(V)V",
+		"(I",
+		"()VV",
+		"(L/;)V",
+		"(La//b;)V",
+		"()La/;",
+		"(" + strings.Repeat("J", MaxParamSlots/2+1) + ")V",
+	}
+	for _, descriptor := range tests {
+		if got, err := ParseMethodDescriptor(descriptor); err == nil {
+			t.Errorf("ParseMethodDescriptor(%q) = %v, want an error", descriptor, got)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package classy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Categories of parse failure. Every ParseError carries one of these as its Kind, so
+// callers can test for them with errors.Is.
+var (
+	// ErrTruncated indicates the data ended before the structure being read did.
+	ErrTruncated = errors.New("truncated data")
+	// ErrBadMagic indicates the data does not begin with the 0xCAFEBABE magic number.
+	ErrBadMagic = errors.New("invalid magic")
+	// ErrBadTag indicates an unknown or disallowed constant pool tag.
+	ErrBadTag = errors.New("invalid constant pool tag")
+	// ErrBadIndex indicates a constant pool index that is out of range or refers to an
+	// entry of the wrong type.
+	ErrBadIndex = errors.New("invalid constant pool index")
+	// ErrMalformed indicates a structure whose contents are inconsistent, such as an
+	// attribute whose length disagrees with its contents or an unknown opcode.
+	ErrMalformed = errors.New("malformed structure")
+)
+
+// ParseError describes where and why decoding a classfile structure failed.
+type ParseError struct {
+	// Offset is the byte offset at which the problem was detected. For the classfile
+	// itself this is relative to the start of the file; for structures decoded from an
+	// attribute's data (such as ReadCodeAttribute) it is relative to the start of that
+	// data.
+	Offset int64
+	// Path names the structure being read, e.g. "method[3].attr[1]".
+	Path string
+	// Kind is one of the Err* sentinels above.
+	Kind error
+	// Detail is a human-readable description of the problem.
+	Detail string
+}
+
+func (e *ParseError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "classfile"
+	}
+	if e.Detail == "" {
+		return fmt.Sprintf("%v at offset %v: %v", path, e.Offset, e.Kind)
+	}
+	return fmt.Sprintf("%v at offset %v: %v: %v", path, e.Offset, e.Kind, e.Detail)
+}
+
+// Unwrap returns the error's Kind so errors.Is can match the sentinels.
+func (e *ParseError) Unwrap() error {
+	return e.Kind
+}
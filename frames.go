@@ -0,0 +1,1185 @@
+package classy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// VerificationType is a type of the JVM's verifier, as held by a local variable or
+// operand stack slot. Tag is one of the Item constants. Class is set for ItemObject, as
+// a class in internal form or an array descriptor, and Offset for ItemUninitialized,
+// giving the new instruction that created the object.
+type VerificationType struct {
+	Tag    byte
+	Class  string
+	Offset int
+}
+
+// itemReturnAddress tags the return addresses that jsr pushes, which only the
+// inference verifier deals in and stack map frames can't hold. Their Offset is the start
+// of the subroutine they return from.
+const itemReturnAddress byte = 9
+
+var (
+	topType               = VerificationType{Tag: ItemTop}
+	intType               = VerificationType{Tag: ItemInteger}
+	floatType             = VerificationType{Tag: ItemFloat}
+	longType              = VerificationType{Tag: ItemLong}
+	doubleType            = VerificationType{Tag: ItemDouble}
+	nullType              = VerificationType{Tag: ItemNull}
+	uninitializedThisType = VerificationType{Tag: ItemUninitializedThis}
+)
+
+func objectType(class string) VerificationType {
+	return VerificationType{Tag: ItemObject, Class: class}
+}
+
+// String renders the type as VerificationTypeInfo.Repr does.
+func (t VerificationType) String() string {
+	switch t.Tag {
+	case ItemObject:
+		return t.Class
+	case ItemUninitialized:
+		return fmt.Sprintf("uninitialized(%v)", t.Offset)
+	case itemReturnAddress:
+		return fmt.Sprintf("returnAddress(%v)", t.Offset)
+	}
+	return VerificationTypeInfo{Tag: t.Tag}.Repr(nil)
+}
+
+// slots is 2 for longs and doubles, and 1 for other types.
+func (t VerificationType) slots() int {
+	if t.Tag == ItemLong || t.Tag == ItemDouble {
+		return 2
+	}
+	return 1
+}
+
+func (t VerificationType) isReference() bool {
+	switch t.Tag {
+	case ItemNull, ItemObject, ItemUninitialized, ItemUninitializedThis:
+		return true
+	}
+	return false
+}
+
+// descriptorType gets the type a value of a field type has on the operand stack.
+func descriptorType(t FieldType) VerificationType {
+	switch t := t.(type) {
+	case BaseType:
+		switch t {
+		case Float:
+			return floatType
+		case Long:
+			return longType
+		case Double:
+			return doubleType
+		}
+		return intType
+	case ObjectType:
+		return objectType(t.ClassName)
+	}
+	return objectType(t.Descriptor())
+}
+
+// Frame holds the types of the local variables and operand stack before an
+// instruction. Longs and doubles take two slots, the second of which is Top.
+type Frame struct {
+	Locals []VerificationType
+	Stack  []VerificationType
+}
+
+func (f *Frame) clone() *Frame {
+	return &Frame{
+		Locals: append([]VerificationType(nil), f.Locals...),
+		Stack:  append([]VerificationType(nil), f.Stack...),
+	}
+}
+
+// compress lists types with one entry for each long and double, as stack map frames
+// do, dropping trailing Tops if trim is set.
+func compress(types []VerificationType, trim bool) []VerificationType {
+	var entries []VerificationType
+	for i := 0; i < len(types); i += types[i].slots() {
+		entries = append(entries, types[i])
+	}
+	for trim && len(entries) > 0 && entries[len(entries)-1] == topType {
+		entries = entries[:len(entries)-1]
+	}
+	return entries
+}
+
+// String lists the types of the locals and the operand stack with an entry for each
+// long and double, leaving out unused locals at the end.
+func (f *Frame) String() string {
+	locals := f.Locals
+	for len(locals) > 0 && locals[len(locals)-1] == topType && (len(locals) == 1 || locals[len(locals)-2].slots() == 1) {
+		locals = locals[:len(locals)-1]
+	}
+	return fmt.Sprintf("locals %v stack %v", typesString(locals), typesString(f.Stack))
+}
+
+func typesString(types []VerificationType) string {
+	var names []string
+	for _, t := range compress(types, false) {
+		names = append(names, t.String())
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+// VerifyError describes code whose types don't check out, at the instruction at Offset
+// in the method Method, which is given by name and descriptor. Frame holds the types
+// before the instruction, when they are known.
+type VerifyError struct {
+	Method string
+	Offset int
+	Detail string
+	Frame  *Frame
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%v at offset %v: %v", e.Method, e.Offset, e.Detail)
+}
+
+// analyzer infers the types of the local variables and operand stack at each
+// instruction of a method by following every path through its code.
+type analyzer struct {
+	cp        []CpEntry
+	hierarchy ClassHierarchy
+	thisClass string
+	name      string
+	method    string
+	access    Access
+	desc      *MethodDescriptor
+	code      *CodeAttribute
+	insns     []Instruction
+	at        map[int]int
+	// frames holds the frame before each instruction, or nil where none has been found
+	frames []*Frame
+	work   []int
+	// strict checks that references are assignable to the classes that instructions
+	// expect, as the verifier does, rather than only that they are references
+	strict bool
+	// subs is set for code whose subroutines are followed, which jsr and ret need
+	subs *subroutines
+}
+
+func newAnalyzer(cf *ClassFile, method *MethodInfo, code *CodeAttribute, hierarchy ClassHierarchy) (*analyzer, error) {
+	a := &analyzer{cp: cf.ConstantPool, hierarchy: hierarchy, access: method.AccessFlags, code: code, at: map[int]int{}}
+	var ok bool
+	if a.thisClass, ok = lookupClassName(cf.ConstantPool, cf.ThisClass); !ok {
+		return nil, fmt.Errorf("this_class #%v is not a class", cf.ThisClass)
+	}
+	name, ok := lookupUtf8(cf.ConstantPool, method.NameIndex)
+	if !ok {
+		return nil, fmt.Errorf("method name #%v is not a Utf8", method.NameIndex)
+	}
+	descriptor, ok := lookupUtf8(cf.ConstantPool, method.DescriptorIndex)
+	if !ok {
+		return nil, fmt.Errorf("method descriptor #%v is not a Utf8", method.DescriptorIndex)
+	}
+	a.name = name.Value()
+	a.method = a.name + descriptor.Value()
+	var err error
+	if a.desc, err = ParseMethodDescriptor(descriptor.Value()); err != nil {
+		return nil, fmt.Errorf("method %v: %v", a.method, err)
+	}
+	if a.insns, err = DecodeInstructions(code.Code); err != nil {
+		return nil, fmt.Errorf("method %v: %v", a.method, err)
+	}
+	for i := range a.insns {
+		a.at[a.insns[i].Offset] = i
+	}
+	a.frames = make([]*Frame, len(a.insns))
+	return a, nil
+}
+
+func (a *analyzer) errorAt(offset int, format string, args ...interface{}) error {
+	return a.frameError(offset, nil, format, args...)
+}
+
+// frameError is errorAt for an instruction whose incoming frame is known.
+func (a *analyzer) frameError(offset int, f *Frame, format string, args ...interface{}) error {
+	return &VerifyError{Method: a.method, Offset: offset, Detail: fmt.Sprintf(format, args...), Frame: f}
+}
+
+// initialFrame gets the frame at the start of the method, which holds this and the
+// parameters.
+func (a *analyzer) initialFrame() (*Frame, error) {
+	f := &Frame{Locals: make([]VerificationType, a.code.MaxLocals)}
+	for i := range f.Locals {
+		f.Locals[i] = topType
+	}
+	var params []VerificationType
+	if a.access&AccStatic == 0 {
+		if a.name == "<init>" && a.thisClass != "java/lang/Object" {
+			params = append(params, uninitializedThisType)
+		} else {
+			params = append(params, objectType(a.thisClass))
+		}
+	}
+	for _, param := range a.desc.Params {
+		params = append(params, descriptorType(param))
+	}
+	i := 0
+	for _, param := range params {
+		if i+param.slots() > len(f.Locals) {
+			return nil, a.errorAt(0, "max_locals %v is too small for the parameters", a.code.MaxLocals)
+		}
+		f.Locals[i] = param
+		i += param.slots()
+	}
+	return f, nil
+}
+
+// run follows every path through the code from the initial frame, merging the frames
+// where paths meet, until nothing changes.
+func (a *analyzer) run() error {
+	if len(a.insns) == 0 {
+		return a.errorAt(0, "no code")
+	}
+	initial, err := a.initialFrame()
+	if err != nil {
+		return err
+	}
+	a.frames[0] = initial
+	a.work = []int{0}
+	end := len(a.code.Code)
+	for len(a.work) > 0 {
+		i := a.work[len(a.work)-1]
+		a.work = a.work[:len(a.work)-1]
+		ins := &a.insns[i]
+		in := a.frames[i]
+		out, err := a.execute(ins, in)
+		if err != nil {
+			return err
+		}
+		if a.strict && len(out.Stack) > int(a.code.MaxStack) {
+			return a.frameError(ins.Offset, in, "%v overflows max_stack %v", ins.Opcode, a.code.MaxStack)
+		}
+
+		for _, handler := range a.code.ExceptionTable {
+			if ins.Offset < int(handler.StartPC) || ins.Offset >= int(handler.EndPC) {
+				continue
+			}
+			catchType, err := a.catchType(ins.Offset, handler)
+			if err != nil {
+				return err
+			}
+			// The handler can be reached with the locals as they are before the
+			// instruction, or after it changes them
+			for _, locals := range [][]VerificationType{in.Locals, out.Locals} {
+				thrown := &Frame{Locals: locals, Stack: []VerificationType{catchType}}
+				if err := a.merge(ins.Offset, int(handler.HandlerPC), thrown); err != nil {
+					return err
+				}
+			}
+		}
+
+		// A subroutine returns to the instruction after each jsr that calls it
+		switch {
+		case a.subs != nil && (ins.Opcode == OpJsr || ins.Opcode == OpJsrW):
+			if err := a.merge(ins.Offset, ins.Target, out); err != nil {
+				return err
+			}
+			for _, ret := range a.subs.rets[ins.Target] {
+				if a.frames[ret] != nil {
+					if err := a.mergeReturn(i, ret); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		case a.subs != nil && ins.Opcode == OpRet:
+			// execute checked that the local holds the address to return to
+			for _, call := range a.subs.callers[in.Locals[ins.Index].Offset] {
+				if a.frames[call] != nil {
+					if err := a.mergeReturn(call, i); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+
+		targets, fallsThrough := successors(ins)
+		if fallsThrough {
+			next := ins.Offset + ins.Size()
+			if next == end {
+				return a.errorAt(ins.Offset, "execution falls off the end of the code")
+			}
+			targets = append(targets, next)
+		}
+		for _, target := range targets {
+			if err := a.merge(ins.Offset, target, out); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// catchType gets the type of the exceptions a handler catches, for an instruction it
+// covers at offset.
+func (a *analyzer) catchType(offset int, handler ExceptionTableEntry) (VerificationType, error) {
+	throwable := objectType("java/lang/Throwable")
+	if handler.CatchType == 0 {
+		return throwable, nil
+	}
+	name, ok := lookupClassName(a.cp, handler.CatchType)
+	if !ok {
+		return throwable, a.errorAt(offset, "exception handler catch type #%v is not a class", handler.CatchType)
+	}
+	if a.strict {
+		if ok, err := a.isAssignable(objectType(name), throwable); err != nil || !ok {
+			return throwable, a.errorAt(int(handler.HandlerPC), "exception handler catches %v, which is not a Throwable%v", name, errorSuffix(err))
+		}
+	}
+	return objectType(name), nil
+}
+
+// merge merges a frame into the one at the instruction at offset to, which control can
+// pass to from the instruction at offset from.
+func (a *analyzer) merge(from, to int, f *Frame) error {
+	i, ok := a.at[to]
+	if !ok {
+		return a.errorAt(from, "branch to %v, which is not the start of an instruction", to)
+	}
+	old := a.frames[i]
+	if old == nil {
+		a.frames[i] = f.clone()
+		a.work = append(a.work, i)
+		return nil
+	}
+	if len(old.Stack) != len(f.Stack) {
+		return a.errorAt(to, "the operand stack is %v on one path here and %v on another", typesString(old.Stack), typesString(f.Stack))
+	}
+	changed := false
+	merged := old.clone()
+	for j := range merged.Locals {
+		t, err := a.mergeTypes(old.Locals[j], f.Locals[j])
+		if err != nil {
+			return a.errorAt(to, "%v", err)
+		}
+		if t == nil {
+			t = &topType
+		}
+		changed = changed || *t != old.Locals[j]
+		merged.Locals[j] = *t
+	}
+	for j := range merged.Stack {
+		t, err := a.mergeTypes(old.Stack[j], f.Stack[j])
+		if err != nil {
+			return a.errorAt(to, "%v", err)
+		}
+		if t == nil {
+			return a.errorAt(to, "the operand stack is %v on one path here and %v on another", typesString(old.Stack), typesString(f.Stack))
+		}
+		changed = changed || *t != old.Stack[j]
+		merged.Stack[j] = *t
+	}
+	// A long or double that lost its first half is no longer a value
+	for j := 1; j < len(merged.Locals); j++ {
+		if merged.Locals[j-1].slots() == 2 && merged.Locals[j] != topType {
+			merged.Locals[j-1] = topType
+		}
+	}
+	if changed {
+		a.frames[i] = merged
+		a.work = append(a.work, i)
+	}
+	return nil
+}
+
+// mergeTypes gets the type that holds values of both a and b, or nil if none does but
+// Top.
+func (a *analyzer) mergeTypes(x, y VerificationType) (*VerificationType, error) {
+	switch {
+	case x == y:
+		return &x, nil
+	case x.Tag == ItemNull && y.Tag == ItemObject:
+		return &y, nil
+	case x.Tag == ItemObject && y.Tag == ItemNull:
+		return &x, nil
+	case x.Tag == ItemObject && y.Tag == ItemObject:
+		common, err := CommonSuperclass(a.hierarchy, x.Class, y.Class)
+		if err != nil {
+			return nil, fmt.Errorf("can't merge %v and %v: %v", x.Class, y.Class, err)
+		}
+		t := objectType(common)
+		return &t, nil
+	}
+	return nil, nil
+}
+
+// typeState is the frame an instruction is being applied to. The first problem found
+// is kept in err.
+type typeState struct {
+	a   *analyzer
+	ins *Instruction
+	in  *Frame
+	*Frame
+	err error
+}
+
+func (s *typeState) fail(format string, args ...interface{}) {
+	if s.err == nil {
+		s.err = s.a.frameError(s.ins.Offset, s.in, format, args...)
+	}
+}
+
+func (s *typeState) push(types ...VerificationType) {
+	for _, t := range types {
+		s.Stack = append(s.Stack, t)
+		if t.slots() == 2 {
+			s.Stack = append(s.Stack, topType)
+		}
+	}
+}
+
+// popSlots pops n slots, which mustn't split a long or double.
+func (s *typeState) popSlots(n int) []VerificationType {
+	if s.err != nil {
+		return make([]VerificationType, n)
+	}
+	if len(s.Stack) < n {
+		s.fail("%v pops %v slots from the operand stack %v", s.ins.Opcode, n, typesString(s.Stack))
+		return make([]VerificationType, n)
+	}
+	at := len(s.Stack) - n
+	if s.Stack[at] == topType {
+		s.fail("%v splits the %v on top of the operand stack %v", s.ins.Opcode, s.Stack[at-1], typesString(s.Stack))
+		return make([]VerificationType, n)
+	}
+	slots := append([]VerificationType(nil), s.Stack[at:]...)
+	s.Stack = s.Stack[:at]
+	return slots
+}
+
+// pop pops a value of type want. For ItemObject that is any initialized reference, or
+// in strict mode one assignable to want.
+func (s *typeState) pop(want VerificationType) VerificationType {
+	if s.err != nil {
+		return want
+	}
+	if len(s.Stack) == 0 {
+		s.fail("%v expects %v on the stack, which is empty", s.ins.Opcode, want)
+		return want
+	}
+	got := s.Stack[len(s.Stack)-1]
+	if want.slots() == 2 && len(s.Stack) > 1 {
+		got = s.Stack[len(s.Stack)-2]
+	}
+	ok := got == want
+	if want.Tag == ItemObject {
+		ok = got.Tag == ItemNull || got.Tag == ItemObject
+		if ok && s.a.strict {
+			var err error
+			if ok, err = s.a.isAssignable(got, want); err != nil {
+				s.fail("can't check that %v is assignable to %v: %v", got, want, err)
+				return want
+			}
+		}
+	}
+	if !ok {
+		s.fail("%v expects %v on the stack, found %v", s.ins.Opcode, want, got)
+		return want
+	}
+	s.popSlots(want.slots())
+	return got
+}
+
+// popReference pops any reference, including uninitialized ones.
+func (s *typeState) popReference() VerificationType {
+	if s.err != nil {
+		return nullType
+	}
+	if len(s.Stack) == 0 || !s.Stack[len(s.Stack)-1].isReference() {
+		got := "nothing"
+		if len(s.Stack) > 0 {
+			got = s.Stack[len(s.Stack)-1].String()
+		}
+		s.fail("%v expects a reference on the stack, found %v", s.ins.Opcode, got)
+		return nullType
+	}
+	return s.popSlots(1)[0]
+}
+
+// popArray pops an array whose components have the given descriptor, or are
+// references if it is "L", or null.
+func (s *typeState) popArray(components ...string) VerificationType {
+	got := s.pop(objectType("java/lang/Object"))
+	if s.err != nil || got.Tag == ItemNull {
+		return got
+	}
+	for _, component := range components {
+		if component == "L" && (strings.HasPrefix(got.Class, "[L") || strings.HasPrefix(got.Class, "[[")) || got.Class == "["+component {
+			return got
+		}
+	}
+	s.fail("%v expects an array of %v on the stack, found %v", s.ins.Opcode, strings.Join(components, " or "), got)
+	return got
+}
+
+func (s *typeState) load(index int, want VerificationType) VerificationType {
+	if index+want.slots() > len(s.Locals) {
+		s.fail("%v uses local %v, beyond max_locals %v", s.ins.Opcode, index, len(s.Locals))
+		return want
+	}
+	got := s.Locals[index]
+	ok := got == want
+	if want.Tag == ItemObject {
+		ok = got.isReference()
+	}
+	if !ok {
+		s.fail("%v expects %v in local %v, found %v", s.ins.Opcode, want, index, got)
+	}
+	return got
+}
+
+func (s *typeState) store(index int, t VerificationType) {
+	if index+t.slots() > len(s.Locals) {
+		s.fail("%v uses local %v, beyond max_locals %v", s.ins.Opcode, index, len(s.Locals))
+		return
+	}
+	if index > 0 && s.Locals[index-1].slots() == 2 {
+		s.Locals[index-1] = topType
+	}
+	s.Locals[index] = t
+	if t.slots() == 2 {
+		s.Locals[index+1] = topType
+	}
+}
+
+// replace replaces every slot of one type with another, as when an object is
+// initialized.
+func (s *typeState) replace(old, t VerificationType) {
+	for _, types := range [][]VerificationType{s.Locals, s.Stack} {
+		for i := range types {
+			if types[i] == old {
+				types[i] = t
+			}
+		}
+	}
+}
+
+// simpleTypes gives the types popped, in order, and pushed by the instructions whose
+// types don't depend on their operands.
+var simpleTypes = map[Opcode]struct{ pop, push []VerificationType }{}
+
+func init() {
+	i, l, f, d := intType, longType, floatType, doubleType
+	types := []struct {
+		pop, push []VerificationType
+		ops       []Opcode
+	}{
+		{nil, nil, []Opcode{OpNop, OpGoto, OpGotoW}},
+		{nil, []VerificationType{nullType}, []Opcode{OpAconstNull}},
+		{nil, []VerificationType{i}, []Opcode{OpIconstM1, OpIconst0, OpIconst1, OpIconst2,
+			OpIconst3, OpIconst4, OpIconst5, OpBipush, OpSipush}},
+		{nil, []VerificationType{l}, []Opcode{OpLconst0, OpLconst1}},
+		{nil, []VerificationType{f}, []Opcode{OpFconst0, OpFconst1, OpFconst2}},
+		{nil, []VerificationType{d}, []Opcode{OpDconst0, OpDconst1}},
+		{[]VerificationType{i, i}, []VerificationType{i}, []Opcode{OpIadd, OpIsub, OpImul,
+			OpIdiv, OpIrem, OpIshl, OpIshr, OpIushr, OpIand, OpIor, OpIxor}},
+		{[]VerificationType{l, l}, []VerificationType{l}, []Opcode{OpLadd, OpLsub, OpLmul,
+			OpLdiv, OpLrem, OpLand, OpLor, OpLxor}},
+		{[]VerificationType{i, l}, []VerificationType{l}, []Opcode{OpLshl, OpLshr, OpLushr}},
+		{[]VerificationType{f, f}, []VerificationType{f}, []Opcode{OpFadd, OpFsub, OpFmul, OpFdiv, OpFrem}},
+		{[]VerificationType{d, d}, []VerificationType{d}, []Opcode{OpDadd, OpDsub, OpDmul, OpDdiv, OpDrem}},
+		{[]VerificationType{i}, []VerificationType{i}, []Opcode{OpIneg, OpI2b, OpI2c, OpI2s}},
+		{[]VerificationType{l}, []VerificationType{l}, []Opcode{OpLneg}},
+		{[]VerificationType{f}, []VerificationType{f}, []Opcode{OpFneg}},
+		{[]VerificationType{d}, []VerificationType{d}, []Opcode{OpDneg}},
+		{[]VerificationType{i}, []VerificationType{l}, []Opcode{OpI2l}},
+		{[]VerificationType{i}, []VerificationType{f}, []Opcode{OpI2f}},
+		{[]VerificationType{i}, []VerificationType{d}, []Opcode{OpI2d}},
+		{[]VerificationType{l}, []VerificationType{i}, []Opcode{OpL2i}},
+		{[]VerificationType{l}, []VerificationType{f}, []Opcode{OpL2f}},
+		{[]VerificationType{l}, []VerificationType{d}, []Opcode{OpL2d}},
+		{[]VerificationType{f}, []VerificationType{i}, []Opcode{OpF2i}},
+		{[]VerificationType{f}, []VerificationType{l}, []Opcode{OpF2l}},
+		{[]VerificationType{f}, []VerificationType{d}, []Opcode{OpF2d}},
+		{[]VerificationType{d}, []VerificationType{i}, []Opcode{OpD2i}},
+		{[]VerificationType{d}, []VerificationType{l}, []Opcode{OpD2l}},
+		{[]VerificationType{d}, []VerificationType{f}, []Opcode{OpD2f}},
+		{[]VerificationType{l, l}, []VerificationType{i}, []Opcode{OpLcmp}},
+		{[]VerificationType{f, f}, []VerificationType{i}, []Opcode{OpFcmpl, OpFcmpg}},
+		{[]VerificationType{d, d}, []VerificationType{i}, []Opcode{OpDcmpl, OpDcmpg}},
+		{[]VerificationType{i}, nil, []Opcode{OpIfeq, OpIfne, OpIflt, OpIfge, OpIfgt, OpIfle,
+			OpTableswitch, OpLookupswitch}},
+		{[]VerificationType{i, i}, nil, []Opcode{OpIfIcmpeq, OpIfIcmpne, OpIfIcmplt,
+			OpIfIcmpge, OpIfIcmpgt, OpIfIcmple}},
+	}
+	for _, t := range types {
+		for _, op := range t.ops {
+			simpleTypes[op] = struct{ pop, push []VerificationType }{t.pop, t.push}
+		}
+	}
+}
+
+// newarrayComponents gives the component descriptor of the arrays newarray creates.
+var newarrayComponents = map[byte]string{
+	TBoolean: "Z", TChar: "C", TFloat: "F", TDouble: "D", TByte: "B", TShort: "S", TInt: "I", TLong: "J",
+}
+
+// execute applies an instruction to the frame before it, giving the frame after it.
+func (a *analyzer) execute(ins *Instruction, in *Frame) (*Frame, error) {
+	s := &typeState{a: a, ins: ins, in: in, Frame: in.clone()}
+	object := objectType("java/lang/Object")
+
+	if types, ok := simpleTypes[ins.Opcode]; ok {
+		for _, t := range types.pop {
+			s.pop(t)
+		}
+		s.push(types.push...)
+		return s.Frame, s.err
+	}
+
+	switch op := ins.Opcode; op {
+	case OpIload, OpLload, OpFload, OpDload, OpAload, OpIload0, OpIload1, OpIload2, OpIload3,
+		OpLload0, OpLload1, OpLload2, OpLload3, OpFload0, OpFload1, OpFload2, OpFload3,
+		OpDload0, OpDload1, OpDload2, OpDload3, OpAload0, OpAload1, OpAload2, OpAload3:
+		index, _, _ := localVariable(ins)
+		s.push(s.load(index, localType(op)))
+	case OpIstore, OpLstore, OpFstore, OpDstore, OpIstore0, OpIstore1, OpIstore2, OpIstore3,
+		OpLstore0, OpLstore1, OpLstore2, OpLstore3, OpFstore0, OpFstore1, OpFstore2, OpFstore3,
+		OpDstore0, OpDstore1, OpDstore2, OpDstore3:
+		index, _, _ := localVariable(ins)
+		t := localType(op)
+		s.pop(t)
+		s.store(index, t)
+	case OpAstore, OpAstore0, OpAstore1, OpAstore2, OpAstore3:
+		index, _, _ := localVariable(ins)
+		if len(s.Stack) > 0 && s.Stack[len(s.Stack)-1].Tag == itemReturnAddress {
+			s.store(index, s.popSlots(1)[0])
+		} else {
+			s.store(index, s.popReference())
+		}
+	case OpIinc:
+		s.load(int(ins.Index), intType)
+
+	case OpIaload, OpBaload, OpCaload, OpSaload:
+		s.pop(intType)
+		s.popArray(map[Opcode][]string{OpIaload: {"I"}, OpBaload: {"B", "Z"}, OpCaload: {"C"}, OpSaload: {"S"}}[op]...)
+		s.push(intType)
+	case OpLaload, OpFaload, OpDaload:
+		s.pop(intType)
+		t := map[Opcode]VerificationType{OpLaload: longType, OpFaload: floatType, OpDaload: doubleType}[op]
+		s.popArray(map[Opcode]string{OpLaload: "J", OpFaload: "F", OpDaload: "D"}[op])
+		s.push(t)
+	case OpAaload:
+		s.pop(intType)
+		array := s.popArray("L")
+		if array.Tag == ItemNull {
+			s.push(nullType)
+		} else if component, ok := componentClass(array.Class); ok {
+			s.push(objectType(component))
+		}
+	case OpIastore, OpBastore, OpCastore, OpSastore:
+		s.pop(intType)
+		s.pop(intType)
+		s.popArray(map[Opcode][]string{OpIastore: {"I"}, OpBastore: {"B", "Z"}, OpCastore: {"C"}, OpSastore: {"S"}}[op]...)
+	case OpLastore, OpFastore, OpDastore:
+		s.pop(map[Opcode]VerificationType{OpLastore: longType, OpFastore: floatType, OpDastore: doubleType}[op])
+		s.pop(intType)
+		s.popArray(map[Opcode]string{OpLastore: "J", OpFastore: "F", OpDastore: "D"}[op])
+	case OpAastore:
+		s.pop(object)
+		s.pop(intType)
+		s.popArray("L")
+
+	case OpPop:
+		s.popSlots(1)
+	case OpPop2:
+		s.popSlots(2)
+	case OpDup:
+		v := s.popSlots(1)
+		s.Stack = append(append(s.Stack, v...), v...)
+	case OpDupX1:
+		v1, v2 := s.popSlots(1), s.popSlots(1)
+		s.Stack = append(append(append(s.Stack, v1...), v2...), v1...)
+	case OpDupX2:
+		v1, v2 := s.popSlots(1), s.popSlots(2)
+		s.Stack = append(append(append(s.Stack, v1...), v2...), v1...)
+	case OpDup2:
+		v := s.popSlots(2)
+		s.Stack = append(append(s.Stack, v...), v...)
+	case OpDup2X1:
+		v1, v2 := s.popSlots(2), s.popSlots(1)
+		s.Stack = append(append(append(s.Stack, v1...), v2...), v1...)
+	case OpDup2X2:
+		v1, v2 := s.popSlots(2), s.popSlots(2)
+		s.Stack = append(append(append(s.Stack, v1...), v2...), v1...)
+	case OpSwap:
+		v1, v2 := s.popSlots(1), s.popSlots(1)
+		s.Stack = append(append(s.Stack, v1...), v2...)
+
+	case OpIfAcmpeq, OpIfAcmpne:
+		s.popReference()
+		s.popReference()
+	case OpIfnull, OpIfnonnull, OpMonitorenter, OpMonitorexit:
+		s.popReference()
+	case OpJsr, OpJsrW, OpRet:
+		if a.subs == nil {
+			s.fail("%v is not allowed in code with stack map frames; inline its subroutines first", op)
+		} else if op == OpRet {
+			if index := int(ins.Index); index >= len(s.Locals) {
+				s.fail("ret uses local %v, beyond max_locals %v", index, len(s.Locals))
+			} else if t := s.Locals[index]; t.Tag != itemReturnAddress {
+				s.fail("ret expects a return address in local %v, found %v", index, t)
+			}
+		} else {
+			s.push(VerificationType{Tag: itemReturnAddress, Offset: ins.Target})
+		}
+
+	case OpIreturn, OpLreturn, OpFreturn, OpDreturn, OpAreturn:
+		if a.desc.Return == Void {
+			s.fail("%v in a method that returns void", op)
+			break
+		}
+		want := descriptorType(a.desc.Return)
+		if (op == OpAreturn) != (want.Tag == ItemObject) || op != OpAreturn && want != localType(op) {
+			s.fail("%v in a method that returns %v", op, a.desc.Return)
+		} else {
+			s.pop(want)
+		}
+	case OpReturn:
+		if a.desc.Return != Void {
+			s.fail("return in a method that returns %v", a.desc.Return)
+		}
+		for _, t := range s.Locals {
+			if a.strict && t == uninitializedThisType {
+				s.fail("return before the superclass constructor is called")
+				break
+			}
+		}
+	case OpAthrow:
+		s.pop(objectType("java/lang/Throwable"))
+
+	case OpLdc, OpLdcW, OpLdc2W:
+		s.push(a.constantType(s, ins.Index))
+	case OpGetstatic, OpPutstatic, OpGetfield, OpPutfield:
+		class, _, descriptor := a.memberRef(s, ins.Index)
+		t, err := ParseFieldDescriptor(descriptor)
+		if err != nil {
+			s.fail("%v", err)
+			break
+		}
+		switch op {
+		case OpGetstatic:
+			s.push(descriptorType(t))
+		case OpPutstatic:
+			s.pop(descriptorType(t))
+		case OpGetfield:
+			s.pop(objectType(class))
+			s.push(descriptorType(t))
+		case OpPutfield:
+			s.pop(descriptorType(t))
+			// Constructors may set their own fields before calling the superclass
+			// constructor
+			if len(s.Stack) > 0 && s.Stack[len(s.Stack)-1] == uninitializedThisType {
+				s.popReference()
+				if a.strict && class != a.thisClass {
+					s.fail("putfield on uninitializedThis to a field of %v", class)
+				}
+			} else {
+				s.pop(objectType(class))
+			}
+		}
+	case OpInvokevirtual, OpInvokespecial, OpInvokestatic, OpInvokeinterface, OpInvokedynamic:
+		class, name, descriptor := a.memberRef(s, ins.Index)
+		m, err := ParseMethodDescriptor(descriptor)
+		if err != nil {
+			s.fail("%v", err)
+			break
+		}
+		for i := len(m.Params) - 1; i >= 0; i-- {
+			s.pop(descriptorType(m.Params[i]))
+		}
+		if op == OpInvokespecial && name == "<init>" {
+			receiver := s.popReference()
+			switch receiver.Tag {
+			case ItemUninitializedThis:
+				if a.strict {
+					a.checkSuperInit(s, class)
+				}
+				s.replace(receiver, objectType(a.thisClass))
+			case ItemUninitialized:
+				created := a.newClass(s, receiver.Offset)
+				if a.strict && created != class {
+					s.fail("invokespecial %v.<init> on %v, a new %v", class, receiver, created)
+				}
+				s.replace(receiver, objectType(created))
+			default:
+				s.fail("invokespecial <init> on %v, which is already initialized", receiver)
+			}
+		} else if op != OpInvokestatic && op != OpInvokedynamic {
+			s.pop(objectType(class))
+		}
+		if m.Return != Void {
+			s.push(descriptorType(m.Return))
+		}
+
+	case OpNew:
+		a.className(s, ins.Index)
+		s.push(VerificationType{Tag: ItemUninitialized, Offset: ins.Offset})
+	case OpNewarray:
+		s.pop(intType)
+		component, ok := newarrayComponents[byte(ins.Value)]
+		if !ok {
+			s.fail("newarray of unknown type %v", ins.Value)
+		}
+		s.push(objectType("[" + component))
+	case OpAnewarray:
+		s.pop(intType)
+		s.push(objectType("[" + classDescriptor(a.className(s, ins.Index))))
+	case OpMultianewarray:
+		for i := 0; i < int(ins.Value); i++ {
+			s.pop(intType)
+		}
+		s.push(objectType(a.className(s, ins.Index)))
+	case OpArraylength:
+		array := s.pop(object)
+		if array.Tag == ItemObject && !strings.HasPrefix(array.Class, "[") {
+			s.fail("arraylength expects an array on the stack, found %v", array)
+		}
+		s.push(intType)
+	case OpCheckcast:
+		s.pop(object)
+		s.push(objectType(a.className(s, ins.Index)))
+	case OpInstanceof:
+		s.pop(object)
+		a.className(s, ins.Index)
+		s.push(intType)
+	default:
+		s.fail("unknown opcode 0x%02x", byte(op))
+	}
+	return s.Frame, s.err
+}
+
+// localType gets the type that a load, store or return instruction works with.
+func localType(op Opcode) VerificationType {
+	kinds := []VerificationType{intType, longType, floatType, doubleType, objectType("java/lang/Object")}
+	switch {
+	case op >= OpIload && op <= OpAload:
+		return kinds[op-OpIload]
+	case op >= OpIload0 && op <= OpAload3:
+		return kinds[(op-OpIload0)/4]
+	case op >= OpIstore && op <= OpAstore:
+		return kinds[op-OpIstore]
+	case op >= OpIstore0 && op <= OpAstore3:
+		return kinds[(op-OpIstore0)/4]
+	case op >= OpIreturn && op <= OpAreturn:
+		return kinds[op-OpIreturn]
+	}
+	return topType
+}
+
+// className gets the name of the CONSTANT_Class an instruction refers to.
+func (a *analyzer) className(s *typeState, index uint16) string {
+	name, ok := lookupClassName(a.cp, index)
+	if !ok {
+		s.fail("#%v is not a class", index)
+		return "java/lang/Object"
+	}
+	return name
+}
+
+// newClass gets the class of the object created by the new instruction at offset.
+func (a *analyzer) newClass(s *typeState, offset int) string {
+	i, ok := a.at[offset]
+	if !ok || a.insns[i].Opcode != OpNew {
+		s.fail("uninitialized(%v) doesn't refer to a new instruction", offset)
+		return "java/lang/Object"
+	}
+	return a.className(s, a.insns[i].Index)
+}
+
+// memberRef gets the class, name and descriptor of the field, method or call site an
+// instruction refers to.
+func (a *analyzer) memberRef(s *typeState, index uint16) (class, name, descriptor string) {
+	descriptor, err := refDescriptor(a.cp, index, s.ins.Offset)
+	if err != nil {
+		s.fail("#%v is not a field, method or call site reference", index)
+		return "", "", "()V"
+	}
+	var classIndex, natIndex uint16
+	switch e := LookupEntry(a.cp, index).(type) {
+	case *CONSTANT_Fieldref_info:
+		classIndex, natIndex = e.ClassIndex, e.NameAndTypeIndex
+	case *CONSTANT_Methodref_info:
+		classIndex, natIndex = e.ClassIndex, e.NameAndTypeIndex
+	case *CONSTANT_InterfaceMethodref_info:
+		classIndex, natIndex = e.ClassIndex, e.NameAndTypeIndex
+	case *CONSTANT_InvokeDynamic_info:
+		natIndex = e.NameAndTypeIndex
+	}
+	if classIndex != 0 {
+		class = a.className(s, classIndex)
+	}
+	name = lookupNameAndType(a.cp, natIndex).Name(a.cp)
+	return class, name, descriptor
+}
+
+// constantType gets the type of the constant an ldc loads.
+func (a *analyzer) constantType(s *typeState, index uint16) VerificationType {
+	switch e := LookupEntry(a.cp, index).(type) {
+	case *CONSTANT_Integer_info:
+		return intType
+	case *CONSTANT_Float_info:
+		return floatType
+	case *CONSTANT_Long_info:
+		return longType
+	case *CONSTANT_Double_info:
+		return doubleType
+	case *CONSTANT_String_info:
+		return objectType("java/lang/String")
+	case *CONSTANT_Class_info:
+		return objectType("java/lang/Class")
+	case *CONSTANT_MethodType_info:
+		return objectType("java/lang/invoke/MethodType")
+	case *CONSTANT_MethodHandle_info:
+		return objectType("java/lang/invoke/MethodHandle")
+	case *CONSTANT_Dynamic_info:
+		descriptor, err := natDescriptor(a.cp, e.NameAndTypeIndex, index, s.ins.Offset)
+		if err == nil {
+			if t, err := ParseFieldDescriptor(descriptor); err == nil {
+				return descriptorType(t)
+			}
+		}
+	}
+	s.fail("#%v is not a loadable constant", index)
+	return topType
+}
+
+// ComputeFrames works out the stack map frames of every method with code, and gives
+// each a StackMapTable in place of the one it had, if any. Classes of version 50 and
+// above need these for the JVM to verify them. Where types from two paths through the
+// code meet, their common superclass is found with hierarchy.
+//
+// Code that can't be reached is replaced with nops ending in an athrow, and left out of
+// the exception table, since there is no frame to describe it with. MaxLocals must be
+// large enough for the code, as ComputeMaxs makes it, and the code must not use jsr or
+// ret.
+func (cf *ClassFile) ComputeFrames(hierarchy ClassHierarchy) error {
+	pool := NewConstantPoolBuilder(cf.ConstantPool)
+	for i := range cf.Methods {
+		method := &cf.Methods[i]
+		attr := findAttr(method.Attrs, cf.ConstantPool, "Code")
+		if attr == nil {
+			continue
+		}
+		code, err := ReadCodeAttribute(attr.AttrData)
+		if err != nil {
+			return err
+		}
+		if err := computeFrames(cf, method, code, pool, hierarchy); err != nil {
+			return err
+		}
+		if attr.AttrData, err = WriteCodeAttribute(code); err != nil {
+			return err
+		}
+	}
+	if err := pool.Err(); err != nil {
+		return err
+	}
+	cf.ConstantPool = pool.Entries()
+	return cf.UpdateCounts()
+}
+
+func computeFrames(cf *ClassFile, method *MethodInfo, code *CodeAttribute, pool *ConstantPoolBuilder, hierarchy ClassHierarchy) error {
+	a, err := newAnalyzer(cf, method, code, hierarchy)
+	if err != nil {
+		return err
+	}
+	if err := a.run(); err != nil {
+		return err
+	}
+	a.removeDeadCode()
+
+	// Frames are needed where control can pass other than from the instruction before,
+	// and after instructions that don't pass control on
+	needed := map[int]bool{}
+	for _, handler := range code.ExceptionTable {
+		needed[int(handler.HandlerPC)] = true
+	}
+	for i := range a.insns {
+		ins := &a.insns[i]
+		targets, fallsThrough := successors(ins)
+		for _, target := range targets {
+			needed[target] = true
+		}
+		if !fallsThrough && i+1 < len(a.insns) {
+			needed[a.insns[i+1].Offset] = true
+		}
+	}
+	var offsets []int
+	for offset := range needed {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	initial, _ := a.initialFrame()
+	previous := compress(initial.Locals, true)
+	last := -1
+	table := &StackMapTableAttribute{}
+	for _, offset := range offsets {
+		f := a.frames[a.at[offset]]
+		frame := compactFrame(previous, compress(f.Locals, true), compress(f.Stack, false), offset-last-1)
+		frame.StackMapFrame.Locals = verificationInfos(frame.Locals, pool)
+		frame.StackMapFrame.Stack = verificationInfos(frame.Stack, pool)
+		table.Entries = append(table.Entries, frame.StackMapFrame)
+		previous = compress(f.Locals, true)
+		last = offset
+	}
+
+	// Replace the old table, or add one
+	var attrs []AttrInfo
+	for _, attr := range code.Attrs {
+		if name, ok := lookupUtf8(pool.Entries(), attr.NameIndex); !ok || name.Value() != "StackMapTable" {
+			attrs = append(attrs, attr)
+		}
+	}
+	if len(table.Entries) > 0 {
+		data, err := WriteStackMapTable(table)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, AttrInfo{NameIndex: pool.Utf8("StackMapTable"), AttrData: data})
+	}
+	code.Attrs = attrs
+	return pool.Err()
+}
+
+// typedFrame is a StackMapFrame whose types are still VerificationTypes, until
+// constants are added for their classes.
+type typedFrame struct {
+	StackMapFrame
+	Locals, Stack []VerificationType
+}
+
+// compactFrame picks the most compact kind of frame that gives the locals and stack,
+// after a frame whose locals were previous.
+func compactFrame(previous, locals, stack []VerificationType, delta int) typedFrame {
+	f := typedFrame{StackMapFrame: StackMapFrame{OffsetDelta: uint16(delta)}}
+	same := func(a, b []VerificationType) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case len(stack) == 0 && same(locals, previous):
+		f.FrameType = SameFrameExtended
+		if delta < 64 {
+			f.FrameType = byte(delta)
+		}
+	case len(stack) == 1 && same(locals, previous):
+		f.Stack = stack
+		f.FrameType = SameLocals1StackItemExtended
+		if delta < 64 {
+			f.FrameType = byte(64 + delta)
+		}
+	case len(stack) == 0 && len(locals) < len(previous) && len(previous)-len(locals) <= 3 && same(locals, previous[:len(locals)]):
+		f.FrameType = SameFrameExtended - byte(len(previous)-len(locals))
+	case len(stack) == 0 && len(locals) > len(previous) && len(locals)-len(previous) <= 3 && same(locals[:len(previous)], previous):
+		f.Locals = locals[len(previous):]
+		f.FrameType = SameFrameExtended + byte(len(f.Locals))
+	default:
+		f.Locals, f.Stack = locals, stack
+		f.FrameType = FullFrame
+	}
+	return f
+}
+
+func verificationInfos(types []VerificationType, pool *ConstantPoolBuilder) []VerificationTypeInfo {
+	var infos []VerificationTypeInfo
+	for _, t := range types {
+		info := VerificationTypeInfo{Tag: t.Tag}
+		switch t.Tag {
+		case ItemObject:
+			info.CpoolIndex = pool.Class(t.Class)
+		case ItemUninitialized:
+			info.Offset = uint16(t.Offset)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// removeDeadCode replaces each run of instructions that can't be reached with nops
+// ending in an athrow, which keeps every offset, and gives it a frame holding just a
+// Throwable. Exception handlers stop covering it.
+func (a *analyzer) removeDeadCode() {
+	code := a.code
+	dead := make([]bool, len(code.Code))
+	var insns []Instruction
+	var frames []*Frame
+	found := false
+	for i := 0; i < len(a.insns); {
+		if a.frames[i] != nil {
+			insns = append(insns, a.insns[i])
+			frames = append(frames, a.frames[i])
+			i++
+			continue
+		}
+		found = true
+		start := a.insns[i].Offset
+		for i < len(a.insns) && a.frames[i] == nil {
+			i++
+		}
+		end := len(code.Code)
+		if i < len(a.insns) {
+			end = a.insns[i].Offset
+		}
+		frame := &Frame{Locals: make([]VerificationType, code.MaxLocals), Stack: []VerificationType{objectType("java/lang/Throwable")}}
+		for j := range frame.Locals {
+			frame.Locals[j] = topType
+		}
+		for pos := start; pos < end; pos++ {
+			op := OpNop
+			if pos == end-1 {
+				op = OpAthrow
+			}
+			code.Code[pos] = byte(op)
+			dead[pos] = true
+			insns = append(insns, Instruction{Offset: pos, Opcode: op})
+			frames = append(frames, frame)
+		}
+	}
+	if !found {
+		return
+	}
+	a.insns, a.frames = insns, frames
+	a.at = map[int]int{}
+	for i := range insns {
+		a.at[insns[i].Offset] = i
+	}
+	if code.MaxStack == 0 {
+		code.MaxStack = 1
+	}
+
+	var handlers []ExceptionTableEntry
+	for _, handler := range code.ExceptionTable {
+		start := int(handler.StartPC)
+		for pos := start; pos <= int(handler.EndPC); pos++ {
+			if pos == int(handler.EndPC) || dead[pos] {
+				if pos > start {
+					entry := handler
+					entry.StartPC, entry.EndPC = uint16(start), uint16(pos)
+					handlers = append(handlers, entry)
+				}
+				start = pos + 1
+			}
+		}
+	}
+	code.ExceptionTable = handlers
+	code.ExceptionTableLength = uint16(len(handlers))
+}
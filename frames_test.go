@@ -0,0 +1,128 @@
+package classy
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// stackMapFrames renders the frames of a StackMapTable one per line as offset: frame.
+func stackMapFrames(t *testing.T, cp []CpEntry, code *CodeAttribute) string {
+	t.Helper()
+	var lines []string
+	for _, attr := range code.Attrs {
+		if attr.Name(cp) != "StackMapTable" {
+			continue
+		}
+		decoded, err := attr.Decode(cp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		table := decoded.(*StackMapTableAttribute)
+		for i, offset := range table.Offsets() {
+			lines = append(lines, strconv.Itoa(offset)+": "+table.Entries[i].Repr(cp))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestComputeFrames(t *testing.T) {
+	tests := []struct {
+		name, descriptor string
+		build            func(m *MethodBuilder)
+		frames           string
+	}{
+		{"straight", "(I)I", func(m *MethodBuilder) {
+			m.VarInsn(OpIload, 0)
+			m.Insn(OpIreturn)
+		}, ""},
+		{"merge", "(Z)Ljava/lang/Object;", func(m *MethodBuilder) {
+			other, end := m.NewLabel(), m.NewLabel()
+			m.VarInsn(OpIload, 0)
+			m.JumpInsn(OpIfeq, other)
+			m.LdcInsn(m.class.cp.String("s"))
+			m.JumpInsn(OpGoto, end)
+			m.Mark(other)
+			m.FieldInsn(OpGetstatic, "java/lang/System", "out", "Ljava/io/PrintStream;")
+			m.Mark(end)
+			m.Insn(OpAreturn)
+		}, "9: same\n12: same_locals_1_stack_item [java/lang/Object]"},
+		{"loop", "(I)I", func(m *MethodBuilder) {
+			top, end := m.NewLabel(), m.NewLabel()
+			m.Insn(OpIconst0)
+			m.VarInsn(OpIstore, 1)
+			m.Mark(top)
+			m.VarInsn(OpIload, 0)
+			m.JumpInsn(OpIfle, end)
+			m.IincInsn(1, 2)
+			m.IincInsn(0, -1)
+			m.JumpInsn(OpGoto, top)
+			m.Mark(end)
+			m.VarInsn(OpIload, 1)
+			m.Insn(OpIreturn)
+		}, "2: append [int]\n15: same"},
+		{"handler", "(Ljava/lang/Object;)V", func(m *MethodBuilder) {
+			start, end, handler := m.NewLabel(), m.NewLabel(), m.NewLabel()
+			m.Mark(start)
+			m.VarInsn(OpAload, 0)
+			m.MethodInsn(OpInvokevirtual, "java/lang/Object", "hashCode", "()I", false)
+			m.Insn(OpPop)
+			m.Mark(end)
+			m.Insn(OpReturn)
+			m.Mark(handler)
+			m.VarInsn(OpAstore, 1)
+			m.Insn(OpReturn)
+			m.TryCatch(start, end, handler, "java/lang/RuntimeException")
+		}, "6: same_locals_1_stack_item [java/lang/RuntimeException]"},
+		// Unreachable code becomes nops ending in an athrow
+		{"unreachable", "()V", func(m *MethodBuilder) {
+			m.Insn(OpReturn)
+			m.Insn(OpIconst1)
+			m.Insn(OpPop)
+			m.Insn(OpReturn)
+		}, "1: same_locals_1_stack_item [java/lang/Throwable]"},
+	}
+	for _, test := range tests {
+		b := NewClassBuilder(AccPublic|AccSuper, "T", "java/lang/Object")
+		b.SetVersion(52, 0)
+		test.build(b.AddMethod(AccPublic|AccStatic, "f", test.descriptor))
+		cf, err := b.Build()
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		hierarchy := NewClassSet(CoreClasses)
+		if err := hierarchy.Add(cf); err != nil {
+			t.Fatal(err)
+		}
+		if err := cf.ComputeFrames(hierarchy); err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		code, insns := methodCode(t, cf, "f")
+		if got := stackMapFrames(t, cf.ConstantPool, code); got != test.frames {
+			t.Errorf("%v: frames\n%v\nwant\n%v\ncode\n%v", test.name, got, test.frames, reprs(cf.ConstantPool, insns))
+		}
+		if errs := cf.Verify(hierarchy); len(errs) > 0 {
+			t.Errorf("%v: %v", test.name, errs)
+		}
+	}
+}
+
+func TestComputeFramesRejectsSubroutines(t *testing.T) {
+	b := NewClassBuilder(AccPublic|AccSuper, "T", "java/lang/Object")
+	m := b.AddMethod(AccPublic|AccStatic, "f", "()V")
+	sub := m.NewLabel()
+	m.JumpInsn(OpJsr, sub)
+	m.Insn(OpReturn)
+	m.Mark(sub)
+	m.VarInsn(OpAstore, 0)
+	m.VarInsn(OpRet, 0)
+	cf, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.ComputeFrames(CoreClasses); err == nil {
+		t.Error("ComputeFrames accepted code with jsr and ret")
+	}
+}
@@ -0,0 +1,250 @@
+package classy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ClassHierarchy answers the questions about classes that working out stack map frames
+// needs, for classes in internal form such as "java/lang/String". Arrays are handled
+// without it.
+type ClassHierarchy interface {
+	// Superclass gets the superclass of a class, or "" for java/lang/Object. The
+	// superclass of an interface is java/lang/Object.
+	Superclass(class string) (string, error)
+	// IsInterface reports whether a class is an interface.
+	IsInterface(class string) (bool, error)
+}
+
+// ClassSet is a ClassHierarchy of the classes added to it, which asks Parent about
+// any others. Parent may be nil.
+type ClassSet struct {
+	Parent  ClassHierarchy
+	classes map[string]classSetEntry
+}
+
+type classSetEntry struct {
+	superclass  string
+	isInterface bool
+}
+
+// NewClassSet creates an empty ClassSet.
+func NewClassSet(parent ClassHierarchy) *ClassSet {
+	return &ClassSet{Parent: parent, classes: map[string]classSetEntry{}}
+}
+
+// Add adds a class to the set.
+func (s *ClassSet) Add(cf *ClassFile) error {
+	name, ok := lookupClassName(cf.ConstantPool, cf.ThisClass)
+	if !ok {
+		return fmt.Errorf("this_class #%v is not a class", cf.ThisClass)
+	}
+	var superclass string
+	if cf.SuperClass != 0 {
+		if superclass, ok = lookupClassName(cf.ConstantPool, cf.SuperClass); !ok {
+			return fmt.Errorf("super_class #%v is not a class", cf.SuperClass)
+		}
+	}
+	s.AddClass(name, superclass, cf.AccessFlags&AccInterface != 0)
+	return nil
+}
+
+// AddClass adds a class to the set by name.
+func (s *ClassSet) AddClass(name, superclass string, isInterface bool) {
+	s.classes[name] = classSetEntry{superclass, isInterface}
+}
+
+func (s *ClassSet) lookup(class string) (classSetEntry, error) {
+	if entry, ok := s.classes[class]; ok {
+		return entry, nil
+	}
+	if s.Parent == nil {
+		return classSetEntry{}, fmt.Errorf("class %v isn't known", class)
+	}
+	superclass, err := s.Parent.Superclass(class)
+	if err != nil {
+		return classSetEntry{}, err
+	}
+	isInterface, err := s.Parent.IsInterface(class)
+	return classSetEntry{superclass, isInterface}, err
+}
+
+// Superclass implements ClassHierarchy.
+func (s *ClassSet) Superclass(class string) (string, error) {
+	entry, err := s.lookup(class)
+	return entry.superclass, err
+}
+
+// IsInterface implements ClassHierarchy.
+func (s *ClassSet) IsInterface(class string) (bool, error) {
+	entry, err := s.lookup(class)
+	return entry.isInterface, err
+}
+
+// CoreClasses knows java/lang/Object and the classes of java.lang, java.io and
+// java.util that compiled code most often mixes, such as strings, boxes and common
+// exceptions, so that frames can be computed for simple classes without a JDK at hand.
+// Use it as the Parent of a ClassSet that holds an application's own classes.
+var CoreClasses ClassHierarchy = newCoreClasses()
+
+func newCoreClasses() *ClassSet {
+	s := NewClassSet(nil)
+	s.AddClass("java/lang/Object", "", false)
+	// Each line gives a superclass and then its subclasses
+	classes := []string{
+		"java/lang/Object java/lang/String java/lang/Number java/lang/Boolean java/lang/Character java/lang/Class java/lang/Throwable java/lang/StringBuilder java/lang/StringBuffer java/lang/Enum java/lang/Record java/lang/Thread java/lang/Math java/lang/System java/util/Objects java/util/Arrays java/util/Collections java/util/AbstractCollection java/util/AbstractMap java/util/Optional java/io/InputStream java/io/OutputStream java/io/Reader java/io/Writer java/io/File java/lang/invoke/MethodHandle java/lang/invoke/MethodType java/lang/invoke/MethodHandles java/lang/invoke/MethodHandles$Lookup java/lang/invoke/CallSite java/lang/invoke/LambdaMetafactory java/lang/invoke/StringConcatFactory java/lang/runtime/ObjectMethods",
+		"java/lang/Number java/lang/Integer java/lang/Long java/lang/Short java/lang/Byte java/lang/Double java/lang/Float",
+		"java/lang/Throwable java/lang/Exception java/lang/Error",
+		"java/lang/Exception java/lang/RuntimeException java/io/IOException java/lang/ReflectiveOperationException java/lang/InterruptedException java/lang/CloneNotSupportedException",
+		"java/lang/ReflectiveOperationException java/lang/ClassNotFoundException java/lang/NoSuchMethodException java/lang/NoSuchFieldException java/lang/IllegalAccessException java/lang/InstantiationException",
+		"java/io/IOException java/io/FileNotFoundException java/io/UncheckedIOException",
+		"java/lang/RuntimeException java/lang/IllegalArgumentException java/lang/IllegalStateException java/lang/NullPointerException java/lang/ClassCastException java/lang/ArithmeticException java/lang/IndexOutOfBoundsException java/lang/UnsupportedOperationException java/lang/ArrayStoreException java/lang/NegativeArraySizeException java/util/NoSuchElementException java/util/ConcurrentModificationException",
+		"java/lang/IllegalArgumentException java/lang/NumberFormatException",
+		"java/lang/IndexOutOfBoundsException java/lang/ArrayIndexOutOfBoundsException java/lang/StringIndexOutOfBoundsException",
+		"java/lang/Error java/lang/AssertionError java/lang/LinkageError java/lang/VirtualMachineError",
+		"java/lang/LinkageError java/lang/NoClassDefFoundError java/lang/VerifyError java/lang/IncompatibleClassChangeError java/lang/ExceptionInInitializerError",
+		"java/lang/VirtualMachineError java/lang/OutOfMemoryError java/lang/StackOverflowError",
+		"java/util/AbstractCollection java/util/AbstractList java/util/AbstractSet java/util/ArrayDeque",
+		"java/util/AbstractList java/util/ArrayList java/util/AbstractSequentialList",
+		"java/util/AbstractSequentialList java/util/LinkedList",
+		"java/util/AbstractSet java/util/HashSet java/util/TreeSet",
+		"java/util/HashSet java/util/LinkedHashSet",
+		"java/util/AbstractMap java/util/HashMap java/util/TreeMap",
+		"java/util/HashMap java/util/LinkedHashMap",
+		"java/io/InputStream java/io/FileInputStream java/io/ByteArrayInputStream",
+		"java/io/OutputStream java/io/FileOutputStream java/io/ByteArrayOutputStream java/io/FilterOutputStream",
+		"java/io/FilterOutputStream java/io/PrintStream",
+		"java/io/Reader java/io/InputStreamReader java/io/BufferedReader java/io/StringReader",
+		"java/io/Writer java/io/OutputStreamWriter java/io/BufferedWriter java/io/StringWriter java/io/PrintWriter",
+	}
+	for _, line := range classes {
+		names := strings.Fields(line)
+		for _, name := range names[1:] {
+			s.AddClass(name, names[0], false)
+		}
+	}
+	for _, name := range strings.Fields("java/lang/Comparable java/lang/CharSequence java/lang/Runnable java/lang/Iterable java/lang/Cloneable java/lang/AutoCloseable java/io/Closeable java/io/Serializable java/util/Collection java/util/List java/util/Set java/util/Map java/util/Map$Entry java/util/Iterator java/util/Comparator java/util/function/Function java/util/function/Supplier java/util/function/Consumer java/util/function/Predicate java/util/function/BiFunction java/util/stream/Stream") {
+		s.AddClass(name, "java/lang/Object", true)
+	}
+	return s
+}
+
+// CommonSuperclass gets the closest superclass that two reference types share, where
+// a type is a class in internal form or an array descriptor. As in the JVM's verifier,
+// interfaces are treated like java/lang/Object, so the result for an interface and any
+// other class is java/lang/Object.
+func CommonSuperclass(h ClassHierarchy, a, b string) (string, error) {
+	if a == b {
+		return a, nil
+	}
+	if strings.HasPrefix(a, "[") || strings.HasPrefix(b, "[") {
+		// Arrays share the supertypes of their components when those are references
+		if strings.HasPrefix(a, "[") && strings.HasPrefix(b, "[") {
+			ca, oka := componentClass(a)
+			cb, okb := componentClass(b)
+			if oka && okb {
+				common, err := CommonSuperclass(h, ca, cb)
+				if err != nil {
+					return "", err
+				}
+				return "[" + classDescriptor(common), nil
+			}
+		}
+		return "java/lang/Object", nil
+	}
+
+	for _, class := range []string{a, b} {
+		isInterface, err := h.IsInterface(class)
+		if err != nil {
+			return "", err
+		}
+		if isInterface {
+			return "java/lang/Object", nil
+		}
+	}
+	supers := map[string]bool{}
+	for class := a; class != ""; {
+		supers[class] = true
+		next, err := h.Superclass(class)
+		if err != nil {
+			return "", err
+		}
+		class = next
+	}
+	for class := b; class != ""; {
+		if supers[class] {
+			return class, nil
+		}
+		next, err := h.Superclass(class)
+		if err != nil {
+			return "", err
+		}
+		class = next
+	}
+	return "java/lang/Object", nil
+}
+
+// IsSubclass reports whether the class sub is the class super or extends it. Arrays are
+// subclasses of java/lang/Object, and of arrays whose component types they are
+// subclasses of.
+func IsSubclass(h ClassHierarchy, sub, super string) (bool, error) {
+	if sub == super || super == "java/lang/Object" {
+		return true, nil
+	}
+	if strings.HasPrefix(sub, "[") || strings.HasPrefix(super, "[") {
+		if strings.HasPrefix(sub, "[") && strings.HasPrefix(super, "[") {
+			cs, oks := componentClass(sub)
+			cp, okp := componentClass(super)
+			if oks && okp {
+				return IsSubclass(h, cs, cp)
+			}
+		}
+		return false, nil
+	}
+	for class := sub; class != ""; {
+		if class == super {
+			return true, nil
+		}
+		next, err := h.Superclass(class)
+		if err != nil {
+			return false, err
+		}
+		class = next
+	}
+	return false, nil
+}
+
+// componentClass gets the component type of an array as a class in internal form or
+// array descriptor, if it is a reference type.
+func componentClass(array string) (string, bool) {
+	component := array[1:]
+	switch {
+	case strings.HasPrefix(component, "["):
+		return component, true
+	case strings.HasPrefix(component, "L") && strings.HasSuffix(component, ";"):
+		return component[1 : len(component)-1], true
+	}
+	return "", false
+}
+
+// classDescriptor turns a class in internal form or an array descriptor into a field
+// descriptor.
+func classDescriptor(class string) string {
+	if strings.HasPrefix(class, "[") {
+		return class
+	}
+	return "L" + class + ";"
+}
+
+// lookupClassName gets the name of the CONSTANT_Class at index, if there is one.
+func lookupClassName(cp []CpEntry, index uint16) (string, bool) {
+	class, ok := LookupEntry(cp, index).(*CONSTANT_Class_info)
+	if !ok {
+		return "", false
+	}
+	name, ok := lookupUtf8(cp, class.NameIndex)
+	if !ok {
+		return "", false
+	}
+	return name.Value(), true
+}
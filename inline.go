@@ -0,0 +1,264 @@
+package classy
+
+import "fmt"
+
+// InlineSubroutines replaces the jsr and ret instructions of every method, which classes
+// of version 51 and above can't use, with copies of the subroutines they call. Each jsr
+// becomes an aconst_null, standing in for the return address, and a goto to a copy of
+// its subroutine, whose ret becomes a goto back to the instruction after the jsr.
+// Exception handlers, line numbers and local variables are copied with the code they
+// cover, and code that can't be reached is dropped. Every other attribute of the code is
+// dropped as well, since it may refer to the code by offset. Branches that the copies
+// put out of reach of a 16-bit offset are widened to goto_w. A ret is taken to return
+// from the subroutine whose copy it is in, as it does in the code compilers generate.
+// Methods without subroutines are left alone.
+func (cf *ClassFile) InlineSubroutines() error {
+	for i := range cf.Methods {
+		method := &cf.Methods[i]
+		attr := findAttr(method.Attrs, cf.ConstantPool, "Code")
+		if attr == nil {
+			continue
+		}
+		code, err := ReadCodeAttribute(attr.AttrData)
+		if err != nil {
+			return err
+		}
+		changed, err := inlineSubroutines(cf, method, code)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+		if attr.AttrData, err = WriteCodeAttribute(code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Upgrade makes a class of version 49 or below into one of version, which must be 50
+// or above, by inlining its subroutines and computing its frames with hierarchy (see
+// ComputeFrames).
+func (cf *ClassFile) Upgrade(version uint16, hierarchy ClassHierarchy) error {
+	if version < 50 {
+		return fmt.Errorf("can't upgrade to version %v, which doesn't use stack map frames", version)
+	}
+	if err := cf.InlineSubroutines(); err != nil {
+		return err
+	}
+	cf.MajorVersion, cf.MinorVersion = version, 0
+	return cf.ComputeFrames(hierarchy)
+}
+
+// copySite identifies an instruction of the original code in one copy of the code.
+type copySite struct {
+	copy, index int
+}
+
+// subroutineCopy is a copy of the main body or of a subroutine, made for the jsr at
+// caller.
+type subroutineCopy struct {
+	sub    int
+	caller copySite
+}
+
+func inlineSubroutines(cf *ClassFile, method *MethodInfo, code *CodeAttribute) (bool, error) {
+	a, err := newAnalyzer(cf, method, code, nil)
+	if err != nil {
+		return false, err
+	}
+	uses := false
+	for i := range a.insns {
+		if op := a.insns[i].Opcode; op == OpJsr || op == OpJsrW || op == OpRet {
+			uses = true
+		}
+	}
+	if !uses {
+		return false, nil
+	}
+	if err := a.findSubroutines(); err != nil {
+		return false, err
+	}
+
+	// Copy the main body, and then each subroutine for each jsr to it, in the order
+	// their jsr instructions were copied. A subroutine's ret returns to the instruction
+	// after the jsr that its copy was made for.
+	copies := []subroutineCopy{{sub: -1}}
+	var out []Instruction
+	var from []copySite
+	jumps := map[int]copySite{}
+	at := map[copySite]int{}
+	for n := 0; n < len(copies); n++ {
+		members := a.subs.members[copies[n].sub]
+		for i := range a.insns {
+			if !members[i] {
+				continue
+			}
+			ins := a.insns[i]
+			here := copySite{n, i}
+			at[here] = len(out)
+			switch ins.Opcode {
+			case OpJsr, OpJsrW:
+				if i+1 == len(a.insns) {
+					return false, a.errorAt(ins.Offset, "execution falls off the end of the code after the subroutine returns")
+				}
+				jumps[len(out)+1] = copySite{len(copies), a.at[ins.Target]}
+				copies = append(copies, subroutineCopy{sub: ins.Target, caller: here})
+				out = append(out, Instruction{Opcode: OpAconstNull}, Instruction{Opcode: OpGoto})
+				from = append(from, here, here)
+			case OpRet:
+				caller := copies[n].caller
+				jumps[len(out)] = copySite{caller.copy, caller.index + 1}
+				out = append(out, Instruction{Opcode: OpGoto})
+				from = append(from, here)
+			default:
+				targets, _ := successors(&ins)
+				for _, target := range targets {
+					if _, ok := a.at[target]; !ok {
+						return false, a.errorAt(ins.Offset, "branch to %v, which is not the start of an instruction", target)
+					}
+				}
+				if ins.Switch != nil {
+					s := *ins.Switch
+					s.Targets = append([]int(nil), s.Targets...)
+					ins.Switch = &s
+				}
+				out = append(out, ins)
+				from = append(from, here)
+			}
+			if len(out) > 65535 {
+				return false, a.errorAt(0, "the code is too large once its subroutines are inlined")
+			}
+		}
+	}
+
+	// relocate finds the index in out of the instruction at an original offset in copy n
+	relocate := func(n, original int) int {
+		return at[copySite{n, a.at[original]}]
+	}
+	for k := range out {
+		ins := &out[k]
+		if to, ok := jumps[k]; ok {
+			ins.Target = at[to]
+			continue
+		}
+		n := from[k].copy
+		if ins.Switch != nil {
+			ins.Switch.Default = relocate(n, ins.Switch.Default)
+			for j, target := range ins.Switch.Targets {
+				ins.Switch.Targets[j] = relocate(n, target)
+			}
+		} else if op := ins.Opcode.Operands(); op == OperandBranch || op == OperandBranchWide {
+			ins.Target = relocate(n, ins.Target)
+		}
+	}
+	bytecode, offsets, err := layoutInstructions(out)
+	if err != nil {
+		return false, a.errorAt(0, "once its subroutines are inlined: %v", err)
+	}
+	if len(bytecode) > 65535 {
+		return false, a.errorAt(0, "the code is too large once its subroutines are inlined")
+	}
+
+	// spans finds the code that the copies of the original code from start to end
+	// became, as runs of instructions in one copy
+	type span struct {
+		copy, start, end int
+	}
+	spans := func(start, end int) []span {
+		var found []span
+		for k := 0; k < len(out); {
+			offset := a.insns[from[k].index].Offset
+			if offset < start || offset >= end {
+				k++
+				continue
+			}
+			s := span{copy: from[k].copy, start: offsets[k]}
+			for k < len(out) && from[k].copy == s.copy {
+				if offset := a.insns[from[k].index].Offset; offset < start || offset >= end {
+					break
+				}
+				k++
+			}
+			s.end = offsets[k]
+			found = append(found, s)
+		}
+		return found
+	}
+
+	var handlers []ExceptionTableEntry
+	for _, handler := range code.ExceptionTable {
+		if _, ok := a.at[int(handler.HandlerPC)]; !ok {
+			return false, a.errorAt(int(handler.StartPC), "exception handler at %v, which is not the start of an instruction", handler.HandlerPC)
+		}
+		for _, s := range spans(int(handler.StartPC), int(handler.EndPC)) {
+			entry := handler
+			entry.StartPC, entry.EndPC = uint16(s.start), uint16(s.end)
+			entry.HandlerPC = uint16(offsets[relocate(s.copy, int(handler.HandlerPC))])
+			handlers = append(handlers, entry)
+		}
+	}
+
+	var attrs []AttrInfo
+	for _, attr := range code.Attrs {
+		name, ok := lookupUtf8(a.cp, attr.NameIndex)
+		if !ok {
+			continue
+		}
+		var values []uint16
+		switch name.Value() {
+		case "LineNumberTable":
+			decoded, err := attr.Decode(a.cp)
+			if err != nil {
+				return false, a.errorAt(0, "LineNumberTable: %v", err)
+			}
+			for _, line := range decoded.(*LineNumberTableAttribute).LineNumberTable {
+				i, ok := a.at[int(line.StartPC)]
+				if !ok {
+					continue
+				}
+				for n := range copies {
+					if k, ok := at[copySite{n, i}]; ok {
+						values = append(values, uint16(offsets[k]), line.LineNumber)
+					}
+				}
+			}
+		case "LocalVariableTable", "LocalVariableTypeTable":
+			decoded, err := attr.Decode(a.cp)
+			if err != nil {
+				return false, a.errorAt(0, "%v: %v", name.Value(), err)
+			}
+			var variables []LocalVariable
+			switch table := decoded.(type) {
+			case *LocalVariableTableAttribute:
+				variables = table.LocalVariableTable
+			case *LocalVariableTypeTableAttribute:
+				for _, v := range table.LocalVariableTypeTable {
+					variables = append(variables, LocalVariable{v.StartPC, v.Length, v.NameIndex, v.SignatureIndex, v.Index})
+				}
+			}
+			for _, v := range variables {
+				for _, s := range spans(int(v.StartPC), int(v.StartPC)+int(v.Length)) {
+					values = append(values, uint16(s.start), uint16(s.end-s.start), v.NameIndex, v.DescriptorIndex, v.Index)
+				}
+			}
+		default:
+			continue
+		}
+		count := len(values) / 2
+		if name.Value() != "LineNumberTable" {
+			count = len(values) / 5
+		}
+		data := u2Bytes(append([]uint16{uint16(count)}, values...)...)
+		attrs = append(attrs, AttrInfo{NameIndex: attr.NameIndex, AttrLength: uint32(len(data)), AttrData: data})
+	}
+
+	code.Code = bytecode
+	code.CodeLength = uint32(len(bytecode))
+	code.ExceptionTable = handlers
+	code.ExceptionTableLength = uint16(len(handlers))
+	code.Attrs = attrs
+	code.AttrsCount = uint16(len(attrs))
+	return true, nil
+}
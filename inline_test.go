@@ -0,0 +1,154 @@
+package classy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestInlineSubroutinesTryFinally(t *testing.T) {
+	cf := buildTryFinally(t)
+	if err := cf.InlineSubroutines(); err != nil {
+		t.Fatal(err)
+	}
+	code, insns := methodCode(t, cf, "f")
+	want := strings.Join([]string{
+		"0: iload_0",
+		"1: iconst_2",
+		"2: idiv",
+		"3: istore_0",
+		"4: aconst_null",
+		"5: goto 20",
+		"8: goto 18",
+		"11: astore_1",
+		"12: aconst_null",
+		"13: goto 31",
+		"16: aload_1",
+		"17: athrow",
+		"18: iload_0",
+		"19: ireturn",
+		"20: astore_2",
+		"21: getstatic java/lang/System.out:Ljava/io/PrintStream;",
+		"24: iload_0",
+		"25: invokevirtual java/io/PrintStream.println:(I)V",
+		"28: goto 8",
+		"31: astore_2",
+		"32: getstatic java/lang/System.out:Ljava/io/PrintStream;",
+		"35: iload_0",
+		"36: invokevirtual java/io/PrintStream.println:(I)V",
+		"39: goto 16",
+	}, "\n")
+	if got := reprs(cf.ConstantPool, insns); got != want {
+		t.Errorf("inlined code\n%v\nwant\n%v", got, want)
+	}
+	if len(code.ExceptionTable) != 1 {
+		t.Fatalf("exception table has %v entries, want 1", len(code.ExceptionTable))
+	}
+	if h := code.ExceptionTable[0]; h.StartPC != 0 || h.EndPC != 4 || h.HandlerPC != 11 || h.CatchType != 0 {
+		t.Errorf("handler covers [%v, %v) -> %v catching #%v, want [0, 4) -> 11 catching any", h.StartPC, h.EndPC, h.HandlerPC, h.CatchType)
+	}
+	checkVerify(t, "inlined", cf.Verify(nil), -1, "")
+
+	hierarchy := NewClassSet(CoreClasses)
+	if err := hierarchy.Add(cf); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.Upgrade(52, hierarchy); err != nil {
+		t.Fatal(err)
+	}
+	if cf.MajorVersion != 52 {
+		t.Errorf("upgraded to version %v, want 52", cf.MajorVersion)
+	}
+	checkVerify(t, "upgraded", cf.Verify(hierarchy), -1, "")
+}
+
+func TestInlineSubroutinesWidensBranches(t *testing.T) {
+	// Three copies of a subroutine of 15000 bytes put the return from the last out of
+	// reach of goto
+	b := NewClassBuilder(AccPublic|AccSuper, "T", "java/lang/Object")
+	m := b.AddMethod(AccPublic|AccStatic, "f", "(I)V")
+	sub, second := m.NewLabel(), m.NewLabel()
+	m.VarInsn(OpIload, 0)
+	m.JumpInsn(OpIfeq, second)
+	m.JumpInsn(OpJsr, sub)
+	m.Mark(second)
+	m.JumpInsn(OpJsr, sub)
+	m.JumpInsn(OpJsr, sub)
+	m.Insn(OpReturn)
+	m.Mark(sub)
+	m.VarInsn(OpAstore, 1)
+	nops(m, 15000)
+	m.VarInsn(OpRet, 1)
+	cf, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.InlineSubroutines(); err != nil {
+		t.Fatal(err)
+	}
+	_, insns := methodCode(t, cf, "f")
+	last := insns[len(insns)-1]
+	if last.Opcode != OpGotoW || last.Target != 16 {
+		t.Errorf("last copy returns with %v", last.Repr(cf.ConstantPool))
+	}
+	checkVerify(t, "widened", cf.Verify(nil), -1, "")
+}
+
+func TestInlineSubroutinesAttributes(t *testing.T) {
+	b := NewClassBuilder(AccPublic|AccSuper, "T", "java/lang/Object")
+	m := b.AddMethod(AccPublic|AccStatic, "f", "()V")
+	sub := m.NewLabel()
+	m.LineNumber(1)
+	m.JumpInsn(OpJsr, sub)
+	m.JumpInsn(OpJsr, sub)
+	m.Insn(OpReturn)
+	m.Mark(sub)
+	m.LineNumber(2)
+	m.VarInsn(OpAstore, 0)
+	m.VarInsn(OpRet, 0)
+	m.AddCodeAttribute("Custom", []byte{1, 2, 3})
+	plain := b.AddMethod(AccPublic|AccStatic, "g", "()V")
+	plain.Insn(OpReturn)
+	cf, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := append([]byte(nil), findAttr(cf.Methods[1].Attrs, cf.ConstantPool, "Code").AttrData...)
+	if err := cf.InlineSubroutines(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each copy of the subroutine gets its line number, and other attributes are dropped
+	code, _ := methodCode(t, cf, "f")
+	if len(code.Attrs) != 1 || code.Attrs[0].Name(cf.ConstantPool) != "LineNumberTable" {
+		t.Fatalf("code attributes are %v, want only LineNumberTable", code.Attrs)
+	}
+	decoded, err := code.Attrs[0].Decode(cf.ConstantPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []uint16
+	for _, line := range decoded.(*LineNumberTableAttribute).LineNumberTable {
+		lines = append(lines, line.StartPC, line.LineNumber)
+	}
+	if want := []uint16{0, 1, 9, 2, 13, 2}; !equalUint16s(lines, want) {
+		t.Errorf("line numbers are %v, want %v", lines, want)
+	}
+
+	after := findAttr(cf.Methods[1].Attrs, cf.ConstantPool, "Code").AttrData
+	if !bytes.Equal(before, after) {
+		t.Error("method without subroutines was changed")
+	}
+}
+
+func equalUint16s(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
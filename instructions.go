@@ -0,0 +1,537 @@
+package classy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Instruction is a single decoded bytecode instruction. Only the fields relevant to the
+// instruction's opcode are populated; branch targets are stored as absolute bytecode
+// offsets rather than the relative offsets found in the classfile.
+type Instruction struct {
+	// Offset is the position of the instruction's opcode within the method's bytecode.
+	Offset int
+	Opcode Opcode
+	// Wide is set when the instruction was prefixed with the wide opcode.
+	Wide bool
+	// Index is the constant pool index or local variable index operand.
+	Index uint16
+	// Value is the immediate operand: the bipush/sipush constant, the iinc increment,
+	// the newarray element type, the invokeinterface count or the multianewarray
+	// dimensions.
+	Value int32
+	// Target is the absolute destination of a branch instruction.
+	Target int
+	// Switch holds the jump table for tableswitch and lookupswitch.
+	Switch *Switch
+}
+
+// Switch is the jump table of a tableswitch or lookupswitch instruction. For
+// tableswitch, Targets[i] is the destination for the key Low+i and Keys is nil; for
+// lookupswitch Targets[i] is the destination for Keys[i].
+type Switch struct {
+	Default int
+	Low     int32
+	High    int32
+	Keys    []int32
+	Targets []int
+}
+
+// DecodeInstructions decodes a method's bytecode into its instructions. Failures are
+// reported as a *ParseError whose offset is the bytecode offset of the bad instruction.
+func DecodeInstructions(code []byte) ([]Instruction, error) {
+	var insns []Instruction
+	for pos := 0; pos < len(code); {
+		ins, size, err := decodeInstruction(code, pos)
+		if err != nil {
+			return nil, err
+		}
+		insns = append(insns, ins)
+		pos += size
+	}
+	return insns, nil
+}
+
+// EncodeInstructions is the inverse of DecodeInstructions. Each instruction's Offset
+// must be where the previous one ends, as it decides the padding of switches and the
+// relative offsets written for branch targets.
+func EncodeInstructions(insns []Instruction) ([]byte, error) {
+	var code []byte
+	for i := range insns {
+		ins := &insns[i]
+		if ins.Offset != len(code) {
+			return nil, fmt.Errorf("%v instruction at offset %v should be at %v", ins.Opcode, ins.Offset, len(code))
+		}
+		var err error
+		if code, err = ins.encode(code); err != nil {
+			return nil, err
+		}
+	}
+	return code, nil
+}
+
+// layoutInstructions places and encodes instructions whose branch and switch targets
+// are indexes into insns rather than offsets, len(insns) standing for the end of the
+// code. A goto or jsr that can't reach its target with a 16-bit offset becomes goto_w
+// or jsr_w, and such an if becomes the opposite if, branching over a goto_w to the
+// target. It returns the code and the offset of each instruction of insns, with one more
+// for the end of the code.
+func layoutInstructions(insns []Instruction) ([]byte, []int, error) {
+	for i := range insns {
+		ins := &insns[i]
+		targets := []int{ins.Target}
+		if ins.Switch != nil {
+			targets = append([]int{ins.Switch.Default}, ins.Switch.Targets...)
+		} else if op := ins.Opcode.Operands(); op != OperandBranch && op != OperandBranchWide {
+			continue
+		}
+		for _, target := range targets {
+			if target < 0 || target > len(insns) {
+				return nil, nil, fmt.Errorf("%v instruction %v branches to instruction %v, past the end of the code", ins.Opcode, i, target)
+			}
+		}
+	}
+
+	// Widening a branch moves the code after it, which can put other branches out of
+	// reach, so branches are widened until none are left out of reach
+	wide := make([]bool, len(insns))
+	offsets := make([]int, len(insns)+1)
+	for widened := true; widened; {
+		offset := 0
+		for i := range insns {
+			offsets[i] = offset
+			ins := insns[i]
+			ins.Offset = offset
+			switch {
+			case !wide[i]:
+				offset += ins.Size()
+			case ins.Opcode == OpGoto || ins.Opcode == OpJsr:
+				offset += 5
+			default:
+				offset += 8
+			}
+		}
+		offsets[len(insns)] = offset
+		widened = false
+		for i := range insns {
+			if insns[i].Opcode.Operands() != OperandBranch || wide[i] {
+				continue
+			}
+			if d := offsets[insns[i].Target] - offsets[i]; d < math.MinInt16 || d > math.MaxInt16 {
+				wide[i], widened = true, true
+			}
+		}
+	}
+
+	out := make([]Instruction, 0, len(insns))
+	for i := range insns {
+		ins := insns[i]
+		ins.Offset = offsets[i]
+		if ins.Switch != nil {
+			s := *ins.Switch
+			s.Default = offsets[s.Default]
+			s.Targets = make([]int, len(ins.Switch.Targets))
+			for j, target := range ins.Switch.Targets {
+				s.Targets[j] = offsets[target]
+			}
+			ins.Switch = &s
+		} else if op := ins.Opcode.Operands(); op == OperandBranch || op == OperandBranchWide {
+			ins.Target = offsets[ins.Target]
+			switch {
+			case !wide[i]:
+			case ins.Opcode == OpGoto:
+				ins.Opcode = OpGotoW
+			case ins.Opcode == OpJsr:
+				ins.Opcode = OpJsrW
+			default:
+				out = append(out, Instruction{Opcode: oppositeBranch(ins.Opcode), Offset: ins.Offset, Target: ins.Offset + 8})
+				ins = Instruction{Opcode: OpGotoW, Offset: ins.Offset + 3, Target: ins.Target}
+			}
+		}
+		out = append(out, ins)
+	}
+	code, err := EncodeInstructions(out)
+	return code, offsets, err
+}
+
+// oppositeBranch gets the if instruction that branches exactly when op doesn't.
+func oppositeBranch(op Opcode) Opcode {
+	switch {
+	case op >= OpIfeq && op <= OpIfAcmpne:
+		// The ifs come in pairs of opposites: ifeq and ifne, iflt and ifge, and so on
+		if (op-OpIfeq)%2 == 0 {
+			return op + 1
+		}
+		return op - 1
+	case op == OpIfnull:
+		return OpIfnonnull
+	case op == OpIfnonnull:
+		return OpIfnull
+	}
+	return op
+}
+
+// Size is the number of bytes the instruction occupies, which for switches depends on
+// its Offset.
+func (ins *Instruction) Size() int {
+	switch opcodeTable[ins.Opcode].operand {
+	case OperandLocal, OperandByte, OperandAtype, OperandCpByte:
+		if ins.Wide {
+			return 4
+		}
+		return 2
+	case OperandIinc:
+		if ins.Wide {
+			return 6
+		}
+		return 3
+	case OperandShort, OperandCp, OperandBranch:
+		return 3
+	case OperandMultiArray:
+		return 4
+	case OperandInterface, OperandDynamic, OperandBranchWide:
+		return 5
+	case OperandTableswitch:
+		return (ins.Offset+4)&^3 - ins.Offset + 12 + 4*len(ins.Switch.Targets)
+	case OperandLookupswitch:
+		return (ins.Offset+4)&^3 - ins.Offset + 8 + 8*len(ins.Switch.Targets)
+	default:
+		return 1
+	}
+}
+
+// encode appends the instruction to code, checking that its operands fit.
+func (ins *Instruction) encode(code []byte) ([]byte, error) {
+	u2 := func(v int) { code = append(code, byte(v>>8), byte(v)) }
+	s4 := func(v int64) { code = append(code, byte(v>>24), byte(v>>16), byte(v>>8), byte(v)) }
+	fail := func(format string, args ...interface{}) ([]byte, error) {
+		return nil, fmt.Errorf("%v at offset %v: %v", ins.Opcode, ins.Offset, fmt.Sprintf(format, args...))
+	}
+	branch := func(target int, min, max int64) bool {
+		delta := int64(target) - int64(ins.Offset)
+		return delta >= min && delta <= max
+	}
+
+	kind := opcodeTable[ins.Opcode].operand
+	if ins.Wide {
+		if kind != OperandLocal && kind != OperandIinc {
+			return fail("cannot be wide")
+		}
+		code = append(code, byte(OpWide))
+	}
+	code = append(code, byte(ins.Opcode))
+	switch kind {
+	case OperandNone:
+	case OperandLocal, OperandIinc:
+		if ins.Wide {
+			u2(int(ins.Index))
+		} else if ins.Index > math.MaxUint8 {
+			return fail("local %v needs wide", ins.Index)
+		} else {
+			code = append(code, byte(ins.Index))
+		}
+		if kind == OperandIinc {
+			if ins.Wide && ins.Value >= math.MinInt16 && ins.Value <= math.MaxInt16 {
+				u2(int(ins.Value))
+			} else if !ins.Wide && ins.Value >= math.MinInt8 && ins.Value <= math.MaxInt8 {
+				code = append(code, byte(ins.Value))
+			} else {
+				return fail("increment %v is out of range", ins.Value)
+			}
+		}
+	case OperandByte:
+		if ins.Value < math.MinInt8 || ins.Value > math.MaxInt8 {
+			return fail("value %v is out of range", ins.Value)
+		}
+		code = append(code, byte(ins.Value))
+	case OperandShort:
+		if ins.Value < math.MinInt16 || ins.Value > math.MaxInt16 {
+			return fail("value %v is out of range", ins.Value)
+		}
+		u2(int(ins.Value))
+	case OperandAtype:
+		if ins.Value < 0 || ins.Value > math.MaxUint8 {
+			return fail("array type %v is out of range", ins.Value)
+		}
+		code = append(code, byte(ins.Value))
+	case OperandCpByte:
+		if ins.Index > math.MaxUint8 {
+			return fail("constant pool index %v needs ldc_w", ins.Index)
+		}
+		code = append(code, byte(ins.Index))
+	case OperandCp:
+		u2(int(ins.Index))
+	case OperandInterface, OperandMultiArray:
+		if ins.Value < 0 || ins.Value > math.MaxUint8 {
+			return fail("count %v is out of range", ins.Value)
+		}
+		u2(int(ins.Index))
+		code = append(code, byte(ins.Value))
+		if kind == OperandInterface {
+			code = append(code, 0)
+		}
+	case OperandDynamic:
+		u2(int(ins.Index))
+		code = append(code, 0, 0)
+	case OperandBranch:
+		if !branch(ins.Target, math.MinInt16, math.MaxInt16) {
+			return fail("target %v is out of range", ins.Target)
+		}
+		u2(ins.Target - ins.Offset)
+	case OperandBranchWide:
+		if !branch(ins.Target, math.MinInt32, math.MaxInt32) {
+			return fail("target %v is out of range", ins.Target)
+		}
+		s4(int64(ins.Target - ins.Offset))
+	case OperandTableswitch, OperandLookupswitch:
+		sw := ins.Switch
+		for len(code)%4 != 0 {
+			code = append(code, 0)
+		}
+		targets := append([]int{sw.Default}, sw.Targets...)
+		for _, target := range targets {
+			if !branch(target, math.MinInt32, math.MaxInt32) {
+				return fail("target %v is out of range", target)
+			}
+		}
+		s4(int64(sw.Default - ins.Offset))
+		if kind == OperandTableswitch {
+			if int64(sw.High)-int64(sw.Low)+1 != int64(len(sw.Targets)) {
+				return fail("%v targets for keys %v to %v", len(sw.Targets), sw.Low, sw.High)
+			}
+			s4(int64(sw.Low))
+			s4(int64(sw.High))
+			for _, target := range sw.Targets {
+				s4(int64(target - ins.Offset))
+			}
+		} else {
+			if len(sw.Keys) != len(sw.Targets) {
+				return fail("%v keys for %v targets", len(sw.Keys), len(sw.Targets))
+			}
+			s4(int64(len(sw.Keys)))
+			for i, key := range sw.Keys {
+				s4(int64(key))
+				s4(int64(sw.Targets[i] - ins.Offset))
+			}
+		}
+	default:
+		return fail("not a valid opcode")
+	}
+	return code, nil
+}
+
+// decodeInstruction decodes the instruction starting at pos, returning it along with
+// the number of bytes it occupies.
+func decodeInstruction(code []byte, pos int) (Instruction, int, error) {
+	ins := Instruction{Offset: pos, Opcode: Opcode(code[pos])}
+	truncated := bytecodeError(ErrTruncated, pos, "%v instruction", ins.Opcode)
+	need := func(n int) bool { return pos+n <= len(code) }
+	u1 := func(at int) int { return int(code[at]) }
+	u2 := func(at int) int { return int(binary.BigEndian.Uint16(code[at:])) }
+	s4 := func(at int) int32 { return int32(binary.BigEndian.Uint32(code[at:])) }
+
+	switch opcodeTable[ins.Opcode].operand {
+	case OperandNone:
+		return ins, 1, nil
+	case OperandLocal:
+		if !need(2) {
+			return ins, 0, truncated
+		}
+		ins.Index = uint16(u1(pos + 1))
+		return ins, 2, nil
+	case OperandIinc:
+		if !need(3) {
+			return ins, 0, truncated
+		}
+		ins.Index = uint16(u1(pos + 1))
+		ins.Value = int32(int8(code[pos+2]))
+		return ins, 3, nil
+	case OperandByte:
+		if !need(2) {
+			return ins, 0, truncated
+		}
+		ins.Value = int32(int8(code[pos+1]))
+		return ins, 2, nil
+	case OperandShort:
+		if !need(3) {
+			return ins, 0, truncated
+		}
+		ins.Value = int32(int16(u2(pos + 1)))
+		return ins, 3, nil
+	case OperandAtype:
+		if !need(2) {
+			return ins, 0, truncated
+		}
+		ins.Value = int32(u1(pos + 1))
+		return ins, 2, nil
+	case OperandCpByte:
+		if !need(2) {
+			return ins, 0, truncated
+		}
+		ins.Index = uint16(u1(pos + 1))
+		return ins, 2, nil
+	case OperandCp:
+		if !need(3) {
+			return ins, 0, truncated
+		}
+		ins.Index = uint16(u2(pos + 1))
+		return ins, 3, nil
+	case OperandInterface:
+		if !need(5) {
+			return ins, 0, truncated
+		}
+		ins.Index = uint16(u2(pos + 1))
+		ins.Value = int32(u1(pos + 3))
+		return ins, 5, nil
+	case OperandDynamic:
+		if !need(5) {
+			return ins, 0, truncated
+		}
+		ins.Index = uint16(u2(pos + 1))
+		return ins, 5, nil
+	case OperandMultiArray:
+		if !need(4) {
+			return ins, 0, truncated
+		}
+		ins.Index = uint16(u2(pos + 1))
+		ins.Value = int32(u1(pos + 3))
+		return ins, 4, nil
+	case OperandBranch:
+		if !need(3) {
+			return ins, 0, truncated
+		}
+		ins.Target = pos + int(int16(u2(pos+1)))
+		return ins, 3, nil
+	case OperandBranchWide:
+		if !need(5) {
+			return ins, 0, truncated
+		}
+		ins.Target = pos + int(s4(pos+1))
+		return ins, 5, nil
+	case OperandTableswitch:
+		// Operands are aligned to a 4-byte boundary relative to the start of the code
+		at := (pos + 4) &^ 3
+		if !need(at - pos + 12) {
+			return ins, 0, truncated
+		}
+		sw := &Switch{Default: pos + int(s4(at)), Low: s4(at + 4), High: s4(at + 8)}
+		if sw.High < sw.Low {
+			return ins, 0, bytecodeError(ErrMalformed, pos, "tableswitch bounds %v..%v", sw.Low, sw.High)
+		}
+		count := int(int64(sw.High) - int64(sw.Low) + 1)
+		at += 12
+		if count > len(code) || !need(at-pos+4*count) {
+			return ins, 0, truncated
+		}
+		for i := 0; i < count; i++ {
+			sw.Targets = append(sw.Targets, pos+int(s4(at+4*i)))
+		}
+		ins.Switch = sw
+		return ins, at - pos + 4*count, nil
+	case OperandLookupswitch:
+		at := (pos + 4) &^ 3
+		if !need(at - pos + 8) {
+			return ins, 0, truncated
+		}
+		sw := &Switch{Default: pos + int(s4(at))}
+		npairs := s4(at + 4)
+		if npairs < 0 {
+			return ins, 0, bytecodeError(ErrMalformed, pos, "lookupswitch pair count %v", npairs)
+		}
+		at += 8
+		if int(npairs) > len(code) || !need(at-pos+8*int(npairs)) {
+			return ins, 0, truncated
+		}
+		for i := 0; i < int(npairs); i++ {
+			sw.Keys = append(sw.Keys, s4(at+8*i))
+			sw.Targets = append(sw.Targets, pos+int(s4(at+8*i+4)))
+		}
+		ins.Switch = sw
+		return ins, at - pos + 8*int(npairs), nil
+	case OperandWide:
+		if !need(2) {
+			return ins, 0, truncated
+		}
+		ins.Opcode = Opcode(code[pos+1])
+		ins.Wide = true
+		switch opcodeTable[ins.Opcode].operand {
+		case OperandLocal:
+			if !need(4) {
+				return ins, 0, truncated
+			}
+			ins.Index = uint16(u2(pos + 2))
+			return ins, 4, nil
+		case OperandIinc:
+			if !need(6) {
+				return ins, 0, truncated
+			}
+			ins.Index = uint16(u2(pos + 2))
+			ins.Value = int32(int16(u2(pos + 4)))
+			return ins, 6, nil
+		default:
+			return ins, 0, bytecodeError(ErrMalformed, pos, "wide cannot modify %v", ins.Opcode)
+		}
+	default:
+		return ins, 0, bytecodeError(ErrMalformed, pos, "unknown opcode 0x%02x", byte(ins.Opcode))
+	}
+}
+
+// bytecodeError builds a ParseError for the instruction at pos.
+func bytecodeError(kind error, pos int, format string, args ...interface{}) error {
+	return &ParseError{Offset: int64(pos), Path: "Code.code", Kind: kind, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Repr renders the instruction with its operands, resolving constant pool references
+// against the provided constant pool.
+func (ins *Instruction) Repr(cp []CpEntry) string {
+	name := ins.Opcode.String()
+	if ins.Wide {
+		name = "wide " + name
+	}
+
+	switch opcodeTable[ins.Opcode].operand {
+	case OperandLocal:
+		return fmt.Sprintf("%v %v", name, ins.Index)
+	case OperandIinc:
+		return fmt.Sprintf("%v %v, %v", name, ins.Index, ins.Value)
+	case OperandByte, OperandShort:
+		return fmt.Sprintf("%v %v", name, ins.Value)
+	case OperandAtype:
+		return fmt.Sprintf("%v %v", name, arrayTypeNames[byte(ins.Value)])
+	case OperandCpByte, OperandCp, OperandDynamic:
+		return fmt.Sprintf("%v %v", name, operandRepr(cp, ins.Index))
+	case OperandInterface:
+		return fmt.Sprintf("%v %v, %v", name, operandRepr(cp, ins.Index), ins.Value)
+	case OperandMultiArray:
+		return fmt.Sprintf("%v %v, %v", name, operandRepr(cp, ins.Index), ins.Value)
+	case OperandBranch, OperandBranchWide:
+		return fmt.Sprintf("%v %v", name, ins.Target)
+	case OperandTableswitch:
+		var cases []string
+		for i, target := range ins.Switch.Targets {
+			cases = append(cases, fmt.Sprintf("%v: %v", int64(ins.Switch.Low)+int64(i), target))
+		}
+		cases = append(cases, fmt.Sprintf("default: %v", ins.Switch.Default))
+		return fmt.Sprintf("%v { %v }", name, strings.Join(cases, ", "))
+	case OperandLookupswitch:
+		var cases []string
+		for i, target := range ins.Switch.Targets {
+			cases = append(cases, fmt.Sprintf("%v: %v", ins.Switch.Keys[i], target))
+		}
+		cases = append(cases, fmt.Sprintf("default: %v", ins.Switch.Default))
+		return fmt.Sprintf("%v { %v }", name, strings.Join(cases, ", "))
+	default:
+		return name
+	}
+}
+
+// operandRepr renders the constant pool entry referenced by an instruction operand.
+func operandRepr(cp []CpEntry, index uint16) string {
+	ent := LookupEntry(cp, index)
+	if ent == nil {
+		return fmt.Sprintf("#%v", index)
+	}
+	return ent.Repr(cp)
+}
@@ -0,0 +1,247 @@
+package classy
+
+// ModuleAttribute is the decoded form of the Module attribute of a module-info
+// classfile. It describes the module's dependencies and the packages it exports, opens,
+// and the services it uses and provides.
+type ModuleAttribute struct {
+	ModuleNameIndex    uint16
+	ModuleFlags        Access
+	ModuleVersionIndex uint16
+	RequiresCount      uint16
+	Requires           []ModuleRequires
+	ExportsCount       uint16
+	Exports            []ModuleExports
+	OpensCount         uint16
+	Opens              []ModuleOpens
+	UsesCount          uint16
+	UsesIndex          []uint16
+	ProvidesCount      uint16
+	Provides           []ModuleProvides
+}
+
+// ModuleRequires is a dependency of a module. RequiresIndex refers to a CONSTANT_Module
+// entry, and RequiresVersionIndex to an optional CONSTANT_Utf8 entry holding the
+// version of the dependency seen at compile time.
+type ModuleRequires struct {
+	RequiresIndex        uint16
+	RequiresFlags        Access
+	RequiresVersionIndex uint16
+}
+
+// ModuleExports is a package exported by a module. When ExportsToIndex is empty the
+// package is exported to every module, otherwise only to the listed CONSTANT_Module
+// entries.
+type ModuleExports struct {
+	ExportsIndex   uint16
+	ExportsFlags   Access
+	ExportsToCount uint16
+	ExportsToIndex []uint16
+}
+
+// ModuleOpens is a package opened for reflection by a module, unqualified when
+// OpensToIndex is empty.
+type ModuleOpens struct {
+	OpensIndex   uint16
+	OpensFlags   Access
+	OpensToCount uint16
+	OpensToIndex []uint16
+}
+
+// ModuleProvides is a service implemented by a module. ProvidesIndex is the
+// CONSTANT_Class entry of the service interface and ProvidesWithIndex the classes
+// implementing it.
+type ModuleProvides struct {
+	ProvidesIndex     uint16
+	ProvidesWithCount uint16
+	ProvidesWithIndex []uint16
+}
+
+// ModulePackagesAttribute lists every package of a module, including the ones that are
+// neither exported nor opened.
+type ModulePackagesAttribute struct {
+	PackageCount uint16
+	PackageIndex []uint16
+}
+
+// ModuleMainClassAttribute names the main class of a module.
+type ModuleMainClassAttribute struct {
+	MainClassIndex uint16
+}
+
+// ReadModuleAttribute decodes the contents of a Module attribute from its raw attribute
+// data.
+func ReadModuleAttribute(data []byte) (*ModuleAttribute, error) {
+	d := &decoder{data: data, path: []string{"Module"}}
+	module := readModule(d)
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return module, nil
+}
+
+func readModule(d *decoder) *ModuleAttribute {
+	module := new(ModuleAttribute)
+
+	module.ModuleNameIndex = d.u2()
+	module.ModuleFlags = Access(d.u2())
+	module.ModuleVersionIndex = d.u2()
+
+	module.RequiresCount = d.u2()
+	for i := uint16(0); d.err == nil && i < module.RequiresCount; i++ {
+		var req ModuleRequires
+		req.RequiresIndex = d.u2()
+		req.RequiresFlags = Access(d.u2())
+		req.RequiresVersionIndex = d.u2()
+		module.Requires = append(module.Requires, req)
+	}
+
+	module.ExportsCount = d.u2()
+	for i := uint16(0); d.err == nil && i < module.ExportsCount; i++ {
+		var exp ModuleExports
+		exp.ExportsIndex = d.u2()
+		exp.ExportsFlags = Access(d.u2())
+		exp.ExportsToCount = d.u2()
+		exp.ExportsToIndex = d.u2s(int(exp.ExportsToCount))
+		module.Exports = append(module.Exports, exp)
+	}
+
+	module.OpensCount = d.u2()
+	for i := uint16(0); d.err == nil && i < module.OpensCount; i++ {
+		var opens ModuleOpens
+		opens.OpensIndex = d.u2()
+		opens.OpensFlags = Access(d.u2())
+		opens.OpensToCount = d.u2()
+		opens.OpensToIndex = d.u2s(int(opens.OpensToCount))
+		module.Opens = append(module.Opens, opens)
+	}
+
+	module.UsesCount = d.u2()
+	module.UsesIndex = d.u2s(int(module.UsesCount))
+
+	module.ProvidesCount = d.u2()
+	for i := uint16(0); d.err == nil && i < module.ProvidesCount; i++ {
+		var prov ModuleProvides
+		prov.ProvidesIndex = d.u2()
+		prov.ProvidesWithCount = d.u2()
+		prov.ProvidesWithIndex = d.u2s(int(prov.ProvidesWithCount))
+		module.Provides = append(module.Provides, prov)
+	}
+
+	return module
+}
+
+// ReadModulePackagesAttribute decodes the contents of a ModulePackages attribute from
+// its raw attribute data.
+func ReadModulePackagesAttribute(data []byte) (*ModulePackagesAttribute, error) {
+	d := &decoder{data: data, path: []string{"ModulePackages"}}
+	packages := readModulePackages(d)
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return packages, nil
+}
+
+func readModulePackages(d *decoder) *ModulePackagesAttribute {
+	packages := new(ModulePackagesAttribute)
+	packages.PackageCount = d.u2()
+	packages.PackageIndex = d.u2s(int(packages.PackageCount))
+	return packages
+}
+
+// ReadModuleMainClassAttribute decodes the contents of a ModuleMainClass attribute from
+// its raw attribute data.
+func ReadModuleMainClassAttribute(data []byte) (*ModuleMainClassAttribute, error) {
+	d := &decoder{data: data, path: []string{"ModuleMainClass"}}
+	mainClass := readModuleMainClass(d)
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return mainClass, nil
+}
+
+func readModuleMainClass(d *decoder) *ModuleMainClassAttribute {
+	mainClass := new(ModuleMainClassAttribute)
+	mainClass.MainClassIndex = d.u2()
+	return mainClass
+}
+
+// Module finds and decodes the Module attribute of a module-info classfile, returning
+// nil with no error if the classfile has none.
+func (cf *ClassFile) Module() (*ModuleAttribute, error) {
+	attr := findAttr(cf.Attrs, cf.ConstantPool, "Module")
+	if attr == nil {
+		return nil, nil
+	}
+	return ReadModuleAttribute(attr.AttrData)
+}
+
+// ModulePackages finds and decodes the ModulePackages attribute, returning nil with no
+// error if the classfile has none.
+func (cf *ClassFile) ModulePackages() (*ModulePackagesAttribute, error) {
+	attr := findAttr(cf.Attrs, cf.ConstantPool, "ModulePackages")
+	if attr == nil {
+		return nil, nil
+	}
+	return ReadModulePackagesAttribute(attr.AttrData)
+}
+
+// ModuleMainClass finds and decodes the ModuleMainClass attribute, returning nil with no
+// error if the classfile has none.
+func (cf *ClassFile) ModuleMainClass() (*ModuleMainClassAttribute, error) {
+	attr := findAttr(cf.Attrs, cf.ConstantPool, "ModuleMainClass")
+	if attr == nil {
+		return nil, nil
+	}
+	return ReadModuleMainClassAttribute(attr.AttrData)
+}
+
+// Name gets the name of the module.
+func (m *ModuleAttribute) Name(cp []CpEntry) string {
+	return cpName(cp, m.ModuleNameIndex)
+}
+
+// Version gets the version of the module, or "" if it has none.
+func (m *ModuleAttribute) Version(cp []CpEntry) string {
+	return cpName(cp, m.ModuleVersionIndex)
+}
+
+// Name gets the name of the required module.
+func (r *ModuleRequires) Name(cp []CpEntry) string {
+	return cpName(cp, r.RequiresIndex)
+}
+
+// Version gets the compile-time version of the required module, or "" if it was not
+// recorded.
+func (r *ModuleRequires) Version(cp []CpEntry) string {
+	return cpName(cp, r.RequiresVersionIndex)
+}
+
+// lookupName resolves an index to the name held by a Utf8, Class, Module or Package
+// constant, if it refers to one.
+func lookupName(cp []CpEntry, index uint16) (string, bool) {
+	var nameIndex uint16
+	switch ent := LookupEntry(cp, index).(type) {
+	case *CONSTANT_Utf8_info:
+		return ent.Value(), true
+	case *CONSTANT_Class_info:
+		nameIndex = ent.NameIndex
+	case *CONSTANT_Module_info:
+		nameIndex = ent.NameIndex
+	case *CONSTANT_Package_info:
+		nameIndex = ent.NameIndex
+	default:
+		return "", false
+	}
+	utf8, ok := lookupUtf8(cp, nameIndex)
+	if !ok {
+		return "", false
+	}
+	return utf8.Value(), true
+}
+
+// cpName is lookupName for accessors, returning "" for index 0 and for indexes that
+// don't refer to a name.
+func cpName(cp []CpEntry, index uint16) string {
+	name, _ := lookupName(cp, index)
+	return name
+}
@@ -0,0 +1,100 @@
+package classy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// DecodeModifiedUTF8 decodes the "modified UTF-8" used by CONSTANT_Utf8 entries
+// (JVMS 4.4.7). It differs from standard UTF-8 in that NUL is encoded in two bytes as
+// 0xC0 0x80, and characters outside the Basic Multilingual Plane are encoded as a pair
+// of three byte surrogates rather than in four bytes.
+//
+// Unpaired surrogates, which Java strings may hold but Go strings cannot, are decoded as
+// utf8.RuneError.
+func DecodeModifiedUTF8(b []byte) (string, error) {
+	s, pos, err := decodeModifiedUTF8(b)
+	if err != nil {
+		return "", fmt.Errorf("invalid modified UTF-8 at byte %v: %v", pos, err)
+	}
+	return s, nil
+}
+
+// decodeModifiedUTF8 does the work of DecodeModifiedUTF8, returning the position of the
+// first invalid byte separately from the error.
+func decodeModifiedUTF8(b []byte) (string, int, error) {
+	var s strings.Builder
+	s.Grow(len(b))
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c == 0:
+			return "", i, errors.New("NUL must be encoded in two bytes")
+		case c < 0x80:
+			s.WriteByte(c)
+			i++
+		case c&0xE0 == 0xC0:
+			if i+1 >= len(b) || b[i+1]&0xC0 != 0x80 {
+				return "", i, errors.New("malformed two byte sequence")
+			}
+			s.WriteRune(rune(c&0x1F)<<6 | rune(b[i+1]&0x3F))
+			i += 2
+		case c&0xF0 == 0xE0:
+			r, ok := decodeThreeBytes(b, i)
+			if !ok {
+				return "", i, errors.New("malformed three byte sequence")
+			}
+			i += 3
+			if utf16.IsSurrogate(r) {
+				if low, ok := decodeThreeBytes(b, i); ok && r < 0xDC00 && low >= 0xDC00 && low <= 0xDFFF {
+					r = utf16.DecodeRune(r, low)
+					i += 3
+				} else {
+					r = utf8.RuneError
+				}
+			}
+			s.WriteRune(r)
+		default:
+			return "", i, fmt.Errorf("unexpected byte 0x%02x", c)
+		}
+	}
+	return s.String(), 0, nil
+}
+
+// decodeThreeBytes decodes the three byte sequence at b[i:], reporting false if there
+// isn't one.
+func decodeThreeBytes(b []byte, i int) (rune, bool) {
+	if i+2 >= len(b) || b[i]&0xF0 != 0xE0 || b[i+1]&0xC0 != 0x80 || b[i+2]&0xC0 != 0x80 {
+		return 0, false
+	}
+	return rune(b[i]&0x0F)<<12 | rune(b[i+1]&0x3F)<<6 | rune(b[i+2]&0x3F), true
+}
+
+// EncodeModifiedUTF8 encodes a string in the modified UTF-8 used by CONSTANT_Utf8
+// entries. Invalid UTF-8 in s is encoded as U+FFFD.
+func EncodeModifiedUTF8(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == 0:
+			b = append(b, 0xC0, 0x80)
+		case r < 0x80:
+			b = append(b, byte(r))
+		case r < 0x800:
+			b = append(b, 0xC0|byte(r>>6), 0x80|byte(r&0x3F))
+		case r < 0x10000:
+			b = appendThreeBytes(b, r)
+		default:
+			high, low := utf16.EncodeRune(r)
+			b = appendThreeBytes(appendThreeBytes(b, high), low)
+		}
+	}
+	return b
+}
+
+func appendThreeBytes(b []byte, r rune) []byte {
+	return append(b, 0xE0|byte(r>>12), 0x80|byte(r>>6&0x3F), 0x80|byte(r&0x3F))
+}
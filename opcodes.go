@@ -0,0 +1,510 @@
+package classy
+
+import "fmt"
+
+// Opcode is the 1-byte operation code that begins every JVM instruction.
+type Opcode byte
+
+const (
+	OpNop             Opcode = 0x00
+	OpAconstNull      Opcode = 0x01
+	OpIconstM1        Opcode = 0x02
+	OpIconst0         Opcode = 0x03
+	OpIconst1         Opcode = 0x04
+	OpIconst2         Opcode = 0x05
+	OpIconst3         Opcode = 0x06
+	OpIconst4         Opcode = 0x07
+	OpIconst5         Opcode = 0x08
+	OpLconst0         Opcode = 0x09
+	OpLconst1         Opcode = 0x0a
+	OpFconst0         Opcode = 0x0b
+	OpFconst1         Opcode = 0x0c
+	OpFconst2         Opcode = 0x0d
+	OpDconst0         Opcode = 0x0e
+	OpDconst1         Opcode = 0x0f
+	OpBipush          Opcode = 0x10
+	OpSipush          Opcode = 0x11
+	OpLdc             Opcode = 0x12
+	OpLdcW            Opcode = 0x13
+	OpLdc2W           Opcode = 0x14
+	OpIload           Opcode = 0x15
+	OpLload           Opcode = 0x16
+	OpFload           Opcode = 0x17
+	OpDload           Opcode = 0x18
+	OpAload           Opcode = 0x19
+	OpIload0          Opcode = 0x1a
+	OpIload1          Opcode = 0x1b
+	OpIload2          Opcode = 0x1c
+	OpIload3          Opcode = 0x1d
+	OpLload0          Opcode = 0x1e
+	OpLload1          Opcode = 0x1f
+	OpLload2          Opcode = 0x20
+	OpLload3          Opcode = 0x21
+	OpFload0          Opcode = 0x22
+	OpFload1          Opcode = 0x23
+	OpFload2          Opcode = 0x24
+	OpFload3          Opcode = 0x25
+	OpDload0          Opcode = 0x26
+	OpDload1          Opcode = 0x27
+	OpDload2          Opcode = 0x28
+	OpDload3          Opcode = 0x29
+	OpAload0          Opcode = 0x2a
+	OpAload1          Opcode = 0x2b
+	OpAload2          Opcode = 0x2c
+	OpAload3          Opcode = 0x2d
+	OpIaload          Opcode = 0x2e
+	OpLaload          Opcode = 0x2f
+	OpFaload          Opcode = 0x30
+	OpDaload          Opcode = 0x31
+	OpAaload          Opcode = 0x32
+	OpBaload          Opcode = 0x33
+	OpCaload          Opcode = 0x34
+	OpSaload          Opcode = 0x35
+	OpIstore          Opcode = 0x36
+	OpLstore          Opcode = 0x37
+	OpFstore          Opcode = 0x38
+	OpDstore          Opcode = 0x39
+	OpAstore          Opcode = 0x3a
+	OpIstore0         Opcode = 0x3b
+	OpIstore1         Opcode = 0x3c
+	OpIstore2         Opcode = 0x3d
+	OpIstore3         Opcode = 0x3e
+	OpLstore0         Opcode = 0x3f
+	OpLstore1         Opcode = 0x40
+	OpLstore2         Opcode = 0x41
+	OpLstore3         Opcode = 0x42
+	OpFstore0         Opcode = 0x43
+	OpFstore1         Opcode = 0x44
+	OpFstore2         Opcode = 0x45
+	OpFstore3         Opcode = 0x46
+	OpDstore0         Opcode = 0x47
+	OpDstore1         Opcode = 0x48
+	OpDstore2         Opcode = 0x49
+	OpDstore3         Opcode = 0x4a
+	OpAstore0         Opcode = 0x4b
+	OpAstore1         Opcode = 0x4c
+	OpAstore2         Opcode = 0x4d
+	OpAstore3         Opcode = 0x4e
+	OpIastore         Opcode = 0x4f
+	OpLastore         Opcode = 0x50
+	OpFastore         Opcode = 0x51
+	OpDastore         Opcode = 0x52
+	OpAastore         Opcode = 0x53
+	OpBastore         Opcode = 0x54
+	OpCastore         Opcode = 0x55
+	OpSastore         Opcode = 0x56
+	OpPop             Opcode = 0x57
+	OpPop2            Opcode = 0x58
+	OpDup             Opcode = 0x59
+	OpDupX1           Opcode = 0x5a
+	OpDupX2           Opcode = 0x5b
+	OpDup2            Opcode = 0x5c
+	OpDup2X1          Opcode = 0x5d
+	OpDup2X2          Opcode = 0x5e
+	OpSwap            Opcode = 0x5f
+	OpIadd            Opcode = 0x60
+	OpLadd            Opcode = 0x61
+	OpFadd            Opcode = 0x62
+	OpDadd            Opcode = 0x63
+	OpIsub            Opcode = 0x64
+	OpLsub            Opcode = 0x65
+	OpFsub            Opcode = 0x66
+	OpDsub            Opcode = 0x67
+	OpImul            Opcode = 0x68
+	OpLmul            Opcode = 0x69
+	OpFmul            Opcode = 0x6a
+	OpDmul            Opcode = 0x6b
+	OpIdiv            Opcode = 0x6c
+	OpLdiv            Opcode = 0x6d
+	OpFdiv            Opcode = 0x6e
+	OpDdiv            Opcode = 0x6f
+	OpIrem            Opcode = 0x70
+	OpLrem            Opcode = 0x71
+	OpFrem            Opcode = 0x72
+	OpDrem            Opcode = 0x73
+	OpIneg            Opcode = 0x74
+	OpLneg            Opcode = 0x75
+	OpFneg            Opcode = 0x76
+	OpDneg            Opcode = 0x77
+	OpIshl            Opcode = 0x78
+	OpLshl            Opcode = 0x79
+	OpIshr            Opcode = 0x7a
+	OpLshr            Opcode = 0x7b
+	OpIushr           Opcode = 0x7c
+	OpLushr           Opcode = 0x7d
+	OpIand            Opcode = 0x7e
+	OpLand            Opcode = 0x7f
+	OpIor             Opcode = 0x80
+	OpLor             Opcode = 0x81
+	OpIxor            Opcode = 0x82
+	OpLxor            Opcode = 0x83
+	OpIinc            Opcode = 0x84
+	OpI2l             Opcode = 0x85
+	OpI2f             Opcode = 0x86
+	OpI2d             Opcode = 0x87
+	OpL2i             Opcode = 0x88
+	OpL2f             Opcode = 0x89
+	OpL2d             Opcode = 0x8a
+	OpF2i             Opcode = 0x8b
+	OpF2l             Opcode = 0x8c
+	OpF2d             Opcode = 0x8d
+	OpD2i             Opcode = 0x8e
+	OpD2l             Opcode = 0x8f
+	OpD2f             Opcode = 0x90
+	OpI2b             Opcode = 0x91
+	OpI2c             Opcode = 0x92
+	OpI2s             Opcode = 0x93
+	OpLcmp            Opcode = 0x94
+	OpFcmpl           Opcode = 0x95
+	OpFcmpg           Opcode = 0x96
+	OpDcmpl           Opcode = 0x97
+	OpDcmpg           Opcode = 0x98
+	OpIfeq            Opcode = 0x99
+	OpIfne            Opcode = 0x9a
+	OpIflt            Opcode = 0x9b
+	OpIfge            Opcode = 0x9c
+	OpIfgt            Opcode = 0x9d
+	OpIfle            Opcode = 0x9e
+	OpIfIcmpeq        Opcode = 0x9f
+	OpIfIcmpne        Opcode = 0xa0
+	OpIfIcmplt        Opcode = 0xa1
+	OpIfIcmpge        Opcode = 0xa2
+	OpIfIcmpgt        Opcode = 0xa3
+	OpIfIcmple        Opcode = 0xa4
+	OpIfAcmpeq        Opcode = 0xa5
+	OpIfAcmpne        Opcode = 0xa6
+	OpGoto            Opcode = 0xa7
+	OpJsr             Opcode = 0xa8
+	OpRet             Opcode = 0xa9
+	OpTableswitch     Opcode = 0xaa
+	OpLookupswitch    Opcode = 0xab
+	OpIreturn         Opcode = 0xac
+	OpLreturn         Opcode = 0xad
+	OpFreturn         Opcode = 0xae
+	OpDreturn         Opcode = 0xaf
+	OpAreturn         Opcode = 0xb0
+	OpReturn          Opcode = 0xb1
+	OpGetstatic       Opcode = 0xb2
+	OpPutstatic       Opcode = 0xb3
+	OpGetfield        Opcode = 0xb4
+	OpPutfield        Opcode = 0xb5
+	OpInvokevirtual   Opcode = 0xb6
+	OpInvokespecial   Opcode = 0xb7
+	OpInvokestatic    Opcode = 0xb8
+	OpInvokeinterface Opcode = 0xb9
+	OpInvokedynamic   Opcode = 0xba
+	OpNew             Opcode = 0xbb
+	OpNewarray        Opcode = 0xbc
+	OpAnewarray       Opcode = 0xbd
+	OpArraylength     Opcode = 0xbe
+	OpAthrow          Opcode = 0xbf
+	OpCheckcast       Opcode = 0xc0
+	OpInstanceof      Opcode = 0xc1
+	OpMonitorenter    Opcode = 0xc2
+	OpMonitorexit     Opcode = 0xc3
+	OpWide            Opcode = 0xc4
+	OpMultianewarray  Opcode = 0xc5
+	OpIfnull          Opcode = 0xc6
+	OpIfnonnull       Opcode = 0xc7
+	OpGotoW           Opcode = 0xc8
+	OpJsrW            Opcode = 0xc9
+)
+
+// OperandKind describes the layout of the operand bytes that follow an opcode.
+type OperandKind byte
+
+const (
+	OperandNone         OperandKind = iota
+	OperandLocal                    // u1 local variable index, u2 when wide
+	OperandIinc                     // u1 local index and s1 increment, u2/s2 when wide
+	OperandByte                     // s1 immediate (bipush)
+	OperandShort                    // s2 immediate (sipush)
+	OperandAtype                    // u1 array type (newarray)
+	OperandCpByte                   // u1 constant pool index (ldc)
+	OperandCp                       // u2 constant pool index
+	OperandInterface                // u2 constant pool index, u1 count, u1 zero
+	OperandDynamic                  // u2 constant pool index, two zero bytes
+	OperandMultiArray               // u2 constant pool index, u1 dimensions
+	OperandBranch                   // s2 branch offset
+	OperandBranchWide               // s4 branch offset
+	OperandTableswitch              // padded jump table
+	OperandLookupswitch             // padded match/offset pairs
+	OperandWide                     // prefix modifying the following instruction
+	OperandInvalid                  // unassigned opcode
+)
+
+type opcodeInfo struct {
+	name    string
+	operand OperandKind
+}
+
+var (
+	opcodeTable   [256]opcodeInfo
+	opcodesByName = map[string]Opcode{}
+)
+
+func init() {
+	for i := range opcodeTable {
+		opcodeTable[i] = opcodeInfo{"", OperandInvalid}
+	}
+	for op, info := range map[Opcode]opcodeInfo{
+		OpNop:             {"nop", OperandNone},
+		OpAconstNull:      {"aconst_null", OperandNone},
+		OpIconstM1:        {"iconst_m1", OperandNone},
+		OpIconst0:         {"iconst_0", OperandNone},
+		OpIconst1:         {"iconst_1", OperandNone},
+		OpIconst2:         {"iconst_2", OperandNone},
+		OpIconst3:         {"iconst_3", OperandNone},
+		OpIconst4:         {"iconst_4", OperandNone},
+		OpIconst5:         {"iconst_5", OperandNone},
+		OpLconst0:         {"lconst_0", OperandNone},
+		OpLconst1:         {"lconst_1", OperandNone},
+		OpFconst0:         {"fconst_0", OperandNone},
+		OpFconst1:         {"fconst_1", OperandNone},
+		OpFconst2:         {"fconst_2", OperandNone},
+		OpDconst0:         {"dconst_0", OperandNone},
+		OpDconst1:         {"dconst_1", OperandNone},
+		OpBipush:          {"bipush", OperandByte},
+		OpSipush:          {"sipush", OperandShort},
+		OpLdc:             {"ldc", OperandCpByte},
+		OpLdcW:            {"ldc_w", OperandCp},
+		OpLdc2W:           {"ldc2_w", OperandCp},
+		OpIload:           {"iload", OperandLocal},
+		OpLload:           {"lload", OperandLocal},
+		OpFload:           {"fload", OperandLocal},
+		OpDload:           {"dload", OperandLocal},
+		OpAload:           {"aload", OperandLocal},
+		OpIload0:          {"iload_0", OperandNone},
+		OpIload1:          {"iload_1", OperandNone},
+		OpIload2:          {"iload_2", OperandNone},
+		OpIload3:          {"iload_3", OperandNone},
+		OpLload0:          {"lload_0", OperandNone},
+		OpLload1:          {"lload_1", OperandNone},
+		OpLload2:          {"lload_2", OperandNone},
+		OpLload3:          {"lload_3", OperandNone},
+		OpFload0:          {"fload_0", OperandNone},
+		OpFload1:          {"fload_1", OperandNone},
+		OpFload2:          {"fload_2", OperandNone},
+		OpFload3:          {"fload_3", OperandNone},
+		OpDload0:          {"dload_0", OperandNone},
+		OpDload1:          {"dload_1", OperandNone},
+		OpDload2:          {"dload_2", OperandNone},
+		OpDload3:          {"dload_3", OperandNone},
+		OpAload0:          {"aload_0", OperandNone},
+		OpAload1:          {"aload_1", OperandNone},
+		OpAload2:          {"aload_2", OperandNone},
+		OpAload3:          {"aload_3", OperandNone},
+		OpIaload:          {"iaload", OperandNone},
+		OpLaload:          {"laload", OperandNone},
+		OpFaload:          {"faload", OperandNone},
+		OpDaload:          {"daload", OperandNone},
+		OpAaload:          {"aaload", OperandNone},
+		OpBaload:          {"baload", OperandNone},
+		OpCaload:          {"caload", OperandNone},
+		OpSaload:          {"saload", OperandNone},
+		OpIstore:          {"istore", OperandLocal},
+		OpLstore:          {"lstore", OperandLocal},
+		OpFstore:          {"fstore", OperandLocal},
+		OpDstore:          {"dstore", OperandLocal},
+		OpAstore:          {"astore", OperandLocal},
+		OpIstore0:         {"istore_0", OperandNone},
+		OpIstore1:         {"istore_1", OperandNone},
+		OpIstore2:         {"istore_2", OperandNone},
+		OpIstore3:         {"istore_3", OperandNone},
+		OpLstore0:         {"lstore_0", OperandNone},
+		OpLstore1:         {"lstore_1", OperandNone},
+		OpLstore2:         {"lstore_2", OperandNone},
+		OpLstore3:         {"lstore_3", OperandNone},
+		OpFstore0:         {"fstore_0", OperandNone},
+		OpFstore1:         {"fstore_1", OperandNone},
+		OpFstore2:         {"fstore_2", OperandNone},
+		OpFstore3:         {"fstore_3", OperandNone},
+		OpDstore0:         {"dstore_0", OperandNone},
+		OpDstore1:         {"dstore_1", OperandNone},
+		OpDstore2:         {"dstore_2", OperandNone},
+		OpDstore3:         {"dstore_3", OperandNone},
+		OpAstore0:         {"astore_0", OperandNone},
+		OpAstore1:         {"astore_1", OperandNone},
+		OpAstore2:         {"astore_2", OperandNone},
+		OpAstore3:         {"astore_3", OperandNone},
+		OpIastore:         {"iastore", OperandNone},
+		OpLastore:         {"lastore", OperandNone},
+		OpFastore:         {"fastore", OperandNone},
+		OpDastore:         {"dastore", OperandNone},
+		OpAastore:         {"aastore", OperandNone},
+		OpBastore:         {"bastore", OperandNone},
+		OpCastore:         {"castore", OperandNone},
+		OpSastore:         {"sastore", OperandNone},
+		OpPop:             {"pop", OperandNone},
+		OpPop2:            {"pop2", OperandNone},
+		OpDup:             {"dup", OperandNone},
+		OpDupX1:           {"dup_x1", OperandNone},
+		OpDupX2:           {"dup_x2", OperandNone},
+		OpDup2:            {"dup2", OperandNone},
+		OpDup2X1:          {"dup2_x1", OperandNone},
+		OpDup2X2:          {"dup2_x2", OperandNone},
+		OpSwap:            {"swap", OperandNone},
+		OpIadd:            {"iadd", OperandNone},
+		OpLadd:            {"ladd", OperandNone},
+		OpFadd:            {"fadd", OperandNone},
+		OpDadd:            {"dadd", OperandNone},
+		OpIsub:            {"isub", OperandNone},
+		OpLsub:            {"lsub", OperandNone},
+		OpFsub:            {"fsub", OperandNone},
+		OpDsub:            {"dsub", OperandNone},
+		OpImul:            {"imul", OperandNone},
+		OpLmul:            {"lmul", OperandNone},
+		OpFmul:            {"fmul", OperandNone},
+		OpDmul:            {"dmul", OperandNone},
+		OpIdiv:            {"idiv", OperandNone},
+		OpLdiv:            {"ldiv", OperandNone},
+		OpFdiv:            {"fdiv", OperandNone},
+		OpDdiv:            {"ddiv", OperandNone},
+		OpIrem:            {"irem", OperandNone},
+		OpLrem:            {"lrem", OperandNone},
+		OpFrem:            {"frem", OperandNone},
+		OpDrem:            {"drem", OperandNone},
+		OpIneg:            {"ineg", OperandNone},
+		OpLneg:            {"lneg", OperandNone},
+		OpFneg:            {"fneg", OperandNone},
+		OpDneg:            {"dneg", OperandNone},
+		OpIshl:            {"ishl", OperandNone},
+		OpLshl:            {"lshl", OperandNone},
+		OpIshr:            {"ishr", OperandNone},
+		OpLshr:            {"lshr", OperandNone},
+		OpIushr:           {"iushr", OperandNone},
+		OpLushr:           {"lushr", OperandNone},
+		OpIand:            {"iand", OperandNone},
+		OpLand:            {"land", OperandNone},
+		OpIor:             {"ior", OperandNone},
+		OpLor:             {"lor", OperandNone},
+		OpIxor:            {"ixor", OperandNone},
+		OpLxor:            {"lxor", OperandNone},
+		OpIinc:            {"iinc", OperandIinc},
+		OpI2l:             {"i2l", OperandNone},
+		OpI2f:             {"i2f", OperandNone},
+		OpI2d:             {"i2d", OperandNone},
+		OpL2i:             {"l2i", OperandNone},
+		OpL2f:             {"l2f", OperandNone},
+		OpL2d:             {"l2d", OperandNone},
+		OpF2i:             {"f2i", OperandNone},
+		OpF2l:             {"f2l", OperandNone},
+		OpF2d:             {"f2d", OperandNone},
+		OpD2i:             {"d2i", OperandNone},
+		OpD2l:             {"d2l", OperandNone},
+		OpD2f:             {"d2f", OperandNone},
+		OpI2b:             {"i2b", OperandNone},
+		OpI2c:             {"i2c", OperandNone},
+		OpI2s:             {"i2s", OperandNone},
+		OpLcmp:            {"lcmp", OperandNone},
+		OpFcmpl:           {"fcmpl", OperandNone},
+		OpFcmpg:           {"fcmpg", OperandNone},
+		OpDcmpl:           {"dcmpl", OperandNone},
+		OpDcmpg:           {"dcmpg", OperandNone},
+		OpIfeq:            {"ifeq", OperandBranch},
+		OpIfne:            {"ifne", OperandBranch},
+		OpIflt:            {"iflt", OperandBranch},
+		OpIfge:            {"ifge", OperandBranch},
+		OpIfgt:            {"ifgt", OperandBranch},
+		OpIfle:            {"ifle", OperandBranch},
+		OpIfIcmpeq:        {"if_icmpeq", OperandBranch},
+		OpIfIcmpne:        {"if_icmpne", OperandBranch},
+		OpIfIcmplt:        {"if_icmplt", OperandBranch},
+		OpIfIcmpge:        {"if_icmpge", OperandBranch},
+		OpIfIcmpgt:        {"if_icmpgt", OperandBranch},
+		OpIfIcmple:        {"if_icmple", OperandBranch},
+		OpIfAcmpeq:        {"if_acmpeq", OperandBranch},
+		OpIfAcmpne:        {"if_acmpne", OperandBranch},
+		OpGoto:            {"goto", OperandBranch},
+		OpJsr:             {"jsr", OperandBranch},
+		OpRet:             {"ret", OperandLocal},
+		OpTableswitch:     {"tableswitch", OperandTableswitch},
+		OpLookupswitch:    {"lookupswitch", OperandLookupswitch},
+		OpIreturn:         {"ireturn", OperandNone},
+		OpLreturn:         {"lreturn", OperandNone},
+		OpFreturn:         {"freturn", OperandNone},
+		OpDreturn:         {"dreturn", OperandNone},
+		OpAreturn:         {"areturn", OperandNone},
+		OpReturn:          {"return", OperandNone},
+		OpGetstatic:       {"getstatic", OperandCp},
+		OpPutstatic:       {"putstatic", OperandCp},
+		OpGetfield:        {"getfield", OperandCp},
+		OpPutfield:        {"putfield", OperandCp},
+		OpInvokevirtual:   {"invokevirtual", OperandCp},
+		OpInvokespecial:   {"invokespecial", OperandCp},
+		OpInvokestatic:    {"invokestatic", OperandCp},
+		OpInvokeinterface: {"invokeinterface", OperandInterface},
+		OpInvokedynamic:   {"invokedynamic", OperandDynamic},
+		OpNew:             {"new", OperandCp},
+		OpNewarray:        {"newarray", OperandAtype},
+		OpAnewarray:       {"anewarray", OperandCp},
+		OpArraylength:     {"arraylength", OperandNone},
+		OpAthrow:          {"athrow", OperandNone},
+		OpCheckcast:       {"checkcast", OperandCp},
+		OpInstanceof:      {"instanceof", OperandCp},
+		OpMonitorenter:    {"monitorenter", OperandNone},
+		OpMonitorexit:     {"monitorexit", OperandNone},
+		OpWide:            {"wide", OperandWide},
+		OpMultianewarray:  {"multianewarray", OperandMultiArray},
+		OpIfnull:          {"ifnull", OperandBranch},
+		OpIfnonnull:       {"ifnonnull", OperandBranch},
+		OpGotoW:           {"goto_w", OperandBranchWide},
+		OpJsrW:            {"jsr_w", OperandBranchWide},
+	} {
+		opcodeTable[op] = info
+		opcodesByName[info.name] = op
+	}
+}
+
+// String returns the mnemonic for the opcode as it appears in the JVM specification.
+func (op Opcode) String() string {
+	if name := opcodeTable[op].name; name != "" {
+		return name
+	}
+	return fmt.Sprintf("<illegal 0x%02x>", byte(op))
+}
+
+// LookupOpcode finds the opcode with the given mnemonic, e.g. "invokevirtual".
+func LookupOpcode(name string) (Opcode, bool) {
+	op, ok := opcodesByName[name]
+	return op, ok
+}
+
+// Valid reports whether the opcode is assigned by the JVM specification.
+func (op Opcode) Valid() bool {
+	return opcodeTable[op].operand != OperandInvalid
+}
+
+// Operands reports the layout of the operands that follow the opcode in bytecode.
+func (op Opcode) Operands() OperandKind {
+	return opcodeTable[op].operand
+}
+
+// Array type codes used as the operand of the newarray instruction.
+const (
+	TBoolean byte = 4
+	TChar    byte = 5
+	TFloat   byte = 6
+	TDouble  byte = 7
+	TByte    byte = 8
+	TShort   byte = 9
+	TInt     byte = 10
+	TLong    byte = 11
+)
+
+var arrayTypeNames = map[byte]string{
+	TBoolean: "boolean",
+	TChar:    "char",
+	TFloat:   "float",
+	TDouble:  "double",
+	TByte:    "byte",
+	TShort:   "short",
+	TInt:     "int",
+	TLong:    "long",
+}
+
+// ArrayTypeName gets the element type created by a newarray instruction with the given
+// operand, e.g. "int", or "" if the operand is not a valid array type.
+func ArrayTypeName(atype byte) string {
+	return arrayTypeNames[atype]
+}
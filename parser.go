@@ -1,210 +1,393 @@
 package classy
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
-	"io"
+	"strings"
 )
 
 const ClassFileMagic uint32 = 0xCAFEBABE
 
-// Deserialize a classfile from its raw byte representation into a ClassFile.
-func ReadClassFile(raw []byte) (classFile *ClassFile, err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			classFile = nil
-			err = e.(error)
-		}
-	}()
-	classFile = new(ClassFile)
-	err = nil
-
-	reader := bytes.NewReader(raw)
-	safeReadBinary(reader, binary.BigEndian, &classFile.Magic)
+// Deserialize a classfile from its raw byte representation into a ClassFile. Failures
+// are reported as a *ParseError.
+func ReadClassFile(raw []byte) (*ClassFile, error) {
+	classFile := new(ClassFile)
+	d := &decoder{data: raw}
 
-	if classFile.Magic != ClassFileMagic {
-		panic(fmt.Errorf("Invalid magic: 0x%X", classFile.Magic))
+	classFile.Magic = d.u4()
+	if d.err == nil && classFile.Magic != ClassFileMagic {
+		d.failAt(0, ErrBadMagic, "0x%X", classFile.Magic)
 	}
 
-	safeReadBinary(reader, binary.BigEndian, &classFile.MinorVersion)
-	safeReadBinary(reader, binary.BigEndian, &classFile.MajorVersion)
-	safeReadBinary(reader, binary.BigEndian, &classFile.ConstantPoolCount)
+	classFile.MinorVersion = d.u2()
+	classFile.MajorVersion = d.u2()
+	classFile.ConstantPoolCount = d.u2()
+	if d.err == nil && classFile.ConstantPoolCount == 0 {
+		d.fail(ErrMalformed, "constant_pool_count must be at least 1")
+	}
 
-	// Read constant pool entries
-	for i := uint16(1); i <= classFile.ConstantPoolCount-1; i++ {
-		ent := readCpEntry(reader)
+	// Read constant pool entries, remembering where each starts so that tags can be
+	// checked against the version and access flags once those are known
+	var cpOffsets []int
+	for i := uint16(1); d.err == nil && i <= classFile.ConstantPoolCount-1; i++ {
+		cpOffsets = append(cpOffsets, d.pos)
+		d.push(fmt.Sprintf("constant_pool[%v]", i))
+		ent := readCpEntry(d)
+		d.pop()
 		classFile.ConstantPool = append(classFile.ConstantPool, ent)
 		// Check to see if the entry is one of the 8-byte varieties
 		// If so, we skip a slot
 		if _, ok := ent.(*CONSTANT_Double_info); ok {
 			classFile.ConstantPool = append(classFile.ConstantPool, nil)
+			cpOffsets = append(cpOffsets, -1)
 			i++
 		}
 		if _, ok := ent.(*CONSTANT_Long_info); ok {
 			classFile.ConstantPool = append(classFile.ConstantPool, nil)
+			cpOffsets = append(cpOffsets, -1)
 			i++
 		}
 	}
+	d.cp = classFile.ConstantPool
 
-	safeReadBinary(reader, binary.BigEndian, &classFile.AccessFlags)
-	safeReadBinary(reader, binary.BigEndian, &classFile.ThisClass)
-	safeReadBinary(reader, binary.BigEndian, &classFile.SuperClass)
-	safeReadBinary(reader, binary.BigEndian, &classFile.InterfacesCount)
-
-	classFile.Interfaces = make([]uint16, classFile.InterfacesCount)
-	for i := uint16(0); i < classFile.InterfacesCount; i++ {
-		safeReadBinary(reader, binary.BigEndian, &classFile.Interfaces[i])
+	classFile.AccessFlags = Access(d.u2())
+	for i, ent := range classFile.ConstantPool {
+		if d.err != nil || ent == nil {
+			continue
+		}
+		if err := CheckConstantTag(ent.RawTag(), classFile.MajorVersion, classFile.AccessFlags); err != nil {
+			d.push(fmt.Sprintf("constant_pool[%v]", i+1))
+			d.failAt(cpOffsets[i], ErrBadTag, "%v", err)
+			d.pop()
+		}
 	}
 
-	safeReadBinary(reader, binary.BigEndian, &classFile.FieldsCount)
-	for i := uint16(0); i < classFile.FieldsCount; i++ {
-		classFile.Fields = append(classFile.Fields, readField(reader))
+	classFile.ThisClass = d.u2()
+	classFile.SuperClass = d.u2()
+	classFile.InterfacesCount = d.u2()
+
+	classFile.Interfaces = make([]uint16, 0, classFile.InterfacesCount)
+	for i := uint16(0); d.err == nil && i < classFile.InterfacesCount; i++ {
+		classFile.Interfaces = append(classFile.Interfaces, d.u2())
 	}
 
-	safeReadBinary(reader, binary.BigEndian, &classFile.MethodsCount)
-	for i := uint16(0); i < classFile.MethodsCount; i++ {
-		classFile.Methods = append(classFile.Methods, readMethod(reader))
+	classFile.FieldsCount = d.u2()
+	for i := uint16(0); d.err == nil && i < classFile.FieldsCount; i++ {
+		d.push(fmt.Sprintf("field[%v]", i))
+		classFile.Fields = append(classFile.Fields, readField(d))
+		d.pop()
 	}
 
-	safeReadBinary(reader, binary.BigEndian, &classFile.AttrsCount)
-	for i := uint16(0); i < classFile.AttrsCount; i++ {
-		classFile.Attrs = append(classFile.Attrs, readAttr(reader))
+	classFile.MethodsCount = d.u2()
+	for i := uint16(0); d.err == nil && i < classFile.MethodsCount; i++ {
+		d.push(fmt.Sprintf("method[%v]", i))
+		classFile.Methods = append(classFile.Methods, readMethod(d))
+		d.pop()
 	}
 
-	return
+	classFile.AttrsCount = d.u2()
+	classFile.Attrs = readAttrs(d, classFile.AttrsCount)
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	classFile.trailing = len(raw) - d.pos
+	return classFile, nil
 }
 
 // Read a constant pool entry from the classfile
-func readCpEntry(reader *bytes.Reader) CpEntry {
-	var tag ConstantTag
-	safeReadBinary(reader, binary.BigEndian, &tag)
-	//fmt.Printf("tag %v\n", tag)
+func readCpEntry(d *decoder) CpEntry {
+	start := d.pos
+	tag := ConstantTag(d.u1())
 	switch tag {
 	case CONSTANT_Class:
 		var info CONSTANT_Class_info
 		info.Tag = tag
-		safeReadBinary(reader, binary.BigEndian, &info.NameIndex)
+		info.NameIndex = d.u2()
 		return &info
 	case CONSTANT_Fieldref:
 		var info CONSTANT_Fieldref_info
 		info.Tag = tag
-		safeReadBinary(reader, binary.BigEndian, &info.ClassIndex)
-		safeReadBinary(reader, binary.BigEndian, &info.NameAndTypeIndex)
+		info.ClassIndex = d.u2()
+		info.NameAndTypeIndex = d.u2()
 		return &info
 	case CONSTANT_Methodref:
 		var info CONSTANT_Methodref_info
 		info.Tag = tag
-		safeReadBinary(reader, binary.BigEndian, &info.ClassIndex)
-		safeReadBinary(reader, binary.BigEndian, &info.NameAndTypeIndex)
+		info.ClassIndex = d.u2()
+		info.NameAndTypeIndex = d.u2()
 		return &info
 	case CONSTANT_InterfaceMethodref:
 		var info CONSTANT_InterfaceMethodref_info
 		info.Tag = tag
-		safeReadBinary(reader, binary.BigEndian, &info.ClassIndex)
-		safeReadBinary(reader, binary.BigEndian, &info.NameAndTypeIndex)
+		info.ClassIndex = d.u2()
+		info.NameAndTypeIndex = d.u2()
 		return &info
 	case CONSTANT_String:
 		var info CONSTANT_String_info
 		info.Tag = tag
-		safeReadBinary(reader, binary.BigEndian, &info.StringIndex)
+		info.StringIndex = d.u2()
 		return &info
 	case CONSTANT_Integer:
 		var info CONSTANT_Integer_info
 		info.Tag = tag
-		safeReadBinary(reader, binary.BigEndian, &info.Value)
+		info.Bytes = d.u4()
 		return &info
 	case CONSTANT_Float:
 		var info CONSTANT_Float_info
 		info.Tag = tag
-		safeReadBinary(reader, binary.BigEndian, &info.Value)
+		info.Bytes = d.u4()
 		return &info
 	case CONSTANT_Long:
 		var info CONSTANT_Long_info
 		info.Tag = tag
-		safeReadBinary(reader, binary.BigEndian, &info.HighBytes)
-		safeReadBinary(reader, binary.BigEndian, &info.LowBytes)
-		// Push a BS copy as well
+		info.HighBytes = d.u4()
+		info.LowBytes = d.u4()
 		return &info
 	case CONSTANT_Double:
 		var info CONSTANT_Double_info
 		info.Tag = tag
-		safeReadBinary(reader, binary.BigEndian, &info.HighBytes)
-		safeReadBinary(reader, binary.BigEndian, &info.LowBytes)
+		info.HighBytes = d.u4()
+		info.LowBytes = d.u4()
 		return &info
 	case CONSTANT_NameAndType:
 		var info CONSTANT_NameAndType_info
 		info.Tag = tag
-		safeReadBinary(reader, binary.BigEndian, &info.NameIndex)
-		safeReadBinary(reader, binary.BigEndian, &info.DescriptorIndex)
+		info.NameIndex = d.u2()
+		info.DescriptorIndex = d.u2()
 		return &info
 	case CONSTANT_Utf8:
 		var info CONSTANT_Utf8_info
 		info.Tag = tag
-		safeReadBinary(reader, binary.BigEndian, &info.Length)
-		info.Bytes = make([]byte, info.Length)
-		io.ReadFull(reader, info.Bytes)
+		info.Length = d.u2()
+		start := d.pos
+		info.Bytes = d.bytes(int(info.Length))
+		if d.err == nil {
+			if _, pos, err := decodeModifiedUTF8(info.Bytes); err != nil {
+				d.failAt(start+pos, ErrMalformed, "invalid modified UTF-8: %v", err)
+			}
+		}
 		return &info
 	case CONSTANT_MethodHandle:
 		var info CONSTANT_MethodHandle_info
 		info.Tag = tag
-		safeReadBinary(reader, binary.BigEndian, &info.ReferenceKind)
-		safeReadBinary(reader, binary.BigEndian, &info.ReferenceIndex)
+		info.ReferenceKind = d.u1()
+		info.ReferenceIndex = d.u2()
 		return &info
 	case CONSTANT_MethodType:
 		var info CONSTANT_MethodType_info
 		info.Tag = tag
-		safeReadBinary(reader, binary.BigEndian, &info.DescriptorIndex)
+		info.DescriptorIndex = d.u2()
+		return &info
+	case CONSTANT_Dynamic:
+		var info CONSTANT_Dynamic_info
+		info.Tag = tag
+		info.BootstrapMethodAttrIndex = d.u2()
+		info.NameAndTypeIndex = d.u2()
 		return &info
 	case CONSTANT_InvokeDynamic:
 		var info CONSTANT_InvokeDynamic_info
 		info.Tag = tag
-		safeReadBinary(reader, binary.BigEndian, &info.BootstrapMethodAttrIndex)
-		safeReadBinary(reader, binary.BigEndian, &info.NameAndTypeIndex)
+		info.BootstrapMethodAttrIndex = d.u2()
+		info.NameAndTypeIndex = d.u2()
+		return &info
+	case CONSTANT_Module:
+		var info CONSTANT_Module_info
+		info.Tag = tag
+		info.NameIndex = d.u2()
+		return &info
+	case CONSTANT_Package:
+		var info CONSTANT_Package_info
+		info.Tag = tag
+		info.NameIndex = d.u2()
 		return &info
 	default:
-		panic(fmt.Errorf("Invalid Tag '%v' for constant pool entry", tag))
+		if d.err == nil {
+			d.failAt(start, ErrBadTag, "tag %v", tag)
+		}
+		return nil
 	}
 }
 
 // Read a field_info struct
-func readField(reader *bytes.Reader) FieldInfo {
+func readField(d *decoder) FieldInfo {
 	var fieldInfo FieldInfo
-	safeReadBinary(reader, binary.BigEndian, &fieldInfo.AccessFlags)
-	safeReadBinary(reader, binary.BigEndian, &fieldInfo.NameIndex)
-	safeReadBinary(reader, binary.BigEndian, &fieldInfo.DescriptorIndex)
-	safeReadBinary(reader, binary.BigEndian, &fieldInfo.AttrsCount)
-	for i := uint16(0); i < fieldInfo.AttrsCount; i++ {
-		fieldInfo.Attrs = append(fieldInfo.Attrs, readAttr(reader))
-	}
+	fieldInfo.AccessFlags = Access(d.u2())
+	fieldInfo.NameIndex = d.u2()
+	fieldInfo.DescriptorIndex = d.u2()
+	fieldInfo.AttrsCount = d.u2()
+	fieldInfo.Attrs = readAttrs(d, fieldInfo.AttrsCount)
 	return fieldInfo
 }
 
-// Read an attribute_info struct
-func readAttr(reader *bytes.Reader) AttrInfo {
+// Reads a method_info struct
+func readMethod(d *decoder) MethodInfo {
+	var methodInfo MethodInfo
+	methodInfo.AccessFlags = Access(d.u2())
+	methodInfo.NameIndex = d.u2()
+	methodInfo.DescriptorIndex = d.u2()
+	methodInfo.AttrsCount = d.u2()
+	methodInfo.Attrs = readAttrs(d, methodInfo.AttrsCount)
+	return methodInfo
+}
+
+// Read count attribute_info structs
+func readAttrs(d *decoder, count uint16) []AttrInfo {
+	var attrs []AttrInfo
+	for i := uint16(0); d.err == nil && i < count; i++ {
+		d.push(fmt.Sprintf("attr[%v]", i))
+		attrs = append(attrs, readAttr(d))
+		d.pop()
+	}
+	return attrs
+}
+
+// Read an attribute_info struct. The name must refer to a CONSTANT_Utf8 entry, as it
+// is what determines how the attribute's contents are interpreted.
+func readAttr(d *decoder) AttrInfo {
 	var attrInfo AttrInfo
-	safeReadBinary(reader, binary.BigEndian, &attrInfo.NameIndex)
-	safeReadBinary(reader, binary.BigEndian, &attrInfo.AttrLength)
-	attrInfo.AttrData = make([]byte, attrInfo.AttrLength)
-	io.ReadFull(reader, attrInfo.AttrData)
+	start := d.pos
+	attrInfo.NameIndex = d.u2()
+	if d.err == nil && d.cp != nil {
+		if _, ok := lookupUtf8(d.cp, attrInfo.NameIndex); !ok {
+			d.failAt(start, ErrBadIndex, "attribute name #%v is not a Utf8 constant", attrInfo.NameIndex)
+		}
+	}
+	attrInfo.AttrLength = d.u4()
+	attrInfo.AttrData = d.bytes(int(attrInfo.AttrLength))
 	return attrInfo
 }
 
-// Reads a method_info struct
-func readMethod(reader *bytes.Reader) MethodInfo {
-	var methodInfo MethodInfo
-	safeReadBinary(reader, binary.BigEndian, &methodInfo.AccessFlags)
-	safeReadBinary(reader, binary.BigEndian, &methodInfo.NameIndex)
-	safeReadBinary(reader, binary.BigEndian, &methodInfo.DescriptorIndex)
-	safeReadBinary(reader, binary.BigEndian, &methodInfo.AttrsCount)
-	for i := uint16(0); i < methodInfo.AttrsCount; i++ {
-		methodInfo.Attrs = append(methodInfo.Attrs, readAttr(reader))
+// LookupEntry returns the constant at the given index, or nil if the index is 0, past
+// the end of the pool, or the unusable slot after a Long or Double. Constant pool
+// indexes read from a classfile should be resolved through it rather than by indexing
+// the pool directly.
+func LookupEntry(cp []CpEntry, index uint16) CpEntry {
+	if index == 0 || int(index) > len(cp) {
+		return nil
 	}
-	return methodInfo
+	return cp[index-1]
+}
+
+// lookupUtf8 returns the Utf8 constant at the given index, and whether the index refers
+// to one.
+func lookupUtf8(cp []CpEntry, index uint16) (*CONSTANT_Utf8_info, bool) {
+	ent, ok := LookupEntry(cp, index).(*CONSTANT_Utf8_info)
+	return ent, ok
+}
+
+// utf8Value gets the string held by the CONSTANT_Utf8 at index, or "" if there isn't one.
+func utf8Value(cp []CpEntry, index uint16) string {
+	if utf8, ok := lookupUtf8(cp, index); ok {
+		return utf8.Value()
+	}
+	return ""
+}
+
+// decoder reads big-endian classfile structures from a byte slice. Errors are sticky:
+// once a read fails, every subsequent read returns a zero value and err holds the
+// first failure, so callers need only check err at the end of a run of reads.
+type decoder struct {
+	data []byte
+	pos  int
+	path []string
+	err  *ParseError
+	// cp is the constant pool, once it has been read, for validating indices.
+	cp []CpEntry
+}
+
+func (d *decoder) push(name string) {
+	d.path = append(d.path, name)
+}
+
+func (d *decoder) pop() {
+	d.path = d.path[:len(d.path)-1]
+}
+
+// fail records an error at the current position, unless one is already recorded.
+func (d *decoder) fail(kind error, format string, args ...interface{}) {
+	d.failAt(d.pos, kind, format, args...)
 }
 
-func safeReadBinary(reader io.Reader, order binary.ByteOrder, location interface{}) {
-	if err := binary.Read(reader, order, location); err != nil {
-		panic(err)
+// failAt records an error at the given position, unless one is already recorded.
+func (d *decoder) failAt(pos int, kind error, format string, args ...interface{}) {
+	if d.err != nil {
+		return
+	}
+	d.err = &ParseError{
+		Offset: int64(pos),
+		Path:   strings.Join(d.path, "."),
+		Kind:   kind,
+		Detail: fmt.Sprintf(format, args...),
+	}
+}
+
+// take consumes n bytes, returning nil if fewer than n remain.
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data)-d.pos {
+		d.fail(ErrTruncated, "need %v bytes, %v remain", n, len(d.data)-d.pos)
+		return nil
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) u1() uint8 {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) u2() uint16 {
+	if b := d.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) u4() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+// u2s reads n consecutive u2 values.
+func (d *decoder) u2s(n int) []uint16 {
+	var values []uint16
+	for i := 0; d.err == nil && i < n; i++ {
+		values = append(values, d.u2())
+	}
+	return values
+}
+
+// bytes returns a copy of the next n bytes.
+func (d *decoder) bytes(n int) []byte {
+	b := d.take(n)
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+// remaining reports the number of unread bytes.
+func (d *decoder) remaining() int {
+	return len(d.data) - d.pos
+}
+
+// finish checks that all of the data was consumed, returning the first error
+// encountered while decoding, if any.
+func (d *decoder) finish() error {
+	if d.err == nil && d.remaining() > 0 {
+		d.fail(ErrMalformed, "%v unexpected bytes at end", d.remaining())
+	}
+	if d.err != nil {
+		return d.err
 	}
+	return nil
 }
@@ -0,0 +1,482 @@
+package classy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TypeSignature is a Java type as encoded in a Signature attribute: a BaseType, a
+// *ClassTypeSignature, a *TypeVariableSignature or an *ArrayTypeSignature. String
+// renders the type as it would be written in Java source, with fully qualified class
+// names.
+type TypeSignature interface {
+	String() string
+}
+
+// ClassTypeSignature is a possibly parameterized class type. Classes holds the
+// outermost class first, followed by each nested class, as in
+// java.util.Map<K, V>.Entry<K, V>.
+type ClassTypeSignature struct {
+	// Package is the package in internal form, e.g. "java/util", or "" for the
+	// unnamed package.
+	Package string
+	Classes []SimpleClassTypeSignature
+}
+
+// SimpleClassTypeSignature is a single class name and its type arguments.
+type SimpleClassTypeSignature struct {
+	Name          string
+	TypeArguments []TypeArgument
+}
+
+// TypeVariableSignature is a reference to a type variable, such as T.
+type TypeVariableSignature struct {
+	Name string
+}
+
+// ArrayTypeSignature is an array of Elem.
+type ArrayTypeSignature struct {
+	Elem TypeSignature
+}
+
+// Wildcard indicators of a TypeArgument.
+const (
+	WildcardNone    byte = 0
+	WildcardAny     byte = '*'
+	WildcardExtends byte = '+'
+	WildcardSuper   byte = '-'
+)
+
+// TypeArgument is an argument to a parameterized type. Type is nil for the unbounded
+// wildcard '*'.
+type TypeArgument struct {
+	Wildcard byte
+	Type     TypeSignature
+}
+
+// TypeParameter is the declaration of a type variable with its bounds. ClassBound is
+// nil when the class bound is omitted, which is the case when the only bounds are
+// interfaces.
+type TypeParameter struct {
+	Name            string
+	ClassBound      TypeSignature
+	InterfaceBounds []TypeSignature
+}
+
+// TypeParameters is a list of type parameter declarations.
+type TypeParameters []TypeParameter
+
+// ClassSignature is the generic signature of a class or interface.
+type ClassSignature struct {
+	TypeParameters TypeParameters
+	Superclass     *ClassTypeSignature
+	Interfaces     []*ClassTypeSignature
+}
+
+// MethodSignature is the generic signature of a method. Result is Void for methods that
+// return nothing, and each of Throws is a *ClassTypeSignature or a
+// *TypeVariableSignature.
+type MethodSignature struct {
+	TypeParameters TypeParameters
+	Params         []TypeSignature
+	Result         TypeSignature
+	Throws         []TypeSignature
+}
+
+// ParseClassSignature parses the Signature attribute of a class.
+func ParseClassSignature(signature string) (*ClassSignature, error) {
+	p := &signatureParser{s: signature}
+	sig := new(ClassSignature)
+	sig.TypeParameters = p.typeParameters()
+	sig.Superclass = p.classType()
+	for p.err == nil && p.more() {
+		sig.Interfaces = append(sig.Interfaces, p.classType())
+	}
+	if err := p.finish(); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// ParseMethodSignature parses the Signature attribute of a method.
+func ParseMethodSignature(signature string) (*MethodSignature, error) {
+	p := &signatureParser{s: signature}
+	sig := new(MethodSignature)
+	sig.TypeParameters = p.typeParameters()
+	p.expect('(')
+	for p.err == nil && p.peek() != ')' {
+		sig.Params = append(sig.Params, p.javaType())
+	}
+	p.expect(')')
+	if p.err == nil && p.peek() == 'V' {
+		p.pos++
+		sig.Result = Void
+	} else {
+		sig.Result = p.javaType()
+	}
+	for p.err == nil && p.peek() == '^' {
+		p.pos++
+		if p.peek() == 'T' {
+			sig.Throws = append(sig.Throws, p.referenceType())
+		} else {
+			sig.Throws = append(sig.Throws, p.classType())
+		}
+	}
+	if err := p.finish(); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// ParseFieldSignature parses the Signature attribute of a field, record component or
+// local variable.
+func ParseFieldSignature(signature string) (TypeSignature, error) {
+	p := &signatureParser{s: signature}
+	sig := p.referenceType()
+	if err := p.finish(); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+func (t *ClassTypeSignature) String() string {
+	var parts []string
+	for _, class := range t.Classes {
+		parts = append(parts, class.String())
+	}
+	name := strings.Join(parts, ".")
+	if t.Package != "" {
+		name = strings.Replace(t.Package, "/", ".", -1) + "." + name
+	}
+	return name
+}
+
+// Name returns the internal name of the erased class, e.g. "java/util/Map$Entry".
+func (t *ClassTypeSignature) Name() string {
+	var parts []string
+	for _, class := range t.Classes {
+		parts = append(parts, class.Name)
+	}
+	name := strings.Join(parts, "$")
+	if t.Package != "" {
+		name = t.Package + "/" + name
+	}
+	return name
+}
+
+func (t SimpleClassTypeSignature) String() string {
+	if len(t.TypeArguments) == 0 {
+		return t.Name
+	}
+	var args []string
+	for _, arg := range t.TypeArguments {
+		args = append(args, arg.String())
+	}
+	return t.Name + "<" + strings.Join(args, ", ") + ">"
+}
+
+func (t *TypeVariableSignature) String() string {
+	return t.Name
+}
+
+func (t *ArrayTypeSignature) String() string {
+	return t.Elem.String() + "[]"
+}
+
+func (a TypeArgument) String() string {
+	switch a.Wildcard {
+	case WildcardAny:
+		return "?"
+	case WildcardExtends:
+		return "? extends " + a.Type.String()
+	case WildcardSuper:
+		return "? super " + a.Type.String()
+	default:
+		return a.Type.String()
+	}
+}
+
+// String renders the type parameter as it would be declared in Java source. A bound of
+// java.lang.Object alone is implied and left out.
+func (t TypeParameter) String() string {
+	var bounds []string
+	if t.ClassBound != nil {
+		bounds = append(bounds, t.ClassBound.String())
+	}
+	for _, bound := range t.InterfaceBounds {
+		bounds = append(bounds, bound.String())
+	}
+	if len(bounds) == 0 || len(bounds) == 1 && bounds[0] == "java.lang.Object" {
+		return t.Name
+	}
+	return t.Name + " extends " + strings.Join(bounds, " & ")
+}
+
+// String renders the type parameters within angle brackets, or "" if there are none.
+func (t TypeParameters) String() string {
+	if len(t) == 0 {
+		return ""
+	}
+	var params []string
+	for _, param := range t {
+		params = append(params, param.String())
+	}
+	return "<" + strings.Join(params, ", ") + ">"
+}
+
+// String renders the signature as the type parameters and supertypes of a class
+// declaration, e.g. "<T> extends java.lang.Object implements java.util.List<T>".
+func (s *ClassSignature) String() string {
+	var parts []string
+	if len(s.TypeParameters) > 0 {
+		parts = append(parts, s.TypeParameters.String())
+	}
+	parts = append(parts, "extends "+s.Superclass.String())
+	if len(s.Interfaces) > 0 {
+		var ifaces []string
+		for _, iface := range s.Interfaces {
+			ifaces = append(ifaces, iface.String())
+		}
+		parts = append(parts, "implements "+strings.Join(ifaces, ", "))
+	}
+	return strings.Join(parts, " ")
+}
+
+// String renders the signature in the form of a method declaration without its name,
+// e.g. "<T> T (java.util.List<T>) throws java.io.IOException".
+func (s *MethodSignature) String() string {
+	var parts []string
+	if len(s.TypeParameters) > 0 {
+		parts = append(parts, s.TypeParameters.String())
+	}
+	parts = append(parts, s.Result.String(), "("+s.ParamsString()+")")
+	if len(s.Throws) > 0 {
+		parts = append(parts, "throws "+s.ThrowsString())
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParamsString renders the parameter types separated by commas.
+func (s *MethodSignature) ParamsString() string {
+	return joinTypes(s.Params)
+}
+
+// ThrowsString renders the thrown types separated by commas.
+func (s *MethodSignature) ThrowsString() string {
+	return joinTypes(s.Throws)
+}
+
+func joinTypes(types []TypeSignature) string {
+	var names []string
+	for _, t := range types {
+		names = append(names, t.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// GenericSignature parses the class's Signature attribute, returning nil with no error
+// if the class has none.
+func (cf *ClassFile) GenericSignature() (*ClassSignature, error) {
+	signature, ok, err := signatureAttr(cf.Attrs, cf.ConstantPool)
+	if !ok || err != nil {
+		return nil, err
+	}
+	return ParseClassSignature(signature)
+}
+
+// GenericSignature parses the method's Signature attribute, returning nil with no error
+// if the method has none.
+func (i *MethodInfo) GenericSignature(cp []CpEntry) (*MethodSignature, error) {
+	signature, ok, err := signatureAttr(i.Attrs, cp)
+	if !ok || err != nil {
+		return nil, err
+	}
+	return ParseMethodSignature(signature)
+}
+
+// GenericSignature parses the field's Signature attribute, returning nil with no error
+// if the field has none.
+func (i *FieldInfo) GenericSignature(cp []CpEntry) (TypeSignature, error) {
+	signature, ok, err := signatureAttr(i.Attrs, cp)
+	if !ok || err != nil {
+		return nil, err
+	}
+	return ParseFieldSignature(signature)
+}
+
+// signatureAttr finds a Signature attribute and resolves the string it refers to.
+func signatureAttr(attrs []AttrInfo, cp []CpEntry) (string, bool, error) {
+	attr := findAttr(attrs, cp, "Signature")
+	if attr == nil {
+		return "", false, nil
+	}
+	d := &decoder{data: attr.AttrData, path: []string{"Signature"}}
+	index := d.u2()
+	if err := d.finish(); err != nil {
+		return "", true, err
+	}
+	ent, ok := lookupUtf8(cp, index)
+	if !ok {
+		return "", true, &ParseError{Path: "Signature", Kind: ErrBadIndex, Detail: fmt.Sprintf("#%v is not a Utf8 constant", index)}
+	}
+	return ent.Value(), true, nil
+}
+
+// signatureParser is a recursive descent parser for the signature grammar. Like
+// decoder, its errors are sticky.
+type signatureParser struct {
+	s   string
+	pos int
+	err error
+}
+
+func (p *signatureParser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf("invalid signature %q at position %v: %v", p.s, p.pos, fmt.Sprintf(format, args...))
+	}
+}
+
+func (p *signatureParser) more() bool {
+	return p.pos < len(p.s)
+}
+
+// peek returns the next character, or 0 at the end of input or after an error.
+func (p *signatureParser) peek() byte {
+	if p.err != nil || !p.more() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *signatureParser) expect(c byte) {
+	if p.peek() != c {
+		p.fail("expected '%c'", c)
+		return
+	}
+	p.pos++
+}
+
+func (p *signatureParser) finish() error {
+	if p.err == nil && p.more() {
+		p.fail("unexpected trailing characters")
+	}
+	return p.err
+}
+
+// identifier reads a name up to, but not including, the next delimiter.
+func (p *signatureParser) identifier() string {
+	start := p.pos
+	for p.more() && !strings.ContainsRune(".;[/<>:", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		p.fail("expected identifier")
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *signatureParser) typeParameters() TypeParameters {
+	if p.peek() != '<' {
+		return nil
+	}
+	p.pos++
+	var params TypeParameters
+	for p.err == nil && p.peek() != '>' {
+		var param TypeParameter
+		param.Name = p.identifier()
+		p.expect(':')
+		if c := p.peek(); c == 'L' || c == 'T' || c == '[' {
+			param.ClassBound = p.referenceType()
+		}
+		for p.err == nil && p.peek() == ':' {
+			p.pos++
+			param.InterfaceBounds = append(param.InterfaceBounds, p.referenceType())
+		}
+		params = append(params, param)
+	}
+	if len(params) == 0 {
+		p.fail("empty type parameter list")
+	}
+	p.expect('>')
+	return params
+}
+
+func (p *signatureParser) javaType() TypeSignature {
+	switch c := p.peek(); c {
+	case 'B', 'C', 'D', 'F', 'I', 'J', 'S', 'Z':
+		p.pos++
+		return BaseType(c)
+	default:
+		return p.referenceType()
+	}
+}
+
+func (p *signatureParser) referenceType() TypeSignature {
+	switch p.peek() {
+	case 'L':
+		return p.classType()
+	case 'T':
+		p.pos++
+		name := p.identifier()
+		p.expect(';')
+		return &TypeVariableSignature{Name: name}
+	case '[':
+		p.pos++
+		return &ArrayTypeSignature{Elem: p.javaType()}
+	default:
+		p.fail("expected reference type")
+		return nil
+	}
+}
+
+func (p *signatureParser) classType() *ClassTypeSignature {
+	p.expect('L')
+	sig := new(ClassTypeSignature)
+
+	// Identifiers followed by '/' make up the package
+	var pkg []string
+	name := p.identifier()
+	for p.err == nil && p.peek() == '/' {
+		p.pos++
+		pkg = append(pkg, name)
+		name = p.identifier()
+	}
+	sig.Package = strings.Join(pkg, "/")
+	sig.Classes = append(sig.Classes, SimpleClassTypeSignature{Name: name, TypeArguments: p.typeArguments()})
+
+	for p.err == nil && p.peek() == '.' {
+		p.pos++
+		name := p.identifier()
+		sig.Classes = append(sig.Classes, SimpleClassTypeSignature{Name: name, TypeArguments: p.typeArguments()})
+	}
+	p.expect(';')
+	if p.err != nil {
+		return nil
+	}
+	return sig
+}
+
+func (p *signatureParser) typeArguments() []TypeArgument {
+	if p.peek() != '<' {
+		return nil
+	}
+	p.pos++
+	var args []TypeArgument
+	for p.err == nil && p.peek() != '>' {
+		switch c := p.peek(); c {
+		case '*':
+			p.pos++
+			args = append(args, TypeArgument{Wildcard: WildcardAny})
+		case '+', '-':
+			p.pos++
+			args = append(args, TypeArgument{Wildcard: c, Type: p.referenceType()})
+		default:
+			args = append(args, TypeArgument{Type: p.referenceType()})
+		}
+	}
+	if len(args) == 0 {
+		p.fail("empty type argument list")
+	}
+	p.expect('>')
+	return args
+}
@@ -0,0 +1,311 @@
+package classy
+
+import (
+	"math"
+)
+
+// fixedStackEffects gives the operand stack slots popped and pushed by the instructions
+// whose effect doesn't depend on their operands.
+var fixedStackEffects = map[Opcode][2]int{}
+
+func init() {
+	effects := []struct {
+		pop, push int
+		ops       []Opcode
+	}{
+		{0, 0, []Opcode{OpNop, OpIinc, OpGoto, OpGotoW, OpRet, OpReturn}},
+		{0, 1, []Opcode{OpAconstNull, OpIconstM1, OpIconst0, OpIconst1, OpIconst2, OpIconst3,
+			OpIconst4, OpIconst5, OpFconst0, OpFconst1, OpFconst2, OpBipush, OpSipush, OpIload,
+			OpFload, OpAload, OpIload0, OpIload1, OpIload2, OpIload3, OpFload0, OpFload1,
+			OpFload2, OpFload3, OpAload0, OpAload1, OpAload2, OpAload3, OpNew, OpJsr, OpJsrW}},
+		{0, 2, []Opcode{OpLconst0, OpLconst1, OpDconst0, OpDconst1, OpLload, OpDload,
+			OpLload0, OpLload1, OpLload2, OpLload3, OpDload0, OpDload1, OpDload2, OpDload3}},
+		{1, 0, []Opcode{OpIstore, OpFstore, OpAstore, OpIstore0, OpIstore1, OpIstore2,
+			OpIstore3, OpFstore0, OpFstore1, OpFstore2, OpFstore3, OpAstore0, OpAstore1,
+			OpAstore2, OpAstore3, OpPop, OpIfeq, OpIfne, OpIflt, OpIfge, OpIfgt, OpIfle,
+			OpTableswitch, OpLookupswitch, OpIreturn, OpFreturn, OpAreturn, OpAthrow,
+			OpMonitorenter, OpMonitorexit, OpIfnull, OpIfnonnull}},
+		{1, 1, []Opcode{OpIneg, OpFneg, OpI2f, OpF2i, OpI2b, OpI2c, OpI2s, OpNewarray,
+			OpAnewarray, OpArraylength, OpCheckcast, OpInstanceof}},
+		{1, 2, []Opcode{OpDup, OpI2l, OpI2d, OpF2l, OpF2d}},
+		{2, 0, []Opcode{OpLstore, OpDstore, OpLstore0, OpLstore1, OpLstore2, OpLstore3,
+			OpDstore0, OpDstore1, OpDstore2, OpDstore3, OpPop2, OpIfIcmpeq, OpIfIcmpne,
+			OpIfIcmplt, OpIfIcmpge, OpIfIcmpgt, OpIfIcmple, OpIfAcmpeq, OpIfAcmpne,
+			OpLreturn, OpDreturn}},
+		{2, 1, []Opcode{OpIaload, OpFaload, OpAaload, OpBaload, OpCaload, OpSaload, OpIadd,
+			OpFadd, OpIsub, OpFsub, OpImul, OpFmul, OpIdiv, OpFdiv, OpIrem, OpFrem, OpIshl,
+			OpIshr, OpIushr, OpIand, OpIor, OpIxor, OpL2i, OpL2f, OpD2i, OpD2f, OpFcmpl,
+			OpFcmpg}},
+		{2, 2, []Opcode{OpLaload, OpDaload, OpSwap, OpLneg, OpDneg, OpL2d, OpD2l}},
+		{2, 3, []Opcode{OpDupX1}},
+		{2, 4, []Opcode{OpDup2}},
+		{3, 0, []Opcode{OpIastore, OpFastore, OpAastore, OpBastore, OpCastore, OpSastore}},
+		{3, 2, []Opcode{OpLshl, OpLshr, OpLushr}},
+		{3, 4, []Opcode{OpDupX2}},
+		{3, 5, []Opcode{OpDup2X1}},
+		{4, 0, []Opcode{OpLastore, OpDastore}},
+		{4, 1, []Opcode{OpLcmp, OpDcmpl, OpDcmpg}},
+		{4, 2, []Opcode{OpLadd, OpDadd, OpLsub, OpDsub, OpLmul, OpDmul, OpLdiv, OpDdiv,
+			OpLrem, OpDrem, OpLand, OpLor, OpLxor}},
+		{4, 6, []Opcode{OpDup2X2}},
+	}
+	for _, effect := range effects {
+		for _, op := range effect.ops {
+			fixedStackEffects[op] = [2]int{effect.pop, effect.push}
+		}
+	}
+}
+
+// StackEffect gets the number of operand stack slots the instruction pops and pushes,
+// counting longs and doubles as two slots. Instructions that load a constant or refer
+// to a field or method look up its type in cp.
+func (ins *Instruction) StackEffect(cp []CpEntry) (pop, push int, err error) {
+	if effect, ok := fixedStackEffects[ins.Opcode]; ok {
+		return effect[0], effect[1], nil
+	}
+
+	switch ins.Opcode {
+	case OpLdc, OpLdcW, OpLdc2W:
+		slots, err := constantSlots(cp, ins.Index, ins.Offset)
+		return 0, slots, err
+	case OpGetstatic, OpPutstatic, OpGetfield, OpPutfield:
+		descriptor, err := refDescriptor(cp, ins.Index, ins.Offset)
+		if err != nil {
+			return 0, 0, err
+		}
+		t, err := ParseFieldDescriptor(descriptor)
+		if err != nil {
+			return 0, 0, bytecodeError(ErrMalformed, ins.Offset, "%v", err)
+		}
+		switch ins.Opcode {
+		case OpGetstatic:
+			return 0, t.Slots(), nil
+		case OpPutstatic:
+			return t.Slots(), 0, nil
+		case OpGetfield:
+			return 1, t.Slots(), nil
+		default:
+			return 1 + t.Slots(), 0, nil
+		}
+	case OpInvokevirtual, OpInvokespecial, OpInvokestatic, OpInvokeinterface, OpInvokedynamic:
+		descriptor, err := refDescriptor(cp, ins.Index, ins.Offset)
+		if err != nil {
+			return 0, 0, err
+		}
+		m, err := ParseMethodDescriptor(descriptor)
+		if err != nil {
+			return 0, 0, bytecodeError(ErrMalformed, ins.Offset, "%v", err)
+		}
+		pop = m.ParamSlots()
+		if ins.Opcode != OpInvokestatic && ins.Opcode != OpInvokedynamic {
+			pop++
+		}
+		return pop, m.Return.Slots(), nil
+	case OpMultianewarray:
+		return int(ins.Value), 1, nil
+	}
+	return 0, 0, bytecodeError(ErrMalformed, ins.Offset, "unknown opcode 0x%02x", byte(ins.Opcode))
+}
+
+// constantSlots gets the number of stack slots taken by the constant an ldc loads.
+func constantSlots(cp []CpEntry, index uint16, pos int) (int, error) {
+	switch e := LookupEntry(cp, index).(type) {
+	case *CONSTANT_Integer_info, *CONSTANT_Float_info, *CONSTANT_String_info,
+		*CONSTANT_Class_info, *CONSTANT_MethodType_info, *CONSTANT_MethodHandle_info:
+		return 1, nil
+	case *CONSTANT_Long_info, *CONSTANT_Double_info:
+		return 2, nil
+	case *CONSTANT_Dynamic_info:
+		descriptor, err := natDescriptor(cp, e.NameAndTypeIndex, index, pos)
+		if err != nil {
+			return 0, err
+		}
+		t, err := ParseFieldDescriptor(descriptor)
+		if err != nil {
+			return 0, bytecodeError(ErrMalformed, pos, "%v", err)
+		}
+		return t.Slots(), nil
+	}
+	return 0, bytecodeError(ErrBadIndex, pos, "#%v is not a loadable constant", index)
+}
+
+// refDescriptor gets the descriptor of the field, method or call site an instruction
+// refers to.
+func refDescriptor(cp []CpEntry, index uint16, pos int) (string, error) {
+	switch e := LookupEntry(cp, index).(type) {
+	case *CONSTANT_Fieldref_info:
+		return natDescriptor(cp, e.NameAndTypeIndex, index, pos)
+	case *CONSTANT_Methodref_info:
+		return natDescriptor(cp, e.NameAndTypeIndex, index, pos)
+	case *CONSTANT_InterfaceMethodref_info:
+		return natDescriptor(cp, e.NameAndTypeIndex, index, pos)
+	case *CONSTANT_InvokeDynamic_info:
+		return natDescriptor(cp, e.NameAndTypeIndex, index, pos)
+	}
+	return "", bytecodeError(ErrBadIndex, pos, "#%v is not a field, method or call site reference", index)
+}
+
+func natDescriptor(cp []CpEntry, natIndex, index uint16, pos int) (string, error) {
+	if nat, ok := LookupEntry(cp, natIndex).(*CONSTANT_NameAndType_info); ok {
+		if descriptor, ok := lookupUtf8(cp, nat.DescriptorIndex); ok {
+			return descriptor.Value(), nil
+		}
+	}
+	return "", bytecodeError(ErrBadIndex, pos, "#%v has no valid NameAndType", index)
+}
+
+// localVariable gets the local variable an instruction loads, stores, increments or
+// returns to, and the number of slots its value takes.
+func localVariable(ins *Instruction) (index, slots int, ok bool) {
+	// The load and store opcodes come in the order int, long, float, double, reference
+	slotsOf := func(kind Opcode) int {
+		if kind == 1 || kind == 3 {
+			return 2
+		}
+		return 1
+	}
+	switch op := ins.Opcode; {
+	case op >= OpIload && op <= OpAload:
+		return int(ins.Index), slotsOf(op - OpIload), true
+	case op >= OpIload0 && op <= OpAload3:
+		return int(op-OpIload0) % 4, slotsOf((op - OpIload0) / 4), true
+	case op >= OpIstore && op <= OpAstore:
+		return int(ins.Index), slotsOf(op - OpIstore), true
+	case op >= OpIstore0 && op <= OpAstore3:
+		return int(op-OpIstore0) % 4, slotsOf((op - OpIstore0) / 4), true
+	case op == OpIinc || op == OpRet:
+		return int(ins.Index), 1, true
+	}
+	return 0, 0, false
+}
+
+// successors gets the offsets control can pass to after an instruction other than
+// through exceptions, and whether one of them is the following instruction.
+func successors(ins *Instruction) (targets []int, fallsThrough bool) {
+	switch ins.Opcode {
+	case OpGoto, OpGotoW:
+		return []int{ins.Target}, false
+	case OpTableswitch, OpLookupswitch:
+		return append([]int{ins.Switch.Default}, ins.Switch.Targets...), false
+	case OpIreturn, OpLreturn, OpFreturn, OpDreturn, OpAreturn, OpReturn, OpAthrow, OpRet:
+		return nil, false
+	}
+	switch ins.Opcode.Operands() {
+	case OperandBranch, OperandBranchWide:
+		return []int{ins.Target}, true
+	}
+	return nil, true
+}
+
+// ComputeMaxs sets MaxStack and MaxLocals to what the code needs, for a method with the
+// given access flags and descriptor. The operand stack must have the same depth on
+// every path to an instruction, as the JVM requires.
+func (c *CodeAttribute) ComputeMaxs(cp []CpEntry, access Access, descriptor string) error {
+	desc, err := ParseMethodDescriptor(descriptor)
+	if err != nil {
+		return err
+	}
+	insns, err := DecodeInstructions(c.Code)
+	if err != nil {
+		return err
+	}
+
+	maxLocals := desc.ParamSlots()
+	if access&AccStatic == 0 {
+		maxLocals++
+	}
+	for i := range insns {
+		if index, slots, ok := localVariable(&insns[i]); ok && index+slots > maxLocals {
+			maxLocals = index + slots
+		}
+	}
+	maxStack, err := maxStackDepth(insns, c.ExceptionTable, cp)
+	if err != nil {
+		return err
+	}
+	if maxStack > math.MaxUint16 || maxLocals > math.MaxUint16 {
+		return bytecodeError(ErrMalformed, 0, "max_stack %v or max_locals %v is too large", maxStack, maxLocals)
+	}
+	c.MaxStack, c.MaxLocals = uint16(maxStack), uint16(maxLocals)
+	return nil
+}
+
+// maxStackDepth follows every path through the instructions to find the deepest the
+// operand stack gets. Exception handlers start with the exception on the stack.
+func maxStackDepth(insns []Instruction, handlers []ExceptionTableEntry, cp []CpEntry) (int, error) {
+	if len(insns) == 0 {
+		return 0, nil
+	}
+	at := make(map[int]int, len(insns))
+	depths := make([]int, len(insns))
+	for i := range insns {
+		at[insns[i].Offset] = i
+		depths[i] = -1
+	}
+	end := insns[len(insns)-1].Offset + insns[len(insns)-1].Size()
+
+	var work []int
+	visit := func(from, pos, depth int) error {
+		i, ok := at[pos]
+		switch {
+		case pos == end:
+			return bytecodeError(ErrMalformed, from, "execution falls off the end of the code")
+		case !ok:
+			return bytecodeError(ErrMalformed, from, "branch to %v, which is not the start of an instruction", pos)
+		case depths[i] < 0:
+			depths[i] = depth
+			work = append(work, i)
+		case depths[i] != depth:
+			return bytecodeError(ErrMalformed, pos, "operand stack holds %v slots on one path here and %v on another", depths[i], depth)
+		}
+		return nil
+	}
+
+	if err := visit(0, 0, 0); err != nil {
+		return 0, err
+	}
+	for _, handler := range handlers {
+		if err := visit(int(handler.HandlerPC), int(handler.HandlerPC), 1); err != nil {
+			return 0, err
+		}
+	}
+	deepest := 1
+	if len(handlers) == 0 {
+		deepest = 0
+	}
+	for len(work) > 0 {
+		ins := &insns[work[len(work)-1]]
+		work = work[:len(work)-1]
+		depth := depths[at[ins.Offset]]
+
+		pop, push, err := ins.StackEffect(cp)
+		if err != nil {
+			return 0, err
+		}
+		if depth < pop {
+			return 0, bytecodeError(ErrMalformed, ins.Offset, "%v pops %v slots from an operand stack holding %v", ins.Opcode, pop, depth)
+		}
+		after := depth - pop + push
+		if after > deepest {
+			deepest = after
+		}
+
+		targets, fallsThrough := successors(ins)
+		for _, target := range targets {
+			if err := visit(ins.Offset, target, after); err != nil {
+				return 0, err
+			}
+		}
+		if fallsThrough {
+			// A subroutine returns to the instruction after its jsr, without the
+			// return address the jsr pushed
+			if ins.Opcode == OpJsr || ins.Opcode == OpJsrW {
+				after = depth
+			}
+			if err := visit(ins.Offset, ins.Offset+ins.Size(), after); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return deepest, nil
+}
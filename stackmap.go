@@ -0,0 +1,297 @@
+package classy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// StackMapTableAttribute holds the stack map frames of a Code attribute, which give the
+// types of the local variables and operand stack at the start of basic blocks so that
+// the verifier can check the method in a single pass.
+type StackMapTableAttribute struct {
+	NumberOfEntries uint16
+	Entries         []StackMapFrame
+}
+
+// StackMapFrame is a single entry of a StackMapTable. FrameType selects the kind of frame:
+//
+//	0-63      same_frame
+//	64-127    same_locals_1_stack_item_frame
+//	247       same_locals_1_stack_item_frame_extended
+//	248-250   chop_frame, dropping the last 251-FrameType locals
+//	251       same_frame_extended
+//	252-254   append_frame, adding FrameType-251 locals
+//	255       full_frame
+//
+// OffsetDelta is set for every kind of frame, including those where the spec encodes it
+// in FrameType. Locals holds the added locals of an append_frame and every local of a
+// full_frame, and Stack the stack items of the same_locals_1_stack_item and full frames.
+type StackMapFrame struct {
+	FrameType   byte
+	OffsetDelta uint16
+	Locals      []VerificationTypeInfo
+	Stack       []VerificationTypeInfo
+}
+
+// Values of StackMapFrame.FrameType with a fixed meaning.
+const (
+	SameLocals1StackItemExtended byte = 247
+	SameFrameExtended            byte = 251
+	FullFrame                    byte = 255
+)
+
+// VerificationTypeInfo is the type of a local variable or stack item in a stack map
+// frame. CpoolIndex is set for ItemObject, naming the CONSTANT_Class of the type, and
+// Offset for ItemUninitialized, giving the offset of the new instruction that created
+// the object.
+type VerificationTypeInfo struct {
+	Tag        byte
+	CpoolIndex uint16
+	Offset     uint16
+}
+
+// Values of VerificationTypeInfo.Tag.
+const (
+	ItemTop               byte = 0
+	ItemInteger           byte = 1
+	ItemFloat             byte = 2
+	ItemDouble            byte = 3
+	ItemLong              byte = 4
+	ItemNull              byte = 5
+	ItemUninitializedThis byte = 6
+	ItemObject            byte = 7
+	ItemUninitialized     byte = 8
+)
+
+func readStackMapTable(d *decoder) *StackMapTableAttribute {
+	attr := new(StackMapTableAttribute)
+	attr.NumberOfEntries = d.u2()
+	for i := uint16(0); d.err == nil && i < attr.NumberOfEntries; i++ {
+		var frame StackMapFrame
+		pos := d.pos
+		frame.FrameType = d.u1()
+		switch t := frame.FrameType; {
+		case t < 64:
+			frame.OffsetDelta = uint16(t)
+		case t < 128:
+			frame.OffsetDelta = uint16(t - 64)
+			frame.Stack = readVerificationTypes(d, 1)
+		case t < SameLocals1StackItemExtended:
+			if d.err == nil {
+				d.failAt(pos, ErrMalformed, "reserved frame_type %v", t)
+			}
+		case t == SameLocals1StackItemExtended:
+			frame.OffsetDelta = d.u2()
+			frame.Stack = readVerificationTypes(d, 1)
+		case t <= SameFrameExtended:
+			frame.OffsetDelta = d.u2()
+		case t < FullFrame:
+			frame.OffsetDelta = d.u2()
+			frame.Locals = readVerificationTypes(d, int(t-SameFrameExtended))
+		default:
+			frame.OffsetDelta = d.u2()
+			frame.Locals = readVerificationTypes(d, int(d.u2()))
+			frame.Stack = readVerificationTypes(d, int(d.u2()))
+		}
+		attr.Entries = append(attr.Entries, frame)
+	}
+	return attr
+}
+
+func readVerificationTypes(d *decoder, count int) []VerificationTypeInfo {
+	var types []VerificationTypeInfo
+	for i := 0; d.err == nil && i < count; i++ {
+		var info VerificationTypeInfo
+		pos := d.pos
+		info.Tag = d.u1()
+		switch info.Tag {
+		case ItemObject:
+			info.CpoolIndex = d.u2()
+		case ItemUninitialized:
+			info.Offset = d.u2()
+		default:
+			if info.Tag > ItemUninitialized && d.err == nil {
+				d.failAt(pos, ErrMalformed, "unknown verification_type_info tag %v", info.Tag)
+			}
+		}
+		types = append(types, info)
+	}
+	return types
+}
+
+// ChoppedLocals returns the number of locals a chop_frame removes, or 0 for other kinds
+// of frame.
+func (f *StackMapFrame) ChoppedLocals() int {
+	if f.FrameType > SameLocals1StackItemExtended && f.FrameType < SameFrameExtended {
+		return int(SameFrameExtended - f.FrameType)
+	}
+	return 0
+}
+
+// Offsets gets the bytecode offset of each frame. The first frame is at its OffsetDelta,
+// and every later one OffsetDelta+1 bytes past the frame before it.
+func (a *StackMapTableAttribute) Offsets() []int {
+	offsets := make([]int, len(a.Entries))
+	for i, frame := range a.Entries {
+		offsets[i] = int(frame.OffsetDelta)
+		if i > 0 {
+			offsets[i] += offsets[i-1] + 1
+		}
+	}
+	return offsets
+}
+
+// Kind names the kind of frame as the JVMS does, without the _frame suffix: "same",
+// "same_locals_1_stack_item", "same_locals_1_stack_item_extended", "chop",
+// "same_extended", "append" or "full". Frame types 128-246 are "reserved".
+func (f *StackMapFrame) Kind() string {
+	switch t := f.FrameType; {
+	case t < 64:
+		return "same"
+	case t < 128:
+		return "same_locals_1_stack_item"
+	case t < SameLocals1StackItemExtended:
+		return "reserved"
+	case t == SameLocals1StackItemExtended:
+		return "same_locals_1_stack_item_extended"
+	case t < SameFrameExtended:
+		return "chop"
+	case t == SameFrameExtended:
+		return "same_extended"
+	case t < FullFrame:
+		return "append"
+	}
+	return "full"
+}
+
+// Repr renders the frame as its kind followed by what it changes, such as
+// "append [int, java/lang/String]" or "full locals [hello] stack []".
+func (f *StackMapFrame) Repr(cp []CpEntry) string {
+	kind := f.Kind()
+	switch {
+	case f.FrameType == FullFrame:
+		return fmt.Sprintf("%v locals %v stack %v", kind, verificationTypesRepr(f.Locals, cp), verificationTypesRepr(f.Stack, cp))
+	case f.FrameType > SameFrameExtended:
+		return kind + " " + verificationTypesRepr(f.Locals, cp)
+	case f.ChoppedLocals() > 0:
+		return fmt.Sprintf("%v %v", kind, f.ChoppedLocals())
+	case len(f.Stack) > 0:
+		return kind + " " + verificationTypesRepr(f.Stack, cp)
+	}
+	return kind
+}
+
+func verificationTypesRepr(types []VerificationTypeInfo, cp []CpEntry) string {
+	var reprs []string
+	for _, info := range types {
+		reprs = append(reprs, info.Repr(cp))
+	}
+	return "[" + strings.Join(reprs, ", ") + "]"
+}
+
+var verificationTypeNames = map[byte]string{
+	ItemTop:               "top",
+	ItemInteger:           "int",
+	ItemFloat:             "float",
+	ItemDouble:            "double",
+	ItemLong:              "long",
+	ItemNull:              "null",
+	ItemUninitializedThis: "uninitializedThis",
+}
+
+// Repr renders the type as the name of its class for ItemObject, "uninitialized(N)" for
+// the object created by the new instruction at offset N, and otherwise as "int",
+// "float", "long", "double", "null", "top" or "uninitializedThis".
+func (v VerificationTypeInfo) Repr(cp []CpEntry) string {
+	switch v.Tag {
+	case ItemObject:
+		return operandRepr(cp, v.CpoolIndex)
+	case ItemUninitialized:
+		return fmt.Sprintf("uninitialized(%v)", v.Offset)
+	}
+	if name, ok := verificationTypeNames[v.Tag]; ok {
+		return name
+	}
+	return fmt.Sprintf("<tag %v>", v.Tag)
+}
+
+// WriteStackMapTable serializes a StackMapTableAttribute into raw attribute data. Each
+// frame is written as the kind its FrameType gives, which must agree with its
+// OffsetDelta, Locals and Stack. NumberOfEntries is ignored.
+func WriteStackMapTable(attr *StackMapTableAttribute) ([]byte, error) {
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	if err := checkCount("stack map frame", len(attr.Entries)); err != nil {
+		return nil, err
+	}
+	write(uint16(len(attr.Entries)))
+	for i, frame := range attr.Entries {
+		t := frame.FrameType
+		locals, stack := 0, 0
+		switch {
+		case t < 64:
+			if frame.OffsetDelta != uint16(t) {
+				return nil, fmt.Errorf("Stack map frame %v: same frame of type %v has offset_delta %v", i, t, frame.OffsetDelta)
+			}
+		case t < 128:
+			stack = 1
+			if frame.OffsetDelta != uint16(t-64) {
+				return nil, fmt.Errorf("Stack map frame %v: same_locals_1_stack_item frame of type %v has offset_delta %v", i, t, frame.OffsetDelta)
+			}
+		case t < SameLocals1StackItemExtended:
+			return nil, fmt.Errorf("Stack map frame %v: reserved frame_type %v", i, t)
+		case t == SameLocals1StackItemExtended:
+			stack = 1
+		case t <= SameFrameExtended:
+		case t < FullFrame:
+			locals = int(t - SameFrameExtended)
+		default:
+			locals, stack = len(frame.Locals), len(frame.Stack)
+			if err := checkCount("frame locals", locals); err != nil {
+				return nil, err
+			}
+			if err := checkCount("frame stack", stack); err != nil {
+				return nil, err
+			}
+		}
+		if len(frame.Locals) != locals || len(frame.Stack) != stack {
+			return nil, fmt.Errorf("Stack map frame %v: %v frame has %v locals and %v stack items", i, frame.Kind(), len(frame.Locals), len(frame.Stack))
+		}
+
+		for _, info := range append(append([]VerificationTypeInfo(nil), frame.Locals...), frame.Stack...) {
+			if info.Tag > ItemUninitialized {
+				return nil, fmt.Errorf("Stack map frame %v: unknown verification_type_info tag %v", i, info.Tag)
+			}
+		}
+
+		write(t)
+		if t >= SameLocals1StackItemExtended {
+			write(frame.OffsetDelta)
+		}
+		if t == FullFrame {
+			write(uint16(locals))
+			writeVerificationTypes(&buf, frame.Locals)
+			write(uint16(stack))
+		} else {
+			writeVerificationTypes(&buf, frame.Locals)
+		}
+		writeVerificationTypes(&buf, frame.Stack)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeVerificationTypes(buf *bytes.Buffer, types []VerificationTypeInfo) {
+	for _, info := range types {
+		buf.WriteByte(info.Tag)
+		switch info.Tag {
+		case ItemObject:
+			binary.Write(buf, binary.BigEndian, info.CpoolIndex)
+		case ItemUninitialized:
+			binary.Write(buf, binary.BigEndian, info.Offset)
+		}
+	}
+}
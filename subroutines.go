@@ -0,0 +1,181 @@
+package classy
+
+import "sort"
+
+// subroutines describes the subroutines of code that uses jsr and ret. A subroutine is
+// identified by the offset of its first instruction, and the main body of the code by
+// -1.
+type subroutines struct {
+	// members marks the instructions of each subroutine, by index: those reachable from
+	// its start, where a jsr passes control to the instruction after it and a ret ends
+	// the subroutine. Code can belong to more than one.
+	members map[int][]bool
+	// entries lists the subroutines in the order they were found, the main body first.
+	entries []int
+	// callers lists the jsr instructions that call each subroutine, by index.
+	callers map[int][]int
+	// rets lists the ret instructions of each subroutine, by index.
+	rets map[int][]int
+	// written marks the locals that each subroutine, or one that it calls, stores to.
+	written map[int][]bool
+}
+
+// findSubroutines works out the instructions of the main body and of each subroutine by
+// following the code from the start of each, treating a jsr as passing control to the
+// instruction after it, where its subroutine returns to.
+func (a *analyzer) findSubroutines() error {
+	subs := &subroutines{
+		members: map[int][]bool{},
+		callers: map[int][]int{},
+		rets:    map[int][]int{},
+		written: map[int][]bool{},
+	}
+	calls := map[int][]int{}
+	called := map[int]bool{}
+	queue := []int{-1}
+	for len(queue) > 0 {
+		sub := queue[0]
+		queue = queue[1:]
+		subs.entries = append(subs.entries, sub)
+		members := make([]bool, len(a.insns))
+		subs.members[sub] = members
+		subs.written[sub] = make([]bool, a.code.MaxLocals)
+		start := 0
+		if sub >= 0 {
+			start = a.at[sub]
+		}
+		work := []int{start}
+		for len(work) > 0 {
+			i := work[len(work)-1]
+			work = work[:len(work)-1]
+			if members[i] {
+				continue
+			}
+			members[i] = true
+			ins := &a.insns[i]
+
+			var next []int
+			switch ins.Opcode {
+			case OpJsr, OpJsrW:
+				if _, ok := a.at[ins.Target]; !ok {
+					return a.errorAt(ins.Offset, "jsr to %v, which is not the start of an instruction", ins.Target)
+				}
+				if !called[ins.Target] {
+					called[ins.Target] = true
+					queue = append(queue, ins.Target)
+				}
+				subs.callers[ins.Target] = append(subs.callers[ins.Target], i)
+				calls[sub] = append(calls[sub], ins.Target)
+				next = append(next, ins.Offset+ins.Size())
+			case OpRet:
+				if sub < 0 {
+					return a.errorAt(ins.Offset, "ret outside a subroutine")
+				}
+				subs.rets[sub] = append(subs.rets[sub], i)
+			default:
+				targets, fallsThrough := successors(ins)
+				next = append(next, targets...)
+				if fallsThrough {
+					next = append(next, ins.Offset+ins.Size())
+				}
+			}
+			if index, slots, ok := localVariable(ins); ok && isStore(ins.Opcode) {
+				for j := index; j < index+slots && j < len(subs.written[sub]); j++ {
+					subs.written[sub][j] = true
+				}
+			}
+			for _, handler := range a.code.ExceptionTable {
+				if ins.Offset >= int(handler.StartPC) && ins.Offset < int(handler.EndPC) {
+					next = append(next, int(handler.HandlerPC))
+				}
+			}
+			for _, offset := range next {
+				// Branches to the middle of an instruction, and running off the end
+				// of the code, are reported when the code is analyzed
+				if j, ok := a.at[offset]; ok {
+					work = append(work, j)
+				}
+			}
+		}
+	}
+
+	// A subroutine may not be called again while it is running, whether directly or
+	// through others it calls
+	state := map[int]int{}
+	var visit func(sub int) error
+	visit = func(sub int) error {
+		state[sub] = 1
+		for _, callee := range calls[sub] {
+			if state[callee] == 1 {
+				return a.errorAt(callee, "the subroutine at %v is called recursively", callee)
+			}
+			if state[callee] == 0 {
+				if err := visit(callee); err != nil {
+					return err
+				}
+			}
+		}
+		state[sub] = 2
+		return nil
+	}
+	if err := visit(-1); err != nil {
+		return err
+	}
+
+	// A subroutine also writes whatever the subroutines it calls write
+	for changed := true; changed; {
+		changed = false
+		for sub, callees := range calls {
+			for _, callee := range callees {
+				for j, w := range subs.written[callee] {
+					if w && !subs.written[sub][j] {
+						subs.written[sub][j] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	// A jsr in code shared by several subroutines is listed once for each
+	for target, callers := range subs.callers {
+		sort.Ints(callers)
+		unique := callers[:0]
+		for j, call := range callers {
+			if j == 0 || call != callers[j-1] {
+				unique = append(unique, call)
+			}
+		}
+		subs.callers[target] = unique
+	}
+	a.subs = subs
+	return nil
+}
+
+// isStore reports whether an instruction stores to a local variable.
+func isStore(op Opcode) bool {
+	return op >= OpIstore && op <= OpAstore || op >= OpIstore0 && op <= OpAstore3 || op == OpIinc
+}
+
+// mergeReturn merges the frame that the ret at index ret returns with into the
+// instruction after the jsr at index call. Locals that the subroutine doesn't write keep
+// their types from before the jsr.
+func (a *analyzer) mergeReturn(call, ret int) error {
+	jsr := &a.insns[call]
+	if call+1 == len(a.insns) {
+		return a.errorAt(jsr.Offset, "execution falls off the end of the code after the subroutine returns")
+	}
+	from, returning := a.frames[call], a.frames[ret]
+	f := &Frame{Locals: append([]VerificationType(nil), from.Locals...), Stack: returning.Stack}
+	for j, w := range a.subs.written[jsr.Target] {
+		if w {
+			f.Locals[j] = returning.Locals[j]
+		}
+	}
+	for j := range f.Locals {
+		// Keep longs and doubles whole where the halves came from different frames
+		if f.Locals[j].slots() == 2 && (j+1 == len(f.Locals) || f.Locals[j+1] != topType) {
+			f.Locals[j] = topType
+		}
+	}
+	return a.merge(a.insns[ret].Offset, a.insns[call+1].Offset, f)
+}
@@ -0,0 +1,27 @@
+public class constants {
+    static final int INT_MIN = Integer.MIN_VALUE;
+    static final int INT_MAX = Integer.MAX_VALUE;
+    static final int INT_NEGATIVE = -1;
+
+    static final long LONG_MIN = Long.MIN_VALUE;
+    static final long LONG_MAX = Long.MAX_VALUE;
+    static final long LONG_NEGATIVE = -1L;
+    static final long LONG_HIGH_WORD = 0x100000000L;
+    static final long LONG_LOW_WORD = 0xFFFFFFFFL;
+
+    static final float FLOAT_NAN = Float.NaN;
+    static final float FLOAT_POSITIVE_INFINITY = Float.POSITIVE_INFINITY;
+    static final float FLOAT_NEGATIVE_INFINITY = Float.NEGATIVE_INFINITY;
+    static final float FLOAT_NEGATIVE_ZERO = -0.0f;
+    static final float FLOAT_MIN = Float.MIN_VALUE;
+    static final float FLOAT_MAX = Float.MAX_VALUE;
+    static final float FLOAT_TENTH = 0.1f;
+
+    static final double DOUBLE_NAN = Double.NaN;
+    static final double DOUBLE_POSITIVE_INFINITY = Double.POSITIVE_INFINITY;
+    static final double DOUBLE_NEGATIVE_INFINITY = Double.NEGATIVE_INFINITY;
+    static final double DOUBLE_NEGATIVE_ZERO = -0.0;
+    static final double DOUBLE_MIN = Double.MIN_VALUE;
+    static final double DOUBLE_MAX = Double.MAX_VALUE;
+    static final double DOUBLE_TEN_MILLION = 1e7;
+}
@@ -0,0 +1,11 @@
+public class more {
+
+    private final static String HIGHLANDER = "connor macleod";
+    protected double THERE_CAN_BE_ONLY = 1.0;
+
+    protected final String name(String... words) {
+        return words[0];
+    }
+
+    protected native String fakeNative();
+}
@@ -0,0 +1,507 @@
+package classy
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// Problem is a way in which a class breaks the format constraints of JVMS §4.8, as
+// found by Validate.
+type Problem struct {
+	// Path names the structure at fault in the style of ParseError.Path, e.g.
+	// "method[3].attr[1]", or is "classfile" for the class as a whole.
+	Path string
+	// Detail describes what is wrong.
+	Detail string
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Detail
+}
+
+// validator collects the problems of a class.
+type validator struct {
+	cf       *ClassFile
+	cp       []CpEntry
+	problems []Problem
+}
+
+func (v *validator) add(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{Path: path, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks a class against the format constraints that the JVM checks before
+// loading it (JVMS §4.8): that the counts agree with what they count, that constant pool
+// entries refer to entries of the right kinds, that names and descriptors are well
+// formed, that access flags are combined legally, that no field or method is declared
+// twice and that nothing follows the last attribute. ReadClassFile only checks what it
+// needs to decode the class, so a class it accepts may still have problems, and using
+// it may then panic; Validate never does.
+func (cf *ClassFile) Validate() []Problem {
+	v := &validator{cf: cf, cp: cf.ConstantPool}
+	if cf.Magic != ClassFileMagic {
+		v.add("classfile", "magic is 0x%X", cf.Magic)
+	}
+	if cf.trailing > 0 {
+		v.add("classfile", "%v bytes follow the last attribute", cf.trailing)
+	}
+	if count, err := constantPoolCount(cf.ConstantPool); err != nil {
+		v.add("classfile", "%v", err)
+	} else if count != cf.ConstantPoolCount {
+		v.add("classfile", "constant_pool_count is %v for %v entries", cf.ConstantPoolCount, count-1)
+	}
+	v.count("classfile", "interfaces_count", int(cf.InterfacesCount), len(cf.Interfaces))
+	v.count("classfile", "fields_count", int(cf.FieldsCount), len(cf.Fields))
+	v.count("classfile", "methods_count", int(cf.MethodsCount), len(cf.Methods))
+	v.count("classfile", "attributes_count", int(cf.AttrsCount), len(cf.Attrs))
+
+	for i, ent := range cf.ConstantPool {
+		if ent != nil {
+			v.constant(fmt.Sprintf("constant_pool[%v]", i+1), ent)
+		}
+	}
+	v.class()
+	v.fields()
+	v.methods()
+	v.attrs("classfile", cf.Attrs)
+	return v.problems
+}
+
+func (v *validator) count(path, what string, count, actual int) {
+	if count != actual {
+		v.add(path, "%v is %v, but there are %v", what, count, actual)
+	}
+}
+
+// entry gets the constant at index if it is one of the given types, given as pointers
+// to their zero values, and otherwise adds a problem.
+func (v *validator) entry(path, what string, index uint16, types ...CpEntry) CpEntry {
+	ent := LookupEntry(v.cp, index)
+	if ent == nil {
+		v.add(path, "%v #%v is not a constant", what, index)
+		return nil
+	}
+	var names []string
+	for _, t := range types {
+		if ent.RawTag() == t.RawTag() {
+			return ent
+		}
+		names = append(names, t.StringTag())
+	}
+	v.add(path, "%v #%v is a %v, not a %v", what, index, ent.StringTag(), strings.Join(names, " or "))
+	return nil
+}
+
+// utf8 gets the Utf8 constant at index, or adds a problem and returns "" and false.
+func (v *validator) utf8(path, what string, index uint16) (string, bool) {
+	ent := v.entry(path, what, index, &CONSTANT_Utf8_info{Tag: CONSTANT_Utf8})
+	if ent == nil {
+		return "", false
+	}
+	return ent.(*CONSTANT_Utf8_info).Value(), true
+}
+
+// className gets the name of the CONSTANT_Class at index, or adds a problem.
+func (v *validator) className(path, what string, index uint16) (string, bool) {
+	if v.entry(path, what, index, &CONSTANT_Class_info{Tag: CONSTANT_Class}) == nil {
+		return "", false
+	}
+	return lookupClassName(v.cp, index)
+}
+
+// nameAndType gets the name and descriptor of the CONSTANT_NameAndType at index.
+func (v *validator) nameAndType(path string, index uint16) (name, descriptor string, ok bool) {
+	ent := v.entry(path, "name_and_type_index", index, &CONSTANT_NameAndType_info{Tag: CONSTANT_NameAndType})
+	if ent == nil {
+		return "", "", false
+	}
+	nat := ent.(*CONSTANT_NameAndType_info)
+	nameUtf8, okName := lookupUtf8(v.cp, nat.NameIndex)
+	descriptorUtf8, okDescriptor := lookupUtf8(v.cp, nat.DescriptorIndex)
+	if !okName || !okDescriptor {
+		return "", "", false
+	}
+	return nameUtf8.Value(), descriptorUtf8.Value(), true
+}
+
+func (v *validator) constant(path string, ent CpEntry) {
+	if err := CheckConstantTag(ent.RawTag(), v.cf.MajorVersion, v.cf.AccessFlags); err != nil {
+		v.add(path, "%v", err)
+	}
+	utf8 := &CONSTANT_Utf8_info{Tag: CONSTANT_Utf8}
+	switch e := ent.(type) {
+	case *CONSTANT_Class_info:
+		if name, ok := v.utf8(path, "name_index", e.NameIndex); ok && !validClassName(name) {
+			v.add(path, "%q is not a class name or array descriptor", name)
+		}
+	case *CONSTANT_Fieldref_info:
+		v.className(path, "class_index", e.ClassIndex)
+		if name, descriptor, ok := v.nameAndType(path, e.NameAndTypeIndex); ok {
+			v.fieldName(path, name, descriptor)
+		}
+	case *CONSTANT_Methodref_info:
+		v.className(path, "class_index", e.ClassIndex)
+		if name, descriptor, ok := v.nameAndType(path, e.NameAndTypeIndex); ok {
+			v.methodName(path, name, descriptor)
+		}
+	case *CONSTANT_InterfaceMethodref_info:
+		v.className(path, "class_index", e.ClassIndex)
+		if name, descriptor, ok := v.nameAndType(path, e.NameAndTypeIndex); ok {
+			v.methodName(path, name, descriptor)
+		}
+	case *CONSTANT_String_info:
+		v.entry(path, "string_index", e.StringIndex, utf8)
+	case *CONSTANT_NameAndType_info:
+		v.entry(path, "name_index", e.NameIndex, utf8)
+		v.entry(path, "descriptor_index", e.DescriptorIndex, utf8)
+	case *CONSTANT_MethodHandle_info:
+		v.methodHandle(path, e)
+	case *CONSTANT_MethodType_info:
+		if descriptor, ok := v.utf8(path, "descriptor_index", e.DescriptorIndex); ok {
+			if _, err := ParseMethodDescriptor(descriptor); err != nil {
+				v.add(path, "%v", err)
+			}
+		}
+	case *CONSTANT_Dynamic_info:
+		v.bootstrapMethod(path, e.BootstrapMethodAttrIndex)
+		if name, descriptor, ok := v.nameAndType(path, e.NameAndTypeIndex); ok {
+			v.fieldName(path, name, descriptor)
+		}
+	case *CONSTANT_InvokeDynamic_info:
+		v.bootstrapMethod(path, e.BootstrapMethodAttrIndex)
+		if name, descriptor, ok := v.nameAndType(path, e.NameAndTypeIndex); ok {
+			v.methodName(path, name, descriptor)
+			if name == "<init>" || name == "<clinit>" {
+				v.add(path, "a call site can't be named %v", name)
+			}
+		}
+	case *CONSTANT_Module_info:
+		v.entry(path, "name_index", e.NameIndex, utf8)
+	case *CONSTANT_Package_info:
+		v.entry(path, "name_index", e.NameIndex, utf8)
+	}
+}
+
+// methodHandle checks that a method handle refers to the kind of member its reference
+// kind needs.
+func (v *validator) methodHandle(path string, e *CONSTANT_MethodHandle_info) {
+	fieldref := &CONSTANT_Fieldref_info{Tag: CONSTANT_Fieldref}
+	methodref := &CONSTANT_Methodref_info{Tag: CONSTANT_Methodref}
+	interfaceMethodref := &CONSTANT_InterfaceMethodref_info{Tag: CONSTANT_InterfaceMethodref}
+	var ent CpEntry
+	switch e.ReferenceKind {
+	case RefGetField, RefGetStatic, RefPutField, RefPutStatic:
+		ent = v.entry(path, "reference_index", e.ReferenceIndex, fieldref)
+	case RefInvokeVirtual, RefNewInvokeSpecial:
+		ent = v.entry(path, "reference_index", e.ReferenceIndex, methodref)
+	case RefInvokeStatic, RefInvokeSpecial:
+		if v.cf.MajorVersion < 52 {
+			ent = v.entry(path, "reference_index", e.ReferenceIndex, methodref)
+		} else {
+			ent = v.entry(path, "reference_index", e.ReferenceIndex, methodref, interfaceMethodref)
+		}
+	case RefInvokeInterface:
+		ent = v.entry(path, "reference_index", e.ReferenceIndex, interfaceMethodref)
+	default:
+		v.add(path, "unknown reference_kind %v", e.ReferenceKind)
+		return
+	}
+	var natIndex uint16
+	switch ref := ent.(type) {
+	case *CONSTANT_Fieldref_info:
+		natIndex = ref.NameAndTypeIndex
+	case *CONSTANT_Methodref_info:
+		natIndex = ref.NameAndTypeIndex
+	case *CONSTANT_InterfaceMethodref_info:
+		natIndex = ref.NameAndTypeIndex
+	}
+	nat, ok := LookupEntry(v.cp, natIndex).(*CONSTANT_NameAndType_info)
+	if !ok {
+		return
+	}
+	name, ok := lookupUtf8(v.cp, nat.NameIndex)
+	if !ok {
+		return
+	}
+	if e.ReferenceKind == RefNewInvokeSpecial && name.Value() != "<init>" {
+		v.add(path, "%v refers to %v, not <init>", ReferenceKindName(e.ReferenceKind), name.Value())
+	}
+	if e.ReferenceKind != RefNewInvokeSpecial && (name.Value() == "<init>" || name.Value() == "<clinit>") {
+		v.add(path, "%v refers to %v", ReferenceKindName(e.ReferenceKind), name.Value())
+	}
+}
+
+func (v *validator) bootstrapMethod(path string, index uint16) {
+	attr := findAttr(v.cf.Attrs, v.cp, "BootstrapMethods")
+	if attr == nil {
+		v.add(path, "there is no BootstrapMethods attribute")
+		return
+	}
+	decoded, err := attr.Decode(v.cp)
+	if err != nil {
+		// Reported with the attribute
+		return
+	}
+	if methods := decoded.(*BootstrapMethodsAttribute).BootstrapMethods; int(index) >= len(methods) {
+		v.add(path, "bootstrap_method_attr_index %v is out of range for %v bootstrap methods", index, len(methods))
+	}
+}
+
+// fieldName checks the name and descriptor of a field.
+func (v *validator) fieldName(path, name, descriptor string) {
+	if !validUnqualifiedName(name, false) {
+		v.add(path, "%q is not a valid field name", name)
+	}
+	if _, err := ParseFieldDescriptor(descriptor); err != nil {
+		v.add(path, "%v", err)
+	} else if strings.HasPrefix(descriptor, strings.Repeat("[", 256)) {
+		v.add(path, "%v has more than 255 array dimensions", descriptor)
+	}
+}
+
+// methodName checks the name and descriptor of a method.
+func (v *validator) methodName(path, name, descriptor string) {
+	if name != "<init>" && name != "<clinit>" && !validUnqualifiedName(name, true) {
+		v.add(path, "%q is not a valid method name", name)
+	}
+	m, err := ParseMethodDescriptor(descriptor)
+	if err != nil {
+		v.add(path, "%v", err)
+		return
+	}
+	if (name == "<init>" || name == "<clinit>") && m.Return != Void {
+		v.add(path, "%v must return void, not %v", name, m.Return)
+	}
+}
+
+// paramSlots checks that the parameters of an instance method, with this, fit in
+// MaxParamSlots. ParseMethodDescriptor has already checked those of static methods.
+func (v *validator) paramSlots(path, descriptor string, flags Access) {
+	if flags&AccStatic != 0 {
+		return
+	}
+	m, err := ParseMethodDescriptor(descriptor)
+	if err != nil {
+		return
+	}
+	if slots := m.ParamSlots() + 1; slots > MaxParamSlots {
+		v.add(path, "%v has parameters taking %v slots with this, more than %v", descriptor, slots, MaxParamSlots)
+	}
+}
+
+// class checks this_class, super_class, the interfaces and the class's access flags.
+func (v *validator) class() {
+	cf := v.cf
+	flags := cf.AccessFlags
+	switch {
+	case flags&AccModule != 0:
+		if flags != AccModule {
+			v.add("classfile", "a module descriptor has access flags other than ACC_MODULE: %v", ClassFlagsRepr(flags))
+		}
+	case flags&AccInterface != 0:
+		if flags&AccAbstract == 0 {
+			v.add("classfile", "an interface must be ACC_ABSTRACT")
+		}
+		if flags&(AccFinal|AccSuper|AccEnum) != 0 {
+			v.add("classfile", "an interface can't be ACC_FINAL, ACC_SUPER or ACC_ENUM")
+		}
+	default:
+		if flags&AccAnnotation != 0 {
+			v.add("classfile", "ACC_ANNOTATION is set on a class that isn't an interface")
+		}
+		if flags&AccFinal != 0 && flags&AccAbstract != 0 {
+			v.add("classfile", "a class can't be both ACC_FINAL and ACC_ABSTRACT")
+		}
+	}
+
+	name, ok := v.className("classfile", "this_class", cf.ThisClass)
+	if ok && strings.HasPrefix(name, "[") {
+		v.add("classfile", "this_class is the array type %v", name)
+	}
+	if ok && flags&AccModule != 0 && name != "module-info" {
+		v.add("classfile", "this_class of a module descriptor is %v, not module-info", name)
+	}
+	if flags&AccModule != 0 {
+		if cf.SuperClass != 0 {
+			v.add("classfile", "a module descriptor has a super_class")
+		}
+		if len(cf.Interfaces) > 0 || len(cf.Fields) > 0 || len(cf.Methods) > 0 {
+			v.add("classfile", "a module descriptor has interfaces, fields or methods")
+		}
+	} else if cf.SuperClass == 0 {
+		if ok && name != "java/lang/Object" {
+			v.add("classfile", "super_class is 0, but only java/lang/Object has no superclass")
+		}
+	} else if super, okSuper := v.className("classfile", "super_class", cf.SuperClass); okSuper {
+		if flags&AccInterface != 0 && super != "java/lang/Object" {
+			v.add("classfile", "the superclass of an interface must be java/lang/Object, not %v", super)
+		}
+		if ok && name == "java/lang/Object" {
+			v.add("classfile", "java/lang/Object has the superclass %v", super)
+		}
+	}
+	for i, index := range cf.Interfaces {
+		v.className(fmt.Sprintf("interfaces[%v]", i), "interface", index)
+	}
+}
+
+func (v *validator) fields() {
+	seen := map[string]bool{}
+	isInterface := v.cf.AccessFlags&AccInterface != 0
+	for i := range v.cf.Fields {
+		field := &v.cf.Fields[i]
+		path := fmt.Sprintf("field[%v]", i)
+		flags := field.AccessFlags
+		if bits.OnesCount16(uint16(flags&(AccPublic|AccPrivate|AccProtected))) > 1 {
+			v.add(path, "more than one of ACC_PUBLIC, ACC_PRIVATE and ACC_PROTECTED: %v", FieldFlagsRepr(flags))
+		}
+		if flags&AccFinal != 0 && flags&AccVolatile != 0 {
+			v.add(path, "a field can't be both ACC_FINAL and ACC_VOLATILE")
+		}
+		if isInterface && flags&^AccSynthetic != AccPublic|AccStatic|AccFinal {
+			v.add(path, "an interface field must be public static final, not %v", FieldFlagsRepr(flags))
+		}
+		name, okName := v.utf8(path, "name_index", field.NameIndex)
+		descriptor, okDescriptor := v.utf8(path, "descriptor_index", field.DescriptorIndex)
+		if okName && okDescriptor {
+			v.fieldName(path, name, descriptor)
+			if seen[name+":"+descriptor] {
+				v.add(path, "duplicate field %v %v", name, descriptor)
+			}
+			seen[name+":"+descriptor] = true
+		}
+		v.count(path, "attributes_count", int(field.AttrsCount), len(field.Attrs))
+		v.attrs(path, field.Attrs)
+	}
+}
+
+func (v *validator) methods() {
+	seen := map[string]bool{}
+	classFlags := v.cf.AccessFlags
+	for i := range v.cf.Methods {
+		method := &v.cf.Methods[i]
+		path := fmt.Sprintf("method[%v]", i)
+		name, okName := v.utf8(path, "name_index", method.NameIndex)
+		descriptor, okDescriptor := v.utf8(path, "descriptor_index", method.DescriptorIndex)
+		if okName && okDescriptor {
+			v.methodName(path, name, descriptor)
+			v.paramSlots(path, descriptor, method.AccessFlags)
+			if seen[name+descriptor] {
+				v.add(path, "duplicate method %v%v", name, descriptor)
+			}
+			seen[name+descriptor] = true
+		}
+		v.methodFlags(path, name, method.AccessFlags, classFlags)
+		if name == "<clinit>" && v.cf.MajorVersion >= 51 && method.AccessFlags&AccStatic == 0 {
+			v.add(path, "<clinit> must be ACC_STATIC")
+		}
+		hasCode := findAttr(method.Attrs, v.cp, "Code") != nil
+		if bodiless := method.AccessFlags&(AccAbstract|AccNative) != 0; hasCode == bodiless {
+			if bodiless {
+				v.add(path, "an abstract or native method has a Code attribute")
+			} else {
+				v.add(path, "no Code attribute")
+			}
+		}
+		v.count(path, "attributes_count", int(method.AttrsCount), len(method.Attrs))
+		v.attrs(path, method.Attrs)
+	}
+}
+
+// methodFlags checks the access flags of a method named name, in a class with the
+// access flags classFlags.
+func (v *validator) methodFlags(path, name string, flags, classFlags Access) {
+	visibility := flags & (AccPublic | AccPrivate | AccProtected)
+	if bits.OnesCount16(uint16(visibility)) > 1 {
+		v.add(path, "more than one of ACC_PUBLIC, ACC_PRIVATE and ACC_PROTECTED: %v", MethodFlagsRepr(flags))
+	}
+	if name == "<clinit>" {
+		// Only ACC_STATIC and ACC_STRICT matter, and other flags are ignored
+		return
+	}
+	if classFlags&AccInterface != 0 {
+		if v.cf.MajorVersion < 52 {
+			if flags&(AccPublic|AccAbstract) != AccPublic|AccAbstract || flags&^(AccPublic|AccAbstract|AccVarargs|AccBridge|AccSynthetic) != 0 {
+				v.add(path, "an interface method before version 52 must be public abstract, not %v", MethodFlagsRepr(flags))
+			}
+		} else {
+			if flags&(AccProtected|AccFinal|AccSynchronized|AccNative) != 0 {
+				v.add(path, "an interface method can't be protected, final, synchronized or native: %v", MethodFlagsRepr(flags))
+			}
+			if visibility != AccPublic && visibility != AccPrivate {
+				v.add(path, "an interface method must be either public or private")
+			}
+		}
+	}
+	if flags&AccAbstract != 0 {
+		var disallowed Access = AccPrivate | AccStatic | AccFinal | AccSynchronized | AccNative
+		if v.cf.MajorVersion >= 46 && v.cf.MajorVersion < 61 {
+			disallowed |= AccStrict
+		}
+		if flags&disallowed != 0 {
+			v.add(path, "an abstract method can't be %v", MethodFlagsRepr(flags&disallowed))
+		}
+	}
+	if name == "<init>" && flags&^(AccPublic|AccPrivate|AccProtected|AccVarargs|AccStrict|AccSynthetic) != 0 {
+		v.add(path, "<init> can't be %v", MethodFlagsRepr(flags&^(AccPublic|AccPrivate|AccProtected|AccVarargs|AccStrict|AccSynthetic)))
+	}
+}
+
+// attrs checks that attributes are named by Utf8 constants, that their lengths are
+// right, and that the attributes this package knows decode, including those of Code
+// attributes.
+func (v *validator) attrs(path string, attrs []AttrInfo) {
+	for i := range attrs {
+		attr := &attrs[i]
+		attrPath := fmt.Sprintf("%v.attr[%v]", path, i)
+		if path == "classfile" {
+			attrPath = fmt.Sprintf("attr[%v]", i)
+		}
+		if int(attr.AttrLength) != len(attr.AttrData) {
+			v.add(attrPath, "attribute_length is %v, but there are %v bytes", attr.AttrLength, len(attr.AttrData))
+		}
+		name, ok := v.utf8(attrPath, "attribute_name_index", attr.NameIndex)
+		if !ok {
+			continue
+		}
+		if _, err := attr.Decode(v.cp); err != nil {
+			v.add(attrPath, "%v: %v", name, err)
+			continue
+		}
+		if name == "Code" {
+			code, _ := ReadCodeAttribute(attr.AttrData)
+			v.count(attrPath, "exception_table_length", int(code.ExceptionTableLength), len(code.ExceptionTable))
+			v.count(attrPath, "attributes_count", int(code.AttrsCount), len(code.Attrs))
+			if len(code.Code) == 0 || len(code.Code) > 65535 {
+				v.add(attrPath, "code_length %v is not between 1 and 65535", len(code.Code))
+			}
+			v.attrs(attrPath, code.Attrs)
+		}
+	}
+}
+
+// validUnqualifiedName reports whether name is a valid unqualified name (JVMS §4.2.2):
+// not empty and without '.', ';', '[' or '/', nor for a method '<' or '>'.
+func validUnqualifiedName(name string, method bool) bool {
+	disallowed := ".;[/"
+	if method {
+		disallowed += "<>"
+	}
+	return name != "" && !strings.ContainsAny(name, disallowed)
+}
+
+// validClassName reports whether name is a binary name in internal form, or an array
+// descriptor as CONSTANT_Class entries hold for array types.
+func validClassName(name string) bool {
+	if strings.HasPrefix(name, "[") {
+		_, err := ParseFieldDescriptor(name)
+		return err == nil && !strings.HasPrefix(name, strings.Repeat("[", 256))
+	}
+	for _, part := range strings.Split(name, "/") {
+		if !validUnqualifiedName(part, false) {
+			return false
+		}
+	}
+	return true
+}
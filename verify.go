@@ -0,0 +1,346 @@
+package classy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Verify checks the code of every method the way the JVM's verifier does. For classes
+// of version 50 and above that is type checking (JVMS §4.10.1): each instruction is
+// checked in order against the frames of the method's StackMapTable, which must be given
+// wherever control can pass other than from the instruction before. Older classes are
+// verified by type inference (JVMS §4.10.2), which follows every path through the code,
+// including into and out of the subroutines of jsr and ret, merging types where paths
+// meet. Classes are compared with hierarchy, which must know every class whose place in
+// the hierarchy matters to the code. A nil hierarchy stands for CoreClasses and the class
+// itself. The first problem found in each method is returned, as a *VerifyError where it
+// concerns the code.
+func (cf *ClassFile) Verify(hierarchy ClassHierarchy) []error {
+	if hierarchy == nil {
+		set := NewClassSet(CoreClasses)
+		if err := set.Add(cf); err != nil {
+			return []error{err}
+		}
+		hierarchy = set
+	}
+	var errs []error
+	for i := range cf.Methods {
+		method := &cf.Methods[i]
+		code := findAttr(method.Attrs, cf.ConstantPool, "Code")
+		if err := verifyMethod(cf, method, code, hierarchy); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func verifyMethod(cf *ClassFile, method *MethodInfo, attr *AttrInfo, hierarchy ClassHierarchy) error {
+	abstract := method.AccessFlags&(AccAbstract|AccNative) != 0
+	if (attr == nil) != abstract {
+		name := fmt.Sprintf("method #%v", method.NameIndex)
+		if utf8, ok := lookupUtf8(cf.ConstantPool, method.NameIndex); ok {
+			name = utf8.Value()
+		}
+		if abstract {
+			return fmt.Errorf("%v is abstract or native, but has code", name)
+		}
+		return fmt.Errorf("%v has no code", name)
+	}
+	if attr == nil {
+		return nil
+	}
+	code, err := ReadCodeAttribute(attr.AttrData)
+	if err != nil {
+		return err
+	}
+	a, err := newAnalyzer(cf, method, code, hierarchy)
+	if err != nil {
+		return err
+	}
+	a.strict = true
+	if cf.MajorVersion >= 50 {
+		err = a.check()
+		// Like the JVM, fall back to inference for version 50, whose compilers
+		// weren't yet required to emit frames
+		if err == nil || cf.MajorVersion > 50 {
+			return err
+		}
+	}
+	if err := a.findSubroutines(); err != nil {
+		return err
+	}
+	return a.run()
+}
+
+// check goes through the code once, checking that each instruction can be applied to
+// the frame before it, and that the frame after it is assignable to the stack map frame
+// of each instruction it can pass control to.
+func (a *analyzer) check() error {
+	if len(a.insns) == 0 {
+		return a.errorAt(0, "no code")
+	}
+	initial, err := a.initialFrame()
+	if err != nil {
+		return err
+	}
+	frames, err := a.stackMapFrames(initial)
+	if err != nil {
+		return err
+	}
+	end := len(a.code.Code)
+	for _, handler := range a.code.ExceptionTable {
+		_, okStart := a.at[int(handler.StartPC)]
+		_, okEnd := a.at[int(handler.EndPC)]
+		if !okStart || !okEnd && int(handler.EndPC) != end || handler.StartPC >= handler.EndPC {
+			return a.errorAt(int(handler.StartPC), "exception handler range %v-%v doesn't cover whole instructions", handler.StartPC, handler.EndPC)
+		}
+	}
+
+	current := initial
+	for i := range a.insns {
+		ins := &a.insns[i]
+		if f, ok := frames[ins.Offset]; ok {
+			if current != nil {
+				if err := a.checkFrame(ins.Offset, current, ins.Offset, f); err != nil {
+					return err
+				}
+			}
+			current = f
+		} else if current == nil {
+			return a.errorAt(ins.Offset, "no stack map frame for the instruction after %v", a.insns[i-1].Opcode)
+		}
+
+		for _, handler := range a.code.ExceptionTable {
+			if ins.Offset < int(handler.StartPC) || ins.Offset >= int(handler.EndPC) {
+				continue
+			}
+			target, ok := frames[int(handler.HandlerPC)]
+			if !ok {
+				return a.frameError(ins.Offset, current, "exception handler at %v has no stack map frame", handler.HandlerPC)
+			}
+			catchType, err := a.catchType(ins.Offset, handler)
+			if err != nil {
+				return err
+			}
+			thrown := &Frame{Locals: current.Locals, Stack: []VerificationType{catchType}}
+			if err := a.checkFrame(ins.Offset, thrown, int(handler.HandlerPC), target); err != nil {
+				return err
+			}
+		}
+
+		out, err := a.execute(ins, current)
+		if err != nil {
+			return err
+		}
+		if len(out.Stack) > int(a.code.MaxStack) {
+			return a.frameError(ins.Offset, current, "%v overflows max_stack %v", ins.Opcode, a.code.MaxStack)
+		}
+		targets, fallsThrough := successors(ins)
+		for _, target := range targets {
+			f, ok := frames[target]
+			if !ok {
+				if _, ok := a.at[target]; !ok {
+					return a.frameError(ins.Offset, current, "branch to %v, which is not the start of an instruction", target)
+				}
+				return a.frameError(ins.Offset, current, "branch to %v, which has no stack map frame", target)
+			}
+			if err := a.checkFrame(ins.Offset, out, target, f); err != nil {
+				return err
+			}
+		}
+		current = nil
+		if fallsThrough {
+			if ins.Offset+ins.Size() == end {
+				return a.errorAt(ins.Offset, "execution falls off the end of the code")
+			}
+			current = out
+		}
+	}
+	return nil
+}
+
+// checkFrame checks that the frame that control passes with from the instruction at
+// offset from is assignable to the stack map frame at offset to.
+func (a *analyzer) checkFrame(from int, f *Frame, to int, target *Frame) error {
+	if len(f.Stack) != len(target.Stack) {
+		return a.frameError(from, f, "the operand stack is %v, but the stack map frame at %v has %v", typesString(f.Stack), to, typesString(target.Stack))
+	}
+	for _, types := range []struct {
+		what         string
+		got, targets []VerificationType
+	}{{"local", f.Locals, target.Locals}, {"operand stack slot", f.Stack, target.Stack}} {
+		for j := range types.got {
+			ok, err := a.isAssignable(types.got[j], types.targets[j])
+			if err != nil || !ok {
+				return a.frameError(from, f, "%v %v is %v, but the stack map frame at %v has %v%v", types.what, j, types.got[j], to, types.targets[j], errorSuffix(err))
+			}
+		}
+	}
+	return nil
+}
+
+func errorSuffix(err error) string {
+	if err == nil {
+		return ""
+	}
+	return fmt.Sprintf(" (%v)", err)
+}
+
+// stackMapFrames expands the frames of the StackMapTable, if the code has one, into the
+// frame at each offset they are given for.
+func (a *analyzer) stackMapFrames(initial *Frame) (map[int]*Frame, error) {
+	frames := map[int]*Frame{}
+	var table *StackMapTableAttribute
+	for _, attr := range a.code.Attrs {
+		if name, ok := lookupUtf8(a.cp, attr.NameIndex); !ok || name.Value() != "StackMapTable" {
+			continue
+		}
+		if table != nil {
+			return nil, a.errorAt(0, "more than one StackMapTable")
+		}
+		decoded, err := attr.Decode(a.cp)
+		if err != nil {
+			return nil, a.errorAt(0, "StackMapTable: %v", err)
+		}
+		table = decoded.(*StackMapTableAttribute)
+	}
+	if table == nil {
+		return frames, nil
+	}
+
+	locals := compress(initial.Locals, true)
+	for i, offset := range table.Offsets() {
+		entry := &table.Entries[i]
+		if _, ok := a.at[offset]; !ok {
+			return nil, a.errorAt(offset, "stack map frame %v is not at the start of an instruction", i)
+		}
+		var stack []VerificationType
+		var err error
+		switch t := entry.FrameType; {
+		case t < 128 || t == SameLocals1StackItemExtended:
+			stack, err = a.frameTypes(entry.Stack)
+		case t < SameFrameExtended:
+			chopped := entry.ChoppedLocals()
+			if chopped > len(locals) {
+				return nil, a.errorAt(offset, "stack map frame %v chops %v locals from %v", i, chopped, typesString(locals))
+			}
+			locals = locals[:len(locals)-chopped]
+		case t == SameFrameExtended:
+		case t < FullFrame:
+			var added []VerificationType
+			added, err = a.frameTypes(entry.Locals)
+			locals = append(append([]VerificationType(nil), locals...), added...)
+		default:
+			if locals, err = a.frameTypes(entry.Locals); err == nil {
+				stack, err = a.frameTypes(entry.Stack)
+			}
+		}
+		if err != nil {
+			return nil, a.errorAt(offset, "stack map frame %v: %v", i, err)
+		}
+
+		f := &Frame{Locals: expand(locals), Stack: expand(stack)}
+		if len(f.Locals) > int(a.code.MaxLocals) {
+			return nil, a.errorAt(offset, "stack map frame %v has locals %v, beyond max_locals %v", i, typesString(f.Locals), a.code.MaxLocals)
+		}
+		if len(f.Stack) > int(a.code.MaxStack) {
+			return nil, a.errorAt(offset, "stack map frame %v has the operand stack %v, beyond max_stack %v", i, typesString(f.Stack), a.code.MaxStack)
+		}
+		for len(f.Locals) < int(a.code.MaxLocals) {
+			f.Locals = append(f.Locals, topType)
+		}
+		frames[offset] = f
+	}
+	return frames, nil
+}
+
+// frameTypes gets the types of the entries of a stack map frame.
+func (a *analyzer) frameTypes(infos []VerificationTypeInfo) ([]VerificationType, error) {
+	var types []VerificationType
+	for _, info := range infos {
+		t := VerificationType{Tag: info.Tag}
+		switch info.Tag {
+		case ItemObject:
+			class, ok := lookupClassName(a.cp, info.CpoolIndex)
+			if !ok {
+				return nil, fmt.Errorf("#%v is not a class", info.CpoolIndex)
+			}
+			t.Class = class
+		case ItemUninitialized:
+			t.Offset = int(info.Offset)
+			if i, ok := a.at[t.Offset]; !ok || a.insns[i].Opcode != OpNew {
+				return nil, fmt.Errorf("uninitialized(%v) doesn't refer to a new instruction", t.Offset)
+			}
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// expand lists types with a slot each, following each long and double with a Top.
+func expand(types []VerificationType) []VerificationType {
+	var slots []VerificationType
+	for _, t := range types {
+		slots = append(slots, t)
+		if t.slots() == 2 {
+			slots = append(slots, topType)
+		}
+	}
+	return slots
+}
+
+// isAssignable reports whether a value of type from can be used where the verifier
+// expects one of type to.
+func (a *analyzer) isAssignable(from, to VerificationType) (bool, error) {
+	switch {
+	case from == to || to == topType:
+		return true, nil
+	case to.Tag == ItemObject && from.Tag == ItemNull:
+		return true, nil
+	case to.Tag == ItemObject && from.Tag == ItemObject:
+		return a.isClassAssignable(from.Class, to.Class)
+	}
+	return false, nil
+}
+
+// isClassAssignable reports whether a reference to the class or array from can be used
+// where one to the class or array to is expected. Like the JVM's verifier it takes any
+// reference to be assignable to an interface, leaving that to be checked at run time.
+func (a *analyzer) isClassAssignable(from, to string) (bool, error) {
+	if from == to || to == "java/lang/Object" {
+		return true, nil
+	}
+	if strings.HasPrefix(to, "[") {
+		if !strings.HasPrefix(from, "[") {
+			return false, nil
+		}
+		fromComponent, okFrom := componentClass(from)
+		toComponent, okTo := componentClass(to)
+		if okFrom && okTo {
+			return a.isClassAssignable(fromComponent, toComponent)
+		}
+		return false, nil
+	}
+	isInterface, err := a.hierarchy.IsInterface(to)
+	if err != nil || isInterface {
+		return isInterface, err
+	}
+	if strings.HasPrefix(from, "[") {
+		return false, nil
+	}
+	return IsSubclass(a.hierarchy, from, to)
+}
+
+// checkSuperInit checks that a constructor calls the constructor of its own class or of
+// its direct superclass on uninitializedThis.
+func (a *analyzer) checkSuperInit(s *typeState, class string) {
+	if class == a.thisClass {
+		return
+	}
+	superclass, err := a.hierarchy.Superclass(a.thisClass)
+	if err != nil {
+		s.fail("can't check the superclass constructor call: %v", err)
+	} else if class != superclass {
+		s.fail("invokespecial %v.<init> on uninitializedThis, but the superclass of %v is %v", class, a.thisClass, superclass)
+	}
+}
@@ -0,0 +1,262 @@
+package classy
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyFixtures(t *testing.T) {
+	classes, err := filepath.Glob("samples/*.class")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, class := range classes {
+		data, err := ioutil.ReadFile(class)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cf, err := ReadClassFile(data)
+		if err != nil {
+			t.Fatalf("%v: %v", class, err)
+		}
+		// A nil hierarchy stands for CoreClasses and the class itself
+		if errs := cf.Verify(nil); len(errs) > 0 {
+			t.Errorf("%v: %v", class, errs)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name, descriptor string
+		// frames computes the frames before verifying
+		frames bool
+		build  func(m *MethodBuilder)
+		// offset and detail are where the verifier should fail, or -1 and "" if it
+		// should pass
+		offset int
+		detail string
+	}{
+		{"valid", "(Ljava/lang/String;)I", true, func(m *MethodBuilder) {
+			m.VarInsn(OpAload, 0)
+			m.MethodInsn(OpInvokevirtual, "java/lang/String", "length", "()I", false)
+			m.Insn(OpIreturn)
+		}, -1, ""},
+		{"subclass argument", "(Ljava/lang/String;)Z", true, func(m *MethodBuilder) {
+			m.VarInsn(OpAload, 0)
+			m.VarInsn(OpAload, 0)
+			m.MethodInsn(OpInvokevirtual, "java/lang/Object", "equals", "(Ljava/lang/Object;)Z", false)
+			m.Insn(OpIreturn)
+		}, -1, ""},
+		{"wrong local type", "(Ljava/lang/Object;)I", false, func(m *MethodBuilder) {
+			m.VarInsn(OpIload, 0)
+			m.Insn(OpIreturn)
+		}, 0, "iload_0 expects int in local 0, found java/lang/Object"},
+		{"wrong receiver", "(Ljava/lang/Object;)I", true, func(m *MethodBuilder) {
+			m.VarInsn(OpAload, 0)
+			m.MethodInsn(OpInvokevirtual, "java/lang/String", "length", "()I", false)
+			m.Insn(OpIreturn)
+		}, 1, "invokevirtual expects java/lang/String on the stack, found java/lang/Object"},
+		{"wrong return", "()Ljava/lang/String;", true, func(m *MethodBuilder) {
+			m.FieldInsn(OpGetstatic, "java/lang/System", "out", "Ljava/io/PrintStream;")
+			m.Insn(OpAreturn)
+		}, 3, "areturn expects java/lang/String on the stack, found java/io/PrintStream"},
+		{"missing frame", "(I)V", false, func(m *MethodBuilder) {
+			end := m.NewLabel()
+			m.VarInsn(OpIload, 0)
+			m.JumpInsn(OpIfeq, end)
+			m.Mark(end)
+			m.Insn(OpReturn)
+		}, 1, "branch to 4, which has no stack map frame"},
+	}
+	for _, test := range tests {
+		b := NewClassBuilder(AccPublic|AccSuper, "T", "java/lang/Object")
+		b.SetVersion(52, 0)
+		test.build(b.AddMethod(AccPublic|AccStatic, "f", test.descriptor))
+		cf, err := b.Build()
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		hierarchy := NewClassSet(CoreClasses)
+		if err := hierarchy.Add(cf); err != nil {
+			t.Fatal(err)
+		}
+		if test.frames {
+			if err := cf.ComputeFrames(hierarchy); err != nil {
+				t.Errorf("%v: %v", test.name, err)
+				continue
+			}
+		}
+		checkVerify(t, test.name, cf.Verify(hierarchy), test.offset, test.detail)
+	}
+}
+
+// checkVerify checks that errs is a single *VerifyError at offset whose detail contains
+// detail, or that there are no errors if offset is -1.
+func checkVerify(t *testing.T, name string, errs []error, offset int, detail string) {
+	t.Helper()
+	if offset < 0 {
+		if len(errs) > 0 {
+			t.Errorf("%v: %v", name, errs)
+		}
+		return
+	}
+	if len(errs) != 1 {
+		t.Errorf("%v: got errors %v, want one at offset %v", name, errs, offset)
+		return
+	}
+	err, ok := errs[0].(*VerifyError)
+	if !ok || err.Offset != offset || !strings.Contains(err.Detail, detail) {
+		t.Errorf("%v: got %v, want an error at offset %v about %q", name, errs[0], offset, detail)
+	}
+}
+
+func TestVerifySuperInit(t *testing.T) {
+	tests := []struct {
+		super  string
+		offset int
+		detail string
+	}{
+		{"java/lang/Object", -1, ""},
+		{"java/lang/String", 1, "but the superclass of T is java/lang/Object"},
+	}
+	for _, test := range tests {
+		b := NewClassBuilder(AccPublic|AccSuper, "T", "java/lang/Object")
+		b.SetVersion(52, 0)
+		m := b.AddMethod(AccPublic, "<init>", "()V")
+		m.VarInsn(OpAload, 0)
+		m.MethodInsn(OpInvokespecial, test.super, "<init>", "()V", false)
+		m.Insn(OpReturn)
+		cf, err := b.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Without a hierarchy, the superclass is found in the class itself
+		checkVerify(t, test.super, cf.Verify(nil), test.offset, test.detail)
+	}
+}
+
+// buildTryFinally builds a class of version 48 with the method that javac 1.4 compiles
+// from
+//
+//	static int f(int x) {
+//		try {
+//			x = x / 2;
+//		} finally {
+//			System.out.println(x);
+//		}
+//		return x;
+//	}
+//
+// whose finally block is a subroutine called with jsr from both the end of the try
+// block and the handler for exceptions thrown in it.
+func buildTryFinally(t *testing.T) *ClassFile {
+	t.Helper()
+	b := NewClassBuilder(AccPublic|AccSuper, "T", "java/lang/Object")
+	b.SetVersion(48, 0)
+	m := b.AddMethod(AccStatic, "f", "(I)I")
+	start, end, handler, finally, after := m.NewLabel(), m.NewLabel(), m.NewLabel(), m.NewLabel(), m.NewLabel()
+	m.Mark(start)
+	m.VarInsn(OpIload, 0)
+	m.Insn(OpIconst2)
+	m.Insn(OpIdiv)
+	m.VarInsn(OpIstore, 0)
+	m.Mark(end)
+	m.JumpInsn(OpJsr, finally)
+	m.JumpInsn(OpGoto, after)
+	m.Mark(handler)
+	m.VarInsn(OpAstore, 1)
+	m.JumpInsn(OpJsr, finally)
+	m.VarInsn(OpAload, 1)
+	m.Insn(OpAthrow)
+	m.Mark(finally)
+	m.VarInsn(OpAstore, 2)
+	m.FieldInsn(OpGetstatic, "java/lang/System", "out", "Ljava/io/PrintStream;")
+	m.VarInsn(OpIload, 0)
+	m.MethodInsn(OpInvokevirtual, "java/io/PrintStream", "println", "(I)V", false)
+	m.VarInsn(OpRet, 2)
+	m.Mark(after)
+	m.VarInsn(OpIload, 0)
+	m.Insn(OpIreturn)
+	m.TryCatch(start, end, handler, "")
+	cf, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cf
+}
+
+func TestVerifyInference(t *testing.T) {
+	checkVerify(t, "try/finally", buildTryFinally(t).Verify(nil), -1, "")
+
+	tests := []struct {
+		name   string
+		build  func(m *MethodBuilder)
+		offset int
+		detail string
+	}{
+		{"loop", func(m *MethodBuilder) {
+			top, end := m.NewLabel(), m.NewLabel()
+			m.Mark(top)
+			m.VarInsn(OpIload, 0)
+			m.JumpInsn(OpIfle, end)
+			m.IincInsn(0, -1)
+			m.JumpInsn(OpGoto, top)
+			m.Mark(end)
+			m.Insn(OpReturn)
+		}, -1, ""},
+		{"ret outside a subroutine", func(m *MethodBuilder) {
+			m.Insn(OpIconst0)
+			m.VarInsn(OpIstore, 1)
+			m.VarInsn(OpRet, 1)
+		}, 2, "ret outside a subroutine"},
+		{"ret of an int", func(m *MethodBuilder) {
+			sub := m.NewLabel()
+			m.JumpInsn(OpJsr, sub)
+			m.Insn(OpReturn)
+			m.Mark(sub)
+			m.VarInsn(OpAstore, 1)
+			m.Insn(OpIconst0)
+			m.VarInsn(OpIstore, 1)
+			m.VarInsn(OpRet, 1)
+		}, 7, "ret expects a return address in local 1, found int"},
+		{"merged local", func(m *MethodBuilder) {
+			other, end := m.NewLabel(), m.NewLabel()
+			m.VarInsn(OpIload, 0)
+			m.JumpInsn(OpIfeq, other)
+			m.Insn(OpAconstNull)
+			m.VarInsn(OpAstore, 1)
+			m.JumpInsn(OpGoto, end)
+			m.Mark(other)
+			m.Insn(OpIconst0)
+			m.VarInsn(OpIstore, 1)
+			m.Mark(end)
+			m.VarInsn(OpIload, 1)
+			m.Insn(OpPop)
+			m.Insn(OpReturn)
+		}, 11, "iload_1 expects int in local 1, found null"},
+		{"aload of a return address", func(m *MethodBuilder) {
+			sub := m.NewLabel()
+			m.JumpInsn(OpJsr, sub)
+			m.Insn(OpReturn)
+			m.Mark(sub)
+			m.VarInsn(OpAstore, 1)
+			m.VarInsn(OpAload, 1)
+			m.VarInsn(OpAstore, 2)
+			m.VarInsn(OpRet, 2)
+		}, 5, "aload_1 expects java/lang/Object in local 1, found returnAddress(4)"},
+	}
+	for _, test := range tests {
+		b := NewClassBuilder(AccPublic|AccSuper, "T", "java/lang/Object")
+		test.build(b.AddMethod(AccPublic|AccStatic, "f", "(I)V"))
+		cf, err := b.Build()
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		checkVerify(t, test.name, cf.Verify(nil), test.offset, test.detail)
+	}
+}
@@ -0,0 +1,291 @@
+package classy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// WriteClassFile serializes a ClassFile into the binary classfile format. The counts
+// written are derived from the lengths of the corresponding slices, so a ClassFile read
+// with ReadClassFile and written back unmodified produces identical bytes.
+func WriteClassFile(cf *ClassFile) ([]byte, error) {
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+
+	write(cf.Magic)
+	write(cf.MinorVersion)
+	write(cf.MajorVersion)
+
+	count, err := constantPoolCount(cf.ConstantPool)
+	if err != nil {
+		return nil, err
+	}
+	write(count)
+	for _, ent := range cf.ConstantPool {
+		if ent == nil {
+			continue
+		}
+		if err := writeCpEntry(&buf, ent); err != nil {
+			return nil, err
+		}
+	}
+
+	write(cf.AccessFlags)
+	write(cf.ThisClass)
+	write(cf.SuperClass)
+	if err := checkCount("interfaces", len(cf.Interfaces)); err != nil {
+		return nil, err
+	}
+	write(uint16(len(cf.Interfaces)))
+	write(cf.Interfaces)
+
+	if err := checkCount("fields", len(cf.Fields)); err != nil {
+		return nil, err
+	}
+	write(uint16(len(cf.Fields)))
+	for _, field := range cf.Fields {
+		write(field.AccessFlags)
+		write(field.NameIndex)
+		write(field.DescriptorIndex)
+		if err := writeAttrs(&buf, field.Attrs); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkCount("methods", len(cf.Methods)); err != nil {
+		return nil, err
+	}
+	write(uint16(len(cf.Methods)))
+	for _, method := range cf.Methods {
+		write(method.AccessFlags)
+		write(method.NameIndex)
+		write(method.DescriptorIndex)
+		if err := writeAttrs(&buf, method.Attrs); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writeAttrs(&buf, cf.Attrs); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UpdateCounts sets the counts a ClassFile stores alongside its slices, and the
+// AttrLength of each attribute, from the lengths of the slices they describe. It is for
+// ClassFiles that were built or edited in memory; WriteClassFile ignores the stored
+// counts either way.
+func (cf *ClassFile) UpdateCounts() error {
+	count, err := constantPoolCount(cf.ConstantPool)
+	if err != nil {
+		return err
+	}
+	cf.ConstantPoolCount = count
+	for _, ent := range cf.ConstantPool {
+		if utf8, ok := ent.(*CONSTANT_Utf8_info); ok {
+			if len(utf8.Bytes) > math.MaxUint16 {
+				return fmt.Errorf("Utf8 constant of %v bytes is too long", len(utf8.Bytes))
+			}
+			utf8.Length = uint16(len(utf8.Bytes))
+		}
+	}
+
+	if err := checkCount("interfaces", len(cf.Interfaces)); err != nil {
+		return err
+	}
+	cf.InterfacesCount = uint16(len(cf.Interfaces))
+	if err := checkCount("fields", len(cf.Fields)); err != nil {
+		return err
+	}
+	cf.FieldsCount = uint16(len(cf.Fields))
+	for i := range cf.Fields {
+		if cf.Fields[i].AttrsCount, err = updateAttrCounts(cf.Fields[i].Attrs); err != nil {
+			return err
+		}
+	}
+	if err := checkCount("methods", len(cf.Methods)); err != nil {
+		return err
+	}
+	cf.MethodsCount = uint16(len(cf.Methods))
+	for i := range cf.Methods {
+		if cf.Methods[i].AttrsCount, err = updateAttrCounts(cf.Methods[i].Attrs); err != nil {
+			return err
+		}
+	}
+	cf.AttrsCount, err = updateAttrCounts(cf.Attrs)
+	return err
+}
+
+// updateAttrCounts sets the AttrLength of each attribute and returns their count.
+func updateAttrCounts(attrs []AttrInfo) (uint16, error) {
+	if err := checkCount("attributes", len(attrs)); err != nil {
+		return 0, err
+	}
+	for i := range attrs {
+		if uint64(len(attrs[i].AttrData)) > math.MaxUint32 {
+			return 0, fmt.Errorf("Attribute length %v is too large", len(attrs[i].AttrData))
+		}
+		attrs[i].AttrLength = uint32(len(attrs[i].AttrData))
+	}
+	return uint16(len(attrs)), nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler using WriteClassFile.
+func (cf *ClassFile) MarshalBinary() ([]byte, error) {
+	return WriteClassFile(cf)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler using ReadClassFile.
+func (cf *ClassFile) UnmarshalBinary(data []byte) error {
+	parsed, err := ReadClassFile(data)
+	if err != nil {
+		return err
+	}
+	*cf = *parsed
+	return nil
+}
+
+// WriteCodeAttribute serializes a CodeAttribute into raw attribute data suitable for
+// the AttrData of a Code attribute.
+func WriteCodeAttribute(code *CodeAttribute) ([]byte, error) {
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+
+	write(code.MaxStack)
+	write(code.MaxLocals)
+	if uint64(len(code.Code)) > math.MaxUint32 {
+		return nil, fmt.Errorf("Code length %v is too large", len(code.Code))
+	}
+	write(uint32(len(code.Code)))
+	write(code.Code)
+	if err := checkCount("exception table", len(code.ExceptionTable)); err != nil {
+		return nil, err
+	}
+	write(uint16(len(code.ExceptionTable)))
+	write(code.ExceptionTable)
+	if err := writeAttrs(&buf, code.Attrs); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Write a constant pool entry, including its tag
+func writeCpEntry(buf *bytes.Buffer, ent CpEntry) error {
+	write := func(v interface{}) {
+		binary.Write(buf, binary.BigEndian, v)
+	}
+
+	write(ent.RawTag())
+	switch info := ent.(type) {
+	case *CONSTANT_Class_info:
+		write(info.NameIndex)
+	case *CONSTANT_Fieldref_info:
+		write(info.ClassIndex)
+		write(info.NameAndTypeIndex)
+	case *CONSTANT_Methodref_info:
+		write(info.ClassIndex)
+		write(info.NameAndTypeIndex)
+	case *CONSTANT_InterfaceMethodref_info:
+		write(info.ClassIndex)
+		write(info.NameAndTypeIndex)
+	case *CONSTANT_String_info:
+		write(info.StringIndex)
+	case *CONSTANT_Integer_info:
+		write(info.Bytes)
+	case *CONSTANT_Float_info:
+		write(info.Bytes)
+	case *CONSTANT_Long_info:
+		write(info.HighBytes)
+		write(info.LowBytes)
+	case *CONSTANT_Double_info:
+		write(info.HighBytes)
+		write(info.LowBytes)
+	case *CONSTANT_NameAndType_info:
+		write(info.NameIndex)
+		write(info.DescriptorIndex)
+	case *CONSTANT_Utf8_info:
+		if len(info.Bytes) > math.MaxUint16 {
+			return fmt.Errorf("Utf8 constant of %v bytes is too long", len(info.Bytes))
+		}
+		write(uint16(len(info.Bytes)))
+		write(info.Bytes)
+	case *CONSTANT_MethodHandle_info:
+		write(info.ReferenceKind)
+		write(info.ReferenceIndex)
+	case *CONSTANT_MethodType_info:
+		write(info.DescriptorIndex)
+	case *CONSTANT_Dynamic_info:
+		write(info.BootstrapMethodAttrIndex)
+		write(info.NameAndTypeIndex)
+	case *CONSTANT_InvokeDynamic_info:
+		write(info.BootstrapMethodAttrIndex)
+		write(info.NameAndTypeIndex)
+	case *CONSTANT_Module_info:
+		write(info.NameIndex)
+	case *CONSTANT_Package_info:
+		write(info.NameIndex)
+	default:
+		return fmt.Errorf("Cannot write constant pool entry of type %T", ent)
+	}
+	return nil
+}
+
+// Write an attribute count followed by each attribute_info struct
+func writeAttrs(buf *bytes.Buffer, attrs []AttrInfo) error {
+	if err := checkCount("attributes", len(attrs)); err != nil {
+		return err
+	}
+	binary.Write(buf, binary.BigEndian, uint16(len(attrs)))
+	for _, attr := range attrs {
+		if uint64(len(attr.AttrData)) > math.MaxUint32 {
+			return fmt.Errorf("Attribute length %v is too large", len(attr.AttrData))
+		}
+		binary.Write(buf, binary.BigEndian, attr.NameIndex)
+		binary.Write(buf, binary.BigEndian, uint32(len(attr.AttrData)))
+		buf.Write(attr.AttrData)
+	}
+	return nil
+}
+
+// constantPoolCount computes the constant_pool_count for a constant pool, checking that
+// the empty slots following 8-byte constants are where they should be. A trailing
+// 8-byte constant may omit its empty slot.
+func constantPoolCount(cp []CpEntry) (uint16, error) {
+	for i := 0; i < len(cp); i++ {
+		if cp[i] == nil {
+			return 0, fmt.Errorf("Empty constant pool entry at index %v", i+1)
+		}
+		switch cp[i].(type) {
+		case *CONSTANT_Long_info, *CONSTANT_Double_info:
+			if i+1 < len(cp) && cp[i+1] != nil {
+				return 0, fmt.Errorf("Constant pool entry %v must be empty, it follows an 8-byte constant", i+2)
+			}
+			i++
+		}
+	}
+	count := len(cp) + 1
+	if len(cp) > 0 && cp[len(cp)-1] != nil {
+		switch cp[len(cp)-1].(type) {
+		case *CONSTANT_Long_info, *CONSTANT_Double_info:
+			count++
+		}
+	}
+	if err := checkCount("constant pool entries", count); err != nil {
+		return 0, err
+	}
+	return uint16(count), nil
+}
+
+// checkCount ensures a count fits in the u2 that precedes it in the classfile.
+func checkCount(what string, count int) error {
+	if count > math.MaxUint16 {
+		return fmt.Errorf("Too many %v: %v", what, count)
+	}
+	return nil
+}
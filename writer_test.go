@@ -0,0 +1,41 @@
+package classy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteClassFileRoundTrip(t *testing.T) {
+	var classes []string
+	for _, pattern := range []string{"testdata/*.class", "samples/*.class"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		classes = append(classes, matches...)
+	}
+	if len(classes) == 0 {
+		t.Fatal("no classes in testdata or samples")
+	}
+	for _, class := range classes {
+		data, err := ioutil.ReadFile(class)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cf, err := ReadClassFile(data)
+		if err != nil {
+			t.Errorf("%v: %v", class, err)
+			continue
+		}
+		out, err := WriteClassFile(cf)
+		if err != nil {
+			t.Errorf("%v: %v", class, err)
+			continue
+		}
+		if !bytes.Equal(out, data) {
+			t.Errorf("%v: writing the class back gives %v bytes that differ from its %v", class, len(out), len(data))
+		}
+	}
+}